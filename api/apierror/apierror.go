@@ -0,0 +1,176 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Codes of a FieldError, stable for clients to switch on
+const (
+	CodeRequired = "required"
+	CodeInvalid  = "invalid"
+	CodeTooLong  = "too_long"
+	CodeTooMany  = "too_many"
+	CodeTaken    = "taken"
+	CodeUnknown  = "unknown"
+)
+
+// CodeInternal is the code of an error of none of the kinds here, a failure the client can't do anything about
+const CodeInternal = "internal"
+
+// FieldError is what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// kind is implemented by every error here, status is the HTTP status it is answered with and code the stable
+// name clients switch on
+type kind interface {
+	error
+	status() int
+	code() string
+}
+
+// NotFound is a resource that doesn't exist, or that the caller may not know exists
+type NotFound struct {
+	Resource string
+}
+
+func (e *NotFound) Error() string {
+	if e.Resource == "" {
+		return "Not Found"
+	}
+	return e.Resource + " Not Found"
+}
+
+func (e *NotFound) status() int  { return http.StatusNotFound }
+func (e *NotFound) code() string { return "not_found" }
+
+// Conflict is a write refused because the value of Field is already used by another row
+type Conflict struct {
+	Field   string
+	Message string
+}
+
+func (e *Conflict) Error() string { return e.Message }
+func (e *Conflict) status() int   { return http.StatusConflict }
+func (e *Conflict) code() string  { return "conflict" }
+
+// Validation is a request body with fields that are missing or wrong, Fields says what is wrong with each
+type Validation struct {
+	Fields []FieldError
+}
+
+// Invalid is a Validation error of the one field
+func Invalid(field, code, message string) *Validation {
+	return &Validation{Fields: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+func (e *Validation) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *Validation) status() int  { return http.StatusUnprocessableEntity }
+func (e *Validation) code() string { return "validation_failed" }
+
+// Unauthorized is a caller who isn't signed in, or whose credentials or token are no good. Code says which,
+// unauthorized when empty
+type Unauthorized struct {
+	Code    string
+	Message string
+}
+
+func (e *Unauthorized) Error() string { return e.Message }
+func (e *Unauthorized) status() int   { return http.StatusUnauthorized }
+func (e *Unauthorized) code() string  { return orDefault(e.Code, "unauthorized") }
+
+// Forbidden is a signed in caller who may not do what they asked. Code says why, forbidden when empty
+type Forbidden struct {
+	Code    string
+	Message string
+}
+
+func (e *Forbidden) Error() string { return e.Message }
+func (e *Forbidden) status() int   { return http.StatusForbidden }
+func (e *Forbidden) code() string  { return orDefault(e.Code, "forbidden") }
+
+// BadRequest is a request that can't be read: a body that isn't JSON, an id in the path that isn't a number
+// or a query string value that makes no sense. Code says which, bad_request when empty
+type BadRequest struct {
+	Code    string
+	Message string
+}
+
+func (e *BadRequest) Error() string { return e.Message }
+func (e *BadRequest) status() int   { return http.StatusBadRequest }
+func (e *BadRequest) code() string  { return orDefault(e.Code, "bad_request") }
+
+// TooManyRequests is a caller who has to slow down. Code says which limit they hit, too_many_requests when
+// empty
+type TooManyRequests struct {
+	Code    string
+	Message string
+}
+
+func (e *TooManyRequests) Error() string { return e.Message }
+func (e *TooManyRequests) status() int   { return http.StatusTooManyRequests }
+func (e *TooManyRequests) code() string  { return orDefault(e.Code, "too_many_requests") }
+
+// TooLarge is a request body over the size limit
+type TooLarge struct{}
+
+func (e *TooLarge) Error() string { return "Request Body Too Large" }
+func (e *TooLarge) status() int   { return http.StatusRequestEntityTooLarge }
+func (e *TooLarge) code() string  { return "body_too_large" }
+
+func orDefault(code, def string) string {
+	if code == "" {
+		return def
+	}
+	return code
+}
+
+// Status is the HTTP status err is answered with, 500 for an error of none of the kinds here
+func Status(err error) int {
+	var k kind
+	if errors.As(err, &k) {
+		return k.status()
+	}
+	return http.StatusInternalServerError
+}
+
+// Code is the stable code of err, CodeInternal for an error of none of the kinds here
+func Code(err error) string {
+	var k kind
+	if errors.As(err, &k) {
+		return k.code()
+	}
+	return CodeInternal
+}
+
+// Fields says what is wrong with each field of a Validation error, or which field a Conflict is over
+func Fields(err error) []FieldError {
+	var v *Validation
+	if errors.As(err, &v) {
+		return v.Fields
+	}
+	var c *Conflict
+	if errors.As(err, &c) && c.Field != "" {
+		return []FieldError{{Field: c.Field, Code: CodeTaken, Message: c.Message}}
+	}
+	return nil
+}
+
+// Internal reports whether err is a failure of the server rather than of the request, its message is not
+// for clients
+func Internal(err error) bool {
+	var k kind
+	return !errors.As(err, &k)
+}
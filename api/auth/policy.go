@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+)
+
+// Role decides which permissions a user holds
+type Role string
+
+// Roles from least to most privileged
+const (
+	RoleReader Role = "reader"
+	RoleAuthor Role = "author"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// DefaultRole is given to users who sign up through CreateUser
+const DefaultRole = RoleAuthor
+
+// Permission names a single action a role may perform
+type Permission string
+
+// Permissions checked by SetMiddlewareAuthorization and Authorize
+const (
+	CreatePost       Permission = "posts:create"
+	UpdateOwnPost    Permission = "posts:update:own"
+	UpdateAnyPost    Permission = "posts:update:any"
+	DeleteOwnPost    Permission = "posts:delete:own"
+	DeleteAnyPost    Permission = "posts:delete:any"
+	ReadOwnDraft     Permission = "posts:drafts:own"
+	ReadAnyDraft     Permission = "posts:drafts:any"
+	UpdateOwnUser    Permission = "users:update:own"
+	UpdateAnyUser    Permission = "users:update:any"
+	DeleteOwnUser    Permission = "users:delete:own"
+	DeleteAnyUser    Permission = "users:delete:any"
+	ManageRoles      Permission = "users:roles"
+	AddCategory      Permission = "categories:create"
+	CreateComment    Permission = "comments:create"
+	UpdateOwnComment Permission = "comments:update:own"
+	DeleteOwnComment Permission = "comments:delete:own"
+	DeleteAnyComment Permission = "comments:delete:any"
+	ModerateOwnPost  Permission = "comments:moderate:own"
+	ModerateAnyPost  Permission = "comments:moderate:any"
+	ReadLockouts     Permission = "users:lockouts"
+	ReadAnyUser      Permission = "users:read:any"
+)
+
+// ErrForbidden is returned when the caller is authenticated but not allowed
+var ErrForbidden error = &apierror.Forbidden{Message: "Forbidden"}
+
+var rolePermissions = map[Role][]Permission{
+	RoleReader: {UpdateOwnUser, DeleteOwnUser, CreateComment, UpdateOwnComment, DeleteOwnComment},
+	RoleAuthor: {UpdateOwnUser, DeleteOwnUser, CreateComment, UpdateOwnComment, DeleteOwnComment, CreatePost, UpdateOwnPost,
+		DeleteOwnPost, ReadOwnDraft, ModerateOwnPost},
+	RoleEditor: {UpdateOwnUser, DeleteOwnUser, CreateComment, UpdateOwnComment, DeleteOwnComment, CreatePost, UpdateOwnPost,
+		DeleteOwnPost, ReadOwnDraft, ModerateOwnPost, UpdateAnyPost, DeleteAnyPost, ReadAnyDraft, AddCategory},
+	RoleAdmin: {UpdateOwnUser, DeleteOwnUser, CreateComment, UpdateOwnComment, DeleteOwnComment, CreatePost, UpdateOwnPost,
+		DeleteOwnPost, ReadOwnDraft, ModerateOwnPost, UpdateAnyPost, DeleteAnyPost, ReadAnyDraft, AddCategory,
+		UpdateAnyUser, DeleteAnyUser, ManageRoles, ModerateAnyPost, DeleteAnyComment, ReadLockouts, ReadAnyUser},
+}
+
+// ValidRole reports whether the role is one we know about
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[Role(role)]
+	return ok
+}
+
+// Can reports whether the role holds the permission
+func (role Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule pairs the permission needed to act on your own resource with the one needed to act on anyone's
+type Rule struct {
+	Own Permission
+	Any Permission
+}
+
+// Rules declared on routes in initializeRoutes and enforced again by the handlers
+var (
+	CreatePostRule    = Rule{Own: CreatePost}
+	UpdatePostRule    = Rule{Own: UpdateOwnPost, Any: UpdateAnyPost}
+	DeletePostRule    = Rule{Own: DeleteOwnPost, Any: DeleteAnyPost}
+	ReadDraftRule     = Rule{Own: ReadOwnDraft, Any: ReadAnyDraft}
+	UpdateUserRule    = Rule{Own: UpdateOwnUser, Any: UpdateAnyUser}
+	DeleteUserRule    = Rule{Own: DeleteOwnUser, Any: DeleteAnyUser}
+	ManageRoleRule    = Rule{Any: ManageRoles}
+	TwoFactorRule     = Rule{Own: UpdateOwnUser} // only the user enrolls their own authenticator
+	CreateCommentRule = Rule{Own: CreateComment}
+	UpdateCommentRule = Rule{Own: UpdateOwnComment}
+	DeleteCommentRule = Rule{Own: DeleteOwnComment, Any: DeleteAnyComment}
+	ModerateRule      = Rule{Own: ModerateOwnPost, Any: ModerateAnyPost} // owned by the post's author
+	ReadLockoutsRule  = Rule{Any: ReadLockouts}
+)
+
+// Allows reports whether the role could satisfy the rule for at least some resource
+func (role Role) Allows(rule Rule) bool {
+	return role.Can(rule.Own) || role.Can(rule.Any)
+}
+
+// Identity is the authenticated caller taken from the token
+type Identity struct {
+	UserID uint
+	Role   Role
+}
+
+// ExtractIdentity returns the user_id and role from the token
+func (i *Issuer) ExtractIdentity(r *http.Request) (Identity, error) {
+	claims, err := i.parseToken(r)
+	if err != nil {
+		return Identity{}, err
+	}
+	uid, err := claimsUserID(claims)
+	if err != nil {
+		return Identity{}, err
+	}
+	// Tokens without a role get the least privileged one
+	role, _ := claims["role"].(string)
+	if !ValidRole(role) {
+		role = string(RoleReader)
+	}
+	return Identity{UserID: uid, Role: Role(role)}, nil
+}
+
+// Authorize is the ownership policy: the caller may act on a resource owned by ownerID when it
+// holds the rule's Any permission, or when it owns the resource and holds the Own permission
+func Authorize(id Identity, ownerID uint, rule Rule) error {
+	if rule.Any != "" && id.Role.Can(rule.Any) {
+		return nil
+	}
+	if rule.Own != "" && id.UserID != 0 && id.UserID == ownerID && id.Role.Can(rule.Own) {
+		return nil
+	}
+	return ErrForbidden
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+)
+
+// ErrTooManyAttempts is returned while a login subject is backing off or locked out
+var ErrTooManyAttempts error = &apierror.TooManyRequests{Code: "too_many_attempts", Message: "Too Many Login Attempts"}
+
+// Throttle is how failed logins against one subject slow it down and then lock it out. After Free failures
+// each attempt has to wait BaseDelay, doubling per failure up to MaxDelay, and at Lockout failures the
+// subject is locked for LockFor. Failures older than Window are forgotten
+type Throttle struct {
+	Free      int
+	Lockout   int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	LockFor   time.Duration
+	Window    time.Duration
+}
+
+var (
+	// EmailThrottle guards one account against password guessing
+	EmailThrottle = Throttle{Free: 3, Lockout: 10, BaseDelay: time.Second, MaxDelay: time.Minute,
+		LockFor: 15 * time.Minute, Window: time.Hour}
+	// IPThrottle guards against one client guessing across many accounts, it allows more since
+	// a shared address may hold many users
+	IPThrottle = Throttle{Free: 20, Lockout: 100, BaseDelay: time.Second, MaxDelay: time.Minute,
+		LockFor: time.Hour, Window: time.Hour}
+)
+
+// Locks reports whether the failures lock the subject out rather than just slow it down
+func (t Throttle) Locks(failures int) bool {
+	return failures >= t.Lockout
+}
+
+// RetryAt is when the subject may try again after failures, the last of them at last. Before Free
+// failures it is last itself
+func (t Throttle) RetryAt(failures int, last time.Time) time.Time {
+	if failures < t.Free {
+		return last
+	}
+	if t.Locks(failures) {
+		return last.Add(t.LockFor)
+	}
+	delay := t.BaseDelay
+	for i := t.Free; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.MaxDelay {
+		delay = t.MaxDelay
+	}
+	return last.Add(delay)
+}
+
+// ClientIP is the address the request came from, without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,46 +1,151 @@
 package auth
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-// CreateToken creates JWT token from userid
-func CreateToken(userID uint) (string, error) {
+const (
+	// AccessTokenLifetime is how long a signed JWT stays valid
+	AccessTokenLifetime = time.Minute * 15
+	// RefreshTokenLifetime is how long a refresh token can be exchanged for a new pair
+	RefreshTokenLifetime = time.Hour * 24 * 30
+	// VerifyEmailTokenLifetime is how long the link mailed to a new account stays usable
+	VerifyEmailTokenLifetime = time.Hour * 48
+	// ResetPasswordTokenLifetime is how long a password reset link stays usable
+	ResetPasswordTokenLifetime = time.Hour
+)
+
+// ErrTokenRevoked is returned when a token's jti is on the revocation list
+var ErrTokenRevoked error = &apierror.Unauthorized{Code: "token_revoked", Message: "Token Revoked"}
+
+// ErrInvalidToken is returned for a token that is forged, spent, expired or meant for something else
+var ErrInvalidToken error = &apierror.Unauthorized{Code: "invalid_token", Message: "Invalid Token"}
+
+// ErrUnauthorized answers a request without a usable access token
+var ErrUnauthorized error = &apierror.Unauthorized{Message: "Unauthorized"}
+
+// RevocationList reports whether the access token with the given jti has been revoked
+type RevocationList interface {
+	IsRevoked(jti string) (bool, error)
+}
+
+// Issuer signs the tokens the server hands out with Secret, the API_SECRET, and verifies the ones it is sent.
+// Access tokens are checked against Revocations, nil means nothing is revoked
+type Issuer struct {
+	Secret      []byte
+	Revocations RevocationList
+}
+
+// NewIssuer returns an Issuer signing with the secret
+func NewIssuer(secret string) *Issuer {
+	return &Issuer{Secret: []byte(secret)}
+}
+
+// ErrNoSecret is returned when a token is signed before Secret is set
+var ErrNoSecret = errors.New("API Secret Not Set")
+
+// TokenPair is handed to the client on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Challenge is handed to a two-factor user on login in place of a TokenPair, it is exchanged for one along
+// with a one time code
+type Challenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// CreateToken creates a short lived JWT token from userid and role, returns the token and its jti
+func (i *Issuer) CreateToken(userID uint, role Role) (string, string, error) {
+	if len(i.Secret) == 0 {
+		return "", "", ErrNoSecret
+	}
+	jti, err := randomString(16, hex.EncodeToString)
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = userID
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() // Expire Token in one hour
+	claims["role"] = string(role)
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(AccessTokenLifetime).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	signed, err := token.SignedString(i.Secret)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
 }
 
-// TokenValid checks validity
-func TokenValid(r *http.Request) error {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+// NewRefreshToken returns an opaque random refresh token, only its hash should be stored
+func NewRefreshToken() (string, error) {
+	return randomString(32, base64.RawURLEncoding.EncodeToString)
+}
+
+// HashToken returns the hex sha256 of a refresh token for storage and lookup
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSignedToken returns a random token signed with Secret for the purpose, such as verifying an email.
+// Only its HashToken should be stored, that row is what makes it single use and expire
+func (i *Issuer) NewSignedToken(purpose string) (string, error) {
+	if len(i.Secret) == 0 {
+		return "", ErrNoSecret
+	}
+	nonce, err := randomString(32, base64.RawURLEncoding.EncodeToString)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+	return nonce + "." + i.sign(purpose, nonce), nil
+}
+
+// VerifySignedToken checks the token was signed by this server for the purpose, so forged tokens and ones
+// issued for another purpose are turned away before any lookup
+func (i *Issuer) VerifySignedToken(token, purpose string) error {
+	parts := strings.Split(token, ".")
+	if len(i.Secret) == 0 || len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(i.sign(purpose, parts[0]))) {
+		return ErrInvalidToken
 	}
 	return nil
 }
 
+// sign returns the HMAC of the purpose and nonce keyed with Secret
+func (i *Issuer) sign(purpose, nonce string) string {
+	mac := hmac.New(sha256.New, i.Secret)
+	mac.Write([]byte(purpose + "." + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TokenValid checks validity
+func (i *Issuer) TokenValid(r *http.Request) error {
+	_, err := i.parseToken(r)
+	return err
+}
+
 // ExtractToken pulls token from request
 func ExtractToken(r *http.Request) string {
 	keys := r.URL.Query()
@@ -56,35 +161,91 @@ func ExtractToken(r *http.Request) string {
 }
 
 // ExtractTokenID returns user_id from the token
-func ExtractTokenID(r *http.Request) (uint, error) {
+func (i *Issuer) ExtractTokenID(r *http.Request) (uint, error) {
+	claims, err := i.parseToken(r)
+	if err != nil {
+		return 0, err
+	}
+	return claimsUserID(claims)
+}
+
+// claimsUserID reads the numeric user_id claim
+func claimsUserID(claims jwt.MapClaims) (uint, error) {
+	uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(uid), nil
+}
+
+// ExtractTokenJTI returns the jti and expiry of the token so it can be revoked
+func (i *Issuer) ExtractTokenJTI(r *http.Request) (string, time.Time, error) {
+	claims, err := i.parseToken(r)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	return jti, time.Unix(int64(exp), 0), nil
+}
+
+// parseToken verifies the signature and expiry then checks the jti against the revocation list
+func (i *Issuer) parseToken(r *http.Request) (jwt.MapClaims, error) {
 	tokenString := ExtractToken(r)
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(os.Getenv("API_SECRET")), nil
+		if len(i.Secret) == 0 {
+			return nil, ErrNoSecret
+		}
+		return i.Secret, nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		uid, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	// Tokens issued before jti existed can't be revoked, so refuse them
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, ErrInvalidToken
+	}
+	if i.Revocations != nil {
+		revoked, err := i.Revocations.IsRevoked(jti)
 		if err != nil {
-			return 0, err
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	if caller, ok := r.Context().Value(callerKey{}).(*uint); ok {
+		if uid, err := claimsUserID(claims); err == nil {
+			*caller = uid
 		}
-		return uint(uid), nil
 	}
-	return 0, nil
+	return claims, nil
+}
 
+// callerKey holds the user id slot RecordCaller puts in a request's context
+type callerKey struct{}
+
+// RecordCaller returns r with a slot that the user id of its token is written to once the token has been
+// checked, so the request log can name the caller without checking the token again. It stays 0 for
+// requests that never were
+func RecordCaller(r *http.Request) (*http.Request, *uint) {
+	caller := new(uint)
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)), caller
 }
 
-// Pretty prints the claims in the terminal all pretty like
-func Pretty(data interface{}) {
-	b, err := json.MarshalIndent("data", "", " ")
-	if err != nil {
-		log.Println(err)
-		return
+// randomString reads n random bytes and encodes them
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	fmt.Println(string(b))
+	return encode(b), nil
 }
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+)
+
+const (
+	// TOTPDigits is the length of a one time code
+	TOTPDigits = 6
+	// TOTPPeriod is how long each code is valid for, RFC 6238's time step
+	TOTPPeriod = 30 * time.Second
+	// TOTPSkew is how many steps either side of now are accepted, for clocks that drift
+	TOTPSkew = 1
+	// RecoveryCodeCount is how many recovery codes are handed out when two-factor is enabled
+	RecoveryCodeCount = 10
+	// ChallengeLifetime is how long the challenge token Login hands a two-factor user stays usable
+	ChallengeLifetime = 5 * time.Minute
+)
+
+// ErrInvalidCode is returned for a one time or recovery code that doesn't match
+var ErrInvalidCode error = &apierror.Unauthorized{Code: "invalid_code", Message: "Invalid Code"}
+
+// base32NoPad is how authenticator apps expect the secret, upper case without padding
+var base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random 160 bit secret, base32 encoded for authenticator apps
+func NewTOTPSecret() (string, error) {
+	return randomString(20, base32NoPad.EncodeToString)
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps read, usually from a QR code
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// TOTPStep is the RFC 6238 time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode returns the code for the secret at the time step, RFC 4226's HOTP over the step counter
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPad.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation picks 31 bits at an offset named by the last nibble
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTP checks the code against the steps within TOTPSkew of now and returns the step it matched.
+// Steps up to after are refused, so a code can't be used twice
+func ValidateTOTP(secret, code string, now time.Time, after int64) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, ErrInvalidCode
+	}
+	current := TOTPStep(now)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if step <= after {
+			continue
+		}
+		want, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+// NewRecoveryCodes returns RecoveryCodeCount random codes like "k3jd9-x2mfq", only their HashToken should
+// be stored
+func NewRecoveryCodes() ([]string, error) {
+	encoding := base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := randomString(7, encoding.EncodeToString)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:10]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lower cases the code and puts back the dash, so codes typed either way hash alike
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
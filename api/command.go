@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/aaronprice00/goblog-mvc/api/config"
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/aaronprice00/goblog-mvc/api/seed"
+	"gorm.io/gorm"
+)
+
+// openDB connects to the database described by the DB_* settings
+func openDB(c config.Database) (*gorm.DB, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return database.Open(c.Driver, c.User, c.Password, c.Port, c.Host, c.Name)
+}
+
+// runMigrate handles: migrate up | down [n] | status
+func runMigrate(c config.Database, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	db, err := openDB(c)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrate.Up(db)
+		for _, m := range done {
+			fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Schema is current")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		done, err := migrate.Down(db, steps)
+		for _, m := range done {
+			fmt.Printf("Reverted %d %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		list, err := migrate.List(db)
+		if err != nil {
+			return err
+		}
+		for _, s := range list {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], usage)
+}
+
+// runSeed handles: seed [-force]
+func runSeed(c config.Database, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	force := flags.Bool("force", false, "delete existing rows before seeding")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	db, err := openDB(c)
+	if err != nil {
+		return err
+	}
+
+	err = seed.Load(db, *force)
+	if errors.Is(err, seed.ErrNotEmpty) {
+		return fmt.Errorf("%w, run: seed -force to delete existing rows and seed anyway", err)
+	}
+	return err
+}
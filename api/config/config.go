@@ -0,0 +1,452 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/mailer"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server is set up with
+type Config struct {
+	DB                   Database
+	HTTPPort             string
+	HTTP                 HTTP
+	LogLevel             logger.Level
+	Tracing              tracing.Config
+	APISecret            string
+	PublicURL            string
+	RequireVerifiedEmail bool
+	PasswordPolicy       validate.PasswordPolicy
+	EmailCheckMX         bool
+	Mail                 mailer.Config
+	SchedulerInterval    time.Duration
+	PostMaxContentLength int
+	CommentEditWindow    time.Duration
+	RateLimits           map[string]ratelimit.Limit
+}
+
+// Database says where the data lives, for sqlite Name is the file path or :memory:. The rest tunes the
+// connection pool, zero leaves a setting unlimited
+type Database struct {
+	Driver          string
+	Host            string
+	Port            string
+	User            string
+	Password        string
+	Name            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// HTTP sets up the listener. Zero timeouts and sizes are unlimited. TLS is served when both TLSCertFile
+// and TLSKeyFile are set. ShutdownTimeout is how long in-flight requests get to finish on SIGINT or SIGTERM
+type HTTP struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int
+	ShutdownTimeout   time.Duration
+	TLSCertFile       string
+	TLSKeyFile        string
+}
+
+// Defaults is the config before anything is read
+func Defaults() Config {
+	limits := map[string]ratelimit.Limit{}
+	for group, limit := range ratelimit.DefaultLimits {
+		limits[group] = limit
+	}
+	return Config{
+		DB: Database{Driver: database.Postgres, Host: "127.0.0.1", Port: "5432",
+			MaxOpenConns: 25, MaxIdleConns: 10, ConnMaxLifetime: 30 * time.Minute},
+		HTTPPort: "8080",
+		HTTP: HTTP{ReadTimeout: 15 * time.Second, ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 30 * time.Second,
+			IdleTimeout: 2 * time.Minute, MaxHeaderBytes: 1 << 20, MaxBodyBytes: 1 << 20, ShutdownTimeout: 10 * time.Second},
+		LogLevel:             logger.Info,
+		Tracing:              tracing.Config{Exporter: tracing.Off, ServiceName: "goblog", SampleRatio: 1},
+		PasswordPolicy:       validate.DefaultPasswordPolicy,
+		Mail:                 mailer.Config{Driver: mailer.Log},
+		PostMaxContentLength: 100000,
+		CommentEditWindow:    15 * time.Minute,
+		RateLimits:           limits,
+	}
+}
+
+// setting is one value of the Config. It is read from the environment variable name, the config file key
+// that is name lower cased, and the flag that is name lower cased with dashes
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+func text(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func boolean(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = strconv.ParseBool(v)
+		return err
+	}
+}
+
+func integer(field func(c *Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = strconv.Atoi(v)
+		return err
+	}
+}
+
+func float(field func(c *Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = strconv.ParseFloat(v, 64)
+		return err
+	}
+}
+
+func duration(field func(c *Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) (err error) {
+		*field(c), err = time.ParseDuration(v)
+		return err
+	}
+}
+
+func logLevel(c *Config, v string) (err error) {
+	c.LogLevel, err = logger.ParseLevel(v)
+	return err
+}
+
+func limit(group string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		l, err := ratelimit.ParseLimit(v)
+		if err != nil {
+			return err
+		}
+		c.RateLimits[group] = l
+		return nil
+	}
+}
+
+// settings are every value Load reads
+var settings = []setting{
+	{"DB_DRIVER", "postgres or sqlite", text(func(c *Config) *string { return &c.DB.Driver })},
+	{"DB_HOST", "Postgres host", text(func(c *Config) *string { return &c.DB.Host })},
+	{"DB_PORT", "Postgres port", text(func(c *Config) *string { return &c.DB.Port })},
+	{"DB_USER", "Postgres user", text(func(c *Config) *string { return &c.DB.User })},
+	{"DB_PASSWORD", "Postgres password", text(func(c *Config) *string { return &c.DB.Password })},
+	{"DB_NAME", "Postgres database, or the SQLite file path or :memory:", text(func(c *Config) *string { return &c.DB.Name })},
+	{"DB_MAX_OPEN_CONNS", "most connections the pool opens, 0 for no limit", integer(func(c *Config) *int { return &c.DB.MaxOpenConns })},
+	{"DB_MAX_IDLE_CONNS", "most idle connections the pool keeps", integer(func(c *Config) *int { return &c.DB.MaxIdleConns })},
+	{"DB_CONN_MAX_LIFETIME", "how long a connection is reused before it is closed, 0 for ever", duration(func(c *Config) *time.Duration { return &c.DB.ConnMaxLifetime })},
+	{"HTTP_PORT", "port the API listens on", text(func(c *Config) *string { return &c.HTTPPort })},
+	{"HTTP_READ_TIMEOUT", "longest time to read a whole request", duration(func(c *Config) *time.Duration { return &c.HTTP.ReadTimeout })},
+	{"HTTP_READ_HEADER_TIMEOUT", "longest time to read the request headers", duration(func(c *Config) *time.Duration { return &c.HTTP.ReadHeaderTimeout })},
+	{"HTTP_WRITE_TIMEOUT", "longest time to write a response", duration(func(c *Config) *time.Duration { return &c.HTTP.WriteTimeout })},
+	{"HTTP_IDLE_TIMEOUT", "how long an idle keep-alive connection stays open", duration(func(c *Config) *time.Duration { return &c.HTTP.IdleTimeout })},
+	{"HTTP_MAX_HEADER_BYTES", "largest request headers accepted, in bytes", integer(func(c *Config) *int { return &c.HTTP.MaxHeaderBytes })},
+	{"HTTP_MAX_BODY_BYTES", "largest request body accepted, in bytes, 0 for no limit", integer(func(c *Config) *int { return &c.HTTP.MaxBodyBytes })},
+	{"SHUTDOWN_TIMEOUT", "how long in-flight requests get to finish on SIGINT or SIGTERM", duration(func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout })},
+	{"TLS_CERT_FILE", "certificate to serve HTTPS with, along with TLS_KEY_FILE", text(func(c *Config) *string { return &c.HTTP.TLSCertFile })},
+	{"TLS_KEY_FILE", "private key of TLS_CERT_FILE", text(func(c *Config) *string { return &c.HTTP.TLSKeyFile })},
+	{"LOG_LEVEL", "least serious log entries written: debug, info, warn or error", logLevel},
+	{"TRACE_EXPORTER", "where spans are sent: otlp, stdout, file or off", text(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACE_OTLP_ENDPOINT", "host:port of the OTLP/HTTP collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT", text(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"TRACE_OTLP_INSECURE", "send spans to the collector over plain HTTP", boolean(func(c *Config) *bool { return &c.Tracing.Insecure })},
+	{"TRACE_FILE", "file the file trace exporter appends to", text(func(c *Config) *string { return &c.Tracing.Path })},
+	{"TRACE_SERVICE_NAME", "service name spans are reported under", text(func(c *Config) *string { return &c.Tracing.ServiceName })},
+	{"TRACE_SAMPLE_RATIO", "share of new traces recorded, from 0 to 1", float(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+	{"API_SECRET", "key tokens are signed with", text(func(c *Config) *string { return &c.APISecret })},
+	{"PUBLIC_URL", "base of links in mail and feeds, required", text(func(c *Config) *string { return &c.PublicURL })},
+	{"REQUIRE_VERIFIED_EMAIL", "refuse sign in until the user has verified their email", boolean(func(c *Config) *bool { return &c.RequireVerifiedEmail })},
+	{"PASSWORD_MIN_LENGTH", "fewest characters a new password may have", integer(func(c *Config) *int { return &c.PasswordPolicy.MinLength })},
+	{"PASSWORD_MIN_CLASSES", "how many of lower case, upper case, digits and symbols a new password needs, 1 to 4", integer(func(c *Config) *int { return &c.PasswordPolicy.MinClasses })},
+	{"EMAIL_CHECK_MX", "refuse new emails whose domain has no mail server", boolean(func(c *Config) *bool { return &c.EmailCheckMX })},
+	{"MAIL_DRIVER", "smtp, file or log", text(func(c *Config) *string { return &c.Mail.Driver })},
+	{"MAIL_FROM", "address mail is sent from", text(func(c *Config) *string { return &c.Mail.From })},
+	{"MAIL_FILE", "file the file mail driver appends to", text(func(c *Config) *string { return &c.Mail.Path })},
+	{"SMTP_HOST", "SMTP server", text(func(c *Config) *string { return &c.Mail.Host })},
+	{"SMTP_PORT", "SMTP port", text(func(c *Config) *string { return &c.Mail.Port })},
+	{"SMTP_USERNAME", "SMTP user", text(func(c *Config) *string { return &c.Mail.Username })},
+	{"SMTP_PASSWORD", "SMTP password", text(func(c *Config) *string { return &c.Mail.Password })},
+	{"SCHEDULER_INTERVAL", "how often scheduled posts are published when due", duration(func(c *Config) *time.Duration { return &c.SchedulerInterval })},
+	{"POST_MAX_CONTENT_LENGTH", "longest Markdown post content, in characters", integer(func(c *Config) *int { return &c.PostMaxContentLength })},
+	{"COMMENT_EDIT_WINDOW", "how long a commenter may still edit their comment", duration(func(c *Config) *time.Duration { return &c.CommentEditWindow })},
+	{"RATE_LIMIT", "requests per client per route group, like 120/1m, or off", limit(ratelimit.Default)},
+	{"RATE_LIMIT_AUTH", "rate limit of the login, token, password and verification routes", limit(ratelimit.Auth)},
+	{"RATE_LIMIT_SIGNUP", "rate limit of sign up", limit(ratelimit.Signup)},
+}
+
+// flagName is the command line flag of the setting, DB_HOST is -db-host
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+// Options says where Load reads from besides the defaults and the environment
+type Options struct {
+	// Args is the command line. Flags are read up to the first argument that isn't one, the rest is
+	// returned by Load
+	Args []string
+	// Prefix goes before every environment variable name, the tests read T_DB_DRIVER and so on
+	Prefix string
+	// EnvFile is the .env file read when -env-file isn't given, .env when empty. It may be missing
+	// unless named by -env-file
+	EnvFile string
+}
+
+// Load reads the config. Later sources win: the defaults, then the YAML file named by -config or
+// CONFIG_FILE, then the .env file, then the environment, then the flags
+func Load(opts Options) (Config, []string, error) {
+	c := Defaults()
+
+	flags := flag.NewFlagSet("goblog", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML `file` to read settings from, keys are the lower cased variable names")
+	envFile := flags.String("env-file", "", "`file` of environment variables to read (default .env)")
+	values := map[string]*string{}
+	for _, s := range settings {
+		values[s.name] = flags.String(flagName(s.name), "", s.usage+", or "+opts.Prefix+s.name)
+	}
+	if err := flags.Parse(opts.Args); err != nil {
+		return c, nil, err
+	}
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	dotenv, err := readEnvFile(*envFile, opts.EnvFile)
+	if err != nil {
+		return c, nil, err
+	}
+	lookup := func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := dotenv[key]
+		return v, ok
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookup(opts.Prefix + "CONFIG_FILE")
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return c, nil, err
+	}
+
+	for _, s := range settings {
+		key := opts.Prefix + s.name
+		v, ok := file[strings.ToLower(s.name)]
+		if ev, has := lookup(key); has {
+			v, ok = ev, true
+		}
+		if given[flagName(s.name)] {
+			v, ok = *values[s.name], true
+		}
+		if !ok {
+			continue
+		}
+		if err = s.set(&c, strings.TrimSpace(v)); err != nil {
+			return c, nil, fmt.Errorf("Invalid %s: %v", key, err)
+		}
+	}
+	return c, flags.Args(), nil
+}
+
+// readEnvFile reads the named .env file, or def when none is named. Only a named file has to exist
+func readEnvFile(named, def string) (map[string]string, error) {
+	path := named
+	if path == "" {
+		path = def
+	}
+	if path == "" {
+		path = ".env"
+	}
+	values, err := godotenv.Read(path)
+	if os.IsNotExist(err) && named == "" {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read env file %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// readConfigFile reads the YAML file into its keys and values, none when path is empty
+func readConfigFile(path string) (map[string]string, error) {
+	values := map[string]string{}
+	if path == "" {
+		return values, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file %s: %v", path, err)
+	}
+	raw := map[string]interface{}{}
+	if err = yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("Could not read config file %s: %v", path, err)
+	}
+	for key, v := range raw {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("Invalid config file %s: %s must be a single value", path, key)
+		case nil:
+			values[strings.ToLower(key)] = ""
+		default:
+			values[strings.ToLower(key)] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// Validate checks the database settings are usable
+func (d Database) Validate() error {
+	switch strings.ToLower(d.Driver) {
+	case database.Postgres:
+		if d.Host == "" || d.Name == "" {
+			return errors.New("Required: DB_HOST and DB_NAME")
+		}
+	case database.SQLite, "sqlite3":
+		if d.Name == "" {
+			return errors.New("Required: DB_NAME as the SQLite file path or :memory:")
+		}
+	default:
+		return fmt.Errorf("Invalid DB_DRIVER: %s", d.Driver)
+	}
+	if d.MaxOpenConns < 0 || d.MaxIdleConns < 0 || d.ConnMaxLifetime < 0 {
+		return errors.New("Invalid DB pool settings: DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME can't be negative")
+	}
+	return nil
+}
+
+// InMemory reports whether the database is an in-memory SQLite one, gone when the process exits
+func (d Database) InMemory() bool {
+	switch strings.ToLower(d.Driver) {
+	case database.SQLite, "sqlite3":
+		return d.Name == ":memory:"
+	}
+	return false
+}
+
+// Pool is the connection pool tuning of the database
+func (d Database) Pool() database.Pool {
+	return database.Pool{MaxOpenConns: d.MaxOpenConns, MaxIdleConns: d.MaxIdleConns, ConnMaxLifetime: d.ConnMaxLifetime}
+}
+
+// Validate checks the listener settings, it reports every problem at once
+func (h HTTP) Validate() error {
+	var problems []string
+	for name, d := range map[string]time.Duration{
+		"HTTP_READ_TIMEOUT":        h.ReadTimeout,
+		"HTTP_READ_HEADER_TIMEOUT": h.ReadHeaderTimeout,
+		"HTTP_WRITE_TIMEOUT":       h.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":        h.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":         h.ShutdownTimeout,
+	} {
+		if d < 0 {
+			problems = append(problems, "Invalid "+name+": "+d.String())
+		}
+	}
+	if h.MaxHeaderBytes < 0 {
+		problems = append(problems, "Invalid HTTP_MAX_HEADER_BYTES: "+strconv.Itoa(h.MaxHeaderBytes))
+	}
+	if h.MaxBodyBytes < 0 {
+		problems = append(problems, "Invalid HTTP_MAX_BODY_BYTES: "+strconv.Itoa(h.MaxBodyBytes))
+	}
+	if (h.TLSCertFile == "") != (h.TLSKeyFile == "") {
+		problems = append(problems, "Required: TLS_CERT_FILE and TLS_KEY_FILE together")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Validate checks the config can run the server, it reports every problem at once
+func (c Config) Validate() error {
+	var problems []string
+	if err := c.DB.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.APISecret == "" {
+		problems = append(problems, "Required: API_SECRET")
+	}
+	if port, err := strconv.Atoi(c.HTTPPort); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, "Invalid HTTP_PORT: "+c.HTTPPort)
+	}
+	if err := c.HTTP.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	// Every mail driver sends links, and they never come from the request's Host
+	if c.PublicURL == "" {
+		problems = append(problems, "Required: PUBLIC_URL for the links in mail")
+	} else if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, "Invalid PUBLIC_URL: "+c.PublicURL)
+	}
+	switch c.Mail.Driver {
+	case mailer.SMTP:
+		if c.Mail.Host == "" || c.Mail.From == "" {
+			problems = append(problems, "Required: SMTP_HOST and MAIL_FROM for the smtp mail driver")
+		}
+	case mailer.File:
+		if c.Mail.Path == "" {
+			problems = append(problems, "Required: MAIL_FILE for the file mail driver")
+		}
+	case mailer.Log, "":
+	default:
+		problems = append(problems, "Invalid MAIL_DRIVER: "+c.Mail.Driver)
+	}
+	switch c.Tracing.Exporter {
+	case tracing.File:
+		if c.Tracing.Path == "" {
+			problems = append(problems, "Required: TRACE_FILE for the file trace exporter")
+		}
+	case tracing.OTLP, tracing.Stdout, tracing.Off, "":
+	default:
+		problems = append(problems, "Invalid TRACE_EXPORTER: "+c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problems = append(problems, "Invalid TRACE_SAMPLE_RATIO: "+strconv.FormatFloat(c.Tracing.SampleRatio, 'g', -1, 64))
+	}
+	if c.PasswordPolicy.MinLength < 1 || c.PasswordPolicy.MinLength > validate.MaxPasswordBytes {
+		problems = append(problems, "Invalid PASSWORD_MIN_LENGTH: "+strconv.Itoa(c.PasswordPolicy.MinLength))
+	}
+	if c.PasswordPolicy.MinClasses < 1 || c.PasswordPolicy.MinClasses > 4 {
+		problems = append(problems, "Invalid PASSWORD_MIN_CLASSES: "+strconv.Itoa(c.PasswordPolicy.MinClasses))
+	}
+	if c.PostMaxContentLength < 1 {
+		problems = append(problems, "Invalid POST_MAX_CONTENT_LENGTH: "+strconv.Itoa(c.PostMaxContentLength))
+	}
+	if c.SchedulerInterval < 0 {
+		problems = append(problems, "Invalid SCHEDULER_INTERVAL: "+c.SchedulerInterval.String())
+	}
+	if c.CommentEditWindow < 0 {
+		problems = append(problems, "Invalid COMMENT_EDIT_WINDOW: "+c.CommentEditWindow.String())
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
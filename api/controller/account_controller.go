@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/mailer"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+)
+
+// accountRequest is the body accepted by the verification and password routes, each reads the fields it needs
+type accountRequest struct {
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// errTokenRequired and errInvalidMailedToken answer a body without a usable mailed token
+var (
+	errTokenRequired      error = apierror.Invalid("token", apierror.CodeRequired, "Required: Token")
+	errInvalidMailedToken error = apierror.Invalid("token", apierror.CodeInvalid, auth.ErrInvalidToken.Error())
+)
+
+// tokenLifetimes is how long a user token of each purpose stays usable
+var tokenLifetimes = map[string]time.Duration{
+	model.VerifyEmailPurpose:   auth.VerifyEmailTokenLifetime,
+	model.ResetPasswordPurpose: auth.ResetPasswordTokenLifetime,
+	model.TwoFactorPurpose:     auth.ChallengeLifetime,
+}
+
+// readAccountRequest decodes the body, responding with the error when it can't
+func readAccountRequest(w http.ResponseWriter, r *http.Request) (accountRequest, bool) {
+	req := accountRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return req, false
+	}
+	return req, true
+}
+
+// readEmail decodes a body holding just an email, responding with the error when it isn't a valid one
+func readEmail(w http.ResponseWriter, r *http.Request) (string, bool) {
+	req, ok := readAccountRequest(w, r)
+	if !ok {
+		return "", false
+	}
+	user := model.User{Email: req.Email}
+	user.Prepare()
+	err := validate.Check(validate.Field{Name: "email", Label: "Email", Value: user.Email,
+		Rules: []validate.Rule{validate.Required, validate.Email}})
+	if err != nil {
+		response.ERROR(w, err)
+		return "", false
+	}
+	return user.Email, true
+}
+
+// issueUserToken stores a new token for the purpose and returns it, the user's earlier tokens for the
+// purpose stop working
+func (server *Server) issueUserToken(ctx context.Context, user *model.User, purpose string) (string, error) {
+	token, err := server.Auth.NewSignedToken(purpose)
+	if err != nil {
+		return "", err
+	}
+	ut := model.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(tokenLifetimes[purpose]),
+	}
+	if _, err = server.repos(ctx).Tokens.CreateUserToken(&ut); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// errNoPublicURL refuses to mail a token without PUBLIC_URL, links built from the request's Host would let
+// anyone asking for a user's token point its link at their own server
+var errNoPublicURL = errors.New("Required: PUBLIC_URL to mail links")
+
+// mailUserToken issues a token for the purpose and mails it to the user, its links always under PublicURL
+func (server *Server) mailUserToken(r *http.Request, user *model.User, purpose string) error {
+	if server.PublicURL == "" {
+		return errNoPublicURL
+	}
+	token, err := server.issueUserToken(r.Context(), user, purpose)
+	if err != nil {
+		return err
+	}
+	return server.Mailer.Send(accountMail(strings.TrimSuffix(server.PublicURL, "/"), user, purpose, token))
+}
+
+// accountMail writes the email carrying a token
+func accountMail(base string, user *model.User, purpose, token string) mailer.Message {
+	msg := mailer.Message{To: html.UnescapeString(user.Email)}
+	greeting := fmt.Sprintf("Hi %s,\n\n", html.UnescapeString(user.Username))
+	switch purpose {
+	case model.VerifyEmailPurpose:
+		msg.Subject = "Verify your email"
+		msg.Body = greeting + fmt.Sprintf("Confirm this address is yours by sending the token below to POST %s/users/verify "+
+			"within %v:\n\n%s\n", base, auth.VerifyEmailTokenLifetime, token)
+	case model.ResetPasswordPurpose:
+		msg.Subject = "Reset your password"
+		msg.Body = greeting + fmt.Sprintf("Someone asked to reset your password. Send the token below with your new password "+
+			"to POST %s/password/reset within %v:\n\n%s\n\nIf it wasn't you, ignore this email and your password stays "+
+			"the same.\n", base, auth.ResetPasswordTokenLifetime, token)
+	}
+	return msg
+}
+
+// checkUserToken returns the stored token when it was signed for the purpose and is still good. Any token
+// that can't be used is auth.ErrInvalidToken so callers learn nothing about why
+func (server *Server) checkUserToken(ctx context.Context, token, purpose string) (*model.UserToken, error) {
+	if err := server.Auth.VerifySignedToken(token, purpose); err != nil {
+		return nil, err
+	}
+	ut, err := server.repos(ctx).Tokens.ReadUserTokenByHash(auth.HashToken(token))
+	if err != nil || ut.Purpose != purpose || !ut.Active() {
+		return nil, auth.ErrInvalidToken
+	}
+	return ut, nil
+}
+
+// useUserToken checks the token like checkUserToken, then spends it
+func (server *Server) useUserToken(ctx context.Context, token, purpose string) (*model.UserToken, error) {
+	ut, err := server.checkUserToken(ctx, token, purpose)
+	if err != nil {
+		return nil, err
+	}
+	err = server.repos(ctx).Tokens.UseUserToken(ut)
+	if err == model.ErrTokenUsed {
+		return nil, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ut, nil
+}
+
+// VerifyEmail spends a verification token mailed on sign up and marks the user's email verified
+func (server *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	req, ok := readAccountRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Token == "" {
+		response.ERROR(w, errTokenRequired)
+		return
+	}
+	ut, err := server.useUserToken(r.Context(), req.Token, model.VerifyEmailPurpose)
+	if err == auth.ErrInvalidToken {
+		response.ERROR(w, errInvalidMailedToken)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	user, err := server.repos(r.Context()).Users.VerifyEmail(ut.UserID)
+	if err != nil {
+		response.ERROR(w, errInvalidMailedToken)
+		return
+	}
+	response.JSON(w, http.StatusOK, dto.NewUserSelf(user))
+}
+
+// ResendVerification mails a new verification token to an unverified account. It answers the same whether
+// or not the email has an account, or the mail went out, so it can't be used to find out
+func (server *Server) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	email, ok := readEmail(w, r)
+	if !ok {
+		return
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByEmail(email)
+	if err == nil && !user.Verified() {
+		server.sendVerification(r, user)
+	}
+	response.NoBody(w, http.StatusAccepted)
+}
+
+// ForgotPassword mails a password reset token. It answers the same whether or not the email has an account,
+// or the mail went out, so it can't be used to find out
+func (server *Server) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	email, ok := readEmail(w, r)
+	if !ok {
+		return
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByEmail(email)
+	if err == nil {
+		if err = server.mailUserToken(r, user, model.ResetPasswordPurpose); err != nil {
+			server.log(r).Warn("Could not mail password reset", logger.Fields{"user_id": user.ID, "error": err})
+		}
+	}
+	response.NoBody(w, http.StatusAccepted)
+}
+
+// ResetPassword spends a reset token and sets the new password. Every session of the user is ended, and the
+// email counts as verified since the token was read from it
+func (server *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	req, ok := readAccountRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Token == "" {
+		response.ERROR(w, errTokenRequired)
+		return
+	}
+	// Checked before the token is spent so a typo doesn't cost the user their link
+	if err := server.UserPolicy.ValidatePassword(req.Password); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	ut, err := server.useUserToken(r.Context(), req.Token, model.ResetPasswordPurpose)
+	if err == auth.ErrInvalidToken {
+		response.ERROR(w, errInvalidMailedToken)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByID(ut.UserID)
+	if err != nil {
+		response.ERROR(w, errInvalidMailedToken)
+		return
+	}
+
+	user.Password = req.Password
+	if _, err = server.repos(r.Context()).Users.UpdateUser(user.ID, user); err != nil {
+		response.ERROR(w, formaterror.FormatError(err))
+		return
+	}
+	if _, err = server.repos(r.Context()).Users.VerifyEmail(user.ID); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if err = server.repos(r.Context()).Tokens.RevokeUserTokens(user.ID); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.NoBody(w, http.StatusNoContent)
+}
+
+// sendVerification mails the verification token, a failed mail is logged and doesn't undo the sign up since
+// another can be asked for through ResendVerification
+func (server *Server) sendVerification(r *http.Request, user *model.User) {
+	if err := server.mailUserToken(r, user, model.VerifyEmailPurpose); err != nil {
+		server.log(r).Warn("Could not mail verification", logger.Fields{"user_id": user.ID, "error": err})
+	}
+}
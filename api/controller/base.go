@@ -1,45 +1,272 @@
 package controller
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/config"
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/mailer"
+	"github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
 	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
+	"github.com/aaronprice00/goblog-mvc/api/scheduler"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
 	"github.com/gorilla/mux"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-// Server holds our db and router objects
+// Server holds our db, repositories and router objects, Configure sets it up from the config
 type Server struct {
 	DB     *gorm.DB
 	Router *mux.Router
-}
+	// Users stores the accounts, UseRepositories sets it and the repositories below
+	Users repository.UserRepository
+	// Posts stores the posts and their tags and categories
+	Posts repository.PostRepository
+	// Tags lists the tags and categories with their post counts
+	Tags repository.TagRepository
+	// Comments stores the comments on posts
+	Comments repository.CommentRepository
+	// Tokens stores the refresh tokens, the revoked access tokens and the tokens mailed to users
+	Tokens repository.TokenRepository
+	// Attempts counts failed logins and the lockouts they lead to
+	Attempts repository.AttemptRepository
+	// Mailer sends the verification and password reset mail
+	Mailer mailer.Mailer
+	// Limiter rate limits every route by its group, nil leaves them unlimited. It must be set before the
+	// routes are
+	Limiter *ratelimit.Limiter
+	// HTTP sets up the listener Run starts
+	HTTP config.HTTP
+	// Logger is what everything is logged to, nil to log nothing
+	Logger *logger.Logger
+	// PublicURL is where the API is reached from outside, the base of links in mail and feeds. Feeds take it
+	// from each request when it is empty
+	PublicURL string
+	// RequireVerifiedEmail refuses sign in to users who haven't verified their email
+	RequireVerifiedEmail bool
+	// Auth signs and checks tokens
+	Auth *auth.Issuer
+	// UserPolicy is what users and their passwords are validated against
+	UserPolicy model.UserPolicy
+	// MaxContentLength is the longest a post's content may be
+	MaxContentLength int
+	// CommentEditWindow is how long after posting a comment its author may still edit it
+	CommentEditWindow time.Duration
+	// Scheduler, nil for none, runs alongside Run and is stopped before the database pool is closed
+	Scheduler *scheduler.Scheduler
 
-// Initialize intitializes Server object with open db connection and routed Router
-func (server *Server) Initialize(DbUser, DbPassword, DbPort, DbHost, DbName string) {
+	schedulerStop chan struct{}
+	schedulerDone chan struct{}
+}
 
+// Configure sets the server up from the config: the token secret, mail, rate limits and the content
+// settings of posts and comments
+func (server *Server) Configure(c config.Config) error {
 	var err error
+	server.Logger = logger.New(os.Stdout, c.LogLevel)
+	if server.Mailer, err = mailer.New(c.Mail); err != nil {
+		return err
+	}
+	server.Auth = auth.NewIssuer(c.APISecret)
+	server.HTTP = c.HTTP
+	server.PublicURL = c.PublicURL
+	server.RequireVerifiedEmail = c.RequireVerifiedEmail
+	server.Limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), c.RateLimits)
+	server.UserPolicy = model.UserPolicy{Password: c.PasswordPolicy, CheckEmailMX: c.EmailCheckMX}
+	server.MaxContentLength = c.PostMaxContentLength
+	server.CommentEditWindow = c.CommentEditWindow
+	return nil
+}
+
+// Initialize intitializes Server object from the config with open db connection and routed Router
+func (server *Server) Initialize(c config.Config) {
+
+	err := server.Configure(c)
+	if err != nil {
+		server.fatal("Config Error", err)
+	}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", DbHost, DbPort, DbUser, DbName, DbPassword)
-	server.DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	server.DB, err = database.Open(c.DB.Driver, c.DB.User, c.DB.Password, c.DB.Port, c.DB.Host, c.DB.Name)
 	if err != nil {
-		fmt.Println("Cannot connect to database")
-		log.Fatalln("Db Error: ", err)
-	} else {
-		fmt.Println("Db Connected")
+		server.fatal("Cannot connect to database", err)
+	}
+	server.Logger.Info("Db Connected", logger.Fields{"driver": c.DB.Driver})
+	if err = database.Tune(server.DB, c.DB.Pool()); err != nil {
+		server.fatal("Db Error", err)
+	}
+	server.registerPoolMetrics()
+	if err = tracing.RegisterCallbacks(server.DB); err != nil {
+		server.fatal("Db Error", err)
 	}
 
-	server.DB.AutoMigrate(&model.User{}, &model.Post{})
+	// The schema is owned by the migrate command, refuse to serve against an older or newer one. An in-memory
+	// database starts empty and no other process can reach it, so it is migrated here
+	if c.DB.InMemory() {
+		if _, err = migrate.Up(server.DB); err != nil {
+			server.fatal("Db Error", err)
+		}
+	}
+	if err = migrate.Current(server.DB); err != nil {
+		server.fatal("Db Error", err)
+	}
+
+	server.UseRepositories(repository.NewGormRepositories(server.DB))
 
 	server.Router = mux.NewRouter()
 
 	server.initializeRoutes()
 }
 
-// Run starts http Listen and Serve
-func (server *Server) Run(addr string) {
-	fmt.Println("Listening on port", addr)
-	log.Fatal(http.ListenAndServe(addr, server.Router))
+// fatal logs the error that keeps the server from starting and exits
+func (server *Server) fatal(msg string, err error) {
+	if server.Logger == nil {
+		log.Fatalln(msg, err)
+	}
+	server.Logger.Error(msg, logger.Fields{"error": err})
+	os.Exit(1)
+}
+
+// log is the logger of the request, carrying its request id, or the server's when the request has none
+func (server *Server) log(r *http.Request) *logger.Logger {
+	if l := logger.FromContext(r.Context()); l != nil {
+		return l
+	}
+	return server.Logger
+}
+
+// UseRepositories points the handlers at the given storage
+func (server *Server) UseRepositories(repos repository.Repositories) {
+	server.Users = repos.Users
+	server.Posts = repos.Posts
+	server.Tags = repos.Tags
+	server.Comments = repos.Comments
+	server.Tokens = repos.Tokens
+	server.Attempts = repos.Attempts
+
+	// Access tokens are checked against the revocation list on every authenticated request
+	if server.Auth == nil {
+		server.Auth = &auth.Issuer{}
+	}
+	server.Auth.Revocations = repos.Tokens
+}
+
+// repos are the repositories running their queries under ctx, so a request's queries are traced as part
+// of it
+func (server *Server) repos(ctx context.Context) repository.Repositories {
+	return repository.Repositories{
+		Users:    server.Users,
+		Posts:    server.Posts,
+		Tags:     server.Tags,
+		Comments: server.Comments,
+		Tokens:   server.Tokens,
+		Attempts: server.Attempts,
+	}.WithContext(ctx)
+}
+
+// HTTPServer is the http.Server Run listens with, serving the router on addr under the HTTP settings
+func (server *Server) HTTPServer(addr string) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           middleware.SetMiddlewareMaxBody(int64(server.HTTP.MaxBodyBytes), server.Router.ServeHTTP),
+		ReadTimeout:       server.HTTP.ReadTimeout,
+		ReadHeaderTimeout: server.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      server.HTTP.WriteTimeout,
+		IdleTimeout:       server.HTTP.IdleTimeout,
+		MaxHeaderBytes:    server.HTTP.MaxHeaderBytes,
+	}
+	if server.HTTP.TLSCertFile != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return srv
+}
+
+// Run serves on addr, over TLS when a certificate is set, until SIGINT or SIGTERM. It then stops taking
+// connections, gives in-flight requests ShutdownTimeout to finish and closes the database pool
+func (server *Server) Run(addr string) error {
+	srv := server.HTTPServer(addr)
+	server.startScheduler()
+	failed := make(chan error, 1)
+	go func() {
+		server.Logger.Info("Listening", logger.Fields{"addr": addr, "tls": server.HTTP.TLSCertFile != ""})
+		if server.HTTP.TLSCertFile != "" {
+			failed <- srv.ListenAndServeTLS(server.HTTP.TLSCertFile, server.HTTP.TLSKeyFile)
+		} else {
+			failed <- srv.ListenAndServe()
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	select {
+	case err := <-failed:
+		server.closeDB()
+		return err
+	case sig := <-quit:
+		server.Logger.Info("Shutting down", logger.Fields{"signal": sig.String()})
+	}
+	return server.Shutdown(srv)
+}
+
+// Shutdown drains srv, waiting up to ShutdownTimeout for in-flight requests, then stops the scheduler and
+// closes the database pool. Requests still running at the deadline are cut off
+func (server *Server) Shutdown(srv *http.Server) error {
+	ctx := context.Background()
+	if server.HTTP.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, server.HTTP.ShutdownTimeout)
+		defer cancel()
+	}
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		srv.Close()
+	}
+	if dbErr := server.closeDB(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// startScheduler runs the Scheduler, if there is one, until stopScheduler
+func (server *Server) startScheduler() {
+	if server.Scheduler == nil || server.schedulerStop != nil {
+		return
+	}
+	server.schedulerStop, server.schedulerDone = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(server.schedulerDone)
+		server.Scheduler.Run(server.schedulerStop)
+	}()
+}
+
+// stopScheduler stops the running Scheduler and waits for the tick in progress to finish
+func (server *Server) stopScheduler() {
+	if server.schedulerStop == nil {
+		return
+	}
+	close(server.schedulerStop)
+	<-server.schedulerDone
+	server.schedulerStop, server.schedulerDone = nil, nil
+}
+
+// closeDB stops the scheduler so no tick runs against a closed pool, then closes the database pool, if
+// there is one
+func (server *Server) closeDB() error {
+	server.stopScheduler()
+	if server.DB == nil {
+		return nil
+	}
+	return database.Close(server.DB)
 }
@@ -0,0 +1,281 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
+	"gorm.io/gorm"
+)
+
+// Comment listing views, tree pages through top level comments with their replies nested
+const (
+	commentsFlat = "flat"
+	commentsTree = "tree"
+)
+
+// commentedPost pulls the post id from the URL and returns the post when the caller may read it
+func (server *Server) commentedPost(w http.ResponseWriter, r *http.Request) (*model.Post, bool) {
+	pid, err := pathID(r, "id")
+	if err != nil {
+		response.ERROR(w, err)
+		return nil, false
+	}
+	post, err := server.repos(r.Context()).Posts.ReadPostByID(pid)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.ERROR(w, err)
+		return nil, false
+	}
+	if err != nil || !server.canRead(r, post) {
+		response.ERROR(w, &apierror.NotFound{Resource: "Post"})
+		return nil, false
+	}
+	return post, true
+}
+
+// postComment pulls the comment id from the URL and returns the comment when it is on the post
+func (server *Server) postComment(w http.ResponseWriter, r *http.Request, post *model.Post) (*model.Comment, bool) {
+	cid, err := pathID(r, "cid")
+	if err != nil {
+		response.ERROR(w, err)
+		return nil, false
+	}
+	comment, err := server.repos(r.Context()).Comments.ReadCommentByID(cid)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.ERROR(w, err)
+		return nil, false
+	}
+	if err != nil || comment.PostID != post.ID {
+		response.ERROR(w, &apierror.NotFound{Resource: "Comment"})
+		return nil, false
+	}
+	return comment, true
+}
+
+// canModerate reports whether the caller moderates the post's comments: its author, or an admin
+func canModerate(id auth.Identity, post *model.Post) bool {
+	return auth.Authorize(id, post.AuthorID, auth.ModerateRule) == nil
+}
+
+// CreateComment adds the caller's comment to the post, held for moderation unless the caller moderates the post
+func (server *Server) CreateComment(w http.ResponseWriter, r *http.Request) {
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	post, ok := server.commentedPost(w, r)
+	if !ok {
+		return
+	}
+	req := dto.CommentRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	comment := req.Model()
+	// The post and author come from the URL and the token, the status from moderation
+	comment.PostID = post.ID
+	comment.AuthorID = id.UserID
+	comment.Status = model.CommentPending
+	if canModerate(id, post) {
+		comment.Status = model.CommentApproved
+	}
+	comment.Prepare()
+	if err = comment.Validate(); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if err = auth.Authorize(id, comment.AuthorID, auth.CreateCommentRule); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	commentCreated, err := server.repos(r.Context()).Comments.CreateComment(&comment)
+	if err != nil {
+		response.ERROR(w, formaterror.FormatError(err))
+		return
+	}
+	metrics.CommentsCreated.Inc()
+	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, commentCreated.ID))
+	response.JSON(w, http.StatusCreated, dto.NewComment(commentCreated))
+}
+
+// GetComments lists a page of the post's comments, flat or as threads with ?view=tree. The public sees
+// approved comments, a signed in commenter their own too and the post's moderators every one
+func (server *Server) GetComments(w http.ResponseWriter, r *http.Request) {
+	post, ok := server.commentedPost(w, r)
+	if !ok {
+		return
+	}
+	values := r.URL.Query()
+	page, err := parsePage(values)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	q := model.CommentQuery{Page: page, PostID: post.ID}
+	if q.Status = values.Get("status"); q.Status != "" && !model.ValidCommentStatus(q.Status) {
+		response.ERROR(w, badQuery(model.ErrInvalidCommentStatus))
+		return
+	}
+	view := values.Get("view")
+	if view == "" {
+		view = commentsFlat
+	}
+	if view != commentsFlat && view != commentsTree {
+		response.ERROR(w, &apierror.BadRequest{Code: "invalid_query", Message: "Invalid View: flat or tree"})
+		return
+	}
+	if id, err := server.Auth.ExtractIdentity(r); err == nil {
+		q.Viewer = id.UserID
+		q.AllStatuses = canModerate(id, post)
+	}
+
+	q.TopLevel = view == commentsTree
+	comments, info, err := server.repos(r.Context()).Comments.ReadAllComments(q)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if view == commentsTree {
+		replies, err := server.repos(r.Context()).Comments.ReadCommentReplies(q)
+		if err != nil {
+			response.ERROR(w, err)
+			return
+		}
+		threads := model.Thread(*comments, *replies)
+		comments = &threads
+	}
+	setPageHeaders(w, info)
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewComments(*comments), Next: info.Next})
+}
+
+// UpdateComment lets the commenter change the content within the CommentEditWindow of posting it, the comment
+// is pending again unless the commenter moderates the post
+func (server *Server) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	post, ok := server.commentedPost(w, r)
+	if !ok {
+		return
+	}
+	comment, ok := server.postComment(w, r, post)
+	if !ok {
+		return
+	}
+	if err = auth.Authorize(id, comment.AuthorID, auth.UpdateCommentRule); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if !comment.Editable(time.Now(), server.CommentEditWindow) {
+		response.ERROR(w, model.ErrEditWindowClosed)
+		return
+	}
+
+	req := dto.CommentRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	commentUpdate := req.Model()
+	// Only the content changes, moderation has its own route. Changed content is held for moderation again
+	// unless the caller moderates the post
+	commentUpdate.ID = comment.ID
+	commentUpdate.PostID = comment.PostID
+	commentUpdate.AuthorID = comment.AuthorID
+	commentUpdate.Status = comment.Status
+	if !canModerate(id, post) {
+		commentUpdate.Status = model.CommentPending
+	}
+	commentUpdate.Prepare()
+	if err = commentUpdate.Validate(); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	commentUpdated, err := server.repos(r.Context()).Comments.UpdateComment(&commentUpdate)
+	if err != nil {
+		response.ERROR(w, formaterror.FormatError(err))
+		return
+	}
+	response.JSON(w, http.StatusOK, dto.NewComment(commentUpdated))
+}
+
+// UpdateCommentStatus moves a comment between pending, approved and spam, for the post's author and admins
+func (server *Server) UpdateCommentStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	post, ok := server.commentedPost(w, r)
+	if !ok {
+		return
+	}
+	comment, ok := server.postComment(w, r, post)
+	if !ok {
+		return
+	}
+	if !canModerate(id, post) {
+		response.ERROR(w, auth.ErrForbidden)
+		return
+	}
+
+	var input struct {
+		Status string `json:"status"`
+	}
+	if err = readJSON(r, &input); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if !model.ValidCommentStatus(input.Status) {
+		response.ERROR(w, model.ErrInvalidCommentStatus)
+		return
+	}
+
+	commentUpdated, err := server.repos(r.Context()).Comments.UpdateCommentStatus(comment.ID, input.Status)
+	if err != nil {
+		response.ERROR(w, notFound(err, "Comment"))
+		return
+	}
+	response.JSON(w, http.StatusOK, dto.NewComment(commentUpdated))
+}
+
+// DeleteComment removes a comment, for its author, the post's author and admins
+func (server *Server) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	post, ok := server.commentedPost(w, r)
+	if !ok {
+		return
+	}
+	comment, ok := server.postComment(w, r, post)
+	if !ok {
+		return
+	}
+	if auth.Authorize(id, comment.AuthorID, auth.DeleteCommentRule) != nil && !canModerate(id, post) {
+		response.ERROR(w, auth.ErrForbidden)
+		return
+	}
+
+	if _, err := server.repos(r.Context()).Comments.DeleteComment(comment.ID); err != nil {
+		response.ERROR(w, notFound(err, "Comment"))
+		return
+	}
+	w.Header().Set("Entity", fmt.Sprintf("%d", comment.ID))
+	response.NoBody(w, http.StatusNoContent)
+}
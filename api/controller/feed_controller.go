@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/feed"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/gorilla/mux"
+)
+
+// FeedSize is the number of newest posts a feed carries
+const FeedSize = 20
+
+// feedTitle names the blog in every feed
+const feedTitle = "GoBlog"
+
+// baseURL is where feeds say the API is reached from outside, PublicURL when set or else the request's own
+// host. Mail never uses it, see mailUserToken
+func (server *Server) baseURL(r *http.Request) string {
+	if server.PublicURL != "" {
+		return strings.TrimSuffix(server.PublicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// GetFeed serves the newest published posts as RSS, Atom or JSON Feed
+func (server *Server) GetFeed(w http.ResponseWriter, r *http.Request) {
+	server.writeFeed(w, r, model.PostQuery{}, feedTitle, "The newest posts")
+}
+
+// GetUserFeed pulls the user id from the URL and serves their newest published posts
+func (server *Server) GetUserFeed(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathID(r, "id")
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByID(uid)
+	if err != nil {
+		response.ERROR(w, notFound(err, "User"))
+		return
+	}
+	name := html.UnescapeString(user.Username)
+	server.writeFeed(w, r, model.PostQuery{AuthorID: user.ID}, feedTitle+": "+name, "The newest posts by "+name)
+}
+
+// GetTagFeed pulls the tag slug from the URL and serves the newest published posts carrying it
+func (server *Server) GetTagFeed(w http.ResponseWriter, r *http.Request) {
+	tag, err := server.repos(r.Context()).Tags.ReadTagBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		response.ERROR(w, notFound(err, "Tag"))
+		return
+	}
+	server.writeFeed(w, r, model.PostQuery{Tag: tag.Slug}, feedTitle+": "+tag.Name, "The newest posts tagged "+tag.Name)
+}
+
+// writeFeed lists the posts q matches like GetPosts does for the public, and answers in the format the
+// route's extension names. Unchanged feeds get 304 Not Modified through If-None-Match or If-Modified-Since
+func (server *Server) writeFeed(w http.ResponseWriter, r *http.Request, q model.PostQuery, title, description string) {
+	format := mux.Vars(r)["format"]
+	contentType, ok := feed.ContentTypes[format]
+	if !ok {
+		response.ERROR(w, feed.ErrUnknownFormat)
+		return
+	}
+	// Feeds are public, a signed in reader is not shown their drafts, and newest by when posts went out
+	q.Status = model.PostPublished
+	q.Page = model.Page{Limit: FeedSize, Sort: "-published_at"}
+	posts, _, err := server.repos(r.Context()).Posts.ReadAllPosts(q)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	base := server.baseURL(r)
+	f := feed.Feed{
+		Title:       title,
+		Description: description,
+		Link:        base + "/posts",
+		FeedURL:     base + r.URL.Path,
+		Items:       make([]feed.Item, 0, len(*posts)),
+	}
+	for _, p := range *posts {
+		f.Items = append(f.Items, feedItem(base, p))
+		if p.UpdatedAt.After(f.Updated) {
+			f.Updated = p.UpdatedAt
+		}
+	}
+	body, err := feed.Encode(f, format)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	// The body covers removed posts too, which leave no newer updated_at behind
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	w.Header().Set("ETag", etag)
+	if !f.Updated.IsZero() {
+		w.Header().Set("Last-Modified", f.Updated.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, f.Updated) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// feedItem describes a post for a feed, its permanent ID is the post's URL by id
+func feedItem(base string, p model.Post) feed.Item {
+	item := feed.Item{
+		ID:          fmt.Sprintf("%s/posts/%d", base, p.ID),
+		Link:        base + "/posts/by-slug/" + p.Slug,
+		Title:       html.UnescapeString(p.Title),
+		Summary:     p.Excerpt,
+		ContentHTML: p.ContentHTML,
+		Author:      html.UnescapeString(p.Author.Username),
+		Published:   p.CreatedAt,
+		Updated:     p.UpdatedAt,
+	}
+	if p.PublishedAt != nil {
+		item.Published = *p.PublishedAt
+	}
+	for _, t := range p.Tags {
+		item.Tags = append(item.Tags, t.Name)
+	}
+	return item
+}
+
+// notModified reports whether the client's copy is current. If-None-Match wins over If-Modified-Since
+// when both are sent, as RFC 7232 asks
+func notModified(r *http.Request, etag string, updated time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || updated.IsZero() {
+		return false
+	}
+	// HTTP dates have whole seconds
+	return !updated.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+)
+
+// readyTimeout bounds the database ping of a readiness check
+const readyTimeout = 2 * time.Second
+
+// healthStatus is the body of the health and readiness checks, Checks names each failed check and why
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz answers liveness checks, the process is up as long as it answers at all
+func (server *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	response.JSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// Readyz answers readiness checks. The server is ready while it can reach the database and the schema is
+// current. Servers without a database, running on the in-memory repositories, are always ready
+func (server *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	if server.DB == nil {
+		response.JSON(w, http.StatusOK, healthStatus{Status: "ok"})
+		return
+	}
+	checks := map[string]string{"database": "ok", "migrations": "ok"}
+	sqlDB, err := server.DB.DB()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		err = sqlDB.PingContext(ctx)
+		cancel()
+	}
+	if err != nil {
+		checks["database"] = err.Error()
+		checks["migrations"] = "not checked"
+	} else if err = migrate.Current(server.DB); err != nil {
+		checks["migrations"] = err.Error()
+	}
+	if err != nil {
+		response.JSON(w, http.StatusServiceUnavailable, healthStatus{Status: "unavailable", Checks: checks})
+		return
+	}
+	response.JSON(w, http.StatusOK, healthStatus{Status: "ok", Checks: checks})
+}
+
+// GetMetrics serves the metrics in the Prometheus text format
+func (server *Server) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Default.Handler(w, r)
+}
+
+// registerPoolMetrics reports the database connection pool stats on every scrape
+func (server *Server) registerPoolMetrics() {
+	sqlDB, err := server.DB.DB()
+	if err != nil {
+		return
+	}
+	for _, m := range []struct {
+		name, help, kind string
+		value            func(s sql.DBStats) float64
+	}{
+		{"goblog_db_max_open_connections", "Most connections the pool opens, 0 for no limit", metrics.GaugeKind,
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"goblog_db_open_connections", "Connections open, in use or idle", metrics.GaugeKind,
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"goblog_db_in_use_connections", "Connections running a query", metrics.GaugeKind,
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"goblog_db_idle_connections", "Connections open and waiting for a query", metrics.GaugeKind,
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"goblog_db_wait_count_total", "Queries that waited for a free connection", metrics.CounterKind,
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"goblog_db_wait_duration_seconds_total", "Time spent waiting for a free connection", metrics.CounterKind,
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	} {
+		value := m.value
+		metrics.Default.Func(m.name, m.help, m.kind, func() float64 { return value(sqlDB.Stats()) })
+	}
+}
@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+)
+
+// GetLockouts lists the logins locked out after too many failures, newest first. ?email= or ?ip= narrows it
+// to one subject
+func (server *Server) GetLockouts(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	page, err := parsePage(values)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	q := model.LockoutQuery{Page: page}
+	if email := values.Get("email"); email != "" {
+		// Escaped like the email Login counted failures for
+		user := model.User{Email: email}
+		user.Prepare()
+		q.Subject = emailSubject(user.Email)
+	} else if ip := values.Get("ip"); ip != "" {
+		q.Subject = ipSubject(ip)
+	}
+
+	lockouts, info, err := server.repos(r.Context()).Attempts.ReadAllLockouts(q)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	setPageHeaders(w, info)
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewLockouts(*lockouts), Next: info.Next})
+}
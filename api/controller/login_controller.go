@@ -1,55 +1,243 @@
 package controller
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/aaronprice00/goblog/api/auth"
-	"github.com/aaronprice00/goblog/api/response"
-	"github.com/aaronprice00/goblog/api/util/formaterror"
-	"github.com/aaronprice00/goblog/model"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
+	"gorm.io/gorm"
 )
 
 // Login ... yup
 func (server *Server) Login(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	req := dto.UserRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
 		return
 	}
-	user := model.User{}
-	if err = json.Unmarshal(body, &user); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	user := req.Model()
+
+	user.Prepare()
+	if err := user.Validate(model.UserLogin, server.UserPolicy); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	signedIn, err := server.authenticate(r.Context(), user.Email, user.Password, auth.ClientIP(r))
+	if err != nil {
+		loginError(w, err)
 		return
 	}
 
-	user.Prepare()
-	if err = user.Validate("login"); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	// Two-factor users get a challenge to finish at /login/2fa with a one time code
+	if signedIn.TwoFactor() {
+		challenge, err := server.issueUserToken(r.Context(), signedIn, model.TwoFactorPurpose)
+		if err != nil {
+			response.ERROR(w, err)
+			return
+		}
+		response.JSON(w, http.StatusOK, auth.Challenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(auth.ChallengeLifetime.Seconds()),
+		})
 		return
 	}
-	token, err := server.SignIn(user.Email, user.Password)
+	token, err := server.issueTokens(r.Context(), signedIn)
 	if err != nil {
-		formattedErr := formaterror.FormatError(err.Error())
-		response.ERROR(w, http.StatusUnprocessableEntity, formattedErr)
+		response.ERROR(w, err)
 		return
 	}
+	server.loginSucceeded(r.Context(), signedIn.Email)
 	response.JSON(w, http.StatusOK, token)
 }
 
-// SignIn creates the token
-func (server *Server) SignIn(email string, password string) (string, error) {
-	var err error
-	u := model.User{}
-	user, err := u.ReadUserByEmail(server.DB, email)
+// loginError answers a failed login, a throttled one says when to try again
+func loginError(w http.ResponseWriter, err error) {
+	if throttled, ok := err.(throttledError); ok {
+		retry := int(math.Ceil(time.Until(throttled.retryAt).Seconds()))
+		if retry < 1 {
+			retry = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	response.ERROR(w, err)
+}
+
+// SignIn checks the credentials and issues an access and refresh token pair. Two-factor users can't sign
+// in with the password alone and get model.ErrTwoFactorRequired
+func (server *Server) SignIn(email string, password string) (auth.TokenPair, error) {
+	ctx := context.Background()
+	user, err := server.authenticate(ctx, email, password, "")
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	if user.TwoFactor() {
+		return auth.TokenPair{}, model.ErrTwoFactorRequired
+	}
+	tokens, err := server.issueTokens(ctx, user)
 	if err != nil {
-		return "", err
+		return auth.TokenPair{}, err
 	}
-	err = model.VerifyPassword(user.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return "", err
+	server.loginSucceeded(ctx, user.Email)
+	return tokens, nil
+}
+
+// authenticate checks the credentials from the client at ip, empty when unknown, and returns the user. An
+// unknown email and a wrong password are both model.ErrInvalidCredentials, and too many of either slow down
+// and then lock out the email and the ip with a throttledError. When RequireVerifiedEmail is set the user
+// must have verified their email
+func (server *Server) authenticate(ctx context.Context, email, password, ip string) (*model.User, error) {
+	now := time.Now()
+	subjects := loginSubjects(email, ip)
+	if err := server.checkThrottle(ctx, subjects, now); err != nil {
+		return nil, err
+	}
+
+	user, err := server.repos(ctx).Users.ReadUserByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// An unknown email is checked against a stand in hash so it takes as long as a wrong password
+	hash := absentUserHash()
+	if err == nil {
+		hash = user.Password
+	}
+	_, span := tracing.Tracer().Start(ctx, "VerifyPassword")
+	mismatch := model.VerifyPassword(hash, password)
+	span.End()
+	if mismatch != nil || err != nil {
+		if err = server.recordLoginFailure(ctx, subjects, ip, now); err != nil {
+			return nil, err
+		}
+		metrics.LoginsFailed.Inc(metrics.InvalidCredentials)
+		return nil, model.ErrInvalidCredentials
+	}
+	if server.RequireVerifiedEmail && !user.Verified() {
+		return nil, model.ErrEmailNotVerified
+	}
+	return user, nil
+}
+
+// loginSubject is one thing a login attempt counts against, with the throttle guarding it
+type loginSubject struct {
+	key      string
+	throttle auth.Throttle
+}
+
+// loginSubjects are the email and, when known, the client address an attempt counts against
+func loginSubjects(email, ip string) []loginSubject {
+	subjects := []loginSubject{{key: emailSubject(email), throttle: auth.EmailThrottle}}
+	if ip != "" {
+		subjects = append(subjects, loginSubject{key: ipSubject(ip), throttle: auth.IPThrottle})
 	}
-	return auth.CreateToken(user.ID)
+	return subjects
+}
+
+// emailSubject is the key failed logins for the email are counted under
+func emailSubject(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// ipSubject is the key failed logins from the client address are counted under
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// throttledError is auth.ErrTooManyAttempts along with when the client may try again
+type throttledError struct {
+	retryAt time.Time
+}
+
+func (e throttledError) Error() string {
+	return auth.ErrTooManyAttempts.Error()
+}
+
+func (e throttledError) Unwrap() error {
+	return auth.ErrTooManyAttempts
+}
+
+// checkThrottle refuses an attempt made before every subject may try again
+func (server *Server) checkThrottle(ctx context.Context, subjects []loginSubject, now time.Time) error {
+	var retryAt time.Time
+	for _, s := range subjects {
+		la, err := server.repos(ctx).Attempts.ReadLoginAttempt(s.key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if at := s.throttle.RetryAt(la.Failures, la.LastFailedAt); at.After(now) && at.After(retryAt) {
+			retryAt = at
+		}
+	}
+	if !retryAt.IsZero() {
+		metrics.LoginsFailed.Inc(metrics.Throttled)
+		return throttledError{retryAt: retryAt}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed attempt against every subject and logs the lockouts it causes
+func (server *Server) recordLoginFailure(ctx context.Context, subjects []loginSubject, ip string, now time.Time) error {
+	for _, s := range subjects {
+		la, err := server.repos(ctx).Attempts.RecordLoginFailure(s.key, now, s.throttle.Window)
+		if err != nil {
+			return err
+		}
+		if !s.throttle.Locks(la.Failures) {
+			continue
+		}
+		lockout := model.Lockout{
+			Subject:     s.key,
+			IP:          ip,
+			Failures:    la.Failures,
+			LockedUntil: s.throttle.RetryAt(la.Failures, now),
+		}
+		if _, err = server.repos(ctx).Attempts.CreateLockout(&lockout); err != nil {
+			return err
+		}
+		metrics.Lockouts.Inc()
+		server.Logger.Warn("Locked out", logger.Fields{"subject": s.key, "ip": ip, "failures": la.Failures, "locked_until": lockout.LockedUntil})
+	}
+	return nil
+}
+
+// loginSucceeded forgets the failures counted against the email once the user is fully signed in. The
+// client address keeps its count so one good account can't be used to reset it
+func (server *Server) loginSucceeded(ctx context.Context, email string) {
+	metrics.LoginsSucceeded.Inc()
+	if err := server.repos(ctx).Attempts.ClearLoginAttempts(emailSubject(email)); err != nil {
+		server.Logger.Error("Could not clear failed logins", logger.Fields{"subject": emailSubject(email), "error": err})
+	}
+}
+
+var (
+	absentHashOnce sync.Once
+	absentHash     string
+)
+
+// absentUserHash is a bcrypt hash at the cost passwords are stored with, compared against when there is no user
+func absentUserHash() string {
+	absentHashOnce.Do(func() {
+		hash, err := model.Hash("absent user")
+		if err != nil {
+			log.Fatal(err)
+		}
+		absentHash = string(hash)
+	})
+	return absentHash
 }
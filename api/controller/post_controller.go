@@ -1,195 +1,271 @@
 package controller
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 
-	"github.com/aaronprice00/goblog/api/auth"
-	"github.com/aaronprice00/goblog/api/response"
-	"github.com/aaronprice00/goblog/api/util/formaterror"
-	"github.com/aaronprice00/goblog/model"
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
 // CreatePost verifies validates and authorizes before creating it
 func (server *Server) CreatePost(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
-		return
-	}
-	post := model.Post{}
-	if err = json.Unmarshal(body, &post); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	req := dto.PostRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
 		return
 	}
+	post := req.Model()
 	post.Prepare()
-	if err = post.Validate(); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	if err := post.Validate(server.MaxContentLength); err != nil {
+		response.ERROR(w, err)
 		return
 	}
-	uid, err := auth.ExtractTokenID(r)
+	id, err := server.Auth.ExtractIdentity(r)
 	if err != nil {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		response.ERROR(w, auth.ErrUnauthorized)
 		return
 	}
-	if uid != post.AuthorID {
-		response.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+	if err = auth.Authorize(id, post.AuthorID, auth.CreatePostRule); err != nil {
+		response.ERROR(w, err)
 		return
 	}
-	postCreated, err := post.CreatePost(server.DB)
+	if err = server.checkCategories(r.Context(), id, post.Categories); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	postCreated, err := server.repos(r.Context()).Posts.CreatePost(&post)
 	if err != nil {
-		formattedErr := formaterror.FormatError(err.Error())
-		response.ERROR(w, http.StatusInternalServerError, formattedErr)
+		response.ERROR(w, formaterror.FormatError(err))
 		return
 	}
+	metrics.PostsCreated.Inc()
 	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.URL.Path, postCreated.ID))
-	response.JSON(w, http.StatusCreated, postCreated)
+	response.JSON(w, http.StatusCreated, dto.NewPost(postCreated))
 }
 
-// GetPosts pulls post from model and responds via JSON
+// GetPosts reads paging, sorting and filters from the query string, pulls the page from model and responds via JSON
 func (server *Server) GetPosts(w http.ResponseWriter, r *http.Request) {
-	post := model.Post{}
-	posts, err := post.ReadAllPosts(server.DB)
+	server.listPosts(w, r, model.PostQuery{})
+}
+
+// listPosts responds with one page of the posts matching q narrowed further by the query string
+func (server *Server) listPosts(w http.ResponseWriter, r *http.Request, q model.PostQuery) {
+	values := r.URL.Query()
+	page, err := parsePage(values)
 	if err != nil {
-		response.ERROR(w, http.StatusInternalServerError, err)
+		response.ERROR(w, err)
 		return
 	}
-	response.JSON(w, http.StatusOK, posts)
+	q.Page = page
+	if authorID := values.Get("author_id"); authorID != "" {
+		aid, err := strconv.ParseUint(authorID, 10, 32)
+		if err != nil {
+			response.ERROR(w, &apierror.BadRequest{Code: "invalid_query", Message: "Invalid Author"})
+			return
+		}
+		q.AuthorID = uint(aid)
+	}
+	if q.Since, err = parseTime(values, "since"); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if q.Until, err = parseTime(values, "until"); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if q.Status = values.Get("status"); q.Status != "" && !model.ValidStatus(q.Status) {
+		response.ERROR(w, badQuery(model.ErrInvalidStatus))
+		return
+	}
+	server.setViewer(r, &q)
+
+	posts, info, err := server.repos(r.Context()).Posts.ReadAllPosts(q)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	setPageHeaders(w, info)
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewPosts(*posts), Next: info.Next})
 }
 
 // GetPost pulls id from the URL and asks model for post
 func (server *Server) GetPost(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	pid, err := strconv.ParseUint(vars["id"], 10, 64)
+	pid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, err)
 		return
 	}
-	post := model.Post{}
-
-	postReceived, err := post.ReadPostByID(server.DB, uint(pid))
+	postReceived, err := server.repos(r.Context()).Posts.ReadPostByID(pid)
 	if err != nil {
-		response.ERROR(w, http.StatusInternalServerError, err)
+		response.ERROR(w, notFound(err, "Post"))
+		return
+	}
+	if !server.canRead(r, postReceived) {
+		response.ERROR(w, &apierror.NotFound{Resource: "Post"})
 		return
 	}
 
-	response.JSON(w, http.StatusOK, postReceived)
+	response.JSON(w, http.StatusOK, dto.NewPost(postReceived))
 }
 
-// UpdatePost pulls id from url escapes, validates, and authenticates before asking model to update
-func (server *Server) UpdatePost(w http.ResponseWriter, r *http.Request) {
+// GetPostBySlug pulls slug from the URL and asks model for the post, a former slug redirects to the current one
+func (server *Server) GetPostBySlug(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	postReceived, err := server.repos(r.Context()).Posts.ReadPostBySlug(vars["slug"])
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		response.ERROR(w, err)
+		return
+	}
+	if err != nil || !server.canRead(r, postReceived) {
+		response.ERROR(w, &apierror.NotFound{Resource: "Post"})
+		return
+	}
+	if postReceived.Slug != vars["slug"] {
+		w.Header().Set("Location", "/posts/by-slug/"+url.PathEscape(postReceived.Slug))
+		response.JSON(w, http.StatusMovedPermanently, dto.NewPost(postReceived))
+		return
+	}
+
+	response.JSON(w, http.StatusOK, dto.NewPost(postReceived))
+}
 
+// setViewer applies the visibility rules to a listing: the public sees published posts, a signed in author
+// their own posts too and editors everything
+func (server *Server) setViewer(r *http.Request, q *model.PostQuery) {
+	if id, err := server.Auth.ExtractIdentity(r); err == nil {
+		q.AllStatuses = id.Role.Can(auth.ReadAnyDraft)
+		if id.Role.Can(auth.ReadOwnDraft) {
+			q.Viewer = id.UserID
+		}
+	}
+}
+
+// canRead reports whether the caller may see the post; unpublished posts are shown only to their author
+// and editors, everyone else is told it doesn't exist
+func (server *Server) canRead(r *http.Request, post *model.Post) bool {
+	if post.Published() {
+		return true
+	}
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		return false
+	}
+	return auth.Authorize(id, post.AuthorID, auth.ReadDraftRule) == nil
+}
+
+// UpdatePost pulls id from url escapes, validates, and authenticates before asking model to update
+func (server *Server) UpdatePost(w http.ResponseWriter, r *http.Request) {
 	// Is Post ID Valid?
-	pid, err := strconv.ParseUint(vars["id"], 10, 64)
+	pid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, err)
 		return
 	}
 
-	// Is auth token valid? get user id from it
-	uid, err := auth.ExtractTokenID(r)
+	// Is auth token valid? get user id and role from it
+	id, err := server.Auth.ExtractIdentity(r)
 	if err != nil {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		response.ERROR(w, auth.ErrUnauthorized)
 		return
 	}
 
 	// Does Post Exist?
-	p := model.Post{}
-	post, err := p.ReadPostByID(server.DB, uint(pid))
+	post, err := server.repos(r.Context()).Posts.ReadPostByID(pid)
 	if err != nil {
-		response.ERROR(w, http.StatusNotFound, err)
+		response.ERROR(w, notFound(err, "Post"))
 		return
 	}
 
-	// Don't allow user to update another user's post
-	if uid != post.AuthorID {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+	// Only the author, or an editor, may update the post
+	if err = auth.Authorize(id, post.AuthorID, auth.UpdatePostRule); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 
 	// Read the POST body data
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
-		return
-	}
-
-	// Start processing data
-	postUpdate := model.Post{}
-	if err = json.Unmarshal(body, &postUpdate); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	req := dto.PostRequest{}
+	if err = readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
 		return
 	}
+	postUpdate := req.Model()
 
-	// Verify Post user is the same as Token user
-	if uid != postUpdate.AuthorID {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+	// Authorship can't be handed to someone else through an update
+	if postUpdate.AuthorID != post.AuthorID {
+		response.ERROR(w, auth.ErrForbidden)
 		return
 	}
 
+	// Leaving status out keeps the post's current one
+	postUpdate.KeepStatus(post)
 	postUpdate.Prepare()
 
-	if err = postUpdate.Validate(); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	if err = postUpdate.Validate(server.MaxContentLength); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if err = server.checkCategories(r.Context(), id, postUpdate.Categories); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 
 	postUpdate.ID = post.ID // Important to ensure the model knows which post row to update
 
-	postUpdated, err := postUpdate.UpdatePost(server.DB)
+	postUpdated, err := server.repos(r.Context()).Posts.UpdatePost(&postUpdate)
 	if err != nil {
-		formattedErr := formaterror.FormatError(err.Error())
-		response.ERROR(w, http.StatusInternalServerError, formattedErr)
+		response.ERROR(w, formaterror.FormatError(err))
 		return
 	}
 
-	response.JSON(w, http.StatusOK, postUpdated)
+	response.JSON(w, http.StatusOK, dto.NewPost(postUpdated))
 }
 
 // DeletePost pulls id from URL, authenticates and asks model to delete
 func (server *Server) DeletePost(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-
 	// Is supplied post ID a number?
-	pid, err := strconv.ParseUint(vars["id"], 10, 64)
+	pid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, err)
 		return
 	}
 
 	// Is this user authenticated?
-	uid, err := auth.ExtractTokenID(r)
+	id, err := server.Auth.ExtractIdentity(r)
 	if err != nil {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		response.ERROR(w, auth.ErrUnauthorized)
 		return
 	}
 
 	// Get Post
-	p := model.Post{}
-	post, err := p.ReadPostByID(server.DB, uint(pid))
+	post, err := server.repos(r.Context()).Posts.ReadPostByID(pid)
 	if err != nil {
-		response.ERROR(w, http.StatusNotFound, err)
+		response.ERROR(w, notFound(err, "Post"))
 		return
 	}
 
-	//Does this Post belong to this user?
-	if uid != post.AuthorID {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+	// Does this Post belong to this user, or is the user an editor?
+	if err = auth.Authorize(id, post.AuthorID, auth.DeletePostRule); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 
 	// Do the Delete
-	if _, err := post.DeletePost(server.DB, uint(pid)); err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+	if _, err := server.repos(r.Context()).Posts.DeletePost(pid); err != nil {
+		response.ERROR(w, notFound(err, "Post"))
 		return
 	}
 
 	w.Header().Set("Entity", fmt.Sprintf("%d", pid))
-	response.JSON(w, http.StatusNoContent, "")
+	response.NoBody(w, http.StatusNoContent)
 }
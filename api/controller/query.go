@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// pageResponse wraps one page of a listing, Next is passed back as ?after= to get the following page
+type pageResponse struct {
+	Data interface{} `json:"data"`
+	Next string      `json:"next"`
+}
+
+// parsePage reads ?limit=, ?after= and ?sort= from the query string
+func parsePage(values url.Values) (model.Page, error) {
+	page := model.Page{
+		After: values.Get("after"),
+		Sort:  values.Get("sort"),
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return model.Page{}, &apierror.BadRequest{Code: "invalid_query", Message: "Invalid Limit"}
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
+
+// parseTime reads an RFC 3339 timestamp or a plain date from the query string, empty is the zero time
+func parseTime(values url.Values, key string) (time.Time, error) {
+	value := values.Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, &apierror.BadRequest{Code: "invalid_query", Message: "Invalid Time: " + key}
+}
+
+// setPageHeaders exposes the total count of the listing
+func setPageHeaders(w http.ResponseWriter, info model.PageInfo) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(info.Total, 10))
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// errInvalidID answers an id in the path that isn't a number
+var errInvalidID error = &apierror.BadRequest{Code: "invalid_id", Message: "Invalid ID"}
+
+// readJSON decodes the request body into v. An empty body sets no fields, so Validate names what is
+// missing. A body over SetMiddlewareMaxBody's limit is an apierror.TooLarge, one that isn't JSON an
+// apierror.BadRequest
+func readJSON(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		// http.MaxBytesReader's error has no type to check for
+		if strings.Contains(err.Error(), "request body too large") {
+			return &apierror.TooLarge{}
+		}
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return &apierror.BadRequest{Code: "invalid_json", Message: "Invalid JSON: " + err.Error()}
+	}
+	return nil
+}
+
+// pathID reads the numeric id named key from the route
+func pathID(r *http.Request, key string) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)[key], 10, 32)
+	if err != nil {
+		return 0, errInvalidID
+	}
+	return uint(id), nil
+}
+
+// notFound is an apierror.NotFound for the resource when err is a missing row, err otherwise
+func notFound(err error, resource string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &apierror.NotFound{Resource: resource}
+	}
+	return err
+}
+
+// badQuery makes a value from the query string that the model rejects as invalid, such as an unknown
+// ?status=, an apierror.BadRequest. The model's Validation errors are meant for fields of a body
+func badQuery(err error) error {
+	var v *apierror.Validation
+	if errors.As(err, &v) {
+		return &apierror.BadRequest{Code: "invalid_query", Message: err.Error()}
+	}
+	return err
+}
@@ -1,27 +1,100 @@
 package controller
 
 import (
-	m "github.com/aaronprice00/goblog/api/middleware"
+	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	m "github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
 )
 
+// limit wraps the handler in the rate limit of its route group, stricter groups guard the routes that check
+// passwords or send mail
+func (s *Server) limit(group string, next http.HandlerFunc) http.HandlerFunc {
+	return m.SetMiddlewareRateLimit(s.Limiter, s.Auth, group, next)
+}
+
+// requireToken wraps the handler in the check of the caller's access token
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return m.SetMiddlewareAuthentication(s.Auth, next)
+}
+
+// authorize wraps the handler in the check of the caller's access token against the route's rule
+func (s *Server) authorize(rule auth.Rule, next http.HandlerFunc) http.HandlerFunc {
+	return m.SetMiddlewareAuthorization(s.Auth, rule, next)
+}
+
 func (s *Server) initializeRoutes() {
+	// Every route is traced, logged under a request id and the trace id, then counted and timed for /metrics
+	s.Router.Use(func(next http.Handler) http.Handler {
+		return m.SetMiddlewareTracing(next.ServeHTTP)
+	})
+	s.Router.Use(func(next http.Handler) http.Handler {
+		return m.SetMiddlewareRequestLog(s.Logger, next.ServeHTTP)
+	})
+	s.Router.Use(func(next http.Handler) http.Handler {
+		return m.SetMiddlewareMetrics(next.ServeHTTP)
+	})
+
+	// Health Routes, left out of the rate limits so probes and scrapes are never refused
+	s.Router.HandleFunc("/healthz", m.SetMiddlewareJSON(s.Healthz)).Methods("GET")
+	s.Router.HandleFunc("/readyz", m.SetMiddlewareJSON(s.Readyz)).Methods("GET")
+	s.Router.HandleFunc("/metrics", s.GetMetrics).Methods("GET")
+
 	// Home Route
-	s.Router.HandleFunc("/", m.SetMiddlewareJSON(s.Home)).Methods("GET")
+	s.Router.HandleFunc("/", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.Home))).Methods("GET")
+
+	// Login Routes
+	s.Router.HandleFunc("/login", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.Login))).Methods("POST")
+	s.Router.HandleFunc("/login/2fa", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.LoginTwoFactor))).Methods("POST")
+	s.Router.HandleFunc("/logout", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.requireToken(s.Logout)))).Methods("POST")
+
+	s.Router.HandleFunc("/lockouts", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.ReadLockoutsRule, s.GetLockouts)))).Methods("GET")
+
+	// Token Routes
+	s.Router.HandleFunc("/token/refresh", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.RefreshToken))).Methods("POST")
 
-	// Login Route
-	s.Router.HandleFunc("/login", m.SetMiddlewareJSON(s.Login)).Methods("POST")
+	// Password Routes
+	s.Router.HandleFunc("/password/forgot", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.ForgotPassword))).Methods("POST")
+	s.Router.HandleFunc("/password/reset", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.ResetPassword))).Methods("POST")
 
 	// User Routes
-	s.Router.HandleFunc("/users", m.SetMiddlewareJSON(s.CreateUser)).Methods("POST")
-	s.Router.HandleFunc("/users", m.SetMiddlewareJSON(s.GetUsers)).Methods("GET")
-	s.Router.HandleFunc("/users/{id}", m.SetMiddlewareJSON(s.GetUser)).Methods("GET")
-	s.Router.HandleFunc("/users/{id}", m.SetMiddlewareJSON(m.SetMiddlewareAuthentication(s.UpdateUser))).Methods("PUT")
-	s.Router.HandleFunc("/users/{id}", m.SetMiddlewareJSON(s.DeleteUser)).Methods("DELETE")
+	s.Router.HandleFunc("/users", s.limit(ratelimit.Signup, m.SetMiddlewareJSON(s.CreateUser))).Methods("POST")
+	s.Router.HandleFunc("/users", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetUsers))).Methods("GET")
+	s.Router.HandleFunc("/users/verify", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.VerifyEmail))).Methods("POST")
+	s.Router.HandleFunc("/users/verify/resend", s.limit(ratelimit.Auth, m.SetMiddlewareJSON(s.ResendVerification))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetUser))).Methods("GET")
+	s.Router.HandleFunc("/users/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.UpdateUserRule, s.UpdateUser)))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.DeleteUserRule, s.DeleteUser)))).Methods("DELETE")
+	s.Router.HandleFunc("/users/{id}/role", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.ManageRoleRule, s.UpdateUserRole)))).Methods("PUT")
+	s.Router.HandleFunc("/users/{id}/2fa", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.TwoFactorRule, s.EnrollTwoFactor)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/2fa/confirm", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.TwoFactorRule, s.ConfirmTwoFactor)))).Methods("POST")
+	s.Router.HandleFunc("/users/{id}/2fa", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.UpdateUserRule, s.DisableTwoFactor)))).Methods("DELETE")
 
 	// Post Routes
-	s.Router.HandleFunc("/posts", m.SetMiddlewareJSON(s.CreatePost)).Methods("POST")
-	s.Router.HandleFunc("/posts", m.SetMiddlewareJSON(s.GetPosts)).Methods("GET")
-	s.Router.HandleFunc("/posts/{id}", m.SetMiddlewareJSON(s.GetPost)).Methods("GET")
-	s.Router.HandleFunc("/posts/{id}", m.SetMiddlewareJSON(m.SetMiddlewareAuthentication(s.UpdatePost))).Methods("PUT")
-	s.Router.HandleFunc("/posts/{id}", m.SetMiddlewareJSON(s.DeletePost)).Methods("DELETE")
+	s.Router.HandleFunc("/posts", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.CreatePostRule, s.CreatePost)))).Methods("POST")
+	s.Router.HandleFunc("/posts", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetPosts))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetPost))).Methods("GET")
+	s.Router.HandleFunc("/posts/by-slug/{slug}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetPostBySlug))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.UpdatePostRule, s.UpdatePost)))).Methods("PUT")
+	s.Router.HandleFunc("/posts/{id}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.DeletePostRule, s.DeletePost)))).Methods("DELETE")
+	s.Router.HandleFunc("/search", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.SearchPosts))).Methods("GET")
+
+	// Comment Routes
+	s.Router.HandleFunc("/posts/{id}/comments", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.CreateCommentRule, s.CreateComment)))).Methods("POST")
+	s.Router.HandleFunc("/posts/{id}/comments", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetComments))).Methods("GET")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.UpdateCommentRule, s.UpdateComment)))).Methods("PUT")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.DeleteCommentRule, s.DeleteComment)))).Methods("DELETE")
+	s.Router.HandleFunc("/posts/{id}/comments/{cid}/status", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.authorize(auth.ModerateRule, s.UpdateCommentStatus)))).Methods("PUT")
+
+	// Tag and Category Routes
+	s.Router.HandleFunc("/tags", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetTags))).Methods("GET")
+	s.Router.HandleFunc("/tags/{slug}/posts", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetTagPosts))).Methods("GET")
+	s.Router.HandleFunc("/categories", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetCategories))).Methods("GET")
+	s.Router.HandleFunc("/categories/{slug}/posts", s.limit(ratelimit.Default, m.SetMiddlewareJSON(s.GetCategoryPosts))).Methods("GET")
+
+	// Feed Routes, served as RSS, Atom or JSON Feed rather than through SetMiddlewareJSON
+	s.Router.HandleFunc("/feed.{format:rss|atom|json}", s.limit(ratelimit.Default, s.GetFeed)).Methods("GET")
+	s.Router.HandleFunc("/users/{id}/feed.{format:rss|atom|json}", s.limit(ratelimit.Default, s.GetUserFeed)).Methods("GET")
+	s.Router.HandleFunc("/tags/{slug}/feed.{format:rss|atom|json}", s.limit(ratelimit.Default, s.GetTagFeed)).Methods("GET")
 }
@@ -0,0 +1,30 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+)
+
+// SearchPosts looks for ?q= in post titles and content and responds with a page of ranked results, the
+// matched words marked in title_highlight and content_highlight. Visibility follows GetPosts
+func (server *Server) SearchPosts(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	page, err := parsePage(values)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	q := model.SearchQuery{PostQuery: model.PostQuery{Page: page}, Text: values.Get("q")}
+	server.setViewer(r, &q.PostQuery)
+
+	results, info, err := server.repos(r.Context()).Posts.SearchPosts(q)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	setPageHeaders(w, info)
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewSearchResults(*results), Next: info.Next})
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// GetTags responds with every tag and the number of published posts carrying it
+func (server *Server) GetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := server.repos(r.Context()).Tags.ReadAllTags()
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewTags(*tags)})
+}
+
+// GetTagPosts pulls the tag slug from the URL and lists its posts like GetPosts
+func (server *Server) GetTagPosts(w http.ResponseWriter, r *http.Request) {
+	tag, err := server.repos(r.Context()).Tags.ReadTagBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		response.ERROR(w, notFound(err, "Tag"))
+		return
+	}
+	server.listPosts(w, r, model.PostQuery{Tag: tag.Slug})
+}
+
+// GetCategories responds with every category and the number of published posts in it
+func (server *Server) GetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := server.repos(r.Context()).Tags.ReadAllCategories()
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, pageResponse{Data: dto.NewCategories(*categories)})
+}
+
+// GetCategoryPosts pulls the category slug from the URL and lists its posts like GetPosts
+func (server *Server) GetCategoryPosts(w http.ResponseWriter, r *http.Request) {
+	category, err := server.repos(r.Context()).Tags.ReadCategoryBySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		response.ERROR(w, notFound(err, "Category"))
+		return
+	}
+	server.listPosts(w, r, model.PostQuery{Category: category.Slug})
+}
+
+// checkCategories lets editors add categories while filing a post, everyone else picks from existing ones
+func (server *Server) checkCategories(ctx context.Context, id auth.Identity, categories []model.Category) error {
+	if id.Role.Can(auth.AddCategory) {
+		return nil
+	}
+	for _, c := range categories {
+		_, err := server.repos(ctx).Tags.ReadCategoryBySlug(c.Slug)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return apierror.Invalid("categories", apierror.CodeUnknown, "Unknown Category: "+c.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
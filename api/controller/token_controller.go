@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+)
+
+// refreshRequest is the body accepted by RefreshToken and Logout
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new token pair, the old refresh token is spent
+func (server *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	req := refreshRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		response.ERROR(w, apierror.Invalid("refresh_token", apierror.CodeRequired, "Required: Refresh Token"))
+		return
+	}
+
+	stored, err := server.repos(r.Context()).Tokens.ReadRefreshTokenByHash(auth.HashToken(req.RefreshToken))
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+
+	if stored.RevokedAt == nil && !stored.Active() {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+
+	// Rotate: the presented token and its access token are revoked before a new pair is issued
+	err = server.repos(r.Context()).Tokens.RevokeRefreshToken(stored)
+	// A spent token being replayed, spent before or by a request racing this one, means it leaked, so end
+	// every session of that user
+	if err == model.ErrTokenUsed {
+		if err = server.repos(r.Context()).Tokens.RevokeUserTokens(stored.UserID); err != nil {
+			response.ERROR(w, err)
+			return
+		}
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	// Read the user again so the new token carries the current role
+	user, err := server.repos(r.Context()).Users.ReadUserByID(stored.UserID)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	tokens, err := server.issueTokens(r.Context(), user)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, tokens)
+}
+
+// Logout revokes the access token used for the request and, when given, the refresh token
+func (server *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	uid, err := server.Auth.ExtractTokenID(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	jti, exp, err := server.Auth.ExtractTokenJTI(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+
+	// The refresh token is optional, an empty body only ends the access token
+	req := refreshRequest{}
+	if err = readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if req.RefreshToken != "" {
+		stored, err := server.repos(r.Context()).Tokens.ReadRefreshTokenByHash(auth.HashToken(req.RefreshToken))
+		if err == nil && stored.UserID == uid && stored.RevokedAt == nil {
+			err = server.repos(r.Context()).Tokens.RevokeRefreshToken(stored)
+			if err != nil && err != model.ErrTokenUsed {
+				response.ERROR(w, err)
+				return
+			}
+		}
+	}
+
+	if err = server.repos(r.Context()).Tokens.RevokeToken(jti, exp); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.NoBody(w, http.StatusNoContent)
+}
+
+// issueTokens signs a new access token and stores the refresh token paired with it
+func (server *Server) issueTokens(ctx context.Context, user *model.User) (auth.TokenPair, error) {
+	accessToken, jti, err := server.Auth.CreateToken(user.ID, auth.Role(user.Role))
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	refreshToken, err := auth.NewRefreshToken()
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	rt := model.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(refreshToken),
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(auth.RefreshTokenLifetime),
+	}
+	if _, err = server.repos(ctx).Tokens.CreateRefreshToken(&rt); err != nil {
+		return auth.TokenPair{}, err
+	}
+	return auth.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenLifetime.Seconds()),
+	}, nil
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+)
+
+// totpIssuer names the blog in authenticator apps
+const totpIssuer = "GoBlog"
+
+// Errors answering a two-factor request whose code is missing or wrong, or a user whose two-factor isn't in
+// the state the route needs
+var (
+	errCodeRequired         error = apierror.Invalid("code", apierror.CodeRequired, "Required: Code")
+	errCodeInvalid          error = apierror.Invalid("code", apierror.CodeInvalid, auth.ErrInvalidCode.Error())
+	errTwoFactorEnabled     error = &apierror.Conflict{Message: "Two-Factor Already Enabled"}
+	errTwoFactorNotEnrolled error = &apierror.Conflict{Message: "Two-Factor Not Enrolled"}
+	errChallengeRequired    error = apierror.Invalid("challenge_token", apierror.CodeRequired, "Required: Challenge Token")
+)
+
+// twoFactorRequest is the body accepted by the two-factor routes, a one time code or a recovery code proves
+// the second factor
+type twoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// readTwoFactorRequest decodes the body, an empty one is allowed for routes that may not need a code
+func readTwoFactorRequest(w http.ResponseWriter, r *http.Request) (twoFactorRequest, bool) {
+	req := twoFactorRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return req, false
+	}
+	return req, true
+}
+
+// twoFactorUser pulls the user id from the URL, checks the caller may manage that user's two-factor under
+// the rule and returns the user
+func (server *Server) twoFactorUser(w http.ResponseWriter, r *http.Request, rule auth.Rule) (auth.Identity, *model.User, bool) {
+	uid, err := pathID(r, "id")
+	if err != nil {
+		response.ERROR(w, err)
+		return auth.Identity{}, nil, false
+	}
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return auth.Identity{}, nil, false
+	}
+	if err = auth.Authorize(id, uid, rule); err != nil {
+		response.ERROR(w, err)
+		return auth.Identity{}, nil, false
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByID(uid)
+	if err != nil {
+		response.ERROR(w, notFound(err, "User"))
+		return auth.Identity{}, nil, false
+	}
+	return id, user, true
+}
+
+// checkSecondFactor spends the user's one time code, or recovery code when no code is given. Codes that are
+// wrong, used already or replayed are auth.ErrInvalidCode
+func (server *Server) checkSecondFactor(ctx context.Context, user *model.User, req twoFactorRequest) error {
+	if req.Code == "" && req.RecoveryCode == "" {
+		return errCodeRequired
+	}
+	var err error
+	if req.Code != "" {
+		var step int64
+		if step, err = auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastStep); err == nil {
+			err = server.repos(ctx).Users.UseTOTPStep(user.ID, step)
+		}
+	} else {
+		err = server.repos(ctx).Users.UseRecoveryCode(user.ID, auth.HashToken(auth.NormalizeRecoveryCode(req.RecoveryCode)))
+	}
+	if err == model.ErrTokenUsed {
+		return auth.ErrInvalidCode
+	}
+	return err
+}
+
+// EnrollTwoFactor gives the user a new TOTP secret and its otpauth:// URI for their authenticator app.
+// Two-factor stays off until ConfirmTwoFactor sees a code from it
+func (server *Server) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := server.twoFactorUser(w, r, auth.TwoFactorRule)
+	if !ok {
+		return
+	}
+	if user.TwoFactor() {
+		response.ERROR(w, errTwoFactorEnabled)
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if err = server.repos(r.Context()).Users.SetTOTPSecret(user.ID, secret); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, html.UnescapeString(user.Email), secret),
+	})
+}
+
+// ConfirmTwoFactor turns two-factor on once given a code from the enrolled authenticator and responds with
+// the recovery codes, the only time they are shown
+func (server *Server) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := server.twoFactorUser(w, r, auth.TwoFactorRule)
+	if !ok {
+		return
+	}
+	req, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if user.TwoFactor() {
+		response.ERROR(w, errTwoFactorEnabled)
+		return
+	}
+	if user.TOTPSecret == "" {
+		response.ERROR(w, errTwoFactorNotEnrolled)
+		return
+	}
+	if req.Code == "" {
+		response.ERROR(w, errCodeRequired)
+		return
+	}
+	step, err := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), 0)
+	if err == auth.ErrInvalidCode {
+		response.ERROR(w, errCodeInvalid)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashToken(code)
+	}
+	if err = server.repos(r.Context()).Users.EnableTOTP(user.ID, step, hashes); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns two-factor off. Users prove they still hold a second factor, admins may turn it off
+// for a user who lost theirs
+func (server *Server) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, user, ok := server.twoFactorUser(w, r, auth.UpdateUserRule)
+	if !ok {
+		return
+	}
+	req, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if !user.TwoFactor() && user.TOTPSecret == "" {
+		response.ERROR(w, errTwoFactorNotEnrolled)
+		return
+	}
+	if id.UserID == user.ID && user.TwoFactor() {
+		err := server.checkSecondFactor(r.Context(), user, req)
+		if err == auth.ErrInvalidCode {
+			response.ERROR(w, errCodeInvalid)
+			return
+		}
+		if err != nil {
+			response.ERROR(w, err)
+			return
+		}
+	}
+	if err := server.repos(r.Context()).Users.DisableTOTP(user.ID); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.NoBody(w, http.StatusNoContent)
+}
+
+// LoginTwoFactor exchanges the challenge token Login handed out, and a one time or recovery code, for an
+// access and refresh token pair
+func (server *Server) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	req, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.ChallengeToken == "" {
+		response.ERROR(w, errChallengeRequired)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		response.ERROR(w, errCodeRequired)
+		return
+	}
+
+	// The challenge survives a mistyped code, it is spent once the code is right
+	challenge, err := server.checkUserToken(r.Context(), req.ChallengeToken, model.TwoFactorPurpose)
+	if err != nil {
+		response.ERROR(w, auth.ErrInvalidToken)
+		return
+	}
+	user, err := server.repos(r.Context()).Users.ReadUserByID(challenge.UserID)
+	if err != nil || !user.TwoFactor() {
+		response.ERROR(w, auth.ErrInvalidToken)
+		return
+	}
+
+	// Wrong codes count against the account like wrong passwords, so the challenge can't be used to guess
+	now := time.Now()
+	subjects := loginSubjects(user.Email, auth.ClientIP(r))
+	if err = server.checkThrottle(r.Context(), subjects, now); err != nil {
+		loginError(w, err)
+		return
+	}
+	err = server.checkSecondFactor(r.Context(), user, req)
+	if err == auth.ErrInvalidCode {
+		if err = server.recordLoginFailure(r.Context(), subjects, auth.ClientIP(r), now); err != nil {
+			response.ERROR(w, err)
+			return
+		}
+		metrics.LoginsFailed.Inc(metrics.InvalidCode)
+		response.ERROR(w, auth.ErrInvalidCode)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	err = server.repos(r.Context()).Tokens.UseUserToken(challenge)
+	if err == model.ErrTokenUsed {
+		response.ERROR(w, auth.ErrInvalidToken)
+		return
+	}
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	tokens, err := server.issueTokens(r.Context(), user)
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	server.loginSucceeded(r.Context(), user.Email)
+	response.JSON(w, http.StatusOK, tokens)
+}
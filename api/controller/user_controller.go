@@ -1,144 +1,235 @@
 package controller
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"strconv"
 
-	"github.com/aaronprice00/goblog/api/auth"
-	"github.com/aaronprice00/goblog/api/response"
-	"github.com/aaronprice00/goblog/api/util/formaterror"
-	"github.com/aaronprice00/goblog/model"
-	"github.com/gorilla/mux"
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
 )
 
 // CreateUser escapes, validates, authenticates before asking model to create
 func (server *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
-	}
-	user := model.User{}
-	if err = json.Unmarshal(body, &user); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	req := dto.UserRequest{}
+	if err := readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
 		return
 	}
+	user := req.Model()
 
 	// Trim whitespaces and escape Username and Email
 	user.Prepare()
 
-	if err = user.Validate(""); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	// New accounts can't pick their own role, admins promote through UpdateUserRole
+	user.Role = string(auth.DefaultRole)
+
+	if err := user.Validate(model.UserCreate, server.UserPolicy); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 
-	userCreated, err := user.CreateUser(server.DB)
+	userCreated, err := server.repos(r.Context()).Users.CreateUser(&user)
 	if err != nil {
-		formattedErr := formaterror.FormatError(err.Error())
-		response.ERROR(w, http.StatusInternalServerError, formattedErr)
+		response.ERROR(w, formaterror.FormatError(err))
 		return
 	}
+	metrics.UsersCreated.Inc()
+
+	server.sendVerification(r, userCreated)
 
 	// Location response header indicates the URL to redirect a page to
 	w.Header().Set("Location", fmt.Sprintf("%s%s/%d", r.Host, r.RequestURI, userCreated.ID))
-	response.JSON(w, http.StatusCreated, userCreated)
+	response.JSON(w, http.StatusCreated, dto.NewUserSelf(userCreated))
 
 }
 
-// GetUsers asks model for list then responds with JSON
+// GetUsers reads paging and sorting from the query string, asks model for the page then responds with JSON
 func (server *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
-	user := model.User{}
-	users, err := user.ReadAllUsers(server.DB)
+	page, err := parsePage(r.URL.Query())
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	users, info, err := server.repos(r.Context()).Users.ReadAllUsers(model.UserQuery{Page: page})
 	if err != nil {
-		response.ERROR(w, http.StatusInternalServerError, err)
+		response.ERROR(w, err)
 		return
 	}
-	response.JSON(w, http.StatusOK, users)
+	viewOf := server.userViewer(r)
+	views := make([]interface{}, len(*users))
+	for i, u := range *users {
+		views[i] = dto.NewUserView(&u, viewOf(u.ID))
+	}
+	setPageHeaders(w, info)
+	response.JSON(w, http.StatusOK, pageResponse{Data: views, Next: info.Next})
 }
 
 // GetUser grabs ID from the URL before asking model for the User
 func (server *Server) GetUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	uid, err := strconv.ParseUint(vars["id"], 10, 32)
+	uid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, err)
 		return
 	}
-	user := model.User{}
-	userReceived, err := user.ReadUserByID(server.DB, uint(uid))
+	userReceived, err := server.repos(r.Context()).Users.ReadUserByID(uid)
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, notFound(err, "User"))
 		return
 	}
-	response.JSON(w, http.StatusOK, userReceived)
+	response.JSON(w, http.StatusOK, dto.NewUserView(userReceived, server.userViewer(r)(uid)))
+}
+
+// userViewer decides how much of each user the caller sees: admins every user's account, a signed in user
+// their own and everyone else the public view
+func (server *Server) userViewer(r *http.Request) func(uid uint) dto.View {
+	id, err := server.Auth.ExtractIdentity(r)
+	return func(uid uint) dto.View {
+		switch {
+		case err != nil:
+			return dto.Public
+		case id.Role.Can(auth.ReadAnyUser):
+			return dto.Admin
+		case id.UserID == uid:
+			return dto.Self
+		}
+		return dto.Public
+	}
 }
 
 // UpdateUser grabs id from url, escapes, validates, and authenticates before asking model to update
 func (server *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	uid, err := strconv.ParseUint(vars["id"], 10, 32)
-	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
-		return
-	}
-	body, err := ioutil.ReadAll(r.Body)
+	uid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+		response.ERROR(w, err)
 		return
 	}
-	user := model.User{}
-	if err = json.Unmarshal(body, &user); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	req := dto.UserRequest{}
+	if err = readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
 		return
 	}
-	tokenID, err := auth.ExtractTokenID(r)
+	user := req.Model()
+	id, err := server.Auth.ExtractIdentity(r)
 	if err != nil {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		response.ERROR(w, auth.ErrUnauthorized)
 		return
 	}
-	if tokenID != uint(uid) {
-		response.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+	if err = auth.Authorize(id, uid, auth.UpdateUserRule); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 	user.Prepare()
-	if err = user.Validate("update"); err != nil {
-		response.ERROR(w, http.StatusUnprocessableEntity, err)
+	if err = user.Validate(model.UserUpdate, server.UserPolicy); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+
+	// Remember whether the password changes, every session is ended if it does
+	current, err := server.repos(r.Context()).Users.ReadUserByID(uid)
+	if err != nil {
+		response.ERROR(w, notFound(err, "User"))
 		return
 	}
-	updatedUser, err := user.UpdateUser(server.DB, uint(uid))
+	passwordChanged := model.VerifyPassword(current.Password, user.Password) != nil
+	if passwordChanged {
+		if err = server.UserPolicy.ValidatePassword(user.Password); err != nil {
+			response.ERROR(w, err)
+			return
+		}
+	}
+
+	updatedUser, err := server.repos(r.Context()).Users.UpdateUser(uid, &user)
 	if err != nil {
-		formattedErr := formaterror.FormatError(err.Error())
-		response.ERROR(w, http.StatusInternalServerError, formattedErr)
+		response.ERROR(w, formaterror.FormatError(err))
 		return
 	}
-	response.JSON(w, http.StatusOK, updatedUser)
+	if passwordChanged {
+		if err = server.repos(r.Context()).Tokens.RevokeUserTokens(uid); err != nil {
+			response.ERROR(w, err)
+			return
+		}
+	}
+	// The update unverified a new email, its owner is mailed a token to verify it again
+	if updatedUser.Email != current.Email {
+		server.sendVerification(r, updatedUser)
+	}
+	response.JSON(w, http.StatusOK, dto.NewUserView(updatedUser, server.userViewer(r)(uid)))
 }
 
 // DeleteUser pulls id from url and authenticates before asking model to delete responds via http JSON
 func (server *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	user := model.User{}
-	uid, err := strconv.ParseUint(vars["id"], 10, 32)
+	uid, err := pathID(r, "id")
 	if err != nil {
-		response.ERROR(w, http.StatusBadRequest, err)
+		response.ERROR(w, err)
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(r)
+	id, err := server.Auth.ExtractIdentity(r)
 	if err != nil {
-		response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
 	}
-	if tokenID != 0 && tokenID != uint(uid) {
-		response.ERROR(w, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+	if err = auth.Authorize(id, uid, auth.DeleteUserRule); err != nil {
+		response.ERROR(w, err)
+		return
 	}
 
-	if _, err := user.DeleteUser(server.DB, uint(uid)); err != nil {
-		response.ERROR(w, http.StatusInternalServerError, err)
+	if _, err := server.repos(r.Context()).Users.DeleteUser(uid); err != nil {
+		response.ERROR(w, notFound(err, "User"))
+		return
+	}
+	if err := server.repos(r.Context()).Tokens.RevokeUserTokens(uid); err != nil {
+		response.ERROR(w, err)
 		return
 	}
 	w.Header().Set("Entity", fmt.Sprintf("%d", uid))
-	response.JSON(w, http.StatusNoContent, "")
+	response.NoBody(w, http.StatusNoContent)
+}
+
+// roleRequest is the body accepted by UpdateUserRole
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+// UpdateUserRole lets an admin change another user's role, the user has to log in again to pick it up
+func (server *Server) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	uid, err := pathID(r, "id")
+	if err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	id, err := server.Auth.ExtractIdentity(r)
+	if err != nil {
+		response.ERROR(w, auth.ErrUnauthorized)
+		return
+	}
+	if err = auth.Authorize(id, uid, auth.ManageRoleRule); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	req := roleRequest{}
+	if err = readJSON(r, &req); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		response.ERROR(w, apierror.Invalid("role", apierror.CodeInvalid, "Invalid Role"))
+		return
+	}
+	updatedUser, err := server.repos(r.Context()).Users.UpdateRole(uid, req.Role)
+	if err != nil {
+		response.ERROR(w, notFound(err, "User"))
+		return
+	}
+	// Outstanding tokens still carry the old role
+	if err = server.repos(r.Context()).Tokens.RevokeUserTokens(uid); err != nil {
+		response.ERROR(w, err)
+		return
+	}
+	response.JSON(w, http.StatusOK, dto.NewUserAdmin(updatedUser))
 }
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Supported values for DB_DRIVER
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Pool tunes the connection pool, zero leaves a setting unlimited
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// gormConfig silences GORM's own logger, its plain text lines would break up the JSON log and its slow query
+// warnings print the values bound to them, password hashes included. Queries are traced instead
+func gormConfig() *gorm.Config {
+	return &gorm.Config{Logger: gormlogger.Discard}
+}
+
+// Open connects to the database named by driver. Postgres uses host, port, user, name and password;
+// SQLite only uses name, a file path or :memory:
+func Open(driver, user, password, port, host, name string) (*gorm.DB, error) {
+	switch strings.ToLower(driver) {
+	case "", Postgres:
+		dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", host, port, user, name, password)
+		return gorm.Open(postgres.Open(dsn), gormConfig())
+	case SQLite, "sqlite3":
+		return openSQLite(name)
+	}
+	return nil, fmt.Errorf("Unknown DB_DRIVER: %s", driver)
+}
+
+// openSQLite opens the file with foreign keys enforced like they are on Postgres
+func openSQLite(path string) (*gorm.DB, error) {
+	if path == "" {
+		return nil, errors.New("Required: DB_NAME as the SQLite file path or :memory:")
+	}
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on", path)
+	db, err := gorm.Open(sqlite.Open(dsn), gormConfig())
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time and every connection to :memory: is a new empty database,
+	// so both are served by a single connection
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Tune applies the pool settings. SQLite is left on its single connection, closing it would lose a
+// :memory: database
+func Tune(db *gorm.DB, p Pool) error {
+	if db.Dialector.Name() == SQLite {
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDB.SetMaxOpenConns(p.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(p.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(p.ConnMaxLifetime)
+	return nil
+}
+
+// Close closes the connection pool, waiting for queries already running to finish
+func Close(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,62 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// Comment is a comment as it is answered, its author in the public view and its replies nested when
+// listed as a tree
+type Comment struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PostID    uint      `json:"post_id"`
+	ParentID  *uint     `json:"parent_id"`
+	Content   string    `json:"content"`
+	Status    string    `json:"status"`
+	AuthorID  uint      `json:"author_id"`
+	Author    User      `json:"author"`
+	Replies   []Comment `json:"replies,omitempty"`
+}
+
+// NewComment is the comment as it is answered
+func NewComment(c *model.Comment) Comment {
+	out := Comment{
+		ID:        c.ID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		PostID:    c.PostID,
+		ParentID:  c.ParentID,
+		Content:   c.Content,
+		Status:    c.Status,
+		AuthorID:  c.AuthorID,
+		Author:    NewUser(&c.Author),
+	}
+	if len(c.Replies) > 0 {
+		out.Replies = NewComments(c.Replies)
+	}
+	return out
+}
+
+// NewComments answers each of the comments
+func NewComments(comments []model.Comment) []Comment {
+	out := make([]Comment, len(comments))
+	for i := range comments {
+		out[i] = NewComment(&comments[i])
+	}
+	return out
+}
+
+// CommentRequest is the body accepted by CreateComment and UpdateComment, the post, author and status come
+// from the URL, the token and moderation
+type CommentRequest struct {
+	ParentID *uint  `json:"parent_id"`
+	Content  string `json:"content"`
+}
+
+// Model is the comment the request describes
+func (req CommentRequest) Model() model.Comment {
+	return model.Comment{ParentID: req.ParentID, Content: req.Content}
+}
@@ -0,0 +1,27 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// Lockout is a lockout as admins reviewing them see it
+type Lockout struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Subject     string    `json:"subject"`
+	IP          string    `json:"ip"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// NewLockouts answers each of the lockouts
+func NewLockouts(lockouts []model.Lockout) []Lockout {
+	out := make([]Lockout, len(lockouts))
+	for i, l := range lockouts {
+		out[i] = Lockout{ID: l.ID, CreatedAt: l.CreatedAt, Subject: l.Subject, IP: l.IP, Failures: l.Failures,
+			LockedUntil: l.LockedUntil}
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// Post is a post as it is answered, its author in the public view
+type Post struct {
+	ID          uint       `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	Content     string     `json:"content"`
+	ContentHTML string     `json:"content_html"`
+	Excerpt     string     `json:"excerpt"`
+	Status      string     `json:"status"`
+	PublishedAt *time.Time `json:"published_at"`
+	AuthorID    uint       `json:"author_id"`
+	Author      User       `json:"author"`
+	Tags        []Term     `json:"tags"`
+	Categories  []Term     `json:"categories"`
+}
+
+// Term is a tag or a category, PostCount is set by the listings that count posts
+type Term struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	PostCount *int64    `json:"post_count,omitempty"`
+}
+
+// SearchResult is one post a search found, with its rank and HTML highlighting the matched words
+type SearchResult struct {
+	Post             Post    `json:"post"`
+	Rank             float64 `json:"rank"`
+	TitleHighlight   string  `json:"title_highlight"`
+	ContentHighlight string  `json:"content_highlight"`
+}
+
+// NewPost is the post as it is answered
+func NewPost(p *model.Post) Post {
+	return Post{
+		ID:          p.ID,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+		Title:       p.Title,
+		Slug:        p.Slug,
+		Content:     p.Content,
+		ContentHTML: p.ContentHTML,
+		Excerpt:     p.Excerpt,
+		Status:      p.Status,
+		PublishedAt: p.PublishedAt,
+		AuthorID:    p.AuthorID,
+		Author:      NewUser(&p.Author),
+		Tags:        NewTags(p.Tags),
+		Categories:  NewCategories(p.Categories),
+	}
+}
+
+// NewPosts answers each of the posts
+func NewPosts(posts []model.Post) []Post {
+	out := make([]Post, len(posts))
+	for i := range posts {
+		out[i] = NewPost(&posts[i])
+	}
+	return out
+}
+
+// NewTags answers each of the tags
+func NewTags(tags []model.Tag) []Term {
+	out := make([]Term, len(tags))
+	for i, t := range tags {
+		out[i] = Term{ID: t.ID, CreatedAt: t.CreatedAt, Name: t.Name, Slug: t.Slug, PostCount: t.PostCount}
+	}
+	return out
+}
+
+// NewCategories answers each of the categories
+func NewCategories(categories []model.Category) []Term {
+	out := make([]Term, len(categories))
+	for i, c := range categories {
+		out[i] = Term{ID: c.ID, CreatedAt: c.CreatedAt, Name: c.Name, Slug: c.Slug, PostCount: c.PostCount}
+	}
+	return out
+}
+
+// NewSearchResults answers each of the results
+func NewSearchResults(results []model.SearchResult) []SearchResult {
+	out := make([]SearchResult, len(results))
+	for i, res := range results {
+		out[i] = SearchResult{Post: NewPost(&res.Post), Rank: res.Rank, TitleHighlight: res.TitleHighlight,
+			ContentHighlight: res.ContentHighlight}
+	}
+	return out
+}
+
+// PostRequest is the body accepted by CreatePost and UpdatePost. Leaving out tags or categories, rather
+// than sending an empty list, keeps the ones an updated post has
+type PostRequest struct {
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	Content     string     `json:"content"`
+	Status      string     `json:"status"`
+	PublishedAt *time.Time `json:"published_at"`
+	AuthorID    uint       `json:"author_id"`
+	Tags        TermNames  `json:"tags"`
+	Categories  TermNames  `json:"categories"`
+}
+
+// TermNames are the names of a post's tags or categories, sent bare or as the objects posts are answered
+// with
+type TermNames []string
+
+// UnmarshalJSON accepts each term as its name or as an object with a name
+func (t *TermNames) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil || raw == nil {
+		return err
+	}
+	names := make(TermNames, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &names[i]); err == nil {
+			continue
+		}
+		var term struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(r, &term); err != nil {
+			return err
+		}
+		names[i] = term.Name
+	}
+	*t = names
+	return nil
+}
+
+// Model is the post the request describes
+func (req PostRequest) Model() model.Post {
+	p := model.Post{Title: req.Title, Slug: req.Slug, Content: req.Content, Status: req.Status,
+		PublishedAt: req.PublishedAt, AuthorID: req.AuthorID}
+	if req.Tags != nil {
+		p.Tags = make([]model.Tag, len(req.Tags))
+		for i, name := range req.Tags {
+			p.Tags[i] = model.Tag{Name: name}
+		}
+	}
+	if req.Categories != nil {
+		p.Categories = make([]model.Category, len(req.Categories))
+		for i, name := range req.Categories {
+			p.Categories[i] = model.Category{Name: name}
+		}
+	}
+	return p
+}
@@ -0,0 +1,78 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// View decides how much of a user the caller sees. No view has the password
+type View int
+
+// Views from least to most revealing
+const (
+	Public View = iota // anyone, without the email
+	Self               // the user themself, with their email and account state
+	Admin              // admins, with everything Self has and when the user last changed
+)
+
+// User is a user as anyone may see them, posts and comments carry their author this way
+type User struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+}
+
+// UserSelf is a user as they see themselves
+type UserSelf struct {
+	User
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at"`
+}
+
+// UserAdmin is a user as admins see them
+type UserAdmin struct {
+	UserSelf
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewUser is the public view of the user
+func NewUser(u *model.User) User {
+	return User{ID: u.ID, CreatedAt: u.CreatedAt, Username: u.Username, Role: u.Role}
+}
+
+// NewUserSelf is the user as they see themselves
+func NewUserSelf(u *model.User) UserSelf {
+	return UserSelf{User: NewUser(u), Email: u.Email, EmailVerifiedAt: u.EmailVerifiedAt, TOTPEnabledAt: u.TOTPEnabledAt}
+}
+
+// NewUserAdmin is the user as admins see them
+func NewUserAdmin(u *model.User) UserAdmin {
+	return UserAdmin{UserSelf: NewUserSelf(u), UpdatedAt: u.UpdatedAt}
+}
+
+// NewUserView is the user in the view, one of User, UserSelf or UserAdmin
+func NewUserView(u *model.User, view View) interface{} {
+	switch view {
+	case Admin:
+		return NewUserAdmin(u)
+	case Self:
+		return NewUserSelf(u)
+	}
+	return NewUser(u)
+}
+
+// UserRequest is the body accepted by CreateUser, UpdateUser and Login, the role and account state are
+// never taken from it
+type UserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Model is the user the request describes
+func (req UserRequest) Model() model.User {
+	return model.User{Username: req.Username, Email: req.Email, Password: req.Password}
+}
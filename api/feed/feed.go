@@ -0,0 +1,253 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+)
+
+// Formats a feed is served in, the extension of its route
+const (
+	RSS  = "rss"
+	Atom = "atom"
+	JSON = "json"
+)
+
+// ErrUnknownFormat is returned for a format that isn't one of the above
+var ErrUnknownFormat error = &apierror.NotFound{Resource: "Feed Format"}
+
+// ContentTypes maps each format to the media type it is served as
+var ContentTypes = map[string]string{
+	RSS:  "application/rss+xml; charset=utf-8",
+	Atom: "application/atom+xml; charset=utf-8",
+	JSON: "application/feed+json; charset=utf-8",
+}
+
+// Feed is a format neutral list of posts; URLs are absolute and Updated is the latest change to any item
+type Feed struct {
+	Title       string
+	Description string
+	Link        string
+	FeedURL     string
+	Updated     time.Time
+	Items       []Item
+}
+
+// Item is one post in a Feed, ID is a permanent URL that survives the post changing its slug
+type Item struct {
+	ID          string
+	Link        string
+	Title       string
+	Summary     string
+	ContentHTML string
+	Author      string
+	Tags        []string
+	Published   time.Time
+	Updated     time.Time
+}
+
+// Encode renders the feed in the format
+func Encode(f Feed, format string) ([]byte, error) {
+	switch format {
+	case RSS:
+		return encodeRSS(f)
+	case Atom:
+		return encodeAtom(f)
+	case JSON:
+		return encodeJSON(f)
+	}
+	return nil, ErrUnknownFormat
+}
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+func encodeRSS(f Feed) ([]byte, error) {
+	doc := rssDoc{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Href: f.FeedURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	for _, it := range f.Items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       it.Title,
+			Link:        it.Link,
+			GUID:        rssGUID{IsPermaLink: false, Value: it.ID},
+			PubDate:     it.Published.UTC().Format(time.RFC1123Z),
+			Creator:     it.Author,
+			Categories:  it.Tags,
+			Description: it.ContentHTML,
+		})
+	}
+	return marshalXML(doc)
+}
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Author     *atomPerson    `xml:"author,omitempty"`
+	Link       atomLink       `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    atomText       `xml:"summary"`
+	Content    atomText       `xml:"content"`
+}
+
+func encodeAtom(f Feed) ([]byte, error) {
+	doc := atomDoc{
+		ID:      f.FeedURL,
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.FeedURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate"},
+		},
+	}
+	for _, it := range f.Items {
+		entry := atomEntry{
+			ID:        it.ID,
+			Title:     it.Title,
+			Updated:   it.Updated.UTC().Format(time.RFC3339),
+			Published: it.Published.UTC().Format(time.RFC3339),
+			Link:      atomLink{Href: it.Link, Rel: "alternate"},
+			Summary:   atomText{Type: "text", Value: it.Summary},
+			Content:   atomText{Type: "html", Value: it.ContentHTML},
+		}
+		if it.Author != "" {
+			entry.Author = &atomPerson{Name: it.Author}
+		}
+		for _, tag := range it.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshalXML(doc)
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+type jsonFeed struct {
+	Version     string     `json:"version"`
+	Title       string     `json:"title"`
+	HomePageURL string     `json:"home_page_url"`
+	FeedURL     string     `json:"feed_url"`
+	Description string     `json:"description,omitempty"`
+	Items       []jsonItem `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonItem struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url"`
+	Title         string       `json:"title"`
+	ContentHTML   string       `json:"content_html"`
+	Summary       string       `json:"summary,omitempty"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Authors       []jsonAuthor `json:"authors,omitempty"`
+	Tags          []string     `json:"tags,omitempty"`
+}
+
+func encodeJSON(f Feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.FeedURL,
+		Description: f.Description,
+		Items:       []jsonItem{},
+	}
+	for _, it := range f.Items {
+		item := jsonItem{
+			ID:            it.ID,
+			URL:           it.Link,
+			Title:         it.Title,
+			ContentHTML:   it.ContentHTML,
+			Summary:       it.Summary,
+			DatePublished: it.Published.UTC().Format(time.RFC3339),
+			DateModified:  it.Updated.UTC().Format(time.RFC3339),
+			Tags:          it.Tags,
+		}
+		if it.Author != "" {
+			item.Authors = []jsonAuthor{{Name: it.Author}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,170 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how serious an entry is, entries below the Logger's level are dropped
+type Level int
+
+// Levels from least to most serious
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("Invalid Log Level: %s", s)
+}
+
+// Fields are the key and values logged along with a message
+type Fields map[string]interface{}
+
+// Logger writes one JSON object per line with the time, level, message and fields of each entry. A nil
+// *Logger drops everything, so it is safe to leave unset
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	fields Fields
+	// Now is the clock entries are stamped with, tests may replace it
+	Now func() time.Time
+}
+
+// New returns a Logger writing entries at level or above to out
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level, Now: time.Now}
+}
+
+// With returns a Logger that adds the fields to every entry, on top of those of l
+func (l *Logger) With(fields Fields) *Logger {
+	if l == nil {
+		return nil
+	}
+	child := *l
+	child.fields = merge(l.fields, fields)
+	return &child
+}
+
+// Enabled reports whether entries at level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debug logs detail only useful while tracking something down
+func (l *Logger) Debug(msg string, fields ...Fields) { l.log(Debug, msg, fields) }
+
+// Info logs the normal running of the server
+func (l *Logger) Info(msg string, fields ...Fields) { l.log(Info, msg, fields) }
+
+// Warn logs something that went wrong without failing a request, or a client misbehaving
+func (l *Logger) Warn(msg string, fields ...Fields) { l.log(Warn, msg, fields) }
+
+// Error logs a failure the server should not have had
+func (l *Logger) Error(msg string, fields ...Fields) { l.log(Error, msg, fields) }
+
+func (l *Logger) log(level Level, msg string, fields []Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	all := l.fields
+	for _, f := range fields {
+		all = merge(all, f)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, l.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSON(buf, msg)
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		if k != "time" && k != "level" && k != "msg" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		writeJSON(buf, k)
+		buf.WriteByte(':')
+		writeJSON(buf, all[k])
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+// contextKey holds the Logger NewContext puts in a context
+type contextKey struct{}
+
+// NewContext returns ctx carrying l, for the handlers of a request to log with its fields
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext is the Logger NewContext put in ctx, nil when there is none
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
+
+// merge returns the fields of a overlaid with b, neither is changed
+func merge(a, b Fields) Fields {
+	if len(b) == 0 {
+		return a
+	}
+	out := make(Fields, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// writeJSON encodes v, errors as their message and durations as text, and anything that can't be
+// encoded as its fmt form
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = t.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
@@ -0,0 +1,163 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Drivers a Mailer can be built with
+const (
+	SMTP = "smtp"
+	File = "file"
+	Log  = "log"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. SMTPMailer delivers it, LogMailer writes it out for development and Recorder keeps it
+// for tests
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config picks and sets up a Mailer, only the fields of the Driver are used
+type Config struct {
+	Driver   string
+	From     string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Path     string
+}
+
+// New returns the Mailer the config names, log when Driver is empty
+func New(c Config) (Mailer, error) {
+	switch c.Driver {
+	case SMTP:
+		if c.Host == "" || c.From == "" {
+			return nil, errors.New("Required: SMTP host and from address")
+		}
+		return &SMTPMailer{Host: c.Host, Port: c.Port, Username: c.Username, Password: c.Password, From: c.From}, nil
+	case File:
+		if c.Path == "" {
+			return nil, errors.New("Required: mail file path")
+		}
+		f, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogMailer(f, c.From), nil
+	case Log, "":
+		return NewLogMailer(os.Stdout, c.From), nil
+	}
+	return nil, fmt.Errorf("Unknown Mail Driver: %s", c.Driver)
+}
+
+// SMTPMailer delivers through an SMTP server, authenticating with PLAIN when Username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message
+func (m *SMTPMailer) Send(msg Message) error {
+	port := m.Port
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+port, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// LogMailer writes every message to Out instead of sending it, nothing is kept once written. It is safe for
+// concurrent use
+type LogMailer struct {
+	mu   sync.Mutex
+	Out  io.Writer
+	From string
+}
+
+// NewLogMailer returns a LogMailer writing to out, nil discards the messages
+func NewLogMailer(out io.Writer, from string) *LogMailer {
+	return &LogMailer{Out: out, From: from}
+}
+
+// Send writes the message out
+func (m *LogMailer) Send(msg Message) error {
+	if m.Out == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.Out, "%s\n", format(m.From, msg))
+	return err
+}
+
+// Recorder keeps every message in memory for tests to read back, no driver builds one. It is safe for
+// concurrent use
+type Recorder struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+// Send keeps the message
+func (m *Recorder) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns the messages sent so far, oldest first
+func (m *Recorder) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
+
+// Last returns the newest message sent to the address
+func (m *Recorder) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// format renders the message with the headers SMTP expects, line breaks in headers are dropped so a
+// recipient or subject can't inject more
+func format(from string, msg Message) []byte {
+	header := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,31 @@
+package metrics
+
+// Default is the registry /metrics serves
+var Default = NewRegistry()
+
+// The metrics the API records. Requests are split by route template, such as /posts/{id}, so the number
+// of series stays bounded
+var (
+	Requests = Default.Counter("goblog_http_requests_total",
+		"HTTP requests served, by method, route template and status code", "method", "route", "code")
+	RequestDuration = Default.Histogram("goblog_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by method and route template", DefaultBuckets, "method", "route")
+	RateLimited = Default.Counter("goblog_rate_limited_total",
+		"Requests refused for going over their rate limit, by route group", "group")
+
+	UsersCreated    = Default.Counter("goblog_users_created_total", "Users signed up")
+	PostsCreated    = Default.Counter("goblog_posts_created_total", "Posts created")
+	PostsPublished  = Default.Counter("goblog_posts_published_total", "Scheduled posts published when they came due")
+	CommentsCreated = Default.Counter("goblog_comments_created_total", "Comments created")
+	LoginsSucceeded = Default.Counter("goblog_logins_succeeded_total", "Logins that handed out a token pair")
+	LoginsFailed    = Default.Counter("goblog_logins_failed_total",
+		"Logins refused for a wrong password or second factor, or while throttled, by reason", "reason")
+	Lockouts = Default.Counter("goblog_lockouts_total", "Login subjects locked out after too many failures")
+)
+
+// Reasons a login failed, the label values of LoginsFailed
+const (
+	InvalidCredentials = "invalid_credentials"
+	InvalidCode        = "invalid_code"
+	Throttled          = "throttled"
+)
@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of metric, as named by the TYPE line of the exposition format
+const (
+	CounterKind   = "counter"
+	GaugeKind     = "gauge"
+	HistogramKind = "histogram"
+)
+
+// ContentType is the Prometheus text exposition format WriteTo produces
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, request latencies are counted into
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is anything a Registry can write
+type metric interface {
+	kind() string
+	help() string
+	write(w io.Writer, name string)
+}
+
+// Registry holds named metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// register adds the metric under name, replacing one registered before
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[name] = m
+}
+
+// Counter registers a counter split by the label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(help, labels)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a histogram counting observations into buckets, split by the label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: newVec(help, labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// Func registers a metric of kind whose value is read from f each time the registry is written, such as
+// connection pool stats. A later Func of the same name replaces it
+func (r *Registry) Func(name, help, kind string, f func() float64) {
+	r.register(name, &valueFunc{helpText: help, kindName: kind, f: f})
+}
+
+// WriteTo writes every metric, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	for i, m := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", names[i], escapeHelp(m.help()), names[i], m.kind())
+		m.write(buf, names[i])
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the registry to a Prometheus scrape
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(http.StatusOK)
+	r.WriteTo(w)
+}
+
+// vec keeps one series per combination of label values
+type vec struct {
+	mu       sync.Mutex
+	helpText string
+	labels   []string
+	series   map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(help string, labels []string) vec {
+	return vec{helpText: help, labels: labels, series: map[string]interface{}{}, values: map[string][]string{}}
+}
+
+// get returns the series of the label values, made by create the first time they are seen. Callers hold mu
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for labels %v", len(values), v.labels))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// sorted returns the series keys in order, so the output is stable. Callers hold mu
+func (v *vec) sorted() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelPairs formats the label values of the series, with extra pairs such as le appended
+func (v *vec) labelPairs(key string, extra ...string) string {
+	pairs := make([]string, 0, len(v.labels)+len(extra)/2)
+	for i, name := range v.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(v.values[key][i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) help() string { return v.helpText }
+
+// Counter is a value that only goes up, such as requests served
+type Counter struct {
+	vec
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds n to the series of the label values
+func (c *Counter) Add(n float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.get(labelValues, func() interface{} { return new(float64) }).(*float64) += n
+}
+
+// Value is the count of the series of the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.series[strings.Join(labelValues, "\xff")]; ok {
+		return *n.(*float64)
+	}
+	return 0
+}
+
+func (c *Counter) kind() string { return CounterKind }
+
+func (c *Counter) write(w io.Writer, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A counter without labels always has its one series
+	if len(c.labels) == 0 && len(c.series) == 0 {
+		c.get(nil, func() interface{} { return new(float64) })
+	}
+	for _, key := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", name, c.labelPairs(key), formatFloat(*c.series[key].(*float64)))
+	}
+}
+
+// Histogram counts observations, such as request latencies, into buckets by their upper bounds
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// histogramSeries is one series of a Histogram, counts are per bucket and not yet cumulative
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe counts v into the series of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues, func() interface{} {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramSeries)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) kind() string { return HistogramKind }
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.sorted() {
+		s := h.series[key].(*histogramSeries)
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelPairs(key, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, h.labelPairs(key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, h.labelPairs(key), s.count)
+	}
+}
+
+// valueFunc is a metric read when written
+type valueFunc struct {
+	helpText string
+	kindName string
+	f        func() float64
+}
+
+func (v *valueFunc) kind() string { return v.kindName }
+func (v *valueFunc) help() string { return v.helpText }
+
+func (v *valueFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v.f()))
+}
+
+// formatFloat writes v the way Prometheus reads it
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
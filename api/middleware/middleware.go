@@ -1,11 +1,28 @@
 package middleware
 
 import (
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
 	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
 	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // SetMiddlewareJSON sets content type of the response to JSON
@@ -16,14 +33,241 @@ func SetMiddlewareJSON(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// SetMiddlewareMaxBody refuses request bodies over limit bytes with 413, and stops handlers reading past it
+// when the length isn't sent up front. A limit of 0 lets any size through
+func SetMiddlewareMaxBody(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	if limit <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			response.ERROR(w, &apierror.TooLarge{})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next(w, r)
+	}
+}
+
 // SetMiddlewareAuthentication checks to see if token is valid, if not set appropriate response
-func SetMiddlewareAuthentication(next http.HandlerFunc) http.HandlerFunc {
+func SetMiddlewareAuthentication(issuer *auth.Issuer, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := issuer.TokenValid(r)
+		if err != nil {
+			response.ERROR(w, auth.ErrUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SetMiddlewareAuthorization checks the token like SetMiddlewareAuthentication, then refuses callers whose role
+// can't satisfy the route's rule for any resource; ownership is checked by the handler with auth.Authorize
+func SetMiddlewareAuthorization(issuer *auth.Issuer, rule auth.Rule, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := issuer.ExtractIdentity(r)
+		if err != nil {
+			response.ERROR(w, auth.ErrUnauthorized)
+			return
+		}
+		if !id.Role.Allows(rule) {
+			response.ERROR(w, auth.ErrForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// SetMiddlewareRateLimit spends one request of the caller's budget for the route group and answers 429 once
+// it is spent. Callers sending a token valid to the issuer are told apart by user_id, others by address. Every
+// limited response carries the RateLimit headers. A nil limiter lets everything through
+func SetMiddlewareRateLimit(limiter *ratelimit.Limiter, issuer *auth.Issuer, group string, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		err := auth.TokenValid(r)
+		client := "ip:" + auth.ClientIP(r)
+		if id, err := issuer.ExtractIdentity(r); err == nil {
+			client = fmt.Sprintf("user:%d", id.UserID)
+		}
+		limit, res, err := limiter.Take(group, client)
 		if err != nil {
-			response.ERROR(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+			// A store that can't be reached shouldn't take the API down with it
+			logger.FromContext(r.Context()).Warn("rate limit skipped", logger.Fields{"group": group, "error": err})
+			next(w, r)
+			return
+		}
+		if limit.Unlimited() {
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, ceilSeconds(limit.Per)))
+		if !res.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			metrics.RateLimited.Inc(group)
+			response.ERROR(w, ratelimit.ErrRateLimited)
 			return
 		}
 		next(w, r)
 	}
 }
+
+// statusRecorder remembers the status code a handler wrote, how many bytes of body and the error
+// response.ERROR answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+	err   error
+}
+
+// RecordError keeps the error the response was written for and passes it on to a recorder underneath
+func (s *statusRecorder) RecordError(err error) {
+	s.err = err
+	if rec, ok := s.ResponseWriter.(interface{ RecordError(error) }); ok {
+		rec.RecordError(err)
+	}
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// routeTemplate is the template of the route mux matched, such as /posts/{id}
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
+
+// maxRequestIDLength bounds an X-Request-ID taken from the client
+const maxRequestIDLength = 128
+
+// requestID is the client's X-Request-ID when it is sensible to log, or a new random one
+func requestID(r *http.Request) string {
+	id := r.Header.Get(response.RequestIDHeader)
+	if id != "" && len(id) <= maxRequestIDLength && strings.Trim(id, requestIDChars) == "" {
+		return id
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestIDChars are the characters a client's request id may have, anything else could forge log lines
+const requestIDChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:"
+
+// SetMiddlewareRequestLog gives the request an id, the client's X-Request-ID or a new one, and sends it back
+// in the response header. Handlers find a logger carrying it with logger.FromContext. Once served the
+// request is logged with its route template, status, latency, size and the user its token named
+func SetMiddlewareRequestLog(log *logger.Logger, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(response.RequestIDHeader, id)
+		fields := logger.Fields{"request_id": id}
+		// Log lines of a traced request can be found from the trace and back
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+		}
+		reqLog := log.With(fields)
+		r = r.WithContext(logger.NewContext(r.Context(), reqLog))
+		r, caller := auth.RecordCaller(r)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		fields = logger.Fields{
+			"method":     r.Method,
+			"route":      routeTemplate(r),
+			"status":     rec.code,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":      rec.bytes,
+			"ip":         auth.ClientIP(r),
+		}
+		if *caller != 0 {
+			fields["user_id"] = *caller
+		}
+		if rec.code >= http.StatusInternalServerError {
+			// The client was only told something went wrong
+			if rec.err != nil {
+				fields["error"] = rec.err
+			}
+			reqLog.Error("request", fields)
+		} else {
+			reqLog.Info("request", fields)
+		}
+	}
+}
+
+// SetMiddlewareMetrics counts the request and times it under its route template, such as /posts/{id}, so
+// every post is one series
+func SetMiddlewareMetrics(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		metrics.RequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+		metrics.Requests.Inc(r.Method, route, strconv.Itoa(rec.code))
+	}
+}
+
+// SetMiddlewareTracing runs the request in a server span named by its route template, such as
+// GET /posts/{id}. A W3C traceparent header from the caller makes it a child of the caller's span, and
+// handlers passing the request context on start their spans under it. 5xx responses mark the span failed
+func SetMiddlewareTracing(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("goblog", route, r)...))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rec.code)...)
+		if rec.code >= http.StatusInternalServerError {
+			if rec.err != nil {
+				span.RecordError(rec.err)
+			}
+			span.SetStatus(codes.Error, http.StatusText(rec.code))
+		}
+	}
+}
+
+// ceilSeconds rounds the duration up to whole seconds, at least one when it isn't zero
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The structs below freeze the schema as of this migration, later model changes get their own migration
+
+type user0001 struct {
+	gorm.Model
+	Username string `gorm:"size:100;not null;unique;"`
+	Email    string `gorm:"size:100;not null;unique;"`
+	Password string `gorm:"size:100;not null;"`
+	Role     string `gorm:"size:20;not null;default:author;"`
+}
+
+func (user0001) TableName() string { return "users" }
+
+type post0001 struct {
+	gorm.Model
+	Title    string `gorm:"size:100;not null;unique;"`
+	Content  string `gorm:"size:255;not null;"`
+	AuthorID uint
+	Author   user0001
+}
+
+func (post0001) TableName() string { return "posts" }
+
+type refreshToken0001 struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"size:64;not null;unique;"`
+	AccessJTI string    `gorm:"size:32;"`
+	ExpiresAt time.Time `gorm:"not null;"`
+	RevokedAt *time.Time
+}
+
+func (refreshToken0001) TableName() string { return "refresh_tokens" }
+
+type revokedToken0001 struct {
+	gorm.Model
+	JTI       string    `gorm:"size:32;not null;unique;"`
+	ExpiresAt time.Time `gorm:"not null;"`
+}
+
+func (revokedToken0001) TableName() string { return "revoked_tokens" }
+
+// initialSchemaUp creates users, posts and the token tables. Databases created by AutoMigrate before
+// migrations existed already have some or all of them, so missing tables and columns are added instead
+func initialSchemaUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&user0001{}, &post0001{}, &refreshToken0001{}, &revokedToken0001{})
+}
+
+// initialSchemaDown drops the tables, DropTable works through its arguments last to first
+func initialSchemaDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&user0001{}, &post0001{}, &refreshToken0001{}, &revokedToken0001{})
+}
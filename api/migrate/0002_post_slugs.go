@@ -0,0 +1,105 @@
+package migrate
+
+import (
+	"html"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/util/slug"
+	"gorm.io/gorm"
+)
+
+// Titles are no longer unique, posts are addressed by a unique slug and replaced slugs become redirects
+
+type post0002 struct {
+	gorm.Model
+	Title    string `gorm:"size:100;not null;"`
+	Slug     string `gorm:"size:100;not null;uniqueIndex;"`
+	Content  string `gorm:"size:255;not null;"`
+	AuthorID uint
+	Author   user0001
+}
+
+func (post0002) TableName() string { return "posts" }
+
+type postSlug0002 struct {
+	ID        uint   `gorm:"primarykey"`
+	PostID    uint   `gorm:"not null;index;"`
+	Slug      string `gorm:"size:100;not null;unique;"`
+	CreatedAt time.Time
+}
+
+func (postSlug0002) TableName() string { return "post_slugs" }
+
+func postSlugsUp(tx *gorm.DB) error {
+	if err := tx.Exec("ALTER TABLE posts ADD COLUMN slug varchar(100)").Error; err != nil {
+		return err
+	}
+	if err := backfillSlugs(tx); err != nil {
+		return err
+	}
+
+	if isSQLite(tx) {
+		if err := rebuildSQLite(tx, "posts", &post0002{}); err != nil {
+			return err
+		}
+	} else {
+		for _, statement := range []string{
+			"ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_title_key",
+			"ALTER TABLE posts ALTER COLUMN slug SET NOT NULL",
+			"CREATE UNIQUE INDEX idx_posts_slug ON posts (slug)",
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Migrator().CreateTable(&postSlug0002{})
+}
+
+// backfillSlugs derives a slug from the title of every existing post, deleted ones included, oldest first
+// so the earliest post keeps the plain slug
+func backfillSlugs(tx *gorm.DB) error {
+	var rows []struct {
+		ID    uint
+		Title string
+	}
+	if err := tx.Raw("SELECT id, title FROM posts ORDER BY id").Scan(&rows).Error; err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, row := range rows {
+		base := slug.Make(html.UnescapeString(row.Title))
+		if base == "" {
+			base = "post"
+		}
+		candidate := base
+		for n := 2; taken[candidate]; n++ {
+			candidate = slug.WithSuffix(base, n)
+		}
+		taken[candidate] = true
+		if err := tx.Exec("UPDATE posts SET slug = ? WHERE id = ?", candidate, row.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postSlugsDown fails when titles have been duplicated since, they can't be made unique again
+func postSlugsDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&postSlug0002{}); err != nil {
+		return err
+	}
+	if isSQLite(tx) {
+		return rebuildSQLite(tx, "posts", &post0001{})
+	}
+	for _, statement := range []string{
+		"DROP INDEX IF EXISTS idx_posts_slug",
+		"ALTER TABLE posts DROP COLUMN slug",
+		"ALTER TABLE posts ADD CONSTRAINT posts_title_key UNIQUE (title)",
+	} {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
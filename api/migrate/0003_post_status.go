@@ -0,0 +1,49 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Posts get a lifecycle status and the time they went public, existing posts were public already
+
+type post0003 struct {
+	gorm.Model
+	Title       string `gorm:"size:100;not null;"`
+	Slug        string `gorm:"size:100;not null;uniqueIndex;"`
+	Content     string `gorm:"size:255;not null;"`
+	Status      string `gorm:"size:20;not null;default:draft;index;"`
+	PublishedAt *time.Time
+	AuthorID    uint
+	Author      user0001
+}
+
+func (post0003) TableName() string { return "posts" }
+
+func postStatusUp(tx *gorm.DB) error {
+	for _, field := range []string{"Status", "PublishedAt"} {
+		if err := tx.Migrator().AddColumn(&post0003{}, field); err != nil {
+			return err
+		}
+	}
+	if err := tx.Migrator().CreateIndex(&post0003{}, "Status"); err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE posts SET status = ?, published_at = created_at", "published").Error
+}
+
+func postStatusDown(tx *gorm.DB) error {
+	if isSQLite(tx) {
+		return rebuildSQLite(tx, "posts", &post0002{})
+	}
+	if err := tx.Migrator().DropIndex(&post0003{}, "Status"); err != nil {
+		return err
+	}
+	for _, field := range []string{"PublishedAt", "Status"} {
+		if err := tx.Migrator().DropColumn(&post0003{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
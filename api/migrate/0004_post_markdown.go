@@ -0,0 +1,21 @@
+package migrate
+
+import "gorm.io/gorm"
+
+// Post content is Markdown source of any length rather than 255 escaped characters. SQLite doesn't enforce
+// varchar lengths and already stores the column as TEXT, so only Postgres changes
+
+func postMarkdownUp(tx *gorm.DB) error {
+	if isSQLite(tx) {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE posts ALTER COLUMN content TYPE text").Error
+}
+
+// postMarkdownDown fails once a post is longer than 255 characters
+func postMarkdownDown(tx *gorm.DB) error {
+	if isSQLite(tx) {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE posts ALTER COLUMN content TYPE varchar(255)").Error
+}
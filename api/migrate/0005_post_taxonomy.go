@@ -0,0 +1,54 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Posts can be grouped under tags and categories, each linked through its own join table
+
+type tag0005 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Name      string `gorm:"size:50;not null;"`
+	Slug      string `gorm:"size:100;not null;unique;"`
+}
+
+func (tag0005) TableName() string { return "tags" }
+
+type category0005 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Name      string `gorm:"size:50;not null;"`
+	Slug      string `gorm:"size:100;not null;unique;"`
+}
+
+func (category0005) TableName() string { return "categories" }
+
+type postTag0005 struct {
+	PostID uint `gorm:"primaryKey"`
+	TagID  uint `gorm:"primaryKey"`
+	Post   post0003
+	Tag    tag0005
+}
+
+func (postTag0005) TableName() string { return "post_tags" }
+
+type postCategory0005 struct {
+	PostID     uint `gorm:"primaryKey"`
+	CategoryID uint `gorm:"primaryKey"`
+	Post       post0003
+	Category   category0005
+}
+
+func (postCategory0005) TableName() string { return "post_categories" }
+
+func postTaxonomyUp(tx *gorm.DB) error {
+	return tx.Migrator().CreateTable(&tag0005{}, &category0005{}, &postTag0005{}, &postCategory0005{})
+}
+
+func postTaxonomyDown(tx *gorm.DB) error {
+	// DropTable drops the last table first, the join tables go before what they reference
+	return tx.Migrator().DropTable(&tag0005{}, &category0005{}, &postTag0005{}, &postCategory0005{})
+}
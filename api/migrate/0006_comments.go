@@ -0,0 +1,29 @@
+package migrate
+
+import (
+	"gorm.io/gorm"
+)
+
+// Readers comment on posts, replies point at their parent comment and every comment is moderated
+
+type comment0006 struct {
+	gorm.Model
+	PostID   uint   `gorm:"not null;index;"`
+	ParentID *uint  `gorm:"index;"`
+	Content  string `gorm:"type:text;not null;"`
+	Status   string `gorm:"size:20;not null;default:pending;index;"`
+	AuthorID uint   `gorm:"not null;index;"`
+	Post     post0003
+	Parent   *comment0006
+	Author   user0001
+}
+
+func (comment0006) TableName() string { return "comments" }
+
+func commentsUp(tx *gorm.DB) error {
+	return tx.Migrator().CreateTable(&comment0006{})
+}
+
+func commentsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&comment0006{})
+}
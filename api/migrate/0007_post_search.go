@@ -0,0 +1,26 @@
+package migrate
+
+import "gorm.io/gorm"
+
+// Postgres searches posts through a tsvector of the title, weighted above the content, that the database
+// generates on every write. SQLite has no full-text search of this kind, the server indexes posts itself
+
+func postSearchUp(tx *gorm.DB) error {
+	if isSQLite(tx) {
+		return nil
+	}
+	err := tx.Exec(`ALTER TABLE posts ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(content, '')), 'B')) STORED`).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec("CREATE INDEX idx_posts_search_vector ON posts USING GIN (search_vector)").Error
+}
+
+func postSearchDown(tx *gorm.DB) error {
+	if isSQLite(tx) {
+		return nil
+	}
+	return tx.Exec("ALTER TABLE posts DROP COLUMN search_vector").Error
+}
@@ -0,0 +1,58 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Users verify their email and may reset a forgotten password through single use tokens mailed to them.
+// Accounts that exist already were trusted without verifying, so they count as verified
+
+type user0008 struct {
+	gorm.Model
+	Username        string `gorm:"size:100;not null;unique;"`
+	Email           string `gorm:"size:100;not null;unique;"`
+	Password        string `gorm:"size:100;not null;"`
+	Role            string `gorm:"size:20;not null;default:author;"`
+	EmailVerifiedAt *time.Time
+}
+
+func (user0008) TableName() string { return "users" }
+
+type userToken0008 struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	Purpose   string    `gorm:"size:20;not null;"`
+	TokenHash string    `gorm:"size:64;not null;unique;"`
+	ExpiresAt time.Time `gorm:"not null;"`
+	UsedAt    *time.Time
+	User      user0008
+}
+
+func (userToken0008) TableName() string { return "user_tokens" }
+
+func userVerificationUp(tx *gorm.DB) error {
+	// SQLite keeps the column when reverted, see userVerificationDown
+	if !tx.Migrator().HasColumn(&user0008{}, "EmailVerifiedAt") {
+		if err := tx.Migrator().AddColumn(&user0008{}, "EmailVerifiedAt"); err != nil {
+			return err
+		}
+	}
+	if err := tx.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+		return err
+	}
+	return tx.Migrator().CreateTable(&userToken0008{})
+}
+
+// userVerificationDown leaves email_verified_at in place on SQLite, which can't drop a column of a table
+// other tables reference
+func userVerificationDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&userToken0008{}); err != nil {
+		return err
+	}
+	if isSQLite(tx) {
+		return nil
+	}
+	return tx.Migrator().DropColumn(&user0008{}, "EmailVerifiedAt")
+}
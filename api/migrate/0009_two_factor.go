@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Users may enroll a TOTP authenticator for two-factor sign in, with single use recovery codes for when
+// they lose it
+
+type user0009 struct {
+	gorm.Model
+	Username        string `gorm:"size:100;not null;unique;"`
+	Email           string `gorm:"size:100;not null;unique;"`
+	Password        string `gorm:"size:100;not null;"`
+	Role            string `gorm:"size:20;not null;default:author;"`
+	EmailVerifiedAt *time.Time
+	TOTPSecret      string `gorm:"size:64;"`
+	TOTPEnabledAt   *time.Time
+	TOTPLastStep    int64 `gorm:"not null;default:0;"`
+}
+
+func (user0009) TableName() string { return "users" }
+
+type recoveryCode0009 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index;"`
+	CodeHash  string `gorm:"size:64;not null;unique;"`
+	UsedAt    *time.Time
+	User      user0009
+}
+
+func (recoveryCode0009) TableName() string { return "recovery_codes" }
+
+// twoFactorFields are the columns added to users
+var twoFactorFields = []string{"TOTPSecret", "TOTPEnabledAt", "TOTPLastStep"}
+
+func twoFactorUp(tx *gorm.DB) error {
+	for _, field := range twoFactorFields {
+		// SQLite keeps the columns when reverted, see twoFactorDown
+		if tx.Migrator().HasColumn(&user0009{}, field) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(&user0009{}, field); err != nil {
+			return err
+		}
+	}
+	return tx.Migrator().CreateTable(&recoveryCode0009{})
+}
+
+// twoFactorDown leaves the users columns in place on SQLite like userVerificationDown, but clears them since
+// the recovery codes that went with them are gone
+func twoFactorDown(tx *gorm.DB) error {
+	if err := tx.Migrator().DropTable(&recoveryCode0009{}); err != nil {
+		return err
+	}
+	if isSQLite(tx) {
+		return tx.Exec("UPDATE users SET totp_secret = '', totp_enabled_at = NULL, totp_last_step = 0").Error
+	}
+	for _, field := range twoFactorFields {
+		if err := tx.Migrator().DropColumn(&user0009{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
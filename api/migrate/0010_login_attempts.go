@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Failed logins are counted per email and client address to slow guessing down, and lockouts are logged for
+// admins
+
+type loginAttempt0010 struct {
+	ID           uint      `gorm:"primarykey"`
+	Subject      string    `gorm:"size:320;not null;unique;"`
+	Failures     int       `gorm:"not null;default:0;"`
+	LastFailedAt time.Time `gorm:"not null;"`
+}
+
+func (loginAttempt0010) TableName() string { return "login_attempts" }
+
+type lockout0010 struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time `gorm:"index;"`
+	Subject     string    `gorm:"size:320;not null;index;"`
+	IP          string    `gorm:"size:64;"`
+	Failures    int       `gorm:"not null;"`
+	LockedUntil time.Time `gorm:"not null;"`
+}
+
+func (lockout0010) TableName() string { return "lockouts" }
+
+func loginAttemptsUp(tx *gorm.DB) error {
+	return tx.Migrator().CreateTable(&loginAttempt0010{}, &lockout0010{})
+}
+
+func loginAttemptsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&lockout0010{}, &loginAttempt0010{})
+}
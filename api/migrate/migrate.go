@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrSchemaOutdated is returned by Current while migrations are pending
+var ErrSchemaOutdated = errors.New("Schema Not Current")
+
+// ErrUnknownMigration is returned when the database has a version this build does not know about
+var ErrUnknownMigration = errors.New("Unknown Migration")
+
+// Migration moves the schema to Version, Down moves it back to the previous version
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is a row of the schema_migrations table, one per applied migration
+type SchemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false;" json:"version"`
+	Name      string    `gorm:"size:255;not null;" json:"name"`
+	AppliedAt time.Time `gorm:"not null;" json:"applied_at"`
+}
+
+// Status pairs a known migration with the time it was applied, nil while pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns those applied
+func Up(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err = run(db, func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns those reverted
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err = run(db, func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// run applies one migration in its own transaction. SQLite has its foreign keys off meanwhile, rebuilding a
+// table that others reference fails otherwise, and they are checked before the transaction commits
+func run(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if !isSQLite(db) {
+		return db.Transaction(fn)
+	}
+	return withoutForeignKeys(db, func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return checkForeignKeys(tx)
+		})
+	})
+}
+
+// List returns the status of every known migration in order
+func List(db *gorm.DB) ([]Status, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Status, len(migrations))
+	for i, m := range migrations {
+		list[i].Migration = m
+		if row, ok := applied[m.Version]; ok {
+			list[i].AppliedAt = &row.AppliedAt
+		}
+	}
+	return list, nil
+}
+
+// Current returns nil when every known migration, and nothing else, has been applied
+func Current(db *gorm.DB) error {
+	applied := map[uint]SchemaMigration{}
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		var err error
+		if applied, err = readApplied(db); err != nil {
+			return err
+		}
+	}
+
+	pending := 0
+	known := make(map[uint]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d is applied but not part of this build", ErrUnknownMigration, version)
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migration(s), run: migrate up", ErrSchemaOutdated, pending)
+	}
+	return nil
+}
+
+// appliedVersions creates the schema_migrations table when missing and returns its rows by version
+func appliedVersions(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	return readApplied(db)
+}
+
+func readApplied(db *gorm.DB) (map[uint]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
@@ -0,0 +1,25 @@
+package migrate
+
+import "fmt"
+
+// migrations is every schema change in the order it is applied; append new ones, never edit or reorder applied ones
+var migrations = []Migration{
+	{Version: 1, Name: "initial_schema", Up: initialSchemaUp, Down: initialSchemaDown},
+	{Version: 2, Name: "post_slugs", Up: postSlugsUp, Down: postSlugsDown},
+	{Version: 3, Name: "post_status", Up: postStatusUp, Down: postStatusDown},
+	{Version: 4, Name: "post_markdown", Up: postMarkdownUp, Down: postMarkdownDown},
+	{Version: 5, Name: "post_taxonomy", Up: postTaxonomyUp, Down: postTaxonomyDown},
+	{Version: 6, Name: "comments", Up: commentsUp, Down: commentsDown},
+	{Version: 7, Name: "post_search", Up: postSearchUp, Down: postSearchDown},
+	{Version: 8, Name: "user_verification", Up: userVerificationUp, Down: userVerificationDown},
+	{Version: 9, Name: "two_factor", Up: twoFactorUp, Down: twoFactorDown},
+	{Version: 10, Name: "login_attempts", Up: loginAttemptsUp, Down: loginAttemptsDown},
+}
+
+func init() {
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			panic(fmt.Sprintf("migrate: version %d must be greater than %d", migrations[i].Version, migrations[i-1].Version))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// isSQLite reports whether the migration runs on SQLite, which can't drop columns or constraints in place
+func isSQLite(tx *gorm.DB) bool {
+	return tx.Dialector.Name() == "sqlite"
+}
+
+// withoutForeignKeys runs fn with foreign keys off, SQLite ignores the pragma inside a transaction so it is set
+// before one begins. The database is served by a single connection, the transaction runs on the same one
+func withoutForeignKeys(db *gorm.DB, fn func() error) error {
+	if err := db.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+		return err
+	}
+	defer db.Exec("PRAGMA foreign_keys = ON")
+	return fn()
+}
+
+// checkForeignKeys fails when a row references one that doesn't exist, what enforcing them would have caught
+func checkForeignKeys(tx *gorm.DB) error {
+	var violations []struct {
+		Table  string
+		Parent string
+	}
+	if err := tx.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("FOREIGN KEY constraint failed: %s references a missing row of %s", violations[0].Table, violations[0].Parent)
+	}
+	return nil
+}
+
+// rebuildSQLite recreates table from the snapshot struct and copies over the columns both versions have.
+// Renaming rewrites foreign keys that point at the table, so it is only for tables nothing references
+func rebuildSQLite(tx *gorm.DB, table string, snapshot interface{}) error {
+	old := table + "__old"
+
+	// Index names are global in SQLite, the old ones have to go before the snapshot creates its own
+	var indexes []string
+	if err := tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Scan(&indexes).Error; err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if err := tx.Exec(fmt.Sprintf("DROP INDEX `%s`", index)).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s`", table, old)).Error; err != nil {
+		return err
+	}
+	if err := tx.Migrator().CreateTable(snapshot); err != nil {
+		return err
+	}
+
+	oldColumns, err := columnNames(tx, old)
+	if err != nil {
+		return err
+	}
+	newColumns, err := columnNames(tx, table)
+	if err != nil {
+		return err
+	}
+	var shared []string
+	for _, column := range newColumns {
+		for _, c := range oldColumns {
+			if c == column {
+				shared = append(shared, "`"+column+"`")
+			}
+		}
+	}
+	list := strings.Join(shared, ",")
+	if err = tx.Exec(fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s`", table, list, list, old)).Error; err != nil {
+		return err
+	}
+	return tx.Exec(fmt.Sprintf("DROP TABLE `%s`", old)).Error
+}
+
+func columnNames(tx *gorm.DB, table string) ([]string, error) {
+	rows, err := tx.Raw(fmt.Sprintf("SELECT * FROM `%s` LIMIT 1", table)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
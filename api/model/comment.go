@@ -0,0 +1,293 @@
+package model
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+	"gorm.io/gorm"
+)
+
+// Comment moderation states, only approved comments are public
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+)
+
+// MaxCommentLength is the longest comment, in characters, Validate accepts
+const MaxCommentLength = 5000
+
+// Errors returned for comments that can't be saved
+var (
+	ErrInvalidCommentStatus error = apierror.Invalid("status", apierror.CodeInvalid, "Invalid Comment Status")
+	ErrInvalidParent        error = apierror.Invalid("parent_id", apierror.CodeInvalid, "Invalid Parent: not a comment on this post")
+	ErrEditWindowClosed     error = &apierror.Forbidden{Code: "edit_window_closed", Message: "Edit Window Closed"}
+)
+
+// Comment is a reader's response to a post, ParentID threads it under another comment on the same post
+type Comment struct {
+	gorm.Model
+	PostID   uint      `gorm:"not null;index;" json:"post_id"`
+	ParentID *uint     `gorm:"index;" json:"parent_id"`
+	Content  string    `gorm:"type:text;not null;" json:"content"`
+	Status   string    `gorm:"size:20;not null;default:pending;index;" json:"status"`
+	AuthorID uint      `gorm:"not null;index;" json:"author_id"`
+	Author   User      `json:"author"`
+	Replies  []Comment `gorm:"-" json:"replies,omitempty"`
+}
+
+// ValidCommentStatus reports whether status is one a comment can have
+func ValidCommentStatus(status string) bool {
+	switch status {
+	case CommentPending, CommentApproved, CommentSpam:
+		return true
+	}
+	return false
+}
+
+// Approved reports whether the comment is public
+func (c *Comment) Approved() bool {
+	return c.Status == CommentApproved
+}
+
+// Editable reports whether the comment's author may still edit it at now, window is how long after posting
+// they may
+func (c *Comment) Editable(now time.Time, window time.Duration) bool {
+	return now.Before(c.CreatedAt.Add(window))
+}
+
+// Prepare Escapes and Trims content, defaults the status to pending and clears Author and Replies
+func (c *Comment) Prepare() {
+	c.Content = html.EscapeString(strings.TrimSpace(c.Content))
+	c.Status = strings.ToLower(strings.TrimSpace(c.Status))
+	if c.Status == "" {
+		c.Status = CommentPending
+	}
+	c.Author = User{}
+	c.Replies = nil
+}
+
+// Validate checks the fields and returns what is wrong with all of them
+func (c *Comment) Validate() error {
+	var errs validate.Errors
+	errs.Check(validate.Field{Name: "content", Label: "Content", Value: c.Content,
+		Rules: []validate.Rule{validate.Required, validate.MaxLength(MaxCommentLength)}})
+	if c.PostID < 1 {
+		errs.Add("post_id", apierror.CodeRequired, "Required: Post")
+	}
+	if c.AuthorID < 1 {
+		errs.Add("author_id", apierror.CodeRequired, "Required: Author")
+	}
+	if !ValidCommentStatus(c.Status) {
+		errs.Include(ErrInvalidCommentStatus)
+	}
+	return errs.Err()
+}
+
+// CreateComment Inserts new comment row, a parent must be a comment on the same post
+func (c *Comment) CreateComment(db *gorm.DB) (*Comment, error) {
+	if c.ParentID != nil {
+		parent := Comment{}
+		err := db.Where("id = ? AND post_id = ?", *c.ParentID, c.PostID).Take(&parent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &Comment{}, ErrInvalidParent
+		}
+		if err != nil {
+			return &Comment{}, err
+		}
+	}
+	if err := db.Create(&c).Error; err != nil {
+		return &Comment{}, err
+	}
+	return c.ReadCommentByID(db, c.ID)
+}
+
+// CommentQuery filters and pages the comments of PostID, oldest first. Only approved comments are listed
+// unless the viewer wrote them or AllStatuses is set. TopLevel leaves out replies, for paging threads
+type CommentQuery struct {
+	Page
+	PostID      uint
+	Status      string
+	Viewer      uint
+	AllStatuses bool
+	TopLevel    bool
+}
+
+// commentSortColumns are the columns comments may be sorted by
+var commentSortColumns = []sortColumn{
+	{name: "created_at", isTime: true},
+	{name: "id"},
+}
+
+// filter narrows the query to the rows matched by q, ignoring paging and TopLevel
+func (q CommentQuery) filter(db *gorm.DB) *gorm.DB {
+	db = db.Where("post_id = ?", q.PostID)
+	if !q.AllStatuses {
+		db = db.Where("(status = ? OR author_id = ?)", CommentApproved, q.Viewer)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	return db
+}
+
+// sortValue returns the value of the column a listing is ordered by
+func (c *Comment) sortValue(column string) interface{} {
+	if column == "created_at" {
+		return c.CreatedAt
+	}
+	return c.ID
+}
+
+// ReadAllComments returns one page of the comments matching q with their authors
+func (c *Comment) ReadAllComments(db *gorm.DB, q CommentQuery) (*[]Comment, PageInfo, error) {
+	order, err := q.order("created_at", commentSortColumns)
+	if err != nil {
+		return &[]Comment{}, PageInfo{}, err
+	}
+
+	filtered := func(db *gorm.DB) *gorm.DB {
+		db = q.filter(db)
+		if q.TopLevel {
+			db = db.Where("parent_id IS NULL")
+		}
+		return db
+	}
+
+	info := PageInfo{}
+	if err = filtered(db.Model(&Comment{})).Count(&info.Total).Error; err != nil {
+		return &[]Comment{}, PageInfo{}, err
+	}
+
+	query, err := q.seek(filtered(db), order)
+	if err != nil {
+		return &[]Comment{}, PageInfo{}, err
+	}
+	var comments []Comment
+	if err = query.Preload("Author").Find(&comments).Error; err != nil {
+		return &[]Comment{}, PageInfo{}, err
+	}
+
+	// seek fetched one row past the limit, if it came back there is another page
+	if len(comments) > q.limit() {
+		comments = comments[:q.limit()]
+		last := comments[len(comments)-1]
+		info.Next = encodeCursor(last.sortValue(order.column.name), last.ID)
+	}
+	return &comments, info, nil
+}
+
+// ReadCommentReplies returns every reply q lets the viewer see, oldest first and unpaged, for Thread
+func (c *Comment) ReadCommentReplies(db *gorm.DB, q CommentQuery) (*[]Comment, error) {
+	var replies []Comment
+	err := q.filter(db).Where("parent_id IS NOT NULL").Order("created_at, id").Preload("Author").Find(&replies).Error
+	if err != nil {
+		return &[]Comment{}, err
+	}
+	return &replies, nil
+}
+
+// Match reports whether the comment passes q's filters, used by stores that filter in memory
+func (q CommentQuery) Match(c *Comment) bool {
+	if c.PostID != q.PostID {
+		return false
+	}
+	if !q.AllStatuses && !c.Approved() && (q.Viewer == 0 || c.AuthorID != q.Viewer) {
+		return false
+	}
+	if q.Status != "" && c.Status != q.Status {
+		return false
+	}
+	return true
+}
+
+// Apply filters, sorts and pages comments already loaded in memory the same way ReadAllComments does in SQL
+func (q CommentQuery) Apply(comments []Comment) ([]Comment, PageInfo, error) {
+	order, err := q.order("created_at", commentSortColumns)
+	if err != nil {
+		return []Comment{}, PageInfo{}, err
+	}
+	matched := []Comment{}
+	for i := range comments {
+		if q.Match(&comments[i]) && (!q.TopLevel || comments[i].ParentID == nil) {
+			matched = append(matched, comments[i])
+		}
+	}
+	idx, next, err := q.inMemory(order, len(matched), func(i int) (interface{}, uint) {
+		return matched[i].sortValue(order.column.name), matched[i].ID
+	})
+	if err != nil {
+		return []Comment{}, PageInfo{}, err
+	}
+	page := make([]Comment, 0, len(idx))
+	for _, i := range idx {
+		page = append(page, matched[i])
+	}
+	return page, PageInfo{Next: next, Total: int64(len(matched))}, nil
+}
+
+// Thread nests replies under the roots they descend from; replies whose parent isn't shown are left out
+func Thread(roots []Comment, replies []Comment) []Comment {
+	children := map[uint][]Comment{}
+	for _, r := range replies {
+		children[*r.ParentID] = append(children[*r.ParentID], r)
+	}
+	// A parent always exists before its replies, so there are no cycles to guard against
+	var nest func(c Comment) Comment
+	nest = func(c Comment) Comment {
+		for _, child := range children[c.ID] {
+			c.Replies = append(c.Replies, nest(child))
+		}
+		return c
+	}
+	threads := make([]Comment, len(roots))
+	for i := range roots {
+		threads[i] = nest(roots[i])
+	}
+	return threads
+}
+
+// ReadCommentByID queries the Comment table by ID and assembles its author
+func (c *Comment) ReadCommentByID(db *gorm.DB, id uint) (*Comment, error) {
+	if err := db.Preload("Author").Take(&c, id).Error; err != nil {
+		return &Comment{}, err
+	}
+	return c, nil
+}
+
+// UpdateComment saves the content and status of the comment with c.ID
+func (c *Comment) UpdateComment(db *gorm.DB) (*Comment, error) {
+	res := db.Model(&Comment{}).Where("id = ?", c.ID).Updates(map[string]interface{}{"content": c.Content, "status": c.Status})
+	if res.Error != nil {
+		return &Comment{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &Comment{}, gorm.ErrRecordNotFound
+	}
+	return (&Comment{}).ReadCommentByID(db, c.ID)
+}
+
+// UpdateCommentStatus moderates the comment with the ID
+func (c *Comment) UpdateCommentStatus(db *gorm.DB, id uint, status string) (*Comment, error) {
+	if !ValidCommentStatus(status) {
+		return &Comment{}, ErrInvalidCommentStatus
+	}
+	res := db.Model(&Comment{}).Where("id = ?", id).Update("status", status)
+	if res.Error != nil {
+		return &Comment{}, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &Comment{}, gorm.ErrRecordNotFound
+	}
+	return c.ReadCommentByID(db, id)
+}
+
+// DeleteComment soft deletes the comment, its replies stay but drop out of threads
+func (c *Comment) DeleteComment(db *gorm.DB, id uint) (int64, error) {
+	res := db.Delete(&Comment{}, id)
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCredentials is the one answer Login gives for an unknown email or a wrong password, so
+// nobody can learn which accounts exist
+var ErrInvalidCredentials error = &apierror.Unauthorized{Code: "invalid_credentials", Message: "Invalid Credentials"}
+
+// LoginAttempt counts the recent failed logins of one subject, an email or a client address
+type LoginAttempt struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	Subject      string    `gorm:"size:320;not null;unique;" json:"subject"`
+	Failures     int       `gorm:"not null;default:0;" json:"failures"`
+	LastFailedAt time.Time `gorm:"not null;" json:"last_failed_at"`
+}
+
+// Lockout records a subject being locked out after too many failed logins, for admins to review
+type Lockout struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `gorm:"index;" json:"created_at"`
+	Subject     string    `gorm:"size:320;not null;index;" json:"subject"`
+	IP          string    `gorm:"size:64;" json:"ip"`
+	Failures    int       `gorm:"not null;" json:"failures"`
+	LockedUntil time.Time `gorm:"not null;" json:"locked_until"`
+}
+
+// LockoutQuery pages the lockouts, newest first, optionally of one Subject
+type LockoutQuery struct {
+	Page
+	Subject string
+}
+
+// lockoutSortColumns are the columns lockouts may be sorted by
+var lockoutSortColumns = []sortColumn{
+	{name: "created_at", isTime: true},
+	{name: "id"},
+}
+
+// ReadLoginAttempt returns the failures counted for the subject
+func ReadLoginAttempt(db *gorm.DB, subject string) (*LoginAttempt, error) {
+	la := LoginAttempt{}
+	if err := db.Where("subject = ?", subject).Take(&la).Error; err != nil {
+		return &LoginAttempt{}, err
+	}
+	return &la, nil
+}
+
+// RecordLoginFailure counts a failed login for the subject at now and returns the new count. Failures
+// older than window are forgotten, so the count starts over
+func RecordLoginFailure(db *gorm.DB, subject string, now time.Time, window time.Duration) (*LoginAttempt, error) {
+	// Counted in SQL so concurrent failures can't overwrite each other
+	bump := func() (int64, error) {
+		res := db.Exec("UPDATE login_attempts SET failures = CASE WHEN last_failed_at < ? THEN 1 ELSE failures + 1 END, "+
+			"last_failed_at = ? WHERE subject = ?", now.Add(-window), now, subject)
+		return res.RowsAffected, res.Error
+	}
+	n, err := bump()
+	if err != nil {
+		return &LoginAttempt{}, err
+	}
+	if n == 0 {
+		la := LoginAttempt{Subject: subject, Failures: 1, LastFailedAt: now}
+		if err = db.Create(&la).Error; err == nil {
+			return &la, nil
+		}
+		// Another request created the row first
+		if _, err = bump(); err != nil {
+			return &LoginAttempt{}, err
+		}
+	}
+	return ReadLoginAttempt(db, subject)
+}
+
+// ClearLoginAttempts forgets the failures of the subject
+func ClearLoginAttempts(db *gorm.DB, subject string) error {
+	return db.Where("subject = ?", subject).Delete(&LoginAttempt{}).Error
+}
+
+// CreateLockout Inserts new lockout row
+func (l *Lockout) CreateLockout(db *gorm.DB) (*Lockout, error) {
+	if err := db.Create(&l).Error; err != nil {
+		return &Lockout{}, err
+	}
+	return l, nil
+}
+
+// sortValue returns the value of the column a listing is ordered by
+func (l *Lockout) sortValue(column string) interface{} {
+	if column == "created_at" {
+		return l.CreatedAt
+	}
+	return l.ID
+}
+
+// filter narrows the query to the rows matched by q, ignoring paging
+func (q LockoutQuery) filter(db *gorm.DB) *gorm.DB {
+	if q.Subject != "" {
+		db = db.Where("subject = ?", q.Subject)
+	}
+	return db
+}
+
+// ReadAllLockouts returns one page of the lockouts matching q
+func (l *Lockout) ReadAllLockouts(db *gorm.DB, q LockoutQuery) (*[]Lockout, PageInfo, error) {
+	order, err := q.order("-created_at", lockoutSortColumns)
+	if err != nil {
+		return &[]Lockout{}, PageInfo{}, err
+	}
+
+	info := PageInfo{}
+	if err = q.filter(db.Model(&Lockout{})).Count(&info.Total).Error; err != nil {
+		return &[]Lockout{}, PageInfo{}, err
+	}
+
+	query, err := q.seek(q.filter(db), order)
+	if err != nil {
+		return &[]Lockout{}, PageInfo{}, err
+	}
+	var lockouts []Lockout
+	if err = query.Find(&lockouts).Error; err != nil {
+		return &[]Lockout{}, PageInfo{}, err
+	}
+
+	// seek fetched one row past the limit, if it came back there is another page
+	if len(lockouts) > q.limit() {
+		lockouts = lockouts[:q.limit()]
+		last := lockouts[len(lockouts)-1]
+		info.Next = encodeCursor(last.sortValue(order.column.name), last.ID)
+	}
+	return &lockouts, info, nil
+}
+
+// Apply filters, sorts and pages lockouts already loaded in memory the same way ReadAllLockouts does in SQL
+func (q LockoutQuery) Apply(lockouts []Lockout) ([]Lockout, PageInfo, error) {
+	order, err := q.order("-created_at", lockoutSortColumns)
+	if err != nil {
+		return []Lockout{}, PageInfo{}, err
+	}
+	matched := []Lockout{}
+	for i := range lockouts {
+		if q.Subject == "" || lockouts[i].Subject == q.Subject {
+			matched = append(matched, lockouts[i])
+		}
+	}
+	idx, next, err := q.inMemory(order, len(matched), func(i int) (interface{}, uint) {
+		return matched[i].sortValue(order.column.name), matched[i].ID
+	})
+	if err != nil {
+		return []Lockout{}, PageInfo{}, err
+	}
+	page := make([]Lockout, 0, len(idx))
+	for _, i := range idx {
+		page = append(page, matched[i])
+	}
+	return page, PageInfo{Next: next, Total: int64(len(matched))}, nil
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultPageLimit is used when no limit is asked for
+	DefaultPageLimit = 20
+	// MaxPageLimit caps how many rows one request can pull
+	MaxPageLimit = 100
+)
+
+// ErrInvalidCursor is returned when the after cursor can't be decoded
+var ErrInvalidCursor error = &apierror.BadRequest{Code: "invalid_cursor", Message: "Invalid Cursor"}
+
+// ErrInvalidSort is returned when sorting by a column that isn't allowed
+var ErrInvalidSort error = &apierror.BadRequest{Code: "invalid_sort", Message: "Invalid Sort"}
+
+// Page asks for one slice of a listing: up to Limit rows after the After cursor, ordered by Sort
+type Page struct {
+	Limit int
+	After string
+	Sort  string // column name, prefixed with - for descending
+}
+
+// PageInfo describes where a listing stopped, Next is empty on the last page
+type PageInfo struct {
+	Next  string
+	Total int64
+}
+
+// sortColumn is a column listings may be ordered by, expr is the SQL ordered by when it isn't the column
+// itself
+type sortColumn struct {
+	name   string
+	isTime bool
+	expr   string
+}
+
+// sortOrder is a parsed Page.Sort
+type sortOrder struct {
+	column sortColumn
+	desc   bool
+}
+
+// cursor is the opaque after value: the sort column value and id of the last row handed out
+type cursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+func (pg Page) limit() int {
+	if pg.Limit < 1 {
+		return DefaultPageLimit
+	}
+	if pg.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return pg.Limit
+}
+
+// order parses Sort against the allowed columns, falling back to def
+func (pg Page) order(def string, allowed []sortColumn) (sortOrder, error) {
+	sort := pg.Sort
+	if sort == "" {
+		sort = def
+	}
+	desc := strings.HasPrefix(sort, "-")
+	name := strings.TrimPrefix(sort, "-")
+	for _, c := range allowed {
+		if c.name == name {
+			return sortOrder{column: c, desc: desc}, nil
+		}
+	}
+	return sortOrder{}, ErrInvalidSort
+}
+
+// seek orders the query, skips past the cursor and fetches one extra row to learn if there is a next page
+func (pg Page) seek(db *gorm.DB, o sortOrder) (*gorm.DB, error) {
+	dir, op := "ASC", ">"
+	if o.desc {
+		dir, op = "DESC", "<"
+	}
+	col := o.column.name
+	if o.column.expr != "" {
+		col = o.column.expr
+	}
+	db = db.Order(fmt.Sprintf("%s %s, id %s", col, dir, dir))
+
+	if pg.After != "" {
+		c, err := decodeCursor(pg.After)
+		if err != nil {
+			return db, err
+		}
+		var value interface{} = c.Value
+		if o.column.isTime {
+			t, err := time.Parse(time.RFC3339Nano, c.Value)
+			if err != nil {
+				return db, ErrInvalidCursor
+			}
+			value = t
+		}
+		if col == "id" {
+			db = db.Where(fmt.Sprintf("id %s ?", op), c.ID)
+		} else {
+			db = db.Where(fmt.Sprintf("((%s %s ?) OR (%s = ? AND id %s ?))", col, op, col, op), value, value, c.ID)
+		}
+	}
+	return db.Limit(pg.limit() + 1), nil
+}
+
+// encodeCursor turns the last row's sort value and id into an opaque string
+func encodeCursor(value interface{}, id uint) string {
+	c := cursor{ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		c.Value = v.Format(time.RFC3339Nano)
+	default:
+		c.Value = fmt.Sprintf("%v", v)
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	c := cursor{}
+	if err = json.Unmarshal(b, &c); err != nil || c.ID == 0 {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// inMemory pages n rows that are already loaded, mirroring seek; row returns the sort value and id of row i.
+// It returns the indexes of the rows on the page in order, and the cursor to the next page
+func (pg Page) inMemory(o sortOrder, n int, row func(i int) (interface{}, uint)) ([]int, string, error) {
+	less := func(av interface{}, aid uint, bv interface{}, bid uint) bool {
+		c := compareValues(av, bv)
+		if c == 0 {
+			c = compareValues(aid, bid)
+		}
+		if o.desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	idx := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		idx = append(idx, i)
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		av, aid := row(idx[a])
+		bv, bid := row(idx[b])
+		return less(av, aid, bv, bid)
+	})
+
+	if pg.After != "" {
+		c, err := decodeCursor(pg.After)
+		if err != nil {
+			return nil, "", err
+		}
+		var after interface{} = c.Value
+		switch {
+		case o.column.isTime:
+			t, err := time.Parse(time.RFC3339Nano, c.Value)
+			if err != nil {
+				return nil, "", ErrInvalidCursor
+			}
+			after = t
+		case o.column.name == "id":
+			after = c.ID
+		}
+		rest := idx[:0]
+		for _, i := range idx {
+			v, id := row(i)
+			if less(after, c.ID, v, id) {
+				rest = append(rest, i)
+			}
+		}
+		idx = rest
+	}
+
+	next := ""
+	if len(idx) > pg.limit() {
+		idx = idx[:pg.limit()]
+		v, id := row(idx[len(idx)-1])
+		next = encodeCursor(v, id)
+	}
+	return idx, next, nil
+}
+
+// compareValues orders two sort values of the same column, returning -1, 0 or 1
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case time.Time:
+		bv := b.(time.Time)
+		switch {
+		case av.Before(bv):
+			return -1
+		case av.After(bv):
+			return 1
+		}
+	case string:
+		return strings.Compare(av, b.(string))
+	case uint:
+		bv := b.(uint)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+	}
+	return 0
+}
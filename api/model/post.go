@@ -1,80 +1,253 @@
 package model
 
 import (
-	"errors"
 	"html"
 	"strings"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/util/markdown"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Post contains the blog post details
 type Post struct {
 	gorm.Model
-	Title    string `gorm:"size:100;not null;unique;" json:"title"`
-	Content  string `gorm:"size:255;not null;" json:"content"`
-	AuthorID uint   `json:"author_id"`
-	Author   User   `json:"author"`
+	Title       string     `gorm:"size:100;not null;" json:"title"`
+	Slug        string     `gorm:"size:100;not null;uniqueIndex;" json:"slug"`
+	Content     string     `gorm:"type:text;not null;" json:"content"`
+	ContentHTML string     `gorm:"-" json:"content_html"`
+	Excerpt     string     `gorm:"-" json:"excerpt"`
+	Status      string     `gorm:"size:20;not null;default:draft;index;" json:"status"`
+	PublishedAt *time.Time `json:"published_at"`
+	AuthorID    uint       `json:"author_id"`
+	Author      User       `json:"author"`
+	Tags        []Tag      `gorm:"many2many:post_tags;" json:"tags"`
+	Categories  []Category `gorm:"many2many:post_categories;" json:"categories"`
 }
 
-// Prepare Escapes and Trims title and content
+// MaxTitleLength is the longest title, in characters, Validate accepts
+const MaxTitleLength = 100
+
+// Prepare Escapes and Trims title, Trims the Markdown content, defaults the status to draft, stamps published_at
+// and trims the tag and category names
 func (p *Post) Prepare() {
 	p.Title = html.EscapeString(strings.TrimSpace(p.Title))
-	p.Content = html.EscapeString(strings.TrimSpace(p.Content))
+	p.Slug = strings.TrimSpace(p.Slug)
+	p.Content = strings.TrimSpace(p.Content)
+	p.Status = strings.ToLower(strings.TrimSpace(p.Status))
+	p.PrepareStatus(time.Now())
+	p.prepareTerms()
 	p.Author = User{}
 }
 
-// Validate checks required fields
-func (p *Post) Validate() error {
-	if p.Title == "" {
-		return errors.New("Required: Title")
-	}
-	if p.Content == "" {
-		return errors.New("Required: Content")
-	}
+// Validate checks the fields and returns what is wrong with all of them, the Markdown content may be up to
+// maxContentLength characters
+func (p *Post) Validate(maxContentLength int) error {
+	var errs validate.Errors
+	errs.Check(validate.Field{Name: "title", Label: "Title", Value: p.Title,
+		Rules: []validate.Rule{validate.Required, validate.MaxLength(MaxTitleLength)}})
+	errs.Check(validate.Field{Name: "content", Label: "Content", Value: p.Content,
+		Rules: []validate.Rule{validate.Required, validate.MaxLength(maxContentLength)}})
 	if p.AuthorID < 1 {
-		return errors.New("Required: Author")
+		errs.Add("author_id", apierror.CodeRequired, "Required: Author")
 	}
+	p.validateTerms(&errs)
+	p.validateStatus(&errs, time.Now())
+	return errs.Err()
+}
+
+// Render fills ContentHTML and Excerpt from the Markdown Content
+func (p *Post) Render() {
+	p.ContentHTML = markdown.Render(p.Content)
+	p.Excerpt = markdown.Excerpt(p.Content)
+}
+
+// AfterFind renders every post read through GORM
+func (p *Post) AfterFind(tx *gorm.DB) error {
+	p.Render()
 	return nil
 }
 
-// CreatePost Inserts new post row in the Post Table
+// CreatePost Inserts new post row in the Post Table, p.Slug is the requested slug or empty to derive one.
+// Tags and categories used for the first time are created
 func (p *Post) CreatePost(db *gorm.DB) (*Post, error) {
-	if err := db.Create(&p).Error; err != nil {
+	if err := p.AssignSlug(p.Slug, slugTaken(db, 0)); err != nil {
 		return &Post{}, err
 	}
+	p.PrepareStatus(time.Now())
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(&p).Error; err != nil {
+			return err
+		}
+		return p.saveTerms(tx)
+	})
+	if err != nil {
+		return &Post{}, err
+	}
+	p.Render()
 	// Todo: if p.id != 0 return fresh pull, check for errors
 	return p, nil
 }
 
-// ReadAllPosts returns all records from the Post Table
-func (p *Post) ReadAllPosts(db *gorm.DB) (*[]Post, error) {
+// PostQuery filters and pages ReadAllPosts, zero values mean no filter; Tag and Category are slugs. Only
+// published posts are listed unless the viewer is their author or AllStatuses is set
+type PostQuery struct {
+	Page
+	AuthorID    uint
+	Status      string
+	Since       time.Time
+	Until       time.Time
+	Viewer      uint
+	AllStatuses bool
+	Tag         string
+	Category    string
+}
+
+// postSortColumns are the columns posts may be sorted by, drafts have no published_at and sort by created_at
+var postSortColumns = []sortColumn{
+	{name: "created_at", isTime: true},
+	{name: "published_at", isTime: true, expr: "COALESCE(published_at, created_at)"},
+	{name: "updated_at", isTime: true},
+	{name: "title"},
+	{name: "id"},
+}
+
+// filter narrows the query to the rows matched by q, ignoring paging
+func (q PostQuery) filter(db *gorm.DB) *gorm.DB {
+	if !q.AllStatuses {
+		db = db.Where("(status = ? OR author_id = ?)", PostPublished, q.Viewer)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	if q.AuthorID != 0 {
+		db = db.Where("author_id = ?", q.AuthorID)
+	}
+	if !q.Since.IsZero() {
+		db = db.Where("created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		db = db.Where("created_at < ?", q.Until)
+	}
+	if q.Tag != "" {
+		db = db.Where("id IN (SELECT post_tags.post_id FROM post_tags JOIN tags ON tags.id = post_tags.tag_id WHERE tags.slug = ?)", q.Tag)
+	}
+	if q.Category != "" {
+		db = db.Where("id IN (SELECT post_categories.post_id FROM post_categories JOIN categories ON categories.id = post_categories.category_id WHERE categories.slug = ?)", q.Category)
+	}
+	return db
+}
+
+// sortValue returns the value of the column a listing is ordered by
+func (p *Post) sortValue(column string) interface{} {
+	switch column {
+	case "created_at":
+		return p.CreatedAt
+	case "published_at":
+		if p.PublishedAt != nil {
+			return *p.PublishedAt
+		}
+		return p.CreatedAt
+	case "updated_at":
+		return p.UpdatedAt
+	case "title":
+		return p.Title
+	}
+	return p.ID
+}
+
+// ReadAllPosts returns one page of the Post Table matching q, newest first unless sorted otherwise
+func (p *Post) ReadAllPosts(db *gorm.DB, q PostQuery) (*[]Post, PageInfo, error) {
+	order, err := q.order("-created_at", postSortColumns)
+	if err != nil {
+		return &[]Post{}, PageInfo{}, err
+	}
+
+	info := PageInfo{}
+	if err = q.filter(db.Model(&Post{})).Count(&info.Total).Error; err != nil {
+		return &[]Post{}, PageInfo{}, err
+	}
+
+	query, err := q.seek(q.filter(db), order)
+	if err != nil {
+		return &[]Post{}, PageInfo{}, err
+	}
+
+	// Assembles the Authors, tags and categories in one query each rather than one per post
 	var posts []Post
-	if err := db.Find(&posts).Error; err != nil {
-		return &[]Post{}, err
+	if err = query.Preload("Author").Preload("Tags").Preload("Categories").Find(&posts).Error; err != nil {
+		return &[]Post{}, PageInfo{}, err
 	}
 
-	// Assembles the Author
-	if len(posts) > 0 {
-		for i, post := range posts {
-			if err := db.Model(&User{}).Where("id = ?", post.AuthorID).Take(&posts[i].Author).Error; err != nil {
-				return &[]Post{}, err
-			}
+	// seek fetched one row past the limit, if it came back there is another page
+	if len(posts) > q.limit() {
+		posts = posts[:q.limit()]
+		last := posts[len(posts)-1]
+		info.Next = encodeCursor(last.sortValue(order.column.name), last.ID)
+	}
+	return &posts, info, nil
+}
+
+// Match reports whether the post passes q's filters, used by stores that filter in memory
+func (q PostQuery) Match(p *Post) bool {
+	if !q.AllStatuses && !p.Published() && (q.Viewer == 0 || p.AuthorID != q.Viewer) {
+		return false
+	}
+	if q.Status != "" && p.Status != q.Status {
+		return false
+	}
+	if q.AuthorID != 0 && p.AuthorID != q.AuthorID {
+		return false
+	}
+	if !q.Since.IsZero() && p.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !p.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.Tag != "" && !p.HasTag(q.Tag) {
+		return false
+	}
+	if q.Category != "" && !p.InCategory(q.Category) {
+		return false
+	}
+	return true
+}
+
+// Apply filters, sorts and pages posts already loaded in memory the same way ReadAllPosts does in SQL
+func (q PostQuery) Apply(posts []Post) ([]Post, PageInfo, error) {
+	order, err := q.order("-created_at", postSortColumns)
+	if err != nil {
+		return []Post{}, PageInfo{}, err
+	}
+	matched := []Post{}
+	for i := range posts {
+		if q.Match(&posts[i]) {
+			matched = append(matched, posts[i])
 		}
 	}
-	return &posts, nil
+	idx, next, err := q.inMemory(order, len(matched), func(i int) (interface{}, uint) {
+		return matched[i].sortValue(order.column.name), matched[i].ID
+	})
+	if err != nil {
+		return []Post{}, PageInfo{}, err
+	}
+	page := make([]Post, 0, len(idx))
+	for _, i := range idx {
+		page = append(page, matched[i])
+	}
+	return page, PageInfo{Next: next, Total: int64(len(matched))}, nil
 }
 
 // ReadPostByID queries the Post table by supplied ID returns match
 func (p *Post) ReadPostByID(db *gorm.DB, id uint) (*Post, error) {
 	var err error
-	if err = db.Take(&p, id).Error; err != nil {
+	if err = db.Preload("Tags").Preload("Categories").Take(&p, id).Error; err != nil {
 		return &Post{}, err
 	}
-	// Post not found Error, otherwise error is....?
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return &Post{}, errors.New("Post Not Found")
-	}
 
 	// Assembles the Author
 	if p.ID != 0 {
@@ -85,27 +258,58 @@ func (p *Post) ReadPostByID(db *gorm.DB, id uint) (*Post, error) {
 	return p, err
 }
 
-// UpdatePost saves columns
+// UpdatePost saves columns. p.Slug is the requested slug; when empty the slug is derived again only if
+// the title changed. A replaced slug is kept as a redirect
 func (p *Post) UpdatePost(db *gorm.DB) (*Post, error) {
-	res := db.Model(&p).Updates(map[string]interface{}{
-		"title":     p.Title,
-		"content":   p.Content,
-		"author":    p.Author,
-		"author_id": p.AuthorID,
-	})
+	current := Post{}
+	err := db.Take(&current, p.ID).Error
+	if err != nil {
+		return &Post{}, err
+	}
+	p.KeepStatus(&current)
+	p.PrepareStatus(time.Now())
+	requested := p.Slug
+	p.Slug = current.Slug
+	if requested != "" || p.Title != current.Title {
+		if err = p.AssignSlug(requested, slugTaken(db, p.ID)); err != nil {
+			return &Post{}, err
+		}
+	}
 
-	var err error
-	if err = res.Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&p).Updates(map[string]interface{}{
+			"title":        p.Title,
+			"slug":         p.Slug,
+			"content":      p.Content,
+			"status":       p.Status,
+			"published_at": p.PublishedAt,
+			"author_id":    p.AuthorID,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if p.Slug != current.Slug && current.Slug != "" {
+			// The new slug may be one the post had before, it stops being a redirect
+			if err := tx.Where("post_id = ? AND slug = ?", p.ID, p.Slug).Delete(&PostSlug{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&PostSlug{PostID: p.ID, Slug: current.Slug}).Error; err != nil {
+				return err
+			}
+		}
+		return p.saveTerms(tx)
+	})
+	if err != nil {
 		return &Post{}, err
 	}
 
 	// Fresh pull and assemble author
 	postUpdated := &Post{}
-	if err = db.Take(&postUpdated, p.ID).Error; err != nil {
+	if err = db.Preload("Tags").Preload("Categories").Take(&postUpdated, p.ID).Error; err != nil {
 		return &Post{}, err
 	}
 	if postUpdated.ID != 0 {
-		if err = db.Model(&User{}).Where("id = ?", postUpdated.ID).Take(&postUpdated.Author).Error; err != nil {
+		if err = db.Model(&User{}).Where("id = ?", postUpdated.AuthorID).Take(&postUpdated.Author).Error; err != nil {
 			return &Post{}, err
 		}
 	}
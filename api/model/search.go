@@ -0,0 +1,178 @@
+package model
+
+import (
+	"html"
+	"strconv"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/search"
+	"github.com/aaronprice00/goblog-mvc/api/util/markdown"
+	"gorm.io/gorm"
+)
+
+// ErrEmptySearch is returned for a search without any words to look for
+var ErrEmptySearch error = &apierror.BadRequest{Code: "invalid_query", Message: "Required: q"}
+
+// tsQuery parses the search text the way people type into search boxes: quoted phrases, or and -word
+const tsQuery = "websearch_to_tsquery('english', ?)"
+
+// SearchQuery finds the posts matching Text, best first, narrowed and made visible like PostQuery. Only
+// Page's Limit and After are used, results are always ordered by rank
+type SearchQuery struct {
+	PostQuery
+	Text string
+}
+
+// SearchResult is one post a search found, with its rank and HTML highlighting the matched words
+type SearchResult struct {
+	Post             Post    `json:"post"`
+	Rank             float64 `json:"rank"`
+	TitleHighlight   string  `json:"title_highlight"`
+	ContentHighlight string  `json:"content_highlight"`
+}
+
+// Document is what a search.Index keeps of the post, its title and content as plain text
+func (p *Post) Document() search.Document {
+	return search.Document{ID: p.ID, Title: html.UnescapeString(p.Title), Content: markdown.Text(p.Content)}
+}
+
+// highlight marks the words of terms in the title and in a snippet of the content
+func (r *SearchResult) highlight(terms []string) {
+	r.TitleHighlight = search.Highlight(html.UnescapeString(r.Post.Title), terms)
+	r.ContentHighlight = search.Snippet(markdown.Text(r.Post.Content), terms)
+}
+
+// offset reads how many results to skip from the After cursor, search pages by position not by a column
+func (q SearchQuery) offset() (int, error) {
+	if q.After == "" {
+		return 0, nil
+	}
+	c, err := decodeCursor(q.After)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(c.Value)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return n, nil
+}
+
+// SearchPosts ranks posts with Postgres full-text search over the search_vector column, a weighted tsvector
+// of title and content the database keeps up to date
+func SearchPosts(db *gorm.DB, q SearchQuery) (*[]SearchResult, PageInfo, error) {
+	terms := search.Terms(q.Text)
+	if len(terms) == 0 {
+		return &[]SearchResult{}, PageInfo{}, ErrEmptySearch
+	}
+	offset, err := q.offset()
+	if err != nil {
+		return &[]SearchResult{}, PageInfo{}, err
+	}
+
+	info := PageInfo{}
+	matched := func(db *gorm.DB) *gorm.DB {
+		return q.filter(db.Model(&Post{})).Where("search_vector @@ "+tsQuery, q.Text)
+	}
+	if err = matched(db).Count(&info.Total).Error; err != nil {
+		return &[]SearchResult{}, PageInfo{}, err
+	}
+
+	var hits []struct {
+		ID   uint
+		Rank float64
+	}
+	err = matched(db).Select("id, ts_rank(search_vector, "+tsQuery+") AS rank", q.Text).
+		Order("rank DESC, id DESC").Offset(offset).Limit(q.limit() + 1).Scan(&hits).Error
+	if err != nil {
+		return &[]SearchResult{}, PageInfo{}, err
+	}
+
+	ids := make([]uint, len(hits))
+	for i := range hits {
+		ids[i] = hits[i].ID
+	}
+	var posts []Post
+	if len(ids) > 0 {
+		if err = db.Preload("Author").Preload("Tags").Preload("Categories").Where("id IN ?", ids).Find(&posts).Error; err != nil {
+			return &[]SearchResult{}, PageInfo{}, err
+		}
+	}
+	byID := map[uint]Post{}
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+	matches := make([]search.Match, len(hits))
+	for i := range hits {
+		matches[i] = search.Match{ID: hits[i].ID, Score: hits[i].Rank}
+	}
+	results, next := q.page(matches, byID, offset)
+	info.Next = next
+	return &results, info, nil
+}
+
+// page turns matches into highlighted results; matches start at offset and may run one past the limit
+func (q SearchQuery) page(matches []search.Match, posts map[uint]Post, offset int) ([]SearchResult, string) {
+	next := ""
+	if len(matches) > q.limit() {
+		matches = matches[:q.limit()]
+		next = encodeCursor(offset+len(matches), matches[len(matches)-1].ID)
+	}
+	terms := search.Terms(q.Text)
+	results := []SearchResult{}
+	for _, m := range matches {
+		p, ok := posts[m.ID]
+		if !ok {
+			// Deleted between ranking and loading
+			continue
+		}
+		r := SearchResult{Post: p, Rank: m.Score}
+		r.highlight(terms)
+		results = append(results, r)
+	}
+	return results, next
+}
+
+// Rank pages the matches of a search.Index the way SearchPosts does, posts holds the loaded posts that
+// may be matched; the ones that are gone or q doesn't let the viewer see are dropped
+func (q SearchQuery) Rank(matches []search.Match, posts []Post) ([]SearchResult, PageInfo, error) {
+	if len(search.Terms(q.Text)) == 0 {
+		return []SearchResult{}, PageInfo{}, ErrEmptySearch
+	}
+	offset, err := q.offset()
+	if err != nil {
+		return []SearchResult{}, PageInfo{}, err
+	}
+	byID := map[uint]Post{}
+	for i := range posts {
+		if q.Match(&posts[i]) {
+			byID[posts[i].ID] = posts[i]
+		}
+	}
+	visible := []search.Match{}
+	for _, m := range matches {
+		if _, ok := byID[m.ID]; ok {
+			visible = append(visible, m)
+		}
+	}
+	info := PageInfo{Total: int64(len(visible))}
+	if offset > len(visible) {
+		offset = len(visible)
+	}
+	results, next := q.page(visible[offset:], byID, offset)
+	info.Next = next
+	return results, info, nil
+}
+
+// ReadSearchDocuments returns the Document of every post, for filling a search.Index
+func ReadSearchDocuments(db *gorm.DB) ([]search.Document, error) {
+	var posts []Post
+	if err := db.Select("id, title, content").Find(&posts).Error; err != nil {
+		return nil, err
+	}
+	docs := make([]search.Document, len(posts))
+	for i := range posts {
+		docs[i] = posts[i].Document()
+	}
+	return docs, nil
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"errors"
+	"html"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/util/slug"
+	"gorm.io/gorm"
+)
+
+// ErrSlugTaken is returned when a requested slug belongs to another post, currently or as a redirect
+var ErrSlugTaken error = &apierror.Conflict{Field: "slug", Message: "Slug Already Used"}
+
+// ErrInvalidSlug is returned when a requested slug has nothing left once normalised
+var ErrInvalidSlug error = apierror.Invalid("slug", apierror.CodeInvalid, "Invalid Slug")
+
+// PostSlug is a slug a post used to have, kept so old URLs redirect to the current one
+type PostSlug struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	PostID    uint      `gorm:"not null;index;" json:"post_id"`
+	Slug      string    `gorm:"size:100;not null;unique;" json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SlugTaken reports whether a post other than the one being saved holds slug, currently or as a redirect
+type SlugTaken func(slug string) (bool, error)
+
+// AssignSlug sets p.Slug. A requested slug is normalised and must be free; otherwise the slug is derived
+// from the title, with -2, -3... appended until it is free
+func (p *Post) AssignSlug(requested string, taken SlugTaken) error {
+	if requested != "" {
+		s := slug.Make(requested)
+		if s == "" {
+			return ErrInvalidSlug
+		}
+		used, err := taken(s)
+		if err != nil {
+			return err
+		}
+		if used {
+			return ErrSlugTaken
+		}
+		p.Slug = s
+		return nil
+	}
+
+	// Prepare escaped the title, the slug is made from what the author typed
+	base := slug.Make(html.UnescapeString(p.Title))
+	if base == "" {
+		base = "post"
+	}
+	for n := 1; ; n++ {
+		candidate := slug.WithSuffix(base, n)
+		used, err := taken(candidate)
+		if err != nil {
+			return err
+		}
+		if !used {
+			p.Slug = candidate
+			return nil
+		}
+	}
+}
+
+// slugTaken checks posts, soft deleted ones included since they keep their unique slug, and redirects
+func slugTaken(db *gorm.DB, postID uint) SlugTaken {
+	return func(s string) (bool, error) {
+		var count int64
+		if err := db.Unscoped().Model(&Post{}).Where("slug = ? AND id <> ?", s, postID).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+		if err := db.Model(&PostSlug{}).Where("slug = ? AND post_id <> ?", s, postID).Count(&count).Error; err != nil {
+			return false, err
+		}
+		return count > 0, nil
+	}
+}
+
+// ReadPostBySlug returns the post whose current or former slug is s; a former slug is told apart by
+// comparing it with the returned post's Slug
+func (p *Post) ReadPostBySlug(db *gorm.DB, s string) (*Post, error) {
+	err := db.Preload("Tags").Preload("Categories").Where("slug = ?", s).Take(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		old := PostSlug{}
+		if err = db.Where("slug = ?", s).Take(&old).Error; err != nil {
+			return &Post{}, err
+		}
+		err = db.Preload("Tags").Preload("Categories").Take(&p, old.PostID).Error
+	}
+	if err != nil {
+		return &Post{}, err
+	}
+
+	// Assembles the Author
+	if err = db.Model(&User{}).Where("id = ?", p.AuthorID).Take(&p.Author).Error; err != nil {
+		return &Post{}, err
+	}
+	return p, nil
+}
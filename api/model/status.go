@@ -0,0 +1,78 @@
+package model
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+	"gorm.io/gorm"
+)
+
+// Post statuses, only published posts are public
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
+// ErrInvalidStatus is returned for a status that isn't one of the above
+var ErrInvalidStatus error = apierror.Invalid("status", apierror.CodeInvalid, "Invalid Status")
+
+// ValidStatus reports whether status is one a post can have
+func ValidStatus(status string) bool {
+	switch status {
+	case PostDraft, PostScheduled, PostPublished, PostArchived:
+		return true
+	}
+	return false
+}
+
+// Published reports whether the post is public
+func (p *Post) Published() bool {
+	return p.Status == PostPublished
+}
+
+// PrepareStatus defaults a new post to draft, stamps published_at the first time a post is published
+// and clears it on drafts. Stores call it on every save, like a column default
+func (p *Post) PrepareStatus(now time.Time) {
+	if p.Status == "" {
+		p.Status = PostDraft
+	}
+	switch p.Status {
+	case PostPublished:
+		if p.PublishedAt == nil {
+			p.PublishedAt = &now
+		}
+	case PostDraft:
+		p.PublishedAt = nil
+	}
+}
+
+// KeepStatus fills the status and published_at an update left out from the post as it is stored
+func (p *Post) KeepStatus(current *Post) {
+	if p.Status == "" {
+		p.Status = current.Status
+	}
+	// Publishing a scheduled post early publishes it now rather than at the scheduled time
+	if p.PublishedAt == nil && !(current.Status == PostScheduled && p.Status == PostPublished) {
+		p.PublishedAt = current.PublishedAt
+	}
+}
+
+// validateStatus records an unknown status, or a scheduled post without a future published_at
+func (p *Post) validateStatus(errs *validate.Errors, now time.Time) {
+	if !ValidStatus(p.Status) {
+		errs.Include(ErrInvalidStatus)
+		return
+	}
+	if p.Status == PostScheduled && (p.PublishedAt == nil || !p.PublishedAt.After(now)) {
+		errs.Add("published_at", apierror.CodeRequired, "Required: Future Published At")
+	}
+}
+
+// PublishDuePosts publishes every scheduled post whose published_at has passed, returns how many
+func PublishDuePosts(db *gorm.DB, now time.Time) (int64, error) {
+	res := db.Model(&Post{}).Where("status = ? AND published_at <= ?", PostScheduled, now).Update("status", PostPublished)
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/util/slug"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+	"gorm.io/gorm"
+)
+
+// MaxTermsPerPost caps the tags, and separately the categories, one post may have
+const MaxTermsPerPost = 20
+
+// maxTermName is the longest tag or category name, in characters
+const maxTermName = 50
+
+// Tag is a free form label, created the first time an author uses it
+type Tag struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `gorm:"size:50;not null;" json:"name"`
+	Slug      string    `gorm:"size:100;not null;unique;" json:"slug"`
+	PostCount *int64    `gorm:"-" json:"post_count,omitempty"`
+}
+
+// Category is a topic from a curated list, only editors add new ones
+type Category struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `gorm:"size:50;not null;" json:"name"`
+	Slug      string    `gorm:"size:100;not null;unique;" json:"slug"`
+	PostCount *int64    `gorm:"-" json:"post_count,omitempty"`
+}
+
+// prepareTerm trims a tag or category name, collapses its whitespace and derives its slug
+func prepareTerm(name string) (string, string) {
+	name = strings.Join(strings.Fields(name), " ")
+	return name, slug.Make(name)
+}
+
+// prepareTerms trims the tags and categories on the post and drops repeats of the same slug; nil is kept
+// as nil since it means an update leaves them alone
+func (p *Post) prepareTerms() {
+	if p.Tags != nil {
+		tags := []Tag{}
+		seen := map[string]bool{}
+		for _, t := range p.Tags {
+			name, s := prepareTerm(t.Name)
+			if s == "" || !seen[s] {
+				seen[s] = true
+				tags = append(tags, Tag{Name: name, Slug: s})
+			}
+		}
+		p.Tags = tags
+	}
+	if p.Categories != nil {
+		categories := []Category{}
+		seen := map[string]bool{}
+		for _, c := range p.Categories {
+			name, s := prepareTerm(c.Name)
+			if s == "" || !seen[s] {
+				seen[s] = true
+				categories = append(categories, Category{Name: name, Slug: s})
+			}
+		}
+		p.Categories = categories
+	}
+}
+
+// validateTerms records more than MaxTermsPerPost tags or categories and the first name of each that
+// makes no slug or doesn't fit the column
+func (p *Post) validateTerms(errs *validate.Errors) {
+	if len(p.Tags) > MaxTermsPerPost {
+		errs.Add("tags", apierror.CodeTooMany, fmt.Sprintf("Too Many Tags: max %d", MaxTermsPerPost))
+	}
+	for _, t := range p.Tags {
+		if t.Slug == "" || utf8.RuneCountInString(t.Name) > maxTermName {
+			errs.Add("tags", apierror.CodeInvalid, "Invalid Tag: "+t.Name)
+			break
+		}
+	}
+	if len(p.Categories) > MaxTermsPerPost {
+		errs.Add("categories", apierror.CodeTooMany, fmt.Sprintf("Too Many Categories: max %d", MaxTermsPerPost))
+	}
+	for _, c := range p.Categories {
+		if c.Slug == "" || utf8.RuneCountInString(c.Name) > maxTermName {
+			errs.Add("categories", apierror.CodeInvalid, "Invalid Category: "+c.Name)
+			break
+		}
+	}
+}
+
+// saveTerms links the post to its tags and categories, creating the ones used for the first time.
+// Nil leaves the post's current ones in place
+func (p *Post) saveTerms(tx *gorm.DB) error {
+	if p.Tags != nil {
+		tags := make([]Tag, len(p.Tags))
+		for i, t := range p.Tags {
+			if err := tx.Where(Tag{Slug: t.Slug}).Attrs(Tag{Name: t.Name}).FirstOrCreate(&tags[i]).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(p).Association("Tags").Replace(&tags); err != nil {
+			return err
+		}
+		p.Tags = tags
+	}
+	if p.Categories != nil {
+		categories := make([]Category, len(p.Categories))
+		for i, c := range p.Categories {
+			if err := tx.Where(Category{Slug: c.Slug}).Attrs(Category{Name: c.Name}).FirstOrCreate(&categories[i]).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(p).Association("Categories").Replace(&categories); err != nil {
+			return err
+		}
+		p.Categories = categories
+	}
+	return nil
+}
+
+// termRow is a tag or category with the number of published posts it is on
+type termRow struct {
+	ID        uint
+	CreatedAt time.Time
+	Name      string
+	Slug      string
+	Posts     int64
+}
+
+// readTerms lists table, tags or categories, by name with the count of published posts through join
+func readTerms(db *gorm.DB, table, join, column string) ([]termRow, error) {
+	var rows []termRow
+	err := db.Table(table).
+		Select(fmt.Sprintf("%s.id, %s.created_at, %s.name, %s.slug, COUNT(posts.id) AS posts", table, table, table, table)).
+		Joins(fmt.Sprintf("LEFT JOIN %s ON %s.%s = %s.id", join, join, column, table)).
+		Joins(fmt.Sprintf("LEFT JOIN posts ON posts.id = %s.post_id AND posts.status = ? AND posts.deleted_at IS NULL", join), PostPublished).
+		Group(fmt.Sprintf("%s.id, %s.created_at, %s.name, %s.slug", table, table, table, table)).
+		Order(table + ".name").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// ReadAllTags returns every tag by name with the number of published posts carrying it
+func ReadAllTags(db *gorm.DB) (*[]Tag, error) {
+	rows, err := readTerms(db, "tags", "post_tags", "tag_id")
+	if err != nil {
+		return &[]Tag{}, err
+	}
+	tags := make([]Tag, len(rows))
+	for i := range rows {
+		tags[i] = Tag{ID: rows[i].ID, CreatedAt: rows[i].CreatedAt, Name: rows[i].Name, Slug: rows[i].Slug, PostCount: &rows[i].Posts}
+	}
+	return &tags, nil
+}
+
+// ReadTagBySlug returns the tag with the slug
+func ReadTagBySlug(db *gorm.DB, s string) (*Tag, error) {
+	t := Tag{}
+	if err := db.Where("slug = ?", s).Take(&t).Error; err != nil {
+		return &Tag{}, err
+	}
+	return &t, nil
+}
+
+// ReadAllCategories returns every category by name with the number of published posts in it
+func ReadAllCategories(db *gorm.DB) (*[]Category, error) {
+	rows, err := readTerms(db, "categories", "post_categories", "category_id")
+	if err != nil {
+		return &[]Category{}, err
+	}
+	categories := make([]Category, len(rows))
+	for i := range rows {
+		categories[i] = Category{ID: rows[i].ID, CreatedAt: rows[i].CreatedAt, Name: rows[i].Name, Slug: rows[i].Slug, PostCount: &rows[i].Posts}
+	}
+	return &categories, nil
+}
+
+// ReadCategoryBySlug returns the category with the slug
+func ReadCategoryBySlug(db *gorm.DB, s string) (*Category, error) {
+	c := Category{}
+	if err := db.Where("slug = ?", s).Take(&c).Error; err != nil {
+		return &Category{}, err
+	}
+	return &c, nil
+}
+
+// HasTag reports whether the post carries the tag with the slug
+func (p *Post) HasTag(s string) bool {
+	for _, t := range p.Tags {
+		if t.Slug == s {
+			return true
+		}
+	}
+	return false
+}
+
+// InCategory reports whether the post is in the category with the slug
+func (p *Post) InCategory(s string) bool {
+	for _, c := range p.Categories {
+		if c.Slug == s {
+			return true
+		}
+	}
+	return false
+}
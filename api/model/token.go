@@ -0,0 +1,176 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshToken is a long lived, single use token exchanged for a new token pair; only its hash is stored
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;unique;" json:"-"`
+	AccessJTI string     `gorm:"size:32;" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;" json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// RevokedToken holds the jti of an access token that may no longer be used
+type RevokedToken struct {
+	gorm.Model
+	JTI       string    `gorm:"size:32;not null;unique;" json:"jti"`
+	ExpiresAt time.Time `gorm:"not null;" json:"expires_at"`
+}
+
+// Purposes a UserToken is issued for, the first two are mailed and the last is handed out by Login to a
+// user who still has to give a one time code
+const (
+	VerifyEmailPurpose   = "verify_email"
+	ResetPasswordPurpose = "reset_password"
+	TwoFactorPurpose     = "two_factor"
+)
+
+// ErrTokenUsed is returned when a UserToken or RefreshToken has been spent already
+var ErrTokenUsed = errors.New("Token Used")
+
+// UserToken is a single use token proving the user read a mail or got past the password; only its hash is stored
+type UserToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"size:20;not null;" json:"purpose"`
+	TokenHash string     `gorm:"size:64;not null;unique;" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null;" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// Active reports whether the user token can still be used
+func (ut *UserToken) Active() bool {
+	return ut.UsedAt == nil && time.Now().Before(ut.ExpiresAt)
+}
+
+// CreateUserToken Inserts new user token row, the user's earlier unused tokens for the same purpose are
+// spent so only the newest mail works
+func (ut *UserToken) CreateUserToken(db *gorm.DB) (*UserToken, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", ut.UserID, ut.Purpose).
+			Update("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&ut).Error
+	})
+	if err != nil {
+		return &UserToken{}, err
+	}
+	return ut, nil
+}
+
+// ReadUserTokenByHash queries the UserToken table by token hash returns match
+func (ut *UserToken) ReadUserTokenByHash(db *gorm.DB, hash string) (*UserToken, error) {
+	if err := db.Where("token_hash = ?", hash).Take(&ut).Error; err != nil {
+		return &UserToken{}, err
+	}
+	return ut, nil
+}
+
+// UseUserToken spends the token, only one of two requests racing with the same token gets through
+func (ut *UserToken) UseUserToken(db *gorm.DB) error {
+	now := time.Now()
+	res := db.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", ut.ID).Update("used_at", now)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	ut.UsedAt = &now
+	return nil
+}
+
+// Active reports whether the refresh token can still be exchanged
+func (rt *RefreshToken) Active() bool {
+	return rt.RevokedAt == nil && time.Now().Before(rt.ExpiresAt)
+}
+
+// CreateRefreshToken Inserts new refresh token row
+func (rt *RefreshToken) CreateRefreshToken(db *gorm.DB) (*RefreshToken, error) {
+	if err := db.Create(&rt).Error; err != nil {
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// ReadRefreshTokenByHash queries the RefreshToken table by token hash returns match
+func (rt *RefreshToken) ReadRefreshTokenByHash(db *gorm.DB, hash string) (*RefreshToken, error) {
+	if err := db.Where("token_hash = ?", hash).Take(&rt).Error; err != nil {
+		return &RefreshToken{}, err
+	}
+	return rt, nil
+}
+
+// RevokeRefreshToken marks the refresh token used and revokes the access token issued alongside it, only
+// one of two requests racing with the same token gets through, the other gets ErrTokenUsed
+func (rt *RefreshToken) RevokeRefreshToken(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&RefreshToken{}).Where("id = ? AND revoked_at IS NULL", rt.ID).Update("revoked_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrTokenUsed
+		}
+		rt.RevokedAt = &now
+		if rt.AccessJTI == "" {
+			return nil
+		}
+		// Access tokens never outlive an hour, past that PurgeRevokedTokens drops the jti
+		revoked := RevokedToken{}
+		return revoked.RevokeToken(tx, rt.AccessJTI, now.Add(time.Hour))
+	})
+}
+
+// RevokeUserTokens revokes every active refresh token of the user and the access tokens paired with them
+func RevokeUserTokens(db *gorm.DB, uid uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var tokens []RefreshToken
+		if err := tx.Where("user_id = ? AND revoked_at IS NULL", uid).Find(&tokens).Error; err != nil {
+			return err
+		}
+		for i := range tokens {
+			// One revoked meanwhile is already where it should be
+			if err := tokens[i].RevokeRefreshToken(tx); err != nil && err != ErrTokenUsed {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RevokeToken adds the jti to the revocation list, revoking twice is not an error
+func (t *RevokedToken) RevokeToken(db *gorm.DB, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("Required: jti")
+	}
+	t.JTI = jti
+	t.ExpiresAt = expiresAt
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&t).Error
+}
+
+// IsTokenRevoked reports whether the jti is on the revocation list
+func IsTokenRevoked(db *gorm.DB, jti string) (bool, error) {
+	var count int64
+	if err := db.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// PurgeRevokedTokens deletes the jtis whose access tokens expired before now, they are rejected without the list
+func PurgeRevokedTokens(db *gorm.DB, now time.Time) (int64, error) {
+	res := db.Unscoped().Where("expires_at < ?", now).Delete(&RevokedToken{})
+	return res.RowsAffected, res.Error
+}
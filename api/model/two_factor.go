@@ -0,0 +1,99 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode stands in for a one time code when the user has lost their authenticator, each works once.
+// Only its hash is stored
+type RecoveryCode struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UserID    uint       `gorm:"not null;index;" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null;unique;" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// SetTOTPSecret stores a new secret for the user to confirm, two-factor stays off until EnableTOTP
+func SetTOTPSecret(db *gorm.DB, uid uint, secret string) error {
+	res := db.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// EnableTOTP turns two-factor on once the user proved their authenticator with the code for step, and
+// replaces their recovery codes with the hashed ones
+func EnableTOTP(db *gorm.DB, uid uint, step int64, codeHashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{UserID: uid, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseTOTPStep records that the code for step was used, it fails with ErrTokenUsed when that step or a later
+// one was used already so a code can't be replayed
+func UseTOTPStep(db *gorm.DB, uid uint, step int64) error {
+	res := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", uid, step).Update("totp_last_step", step)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode spends the user's recovery code with the hash, ErrTokenUsed when there is no unused one
+func UseRecoveryCode(db *gorm.DB, uid uint, hash string) error {
+	res := db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", uid, hash).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// DisableTOTP turns two-factor off, forgetting the secret and the recovery codes
+func DisableTOTP(db *gorm.DB, uid uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", uid).Delete(&RecoveryCode{}).Error
+	})
+}
@@ -1,22 +1,46 @@
 package model
 
 import (
-	"errors"
 	"html"
-	"log"
+	"regexp"
 	"strings"
+	"time"
 
-	"github.com/badoux/checkmail"
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-// User holds our User; gorm.Model contains ID, CreatedAt, DeletedAt, and UpdatedA details
+// User holds our User; gorm.Model contains ID, CreatedAt, DeletedAt, and UpdatedA details.
+// EmailVerifiedAt is set once the user proves the email is theirs. TOTPSecret is set on enrolling in
+// two-factor, which is on from TOTPEnabledAt; TOTPLastStep is the last time step a code was used for
 type User struct {
 	gorm.Model
-	Username string `gorm:"size:100;not null;unique;" json:"username"`
-	Email    string `gorm:"size:100;not null;unique;" json:"email"`
-	Password string `gorm:"size:100;not null;" json:"password"`
+	Username        string     `gorm:"size:100;not null;unique;" json:"username"`
+	Email           string     `gorm:"size:100;not null;unique;" json:"email"`
+	Password        string     `gorm:"size:100;not null;" json:"-"`
+	Role            string     `gorm:"size:20;not null;default:author;" json:"role"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	TOTPSecret      string     `gorm:"size:64;" json:"-"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at"`
+	TOTPLastStep    int64      `gorm:"not null;default:0;" json:"-"`
+}
+
+// ErrEmailNotVerified is returned by sign in when verified emails are required and the user's isn't
+var ErrEmailNotVerified error = &apierror.Forbidden{Code: "email_not_verified", Message: "Email Not Verified"}
+
+// ErrTwoFactorRequired is returned by sign in for a user who has to finish with a one time code
+var ErrTwoFactorRequired error = &apierror.Unauthorized{Code: "two_factor_required", Message: "Two-Factor Required"}
+
+// TwoFactor reports whether the user signs in with a one time code as well as the password
+func (u *User) TwoFactor() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// Verified reports whether the user has verified their email
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 // Hash encrypts the supplied password returns hash and error
@@ -45,49 +69,70 @@ func (u *User) Prepare() {
 	u.Email = html.EscapeString(strings.TrimSpace(u.Email))
 }
 
-// Validate checks required fields on specified actions
-func (u *User) Validate(action string) error {
-	switch strings.ToLower(action) {
-	case "update":
-		if u.Username == "" {
-			return errors.New("Required: Username")
-		}
-		if u.Password == "" {
-			return errors.New("Required: Password")
-		}
-		if u.Email == "" {
-			return errors.New("Required: Email")
-		}
-		if err := checkmail.ValidateFormat(u.Email); err != nil {
-			return errors.New("Invalid Email")
-		}
-		return nil
-	case "login":
-		if u.Password == "" {
-			return errors.New("Required: Password")
-		}
-		if u.Email == "" {
-			return errors.New("Required: Email")
-		}
-		if err := checkmail.ValidateFormat(u.Email); err != nil {
-			return errors.New("Invalid Email")
-		}
-		return nil
-	default:
-		if u.Username == "" {
-			return errors.New("Required: Username")
-		}
-		if u.Password == "" {
-			return errors.New("Required: Password")
-		}
-		if u.Email == "" {
-			return errors.New("Required: Email")
-		}
-		if err := checkmail.ValidateFormat(u.Email); err != nil {
-			return errors.New("Invalid Email")
-		}
-		return nil
+// UserOperation names what a user is being validated for, each has its own rules
+type UserOperation int
+
+// Operations users are validated for
+const (
+	UserCreate UserOperation = iota
+	UserUpdate
+	UserLogin
+)
+
+// MaxUsernameLength and MaxEmailLength fit the columns
+const (
+	MaxUsernameLength = 100
+	MaxEmailLength    = 100
+)
+
+// usernamePattern is what a username may be made of
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// UserPolicy is what the config asks of the passwords and emails users give, the server passes it to Validate.
+// Password is what new passwords have to meet, CheckEmailMX makes new and changed emails need a domain with
+// a mail server
+type UserPolicy struct {
+	Password     validate.PasswordPolicy
+	CheckEmailMX bool
+}
+
+// DefaultUserPolicy is the policy unless the config sets another
+var DefaultUserPolicy = UserPolicy{Password: validate.DefaultPasswordPolicy}
+
+// Validate checks the fields the operation needs and returns what is wrong with all of them. Logging in
+// only needs an email and password, a password being set has to meet the policy. Update checks the policy
+// with ValidatePassword only when the password changes, so older passwords can be sent back
+func (u *User) Validate(op UserOperation, policy UserPolicy) error {
+	username := validate.Field{Name: "username", Label: "Username", Value: u.Username,
+		Rules: []validate.Rule{validate.Required, validate.MaxLength(MaxUsernameLength),
+			validate.Matches(usernamePattern, "use letters, digits, '.', '_' and '-'")}}
+	email := validate.Field{Name: "email", Label: "Email", Value: u.Email, Rules: policy.EmailRules()}
+	password := validate.Field{Name: "password", Label: "Password", Value: u.Password,
+		Rules: []validate.Rule{validate.Required}}
+
+	switch op {
+	case UserLogin:
+		email.Rules = []validate.Rule{validate.Required, validate.Email}
+		return validate.Check(email, password)
+	case UserCreate:
+		password.Rules = append(password.Rules, policy.Password.Rule)
 	}
+	return validate.Check(username, email, password)
+}
+
+// EmailRules are the rules of an email being given to an account
+func (policy UserPolicy) EmailRules() []validate.Rule {
+	rules := []validate.Rule{validate.Required, validate.MaxLength(MaxEmailLength), validate.Email}
+	if policy.CheckEmailMX {
+		rules = append(rules, validate.MailServer)
+	}
+	return rules
+}
+
+// ValidatePassword checks a new password against the policy
+func (policy UserPolicy) ValidatePassword(password string) error {
+	return validate.Check(validate.Field{Name: "password", Label: "Password", Value: password,
+		Rules: []validate.Rule{validate.Required, policy.Password.Rule}})
 }
 
 // CreateUser Inserts user into db returns User and error
@@ -99,13 +144,76 @@ func (u *User) CreateUser(db *gorm.DB) (*User, error) {
 	return u, nil
 }
 
-// ReadAllUsers returns all results from User table
-func (u *User) ReadAllUsers(db *gorm.DB) (*[]User, error) {
+// UserQuery pages ReadAllUsers
+type UserQuery struct {
+	Page
+}
+
+// userSortColumns are the columns users may be sorted by
+var userSortColumns = []sortColumn{
+	{name: "created_at", isTime: true},
+	{name: "username"},
+	{name: "id"},
+}
+
+// sortValue returns the value of the column a listing is ordered by
+func (u *User) sortValue(column string) interface{} {
+	switch column {
+	case "created_at":
+		return u.CreatedAt
+	case "username":
+		return u.Username
+	}
+	return u.ID
+}
+
+// ReadAllUsers returns one page of the User table, oldest first unless sorted otherwise
+func (u *User) ReadAllUsers(db *gorm.DB, q UserQuery) (*[]User, PageInfo, error) {
+	order, err := q.order("id", userSortColumns)
+	if err != nil {
+		return &[]User{}, PageInfo{}, err
+	}
+
+	info := PageInfo{}
+	if err = db.Model(&User{}).Count(&info.Total).Error; err != nil {
+		return &[]User{}, PageInfo{}, err
+	}
+
+	query, err := q.seek(db, order)
+	if err != nil {
+		return &[]User{}, PageInfo{}, err
+	}
 	var users []User
-	if err := db.Find(&users).Error; err != nil {
-		return &[]User{}, err
+	if err = query.Find(&users).Error; err != nil {
+		return &[]User{}, PageInfo{}, err
 	}
-	return &users, nil
+
+	// seek fetched one row past the limit, if it came back there is another page
+	if len(users) > q.limit() {
+		users = users[:q.limit()]
+		last := users[len(users)-1]
+		info.Next = encodeCursor(last.sortValue(order.column.name), last.ID)
+	}
+	return &users, info, nil
+}
+
+// Apply sorts and pages users already loaded in memory the same way ReadAllUsers does in SQL
+func (q UserQuery) Apply(users []User) ([]User, PageInfo, error) {
+	order, err := q.order("id", userSortColumns)
+	if err != nil {
+		return []User{}, PageInfo{}, err
+	}
+	idx, next, err := q.inMemory(order, len(users), func(i int) (interface{}, uint) {
+		return users[i].sortValue(order.column.name), users[i].ID
+	})
+	if err != nil {
+		return []User{}, PageInfo{}, err
+	}
+	page := make([]User, 0, len(idx))
+	for _, i := range idx {
+		page = append(page, users[i])
+	}
+	return page, PageInfo{Next: next, Total: int64(len(users))}, nil
 }
 
 // ReadUserByID queries User table by ID and returns the matching user
@@ -114,10 +222,6 @@ func (u *User) ReadUserByID(db *gorm.DB, uid uint) (*User, error) {
 	if err = db.Take(&u, uid).Error; err != nil {
 		return &User{}, err
 	}
-	// User not found
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return &User{}, errors.New("User Not Found")
-	}
 	return u, err
 }
 
@@ -127,10 +231,6 @@ func (u *User) ReadUserByEmail(db *gorm.DB, email string) (*User, error) {
 	if err = db.Where("email = ?", email).Take(&u).Error; err != nil {
 		return &User{}, err
 	}
-	// User not found
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return &User{}, errors.New("User Not Found")
-	}
 	return u, err
 }
 
@@ -139,12 +239,14 @@ func (u *User) UpdateUser(db *gorm.DB, uid uint) (*User, error) {
 	var err error
 	// Hash the password
 	if err = u.BeforeSave(db); err != nil {
-		log.Fatalln(err)
+		return &User{}, err
 	}
+	// A new email is unverified until its owner proves it, compared in the statement so no one slips between
 	res := db.Model(&User{}).Where("id = ?", uid).Updates(map[string]interface{}{
-		"username": u.Username,
-		"email":    u.Email,
-		"password": u.Password,
+		"username":          u.Username,
+		"email":             u.Email,
+		"password":          u.Password,
+		"email_verified_at": gorm.Expr("CASE WHEN email = ? THEN email_verified_at ELSE NULL END", u.Email),
 	})
 	if err = res.Error; err != nil {
 		return &User{}, err
@@ -158,6 +260,36 @@ func (u *User) UpdateUser(db *gorm.DB, uid uint) (*User, error) {
 	return u, nil
 }
 
+// UpdateRole saves only the role column of the User row at supplied ID
+func (u *User) UpdateRole(db *gorm.DB, uid uint, role string) (*User, error) {
+	res := db.Model(&User{}).Where("id = ?", uid).Update("role", role)
+	if err := res.Error; err != nil {
+		return &User{}, err
+	}
+	if res.RowsAffected == 0 {
+		return &User{}, gorm.ErrRecordNotFound
+	}
+
+	// Grab a fresh copy
+	if err := db.Take(&u, uid).Error; err != nil {
+		return &User{}, err
+	}
+	return u, nil
+}
+
+// VerifyEmail stamps the user's email verified, verifying twice keeps the first time
+func (u *User) VerifyEmail(db *gorm.DB, uid uint) (*User, error) {
+	res := db.Model(&User{}).Where("id = ? AND email_verified_at IS NULL", uid).Update("email_verified_at", time.Now())
+	if err := res.Error; err != nil {
+		return &User{}, err
+	}
+	// Grab a fresh copy
+	if err := db.Take(&u, uid).Error; err != nil {
+		return &User{}, err
+	}
+	return u, nil
+}
+
 // DeleteUser sets User row inactive (will need to purge), the return int is used for Testing suite to check isDeleted = 1
 func (u *User) DeleteUser(db *gorm.DB, uid uint) (int64, error) {
 	var err error
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+)
+
+// Route groups sharing a limit, routes not in a stricter group use Default
+const (
+	Default = "default"
+	Auth    = "auth"
+	Signup  = "signup"
+)
+
+// Limit lets a client make Burst requests at once, refilled at Burst per Per. The zero Limit doesn't limit
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// DefaultLimits are used for groups the server isn't configured with
+var DefaultLimits = map[string]Limit{
+	Default: {Burst: 120, Per: time.Minute},
+	Auth:    {Burst: 10, Per: time.Minute},
+	Signup:  {Burst: 5, Per: time.Hour},
+}
+
+// Unlimited reports whether the limit lets every request through
+func (l Limit) Unlimited() bool {
+	return l.Burst < 1 || l.Per <= 0
+}
+
+// rate is how many requests are refilled per second
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / l.Per.Seconds()
+}
+
+// String writes the limit the way ParseLimit reads it
+func (l Limit) String() string {
+	if l.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// ParseLimit reads a limit like "10/1m", ten requests a minute. "off" or "0" is unlimited
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("Invalid Rate Limit: %s", s)
+	}
+	burst, err := strconv.Atoi(parts[0])
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("Invalid Rate Limit: %s", s)
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("Invalid Rate Limit: %s", s)
+	}
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+// Result is what a Store decided about one request
+type Result struct {
+	Allowed bool
+	// Remaining is how many more requests would be allowed right now
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, zero when it is allowed now
+	RetryAfter time.Duration
+}
+
+// Store keeps a token bucket per key. MemoryStore serves a single process, a store shared between
+// processes can be swapped in behind the same interface
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+var (
+	// ErrRateLimited is the error a client over its limit is answered with
+	ErrRateLimited error = &apierror.TooManyRequests{Code: "rate_limited", Message: "Too Many Requests"}
+	// ErrUnknownGroup is returned when a route asks for a group with no limit
+	ErrUnknownGroup = errors.New("Unknown Rate Limit Group")
+)
+
+// Limiter applies the limit of each route group to clients, keyed by whatever the caller identifies them by
+type Limiter struct {
+	Store  Store
+	Limits map[string]Limit
+}
+
+// NewLimiter returns a Limiter over the store, groups missing from limits get their DefaultLimits
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	merged := map[string]Limit{}
+	for group, limit := range DefaultLimits {
+		merged[group] = limit
+	}
+	for group, limit := range limits {
+		merged[group] = limit
+	}
+	return &Limiter{Store: store, Limits: merged}
+}
+
+// Limit returns the limit of the group
+func (l *Limiter) Limit(group string) (Limit, error) {
+	limit, ok := l.Limits[group]
+	if !ok {
+		return Limit{}, ErrUnknownGroup
+	}
+	return limit, nil
+}
+
+// Take spends one request of the client's bucket for the group
+func (l *Limiter) Take(group, client string) (Limit, Result, error) {
+	limit, err := l.Limit(group)
+	if err != nil {
+		return Limit{}, Result{}, err
+	}
+	if limit.Unlimited() {
+		return limit, Result{Allowed: true}, nil
+	}
+	res, err := l.Store.Take(group+"|"+client, limit, time.Now())
+	return limit, res, err
+}
+
+// bucket is the state of one key, tokens as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+// sweepEvery is how often MemoryStore forgets buckets that have refilled
+const sweepEvery = time.Minute
+
+// MemoryStore keeps the buckets in this process. Buckets that have refilled are forgotten so idle clients
+// don't hold memory. It is safe for concurrent use
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take spends a token from the key's bucket if it has one
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepEvery {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.per = limit.Per
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.rate())
+		b.last = now
+	}
+
+	res := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / limit.rate())
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.rate())
+	return res, nil
+}
+
+// sweep forgets the buckets that have had time to refill
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if now.Sub(b.last) >= b.per {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// Len is how many buckets are held
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.buckets)
+}
+
+// seconds turns a count of seconds into a Duration
+func seconds(n float64) time.Duration {
+	return time.Duration(n * float64(time.Second))
+}
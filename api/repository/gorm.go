@@ -0,0 +1,422 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/search"
+	"gorm.io/gorm"
+)
+
+// NewGormRepositories returns repositories backed by the model's GORM methods. Posts are searched with
+// Postgres full-text search, other databases get an in-process index
+func NewGormRepositories(db *gorm.DB) Repositories {
+	posts := &GormPostRepository{DB: db}
+	if db.Dialector.Name() != database.Postgres {
+		posts.Index = search.NewInvertedIndex()
+	}
+	return Repositories{
+		Users:    &GormUserRepository{DB: db},
+		Posts:    posts,
+		Tags:     &GormTagRepository{DB: db},
+		Comments: &GormCommentRepository{DB: db},
+		Tokens:   &GormTokenRepository{DB: db},
+		Attempts: &GormAttemptRepository{DB: db},
+	}
+}
+
+// WithContext returns the repositories running their GORM queries under ctx, so they are traced and
+// cancelled along with the request ctx belongs to. Other repositories are returned as they are
+func (repos Repositories) WithContext(ctx context.Context) Repositories {
+	if r, ok := repos.Users.(*GormUserRepository); ok {
+		repos.Users = &GormUserRepository{DB: r.DB.WithContext(ctx)}
+	}
+	if r, ok := repos.Posts.(*GormPostRepository); ok {
+		repos.Posts = r.WithContext(ctx)
+	}
+	if r, ok := repos.Tags.(*GormTagRepository); ok {
+		repos.Tags = &GormTagRepository{DB: r.DB.WithContext(ctx)}
+	}
+	if r, ok := repos.Comments.(*GormCommentRepository); ok {
+		repos.Comments = &GormCommentRepository{DB: r.DB.WithContext(ctx)}
+	}
+	if r, ok := repos.Tokens.(*GormTokenRepository); ok {
+		repos.Tokens = &GormTokenRepository{DB: r.DB.WithContext(ctx)}
+	}
+	if r, ok := repos.Attempts.(*GormAttemptRepository); ok {
+		repos.Attempts = &GormAttemptRepository{DB: r.DB.WithContext(ctx)}
+	}
+	return repos
+}
+
+// GormUserRepository stores users in the users table
+type GormUserRepository struct {
+	DB *gorm.DB
+}
+
+// CreateUser Inserts user
+func (r *GormUserRepository) CreateUser(u *model.User) (*model.User, error) {
+	return u.CreateUser(r.DB)
+}
+
+// ReadAllUsers returns one page of users
+func (r *GormUserRepository) ReadAllUsers(q model.UserQuery) (*[]model.User, model.PageInfo, error) {
+	u := model.User{}
+	return u.ReadAllUsers(r.DB, q)
+}
+
+// ReadUserByID returns the user with the ID
+func (r *GormUserRepository) ReadUserByID(uid uint) (*model.User, error) {
+	u := model.User{}
+	return u.ReadUserByID(r.DB, uid)
+}
+
+// ReadUserByEmail returns the user with the email
+func (r *GormUserRepository) ReadUserByEmail(email string) (*model.User, error) {
+	u := model.User{}
+	return u.ReadUserByEmail(r.DB, email)
+}
+
+// UpdateUser saves username, email and password of the user with the ID
+func (r *GormUserRepository) UpdateUser(uid uint, u *model.User) (*model.User, error) {
+	return u.UpdateUser(r.DB, uid)
+}
+
+// UpdateRole saves the role of the user with the ID
+func (r *GormUserRepository) UpdateRole(uid uint, role string) (*model.User, error) {
+	u := model.User{}
+	return u.UpdateRole(r.DB, uid, role)
+}
+
+// VerifyEmail stamps the email of the user with the ID verified
+func (r *GormUserRepository) VerifyEmail(uid uint) (*model.User, error) {
+	u := model.User{}
+	return u.VerifyEmail(r.DB, uid)
+}
+
+// DeleteUser soft deletes the user with the ID
+func (r *GormUserRepository) DeleteUser(uid uint) (int64, error) {
+	u := model.User{}
+	return u.DeleteUser(r.DB, uid)
+}
+
+// SetTOTPSecret stores a two-factor secret for the user to confirm
+func (r *GormUserRepository) SetTOTPSecret(uid uint, secret string) error {
+	return model.SetTOTPSecret(r.DB, uid, secret)
+}
+
+// EnableTOTP turns two-factor on and replaces the user's recovery codes
+func (r *GormUserRepository) EnableTOTP(uid uint, step int64, codeHashes []string) error {
+	return model.EnableTOTP(r.DB, uid, step, codeHashes)
+}
+
+// UseTOTPStep records the time step of a used code
+func (r *GormUserRepository) UseTOTPStep(uid uint, step int64) error {
+	return model.UseTOTPStep(r.DB, uid, step)
+}
+
+// UseRecoveryCode spends one of the user's recovery codes
+func (r *GormUserRepository) UseRecoveryCode(uid uint, hash string) error {
+	return model.UseRecoveryCode(r.DB, uid, hash)
+}
+
+// DisableTOTP turns two-factor off
+func (r *GormUserRepository) DisableTOTP(uid uint) error {
+	return model.DisableTOTP(r.DB, uid)
+}
+
+// GormPostRepository stores posts in the posts table. When Index is set searches go through it instead of
+// the database, it is filled from the posts table on the first search and kept current by this repository
+type GormPostRepository struct {
+	DB    *gorm.DB
+	Index search.Index
+
+	mu      sync.Mutex
+	indexed bool
+	// owner is the repository WithContext was called on, whose index state is shared
+	owner *GormPostRepository
+}
+
+// WithContext returns the repository running its queries under ctx, searching and keeping current the
+// same index
+func (r *GormPostRepository) WithContext(ctx context.Context) *GormPostRepository {
+	owner := r.state()
+	return &GormPostRepository{DB: owner.DB.WithContext(ctx), Index: owner.Index, owner: owner}
+}
+
+// state is the repository holding the index lock and whether it is filled
+func (r *GormPostRepository) state() *GormPostRepository {
+	if r.owner != nil {
+		return r.owner
+	}
+	return r
+}
+
+// CreatePost Inserts post
+func (r *GormPostRepository) CreatePost(p *model.Post) (*model.Post, error) {
+	created, err := p.CreatePost(r.DB)
+	if err == nil {
+		r.reindex(created)
+	}
+	return created, err
+}
+
+// ReadAllPosts returns one page of posts
+func (r *GormPostRepository) ReadAllPosts(q model.PostQuery) (*[]model.Post, model.PageInfo, error) {
+	p := model.Post{}
+	return p.ReadAllPosts(r.DB, q)
+}
+
+// ReadPostByID returns the post with the ID
+func (r *GormPostRepository) ReadPostByID(pid uint) (*model.Post, error) {
+	p := model.Post{}
+	return p.ReadPostByID(r.DB, pid)
+}
+
+// ReadPostBySlug returns the post whose current or former slug matches
+func (r *GormPostRepository) ReadPostBySlug(slug string) (*model.Post, error) {
+	p := model.Post{}
+	return p.ReadPostBySlug(r.DB, slug)
+}
+
+// UpdatePost saves the post's columns, keeping a replaced slug as a redirect
+func (r *GormPostRepository) UpdatePost(p *model.Post) (*model.Post, error) {
+	updated, err := p.UpdatePost(r.DB)
+	if err == nil {
+		r.reindex(updated)
+	}
+	return updated, err
+}
+
+// PublishDuePosts publishes every scheduled post whose published_at has passed
+func (r *GormPostRepository) PublishDuePosts(now time.Time) (int64, error) {
+	return model.PublishDuePosts(r.DB, now)
+}
+
+// DeletePost soft deletes the post with the ID
+func (r *GormPostRepository) DeletePost(pid uint) (int64, error) {
+	p := model.Post{}
+	deleted, err := p.DeletePost(r.DB, pid)
+	if err == nil && r.Index != nil {
+		r.Index.Remove(pid)
+	}
+	return deleted, err
+}
+
+// reindex replaces the post in the index, before the first search there is nothing to keep current
+func (r *GormPostRepository) reindex(p *model.Post) {
+	if r.Index == nil {
+		return
+	}
+	st := r.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.indexed {
+		r.Index.Add(p.Document())
+	}
+}
+
+// SearchPosts returns one page of the posts matching q, best first
+func (r *GormPostRepository) SearchPosts(q model.SearchQuery) (*[]model.SearchResult, model.PageInfo, error) {
+	if r.Index == nil {
+		return model.SearchPosts(r.DB, q)
+	}
+	if err := r.fillIndex(); err != nil {
+		return &[]model.SearchResult{}, model.PageInfo{}, err
+	}
+	matches := r.Index.Search(q.Text)
+	ids := make([]uint, len(matches))
+	for i := range matches {
+		ids[i] = matches[i].ID
+	}
+	var posts []model.Post
+	if len(ids) > 0 {
+		if err := r.DB.Preload("Author").Preload("Tags").Preload("Categories").Where("id IN ?", ids).Find(&posts).Error; err != nil {
+			return &[]model.SearchResult{}, model.PageInfo{}, err
+		}
+	}
+	results, info, err := q.Rank(matches, posts)
+	return &results, info, err
+}
+
+// fillIndex loads every post into the index the first time it is needed
+func (r *GormPostRepository) fillIndex() error {
+	st := r.state()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.indexed {
+		return nil
+	}
+	docs, err := model.ReadSearchDocuments(r.DB)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		r.Index.Add(doc)
+	}
+	st.indexed = true
+	return nil
+}
+
+// GormTagRepository reads the tags and categories tables
+type GormTagRepository struct {
+	DB *gorm.DB
+}
+
+// ReadAllTags returns every tag with its published post count
+func (r *GormTagRepository) ReadAllTags() (*[]model.Tag, error) {
+	return model.ReadAllTags(r.DB)
+}
+
+// ReadTagBySlug returns the tag with the slug
+func (r *GormTagRepository) ReadTagBySlug(slug string) (*model.Tag, error) {
+	return model.ReadTagBySlug(r.DB, slug)
+}
+
+// ReadAllCategories returns every category with its published post count
+func (r *GormTagRepository) ReadAllCategories() (*[]model.Category, error) {
+	return model.ReadAllCategories(r.DB)
+}
+
+// ReadCategoryBySlug returns the category with the slug
+func (r *GormTagRepository) ReadCategoryBySlug(slug string) (*model.Category, error) {
+	return model.ReadCategoryBySlug(r.DB, slug)
+}
+
+// GormCommentRepository stores comments in the comments table
+type GormCommentRepository struct {
+	DB *gorm.DB
+}
+
+// CreateComment Inserts comment
+func (r *GormCommentRepository) CreateComment(c *model.Comment) (*model.Comment, error) {
+	return c.CreateComment(r.DB)
+}
+
+// ReadAllComments returns one page of comments
+func (r *GormCommentRepository) ReadAllComments(q model.CommentQuery) (*[]model.Comment, model.PageInfo, error) {
+	c := model.Comment{}
+	return c.ReadAllComments(r.DB, q)
+}
+
+// ReadCommentReplies returns every reply matching q
+func (r *GormCommentRepository) ReadCommentReplies(q model.CommentQuery) (*[]model.Comment, error) {
+	c := model.Comment{}
+	return c.ReadCommentReplies(r.DB, q)
+}
+
+// ReadCommentByID returns the comment with the ID
+func (r *GormCommentRepository) ReadCommentByID(cid uint) (*model.Comment, error) {
+	c := model.Comment{}
+	return c.ReadCommentByID(r.DB, cid)
+}
+
+// UpdateComment saves the content and status of the comment
+func (r *GormCommentRepository) UpdateComment(c *model.Comment) (*model.Comment, error) {
+	return c.UpdateComment(r.DB)
+}
+
+// UpdateCommentStatus saves the moderation status of the comment with the ID
+func (r *GormCommentRepository) UpdateCommentStatus(cid uint, status string) (*model.Comment, error) {
+	c := model.Comment{}
+	return c.UpdateCommentStatus(r.DB, cid, status)
+}
+
+// DeleteComment soft deletes the comment with the ID
+func (r *GormCommentRepository) DeleteComment(cid uint) (int64, error) {
+	c := model.Comment{}
+	return c.DeleteComment(r.DB, cid)
+}
+
+// GormTokenRepository stores tokens in the refresh_tokens, revoked_tokens and user_tokens tables
+type GormTokenRepository struct {
+	DB *gorm.DB
+}
+
+// CreateRefreshToken Inserts refresh token
+func (r *GormTokenRepository) CreateRefreshToken(rt *model.RefreshToken) (*model.RefreshToken, error) {
+	return rt.CreateRefreshToken(r.DB)
+}
+
+// ReadRefreshTokenByHash returns the refresh token with the hash
+func (r *GormTokenRepository) ReadRefreshTokenByHash(hash string) (*model.RefreshToken, error) {
+	rt := model.RefreshToken{}
+	return rt.ReadRefreshTokenByHash(r.DB, hash)
+}
+
+// RevokeRefreshToken marks the refresh token used and revokes its access token, model.ErrTokenUsed when it
+// was already
+func (r *GormTokenRepository) RevokeRefreshToken(rt *model.RefreshToken) error {
+	return rt.RevokeRefreshToken(r.DB)
+}
+
+// RevokeUserTokens ends every session of the user
+func (r *GormTokenRepository) RevokeUserTokens(uid uint) error {
+	return model.RevokeUserTokens(r.DB, uid)
+}
+
+// RevokeToken adds the jti to the revocation list
+func (r *GormTokenRepository) RevokeToken(jti string, expiresAt time.Time) error {
+	t := model.RevokedToken{}
+	return t.RevokeToken(r.DB, jti, expiresAt)
+}
+
+// IsRevoked reports whether the jti is on the revocation list
+func (r *GormTokenRepository) IsRevoked(jti string) (bool, error) {
+	return model.IsTokenRevoked(r.DB, jti)
+}
+
+// PurgeRevokedTokens deletes the jtis of access tokens expired before now
+func (r *GormTokenRepository) PurgeRevokedTokens(now time.Time) (int64, error) {
+	return model.PurgeRevokedTokens(r.DB, now)
+}
+
+// CreateUserToken Inserts user token, spending the user's earlier ones for the purpose
+func (r *GormTokenRepository) CreateUserToken(ut *model.UserToken) (*model.UserToken, error) {
+	return ut.CreateUserToken(r.DB)
+}
+
+// ReadUserTokenByHash returns the user token with the hash
+func (r *GormTokenRepository) ReadUserTokenByHash(hash string) (*model.UserToken, error) {
+	ut := model.UserToken{}
+	return ut.ReadUserTokenByHash(r.DB, hash)
+}
+
+// UseUserToken spends the user token
+func (r *GormTokenRepository) UseUserToken(ut *model.UserToken) error {
+	return ut.UseUserToken(r.DB)
+}
+
+// GormAttemptRepository stores failed logins in the login_attempts table and lockouts in the lockouts table
+type GormAttemptRepository struct {
+	DB *gorm.DB
+}
+
+// ReadLoginAttempt returns the failures counted for the subject
+func (r *GormAttemptRepository) ReadLoginAttempt(subject string) (*model.LoginAttempt, error) {
+	return model.ReadLoginAttempt(r.DB, subject)
+}
+
+// RecordLoginFailure counts a failed login for the subject
+func (r *GormAttemptRepository) RecordLoginFailure(subject string, now time.Time, window time.Duration) (*model.LoginAttempt, error) {
+	return model.RecordLoginFailure(r.DB, subject, now, window)
+}
+
+// ClearLoginAttempts forgets the failures of the subject
+func (r *GormAttemptRepository) ClearLoginAttempts(subject string) error {
+	return model.ClearLoginAttempts(r.DB, subject)
+}
+
+// CreateLockout Inserts lockout
+func (r *GormAttemptRepository) CreateLockout(l *model.Lockout) (*model.Lockout, error) {
+	return l.CreateLockout(r.DB)
+}
+
+// ReadAllLockouts returns one page of lockouts
+func (r *GormAttemptRepository) ReadAllLockouts(q model.LockoutQuery) (*[]model.Lockout, model.PageInfo, error) {
+	l := model.Lockout{}
+	return l.ReadAllLockouts(r.DB, q)
+}
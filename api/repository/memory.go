@@ -0,0 +1,1141 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/search"
+	"gorm.io/gorm"
+)
+
+// NewMemoryRepositories returns repositories that keep everything in process memory. They follow the same
+// rules as the GORM ones: unique usernames, emails and slugs, soft deletes and posts read with their Author,
+// tags and categories
+func NewMemoryRepositories() Repositories {
+	m := &memoryStore{
+		revoked:        map[string]time.Time{},
+		attempts:       map[string]model.LoginAttempt{},
+		postTags:       map[uint][]uint{},
+		postCategories: map[uint][]uint{},
+		index:          search.NewInvertedIndex(),
+	}
+	return Repositories{
+		Users:    &MemoryUserRepository{m},
+		Posts:    &MemoryPostRepository{m},
+		Tags:     &MemoryTagRepository{m},
+		Comments: &MemoryCommentRepository{m},
+		Tokens:   &MemoryTokenRepository{m},
+		Attempts: &MemoryAttemptRepository{m},
+	}
+}
+
+// memoryStore holds the rows of every memory repository so posts can assemble their authors
+type memoryStore struct {
+	mu      sync.RWMutex
+	users   []model.User
+	posts   []model.Post
+	slugs   []model.PostSlug
+	refresh []model.RefreshToken
+	revoked map[string]time.Time
+	mailed  []model.UserToken
+	codes   []model.RecoveryCode
+	lastIDs map[string]uint
+
+	attempts map[string]model.LoginAttempt
+	lockouts []model.Lockout
+
+	tags           []model.Tag
+	categories     []model.Category
+	postTags       map[uint][]uint
+	postCategories map[uint][]uint
+
+	comments []model.Comment
+	index    search.Index
+}
+
+// nextID hands out auto increment ids per table
+func (m *memoryStore) nextID(table string, want uint) uint {
+	if m.lastIDs == nil {
+		m.lastIDs = map[string]uint{}
+	}
+	if want > m.lastIDs[table] {
+		m.lastIDs[table] = want
+		return want
+	}
+	if want != 0 {
+		return want
+	}
+	m.lastIDs[table]++
+	return m.lastIDs[table]
+}
+
+// uniqueViolation reads like the Postgres error so formaterror treats both stores alike
+func uniqueViolation(table, column string) error {
+	return fmt.Errorf("ERROR: duplicate key value violates unique constraint \"%s_%s_key\" (SQLSTATE 23505)", table, column)
+}
+
+// foreignKeyViolation reads like the Postgres error for a missing referenced row
+func foreignKeyViolation(table, constraint string) error {
+	return fmt.Errorf("ERROR: insert or update on table \"%s\" violates foreign key constraint \"%s\" (SQLSTATE 23503)", table, constraint)
+}
+
+func softDelete(deletedAt *gorm.DeletedAt) {
+	*deletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+}
+
+// MemoryUserRepository keeps users in memory
+type MemoryUserRepository struct {
+	*memoryStore
+}
+
+// liveUser returns the index of the user with the ID unless it is soft deleted
+func (m *memoryStore) liveUser(uid uint) int {
+	for i := range m.users {
+		if m.users[i].ID == uid && !m.users[i].DeletedAt.Valid {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkUser enforces the unique columns, soft deleted rows still hold on to their values
+func (m *memoryStore) checkUser(u *model.User, self uint) error {
+	for i := range m.users {
+		if m.users[i].ID == self {
+			continue
+		}
+		if u.ID != 0 && m.users[i].ID == u.ID && self == 0 {
+			return uniqueViolation("users", "pkey")
+		}
+		if m.users[i].Username == u.Username {
+			return uniqueViolation("users", "username")
+		}
+		if m.users[i].Email == u.Email {
+			return uniqueViolation("users", "email")
+		}
+	}
+	return nil
+}
+
+// CreateUser hashes the password and stores the user
+func (r *MemoryUserRepository) CreateUser(u *model.User) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkUser(u, 0); err != nil {
+		return &model.User{}, err
+	}
+	if err := u.BeforeSave(nil); err != nil {
+		return &model.User{}, err
+	}
+	// Mirrors the column default
+	if u.Role == "" {
+		u.Role = "author"
+	}
+	u.ID = r.nextID("users", u.ID)
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	r.users = append(r.users, *u)
+	return u, nil
+}
+
+// ReadAllUsers returns one page of users that aren't deleted
+func (r *MemoryUserRepository) ReadAllUsers(q model.UserQuery) (*[]model.User, model.PageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	live := []model.User{}
+	for i := range r.users {
+		if !r.users[i].DeletedAt.Valid {
+			live = append(live, r.users[i])
+		}
+	}
+	users, info, err := q.Apply(live)
+	if err != nil {
+		return &[]model.User{}, model.PageInfo{}, err
+	}
+	return &users, info, nil
+}
+
+// ReadUserByID returns the user with the ID
+func (r *MemoryUserRepository) ReadUserByID(uid uint) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return &model.User{}, gorm.ErrRecordNotFound
+	}
+	u := r.users[i]
+	return &u, nil
+}
+
+// ReadUserByEmail returns the user with the email
+func (r *MemoryUserRepository) ReadUserByEmail(email string) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.users {
+		if r.users[i].Email == email && !r.users[i].DeletedAt.Valid {
+			u := r.users[i]
+			return &u, nil
+		}
+	}
+	return &model.User{}, gorm.ErrRecordNotFound
+}
+
+// UpdateUser hashes the password and saves username, email and password, a changed email is unverified
+func (r *MemoryUserRepository) UpdateUser(uid uint, u *model.User) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return &model.User{}, gorm.ErrRecordNotFound
+	}
+	if err := r.checkUser(u, uid); err != nil {
+		return &model.User{}, err
+	}
+	if err := u.BeforeSave(nil); err != nil {
+		return &model.User{}, err
+	}
+	r.users[i].Username = u.Username
+	if r.users[i].Email != u.Email {
+		r.users[i].EmailVerifiedAt = nil
+	}
+	r.users[i].Email = u.Email
+	r.users[i].Password = u.Password
+	r.users[i].UpdatedAt = time.Now()
+
+	// Hand back a fresh copy like the GORM store does
+	*u = r.users[i]
+	return u, nil
+}
+
+// UpdateRole saves only the role
+func (r *MemoryUserRepository) UpdateRole(uid uint, role string) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return &model.User{}, gorm.ErrRecordNotFound
+	}
+	r.users[i].Role = role
+	r.users[i].UpdatedAt = time.Now()
+	u := r.users[i]
+	return &u, nil
+}
+
+// VerifyEmail stamps the user's email verified, verifying twice keeps the first time
+func (r *MemoryUserRepository) VerifyEmail(uid uint) (*model.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return &model.User{}, gorm.ErrRecordNotFound
+	}
+	if r.users[i].EmailVerifiedAt == nil {
+		now := time.Now()
+		r.users[i].EmailVerifiedAt = &now
+		r.users[i].UpdatedAt = now
+	}
+	u := r.users[i]
+	return &u, nil
+}
+
+// DeleteUser soft deletes the user, deleting a missing user affects no rows
+func (r *MemoryUserRepository) DeleteUser(uid uint) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return 0, nil
+	}
+	softDelete(&r.users[i].DeletedAt)
+	return 1, nil
+}
+
+// SetTOTPSecret stores a two-factor secret for the user to confirm, two-factor stays off until EnableTOTP
+func (r *MemoryUserRepository) SetTOTPSecret(uid uint, secret string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return gorm.ErrRecordNotFound
+	}
+	r.users[i].TOTPSecret = secret
+	r.users[i].TOTPEnabledAt = nil
+	r.users[i].TOTPLastStep = 0
+	return nil
+}
+
+// EnableTOTP turns two-factor on and replaces the user's recovery codes
+func (r *MemoryUserRepository) EnableTOTP(uid uint, step int64, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	r.users[i].TOTPEnabledAt = &now
+	r.users[i].TOTPLastStep = step
+	r.dropRecoveryCodes(uid)
+	for _, hash := range codeHashes {
+		id := r.nextID("recovery_codes", 0)
+		r.codes = append(r.codes, model.RecoveryCode{ID: id, CreatedAt: now, UserID: uid, CodeHash: hash})
+	}
+	return nil
+}
+
+// dropRecoveryCodes forgets the user's recovery codes, the caller holds the lock
+func (m *memoryStore) dropRecoveryCodes(uid uint) {
+	kept := m.codes[:0]
+	for _, c := range m.codes {
+		if c.UserID != uid {
+			kept = append(kept, c)
+		}
+	}
+	m.codes = kept
+}
+
+// UseTOTPStep records the time step of a used code, model.ErrTokenUsed when it or a later one was used
+func (r *MemoryUserRepository) UseTOTPStep(uid uint, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveUser(uid)
+	if i < 0 || r.users[i].TOTPLastStep >= step {
+		return model.ErrTokenUsed
+	}
+	r.users[i].TOTPLastStep = step
+	return nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes, model.ErrTokenUsed when there is no unused one
+func (r *MemoryUserRepository) UseRecoveryCode(uid uint, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.codes {
+		if r.codes[i].UserID == uid && r.codes[i].CodeHash == hash && r.codes[i].UsedAt == nil {
+			now := time.Now()
+			r.codes[i].UsedAt = &now
+			return nil
+		}
+	}
+	return model.ErrTokenUsed
+}
+
+// DisableTOTP turns two-factor off, forgetting the secret and the recovery codes
+func (r *MemoryUserRepository) DisableTOTP(uid uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i := r.liveUser(uid); i >= 0 {
+		r.users[i].TOTPSecret = ""
+		r.users[i].TOTPEnabledAt = nil
+		r.users[i].TOTPLastStep = 0
+	}
+	r.dropRecoveryCodes(uid)
+	return nil
+}
+
+// MemoryPostRepository keeps posts in memory
+type MemoryPostRepository struct {
+	*memoryStore
+}
+
+func (m *memoryStore) livePost(pid uint) int {
+	for i := range m.posts {
+		if m.posts[i].ID == pid && !m.posts[i].DeletedAt.Valid {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkPost enforces the unique slug and the author foreign key
+func (m *memoryStore) checkPost(p *model.Post, self uint) error {
+	for i := range m.posts {
+		if m.posts[i].ID == self {
+			continue
+		}
+		if p.ID != 0 && m.posts[i].ID == p.ID && self == 0 {
+			return uniqueViolation("posts", "pkey")
+		}
+		if m.posts[i].Slug == p.Slug {
+			return uniqueViolation("posts", "slug")
+		}
+	}
+	// The foreign key doesn't care whether the author is soft deleted
+	for i := range m.users {
+		if m.users[i].ID == p.AuthorID {
+			return nil
+		}
+	}
+	return foreignKeyViolation("posts", "fk_posts_author")
+}
+
+// slugTaken checks every post, soft deleted ones included, and the redirects of posts other than postID
+func (m *memoryStore) slugTaken(postID uint) model.SlugTaken {
+	return func(s string) (bool, error) {
+		for i := range m.posts {
+			if m.posts[i].Slug == s && m.posts[i].ID != postID {
+				return true, nil
+			}
+		}
+		for i := range m.slugs {
+			if m.slugs[i].Slug == s && m.slugs[i].PostID != postID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// withTerms copies the post and assembles its tags and categories
+func (m *memoryStore) withTerms(p model.Post) model.Post {
+	p.Tags = []model.Tag{}
+	for _, id := range m.postTags[p.ID] {
+		for _, t := range m.tags {
+			if t.ID == id {
+				p.Tags = append(p.Tags, t)
+			}
+		}
+	}
+	p.Categories = []model.Category{}
+	for _, id := range m.postCategories[p.ID] {
+		for _, c := range m.categories {
+			if c.ID == id {
+				p.Categories = append(p.Categories, c)
+			}
+		}
+	}
+	return p
+}
+
+// saveTerms links the post to its tags and categories, creating the ones used for the first time. Nil
+// leaves the post's current ones in place
+func (m *memoryStore) saveTerms(p *model.Post) {
+	if p.Tags != nil {
+		ids := []uint{}
+		for _, t := range p.Tags {
+			found := false
+			for j := range m.tags {
+				if m.tags[j].Slug == t.Slug {
+					ids, found = append(ids, m.tags[j].ID), true
+				}
+			}
+			if !found {
+				t.ID = m.nextID("tags", 0)
+				t.CreatedAt = time.Now()
+				m.tags = append(m.tags, t)
+				ids = append(ids, t.ID)
+			}
+		}
+		m.postTags[p.ID] = ids
+	}
+	if p.Categories != nil {
+		ids := []uint{}
+		for _, c := range p.Categories {
+			found := false
+			for j := range m.categories {
+				if m.categories[j].Slug == c.Slug {
+					ids, found = append(ids, m.categories[j].ID), true
+				}
+			}
+			if !found {
+				c.ID = m.nextID("categories", 0)
+				c.CreatedAt = time.Now()
+				m.categories = append(m.categories, c)
+				ids = append(ids, c.ID)
+			}
+		}
+		m.postCategories[p.ID] = ids
+	}
+}
+
+// withAuthor copies the post, renders it like a GORM read and assembles its author, tags and categories, ok
+// is false when the author is gone
+func (m *memoryStore) withAuthor(p model.Post) (model.Post, bool) {
+	p = m.withTerms(p)
+	p.Render()
+	i := m.liveUser(p.AuthorID)
+	if i < 0 {
+		p.Author = model.User{}
+		return p, false
+	}
+	p.Author = m.users[i]
+	return p, true
+}
+
+// CreatePost stores the post
+func (r *MemoryPostRepository) CreatePost(p *model.Post) (*model.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := p.AssignSlug(p.Slug, r.slugTaken(0)); err != nil {
+		return &model.Post{}, err
+	}
+	p.PrepareStatus(time.Now())
+	if err := r.checkPost(p, 0); err != nil {
+		return &model.Post{}, err
+	}
+	p.ID = r.nextID("posts", p.ID)
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+	r.saveTerms(p)
+	stored := *p
+	stored.Author = model.User{}
+	stored.Tags, stored.Categories = nil, nil
+	r.posts = append(r.posts, stored)
+	r.index.Add(stored.Document())
+	*p = r.withTerms(*p)
+	p.Render()
+	return p, nil
+}
+
+// ReadAllPosts returns one page of posts that aren't deleted with their authors
+func (r *MemoryPostRepository) ReadAllPosts(q model.PostQuery) (*[]model.Post, model.PageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	live := []model.Post{}
+	for i := range r.posts {
+		if !r.posts[i].DeletedAt.Valid {
+			live = append(live, r.withTerms(r.posts[i]))
+		}
+	}
+	posts, info, err := q.Apply(live)
+	if err != nil {
+		return &[]model.Post{}, model.PageInfo{}, err
+	}
+	// Like a preload, a deleted author leaves the post with an empty Author
+	for i := range posts {
+		posts[i], _ = r.withAuthor(posts[i])
+	}
+	return &posts, info, nil
+}
+
+// ReadPostByID returns the post with the ID and its author
+func (r *MemoryPostRepository) ReadPostByID(pid uint) (*model.Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.livePost(pid)
+	if i < 0 {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	p, ok := r.withAuthor(r.posts[i])
+	if !ok {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	return &p, nil
+}
+
+// ReadPostBySlug returns the post whose current or former slug matches, with its author
+func (r *MemoryPostRepository) ReadPostBySlug(slug string) (*model.Post, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := -1
+	for j := range r.posts {
+		if r.posts[j].Slug == slug && !r.posts[j].DeletedAt.Valid {
+			i = j
+		}
+	}
+	for j := range r.slugs {
+		if i < 0 && r.slugs[j].Slug == slug {
+			i = r.livePost(r.slugs[j].PostID)
+		}
+	}
+	if i < 0 {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	p, ok := r.withAuthor(r.posts[i])
+	if !ok {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	return &p, nil
+}
+
+// UpdatePost saves title, slug, content, status and author then returns a fresh copy with the author. The slug is
+// derived again when none is requested and the title changed, a replaced slug is kept as a redirect
+func (r *MemoryPostRepository) UpdatePost(p *model.Post) (*model.Post, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.livePost(p.ID)
+	if i < 0 {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	p.KeepStatus(&r.posts[i])
+	p.PrepareStatus(time.Now())
+	requested := p.Slug
+	p.Slug = r.posts[i].Slug
+	if requested != "" || p.Title != r.posts[i].Title {
+		if err := p.AssignSlug(requested, r.slugTaken(p.ID)); err != nil {
+			return &model.Post{}, err
+		}
+	}
+	if err := r.checkPost(p, p.ID); err != nil {
+		return &model.Post{}, err
+	}
+	if old := r.posts[i].Slug; old != p.Slug {
+		kept := r.slugs[:0]
+		for _, s := range r.slugs {
+			if s.PostID != p.ID || s.Slug != p.Slug {
+				kept = append(kept, s)
+			}
+		}
+		r.slugs = append(kept, model.PostSlug{ID: r.nextID("post_slugs", 0), PostID: p.ID, Slug: old, CreatedAt: time.Now()})
+	}
+	r.posts[i].Title = p.Title
+	r.posts[i].Slug = p.Slug
+	r.posts[i].Status = p.Status
+	r.posts[i].PublishedAt = p.PublishedAt
+	r.posts[i].Content = p.Content
+	r.posts[i].AuthorID = p.AuthorID
+	r.posts[i].UpdatedAt = time.Now()
+	r.saveTerms(p)
+	r.index.Add(r.posts[i].Document())
+
+	updated, ok := r.withAuthor(r.posts[i])
+	if !ok {
+		return &model.Post{}, gorm.ErrRecordNotFound
+	}
+	return &updated, nil
+}
+
+// PublishDuePosts publishes every scheduled post whose published_at has passed
+func (r *MemoryPostRepository) PublishDuePosts(now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var published int64
+	for i := range r.posts {
+		p := &r.posts[i]
+		if p.Status == model.PostScheduled && !p.DeletedAt.Valid && p.PublishedAt != nil && !p.PublishedAt.After(now) {
+			p.Status = model.PostPublished
+			p.UpdatedAt = now
+			published++
+		}
+	}
+	return published, nil
+}
+
+// DeletePost soft deletes the post, deleting a missing post affects no rows
+func (r *MemoryPostRepository) DeletePost(pid uint) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.livePost(pid)
+	if i < 0 {
+		return 0, nil
+	}
+	softDelete(&r.posts[i].DeletedAt)
+	r.index.Remove(pid)
+	return 1, nil
+}
+
+// SearchPosts ranks the posts with the in-process index, filtered and paged like the GORM fallback
+func (r *MemoryPostRepository) SearchPosts(q model.SearchQuery) (*[]model.SearchResult, model.PageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := r.index.Search(q.Text)
+	posts := []model.Post{}
+	for _, match := range matches {
+		if i := r.livePost(match.ID); i >= 0 {
+			p, _ := r.withAuthor(r.posts[i])
+			posts = append(posts, p)
+		}
+	}
+	results, info, err := q.Rank(matches, posts)
+	return &results, info, err
+}
+
+// MemoryTagRepository reads the tags and categories posts created in memory
+type MemoryTagRepository struct {
+	*memoryStore
+}
+
+// publishedCount counts the live published posts linked through links to the term with the ID
+func (m *memoryStore) publishedCount(links map[uint][]uint, id uint) *int64 {
+	var count int64
+	for i := range m.posts {
+		p := &m.posts[i]
+		if p.DeletedAt.Valid || !p.Published() {
+			continue
+		}
+		for _, linked := range links[p.ID] {
+			if linked == id {
+				count++
+			}
+		}
+	}
+	return &count
+}
+
+// ReadAllTags returns every tag by name with its published post count
+func (r *MemoryTagRepository) ReadAllTags() (*[]model.Tag, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tags := make([]model.Tag, len(r.tags))
+	for i, t := range r.tags {
+		t.PostCount = r.publishedCount(r.postTags, t.ID)
+		tags[i] = t
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return &tags, nil
+}
+
+// ReadTagBySlug returns the tag with the slug
+func (r *MemoryTagRepository) ReadTagBySlug(slug string) (*model.Tag, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tags {
+		if t.Slug == slug {
+			return &t, nil
+		}
+	}
+	return &model.Tag{}, gorm.ErrRecordNotFound
+}
+
+// ReadAllCategories returns every category by name with its published post count
+func (r *MemoryTagRepository) ReadAllCategories() (*[]model.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	categories := make([]model.Category, len(r.categories))
+	for i, c := range r.categories {
+		c.PostCount = r.publishedCount(r.postCategories, c.ID)
+		categories[i] = c
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return &categories, nil
+}
+
+// ReadCategoryBySlug returns the category with the slug
+func (r *MemoryTagRepository) ReadCategoryBySlug(slug string) (*model.Category, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, c := range r.categories {
+		if c.Slug == slug {
+			return &c, nil
+		}
+	}
+	return &model.Category{}, gorm.ErrRecordNotFound
+}
+
+// MemoryCommentRepository keeps comments in memory
+type MemoryCommentRepository struct {
+	*memoryStore
+}
+
+func (m *memoryStore) liveComment(cid uint) int {
+	for i := range m.comments {
+		if m.comments[i].ID == cid && !m.comments[i].DeletedAt.Valid {
+			return i
+		}
+	}
+	return -1
+}
+
+// withCommenter copies the comment and assembles its author, a deleted author leaves it empty like a preload
+func (m *memoryStore) withCommenter(c model.Comment) model.Comment {
+	c.Author = model.User{}
+	if i := m.liveUser(c.AuthorID); i >= 0 {
+		c.Author = m.users[i]
+	}
+	return c
+}
+
+// CreateComment stores the comment, enforcing the parent and the post and author foreign keys
+func (r *MemoryCommentRepository) CreateComment(c *model.Comment) (*model.Comment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.ParentID != nil {
+		i := r.liveComment(*c.ParentID)
+		if i < 0 || r.comments[i].PostID != c.PostID {
+			return &model.Comment{}, model.ErrInvalidParent
+		}
+	}
+	post, author := false, false
+	for i := range r.posts {
+		post = post || r.posts[i].ID == c.PostID
+	}
+	for i := range r.users {
+		author = author || r.users[i].ID == c.AuthorID
+	}
+	if !post {
+		return &model.Comment{}, foreignKeyViolation("comments", "fk_comments_post")
+	}
+	if !author {
+		return &model.Comment{}, foreignKeyViolation("comments", "fk_comments_author")
+	}
+	c.ID = r.nextID("comments", c.ID)
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+	stored := *c
+	stored.Author = model.User{}
+	stored.Replies = nil
+	r.comments = append(r.comments, stored)
+	created := r.withCommenter(stored)
+	return &created, nil
+}
+
+// ReadAllComments returns one page of comments that aren't deleted with their authors
+func (r *MemoryCommentRepository) ReadAllComments(q model.CommentQuery) (*[]model.Comment, model.PageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	live := []model.Comment{}
+	for i := range r.comments {
+		if !r.comments[i].DeletedAt.Valid {
+			live = append(live, r.comments[i])
+		}
+	}
+	comments, info, err := q.Apply(live)
+	if err != nil {
+		return &[]model.Comment{}, model.PageInfo{}, err
+	}
+	for i := range comments {
+		comments[i] = r.withCommenter(comments[i])
+	}
+	return &comments, info, nil
+}
+
+// ReadCommentReplies returns every live reply matching q, oldest first
+func (r *MemoryCommentRepository) ReadCommentReplies(q model.CommentQuery) (*[]model.Comment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	replies := []model.Comment{}
+	for i := range r.comments {
+		c := r.comments[i]
+		if !c.DeletedAt.Valid && c.ParentID != nil && q.Match(&c) {
+			replies = append(replies, r.withCommenter(c))
+		}
+	}
+	sort.SliceStable(replies, func(i, j int) bool { return replies[i].CreatedAt.Before(replies[j].CreatedAt) })
+	return &replies, nil
+}
+
+// ReadCommentByID returns the comment with the ID and its author
+func (r *MemoryCommentRepository) ReadCommentByID(cid uint) (*model.Comment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.liveComment(cid)
+	if i < 0 {
+		return &model.Comment{}, gorm.ErrRecordNotFound
+	}
+	c := r.withCommenter(r.comments[i])
+	return &c, nil
+}
+
+// UpdateComment saves the content and status of the comment
+func (r *MemoryCommentRepository) UpdateComment(c *model.Comment) (*model.Comment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveComment(c.ID)
+	if i < 0 {
+		return &model.Comment{}, gorm.ErrRecordNotFound
+	}
+	r.comments[i].Content = c.Content
+	r.comments[i].Status = c.Status
+	r.comments[i].UpdatedAt = time.Now()
+	updated := r.withCommenter(r.comments[i])
+	return &updated, nil
+}
+
+// UpdateCommentStatus saves the moderation status of the comment
+func (r *MemoryCommentRepository) UpdateCommentStatus(cid uint, status string) (*model.Comment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !model.ValidCommentStatus(status) {
+		return &model.Comment{}, model.ErrInvalidCommentStatus
+	}
+	i := r.liveComment(cid)
+	if i < 0 {
+		return &model.Comment{}, gorm.ErrRecordNotFound
+	}
+	r.comments[i].Status = status
+	r.comments[i].UpdatedAt = time.Now()
+	updated := r.withCommenter(r.comments[i])
+	return &updated, nil
+}
+
+// DeleteComment soft deletes the comment, deleting a missing comment affects no rows
+func (r *MemoryCommentRepository) DeleteComment(cid uint) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.liveComment(cid)
+	if i < 0 {
+		return 0, nil
+	}
+	softDelete(&r.comments[i].DeletedAt)
+	return 1, nil
+}
+
+// MemoryTokenRepository keeps refresh tokens, the revocation list and mailed user tokens in memory
+type MemoryTokenRepository struct {
+	*memoryStore
+}
+
+// CreateRefreshToken stores the refresh token
+func (r *MemoryTokenRepository) CreateRefreshToken(rt *model.RefreshToken) (*model.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.refresh {
+		if r.refresh[i].TokenHash == rt.TokenHash {
+			return &model.RefreshToken{}, uniqueViolation("refresh_tokens", "token_hash")
+		}
+	}
+	rt.ID = r.nextID("refresh_tokens", rt.ID)
+	rt.CreatedAt = time.Now()
+	rt.UpdatedAt = rt.CreatedAt
+	r.refresh = append(r.refresh, *rt)
+	return rt, nil
+}
+
+// ReadRefreshTokenByHash returns the refresh token with the hash
+func (r *MemoryTokenRepository) ReadRefreshTokenByHash(hash string) (*model.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.refresh {
+		if r.refresh[i].TokenHash == hash && !r.refresh[i].DeletedAt.Valid {
+			rt := r.refresh[i]
+			return &rt, nil
+		}
+	}
+	return &model.RefreshToken{}, gorm.ErrRecordNotFound
+}
+
+// revokeRefresh marks the token at i used and revokes its access token, the caller holds the lock
+func (m *memoryStore) revokeRefresh(i int) {
+	now := time.Now()
+	if m.refresh[i].RevokedAt == nil {
+		m.refresh[i].RevokedAt = &now
+	}
+	if jti := m.refresh[i].AccessJTI; jti != "" {
+		if _, ok := m.revoked[jti]; !ok {
+			m.revoked[jti] = now.Add(time.Hour)
+		}
+	}
+}
+
+// RevokeRefreshToken marks the refresh token used and revokes its access token, model.ErrTokenUsed when it
+// was already
+func (r *MemoryTokenRepository) RevokeRefreshToken(rt *model.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.refresh {
+		if r.refresh[i].ID == rt.ID {
+			if r.refresh[i].RevokedAt != nil {
+				return model.ErrTokenUsed
+			}
+			r.revokeRefresh(i)
+			rt.RevokedAt = r.refresh[i].RevokedAt
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens ends every session of the user
+func (r *MemoryTokenRepository) RevokeUserTokens(uid uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.refresh {
+		if r.refresh[i].UserID == uid && r.refresh[i].RevokedAt == nil {
+			r.revokeRefresh(i)
+		}
+	}
+	return nil
+}
+
+// RevokeToken adds the jti to the revocation list, revoking twice is not an error
+func (r *MemoryTokenRepository) RevokeToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return errors.New("Required: jti")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.revoked[jti]; !ok {
+		r.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+// IsRevoked reports whether the jti is on the revocation list
+func (r *MemoryTokenRepository) IsRevoked(jti string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.revoked[jti]
+	return ok, nil
+}
+
+// PurgeRevokedTokens deletes the jtis of access tokens expired before now
+func (r *MemoryTokenRepository) PurgeRevokedTokens(now time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for jti, expiresAt := range r.revoked {
+		if expiresAt.Before(now) {
+			delete(r.revoked, jti)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// CreateUserToken stores the user token, spending the user's earlier ones for the purpose
+func (r *MemoryTokenRepository) CreateUserToken(ut *model.UserToken) (*model.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for i := range r.mailed {
+		if r.mailed[i].TokenHash == ut.TokenHash {
+			return &model.UserToken{}, uniqueViolation("user_tokens", "token_hash")
+		}
+	}
+	for i := range r.mailed {
+		if r.mailed[i].UserID == ut.UserID && r.mailed[i].Purpose == ut.Purpose && r.mailed[i].UsedAt == nil {
+			r.mailed[i].UsedAt = &now
+		}
+	}
+	ut.ID = r.nextID("user_tokens", ut.ID)
+	ut.CreatedAt = now
+	ut.UpdatedAt = now
+	r.mailed = append(r.mailed, *ut)
+	return ut, nil
+}
+
+// ReadUserTokenByHash returns the user token with the hash
+func (r *MemoryTokenRepository) ReadUserTokenByHash(hash string) (*model.UserToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.mailed {
+		if r.mailed[i].TokenHash == hash && !r.mailed[i].DeletedAt.Valid {
+			ut := r.mailed[i]
+			return &ut, nil
+		}
+	}
+	return &model.UserToken{}, gorm.ErrRecordNotFound
+}
+
+// UseUserToken spends the user token, spending it twice is model.ErrTokenUsed
+func (r *MemoryTokenRepository) UseUserToken(ut *model.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.mailed {
+		if r.mailed[i].ID != ut.ID {
+			continue
+		}
+		if r.mailed[i].UsedAt != nil {
+			return model.ErrTokenUsed
+		}
+		now := time.Now()
+		r.mailed[i].UsedAt = &now
+		ut.UsedAt = &now
+		return nil
+	}
+	return model.ErrTokenUsed
+}
+
+// MemoryAttemptRepository keeps failed logins and lockouts in memory
+type MemoryAttemptRepository struct {
+	*memoryStore
+}
+
+// ReadLoginAttempt returns the failures counted for the subject
+func (r *MemoryAttemptRepository) ReadLoginAttempt(subject string) (*model.LoginAttempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	la, ok := r.attempts[subject]
+	if !ok {
+		return &model.LoginAttempt{}, gorm.ErrRecordNotFound
+	}
+	return &la, nil
+}
+
+// RecordLoginFailure counts a failed login for the subject, failures older than window are forgotten
+func (r *MemoryAttemptRepository) RecordLoginFailure(subject string, now time.Time, window time.Duration) (*model.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	la, ok := r.attempts[subject]
+	if !ok {
+		la = model.LoginAttempt{ID: r.nextID("login_attempts", 0), Subject: subject}
+	}
+	if la.LastFailedAt.Before(now.Add(-window)) {
+		la.Failures = 0
+	}
+	la.Failures++
+	la.LastFailedAt = now
+	r.attempts[subject] = la
+	return &la, nil
+}
+
+// ClearLoginAttempts forgets the failures of the subject
+func (r *MemoryAttemptRepository) ClearLoginAttempts(subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, subject)
+	return nil
+}
+
+// CreateLockout stores the lockout
+func (r *MemoryAttemptRepository) CreateLockout(l *model.Lockout) (*model.Lockout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l.ID = r.nextID("lockouts", l.ID)
+	if l.CreatedAt.IsZero() {
+		l.CreatedAt = time.Now()
+	}
+	r.lockouts = append(r.lockouts, *l)
+	return l, nil
+}
+
+// ReadAllLockouts returns one page of lockouts
+func (r *MemoryAttemptRepository) ReadAllLockouts(q model.LockoutQuery) (*[]model.Lockout, model.PageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lockouts, info, err := q.Apply(r.lockouts)
+	if err != nil {
+		return &[]model.Lockout{}, model.PageInfo{}, err
+	}
+	return &lockouts, info, nil
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+)
+
+// UserRepository stores users and their two-factor settings; usernames and emails are unique and deletes
+// are soft
+type UserRepository interface {
+	CreateUser(u *model.User) (*model.User, error)
+	ReadAllUsers(q model.UserQuery) (*[]model.User, model.PageInfo, error)
+	ReadUserByID(uid uint) (*model.User, error)
+	ReadUserByEmail(email string) (*model.User, error)
+	UpdateUser(uid uint, u *model.User) (*model.User, error)
+	UpdateRole(uid uint, role string) (*model.User, error)
+	VerifyEmail(uid uint) (*model.User, error)
+	DeleteUser(uid uint) (int64, error)
+	SetTOTPSecret(uid uint, secret string) error
+	EnableTOTP(uid uint, step int64, codeHashes []string) error
+	UseTOTPStep(uid uint, step int64) error
+	UseRecoveryCode(uid uint, hash string) error
+	DisableTOTP(uid uint) error
+}
+
+// PostRepository stores posts; slugs are unique and replaced ones are kept as redirects, deletes are soft
+// and reads come with the Author, tags and categories assembled
+type PostRepository interface {
+	CreatePost(p *model.Post) (*model.Post, error)
+	ReadAllPosts(q model.PostQuery) (*[]model.Post, model.PageInfo, error)
+	ReadPostByID(pid uint) (*model.Post, error)
+	ReadPostBySlug(slug string) (*model.Post, error)
+	UpdatePost(p *model.Post) (*model.Post, error)
+	PublishDuePosts(now time.Time) (int64, error)
+	DeletePost(pid uint) (int64, error)
+	SearchPosts(q model.SearchQuery) (*[]model.SearchResult, model.PageInfo, error)
+}
+
+// TagRepository reads the tags and categories posts are grouped under, listings count the published posts.
+// They are created by PostRepository the first time a post uses them
+type TagRepository interface {
+	ReadAllTags() (*[]model.Tag, error)
+	ReadTagBySlug(slug string) (*model.Tag, error)
+	ReadAllCategories() (*[]model.Category, error)
+	ReadCategoryBySlug(slug string) (*model.Category, error)
+}
+
+// CommentRepository stores comments; a parent must be on the same post, deletes are soft and reads come
+// with the Author assembled
+type CommentRepository interface {
+	CreateComment(c *model.Comment) (*model.Comment, error)
+	ReadAllComments(q model.CommentQuery) (*[]model.Comment, model.PageInfo, error)
+	ReadCommentReplies(q model.CommentQuery) (*[]model.Comment, error)
+	ReadCommentByID(cid uint) (*model.Comment, error)
+	UpdateComment(c *model.Comment) (*model.Comment, error)
+	UpdateCommentStatus(cid uint, status string) (*model.Comment, error)
+	DeleteComment(cid uint) (int64, error)
+}
+
+// TokenRepository stores refresh tokens, the access token revocation list and the single use tokens mailed
+// to users, it satisfies auth.RevocationList
+type TokenRepository interface {
+	CreateRefreshToken(rt *model.RefreshToken) (*model.RefreshToken, error)
+	ReadRefreshTokenByHash(hash string) (*model.RefreshToken, error)
+	RevokeRefreshToken(rt *model.RefreshToken) error
+	RevokeUserTokens(uid uint) error
+	RevokeToken(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	PurgeRevokedTokens(now time.Time) (int64, error)
+	CreateUserToken(ut *model.UserToken) (*model.UserToken, error)
+	ReadUserTokenByHash(hash string) (*model.UserToken, error)
+	UseUserToken(ut *model.UserToken) error
+}
+
+// AttemptRepository counts failed logins per subject and keeps the log of lockouts admins review
+type AttemptRepository interface {
+	ReadLoginAttempt(subject string) (*model.LoginAttempt, error)
+	RecordLoginFailure(subject string, now time.Time, window time.Duration) (*model.LoginAttempt, error)
+	ClearLoginAttempts(subject string) error
+	CreateLockout(l *model.Lockout) (*model.Lockout, error)
+	ReadAllLockouts(q model.LockoutQuery) (*[]model.Lockout, model.PageInfo, error)
+}
+
+// Repositories bundles every repository the Server depends on
+type Repositories struct {
+	Users    UserRepository
+	Posts    PostRepository
+	Tags     TagRepository
+	Comments CommentRepository
+	Tokens   TokenRepository
+	Attempts AttemptRepository
+}
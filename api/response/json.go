@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
 )
 
 // JSON converts data to json
@@ -15,15 +17,60 @@ func JSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	}
 }
 
-// ERROR converts statusCode and error to JSON for response
-func ERROR(w http.ResponseWriter, statusCode int, err error) {
-	if err != nil {
-		JSON(w, statusCode, struct {
-			Error string `json:"error"`
-		}{
-			Error: err.Error(),
-		})
-		return
+// NoBody answers statusCode without a body, and so without the content type SetMiddlewareJSON gave it
+func NoBody(w http.ResponseWriter, statusCode int) {
+	w.Header().Del("Content-Type")
+	w.WriteHeader(statusCode)
+}
+
+// RequestIDHeader carries the id the request is logged under, ERROR repeats it in the body
+const RequestIDHeader = "X-Request-ID"
+
+// ProblemContentType is the media type of an RFC 7807 problem, the body of every error response
+const ProblemContentType = "application/problem+json"
+
+// Problem is the RFC 7807 body of an error response. Code names the error for clients to switch on and
+// stays the same when Detail is reworded, Errors says what is wrong with each field of an invalid request
+type Problem struct {
+	Type      string                `json:"type"`
+	Title     string                `json:"title"`
+	Status    int                   `json:"status"`
+	Detail    string                `json:"detail"`
+	Code      string                `json:"code"`
+	Errors    []apierror.FieldError `json:"errors,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+// internalDetail stands in for the message of an error that isn't the client's doing, which may say more
+// about the server than it should
+const internalDetail = "Something went wrong, report the request_id if it keeps happening"
+
+// errorRecorder is a ResponseWriter that wants to know the error a response was written for, the request
+// log and trace middleware record it
+type errorRecorder interface {
+	RecordError(err error)
+}
+
+// ERROR answers err as a problem+json with the status and code apierror maps it to, and the request id
+// when there is one so a reported error can be found in the logs. Errors of no apierror kind are 500s
+// whose message is only logged
+func ERROR(w http.ResponseWriter, err error) {
+	if rec, ok := w.(errorRecorder); ok {
+		rec.RecordError(err)
+	}
+	status := apierror.Status(err)
+	detail := err.Error()
+	if apierror.Internal(err) {
+		detail = internalDetail
 	}
-	JSON(w, http.StatusBadRequest, nil)
+	w.Header().Set("Content-Type", ProblemContentType)
+	JSON(w, status, Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      apierror.Code(err),
+		Errors:    apierror.Fields(err),
+		RequestID: w.Header().Get(RequestIDHeader),
+	})
 }
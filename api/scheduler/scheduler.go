@@ -0,0 +1,84 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+)
+
+// DefaultInterval is how often due posts are looked for when no interval is configured
+const DefaultInterval = time.Minute
+
+// Publisher publishes the scheduled posts that are due, repository.PostRepository satisfies it
+type Publisher interface {
+	PublishDuePosts(now time.Time) (int64, error)
+}
+
+// Purger deletes the revoked access token jtis that expired, repository.TokenRepository satisfies it
+type Purger interface {
+	PurgeRevokedTokens(now time.Time) (int64, error)
+}
+
+// Scheduler publishes scheduled posts in the background once their published_at has passed and purges the
+// expired revocation list of Tokens, nil to purge nothing. What it does and fails to is logged to Logger, nil
+// to log nothing
+type Scheduler struct {
+	Posts    Publisher
+	Tokens   Purger
+	Interval time.Duration
+	Logger   *logger.Logger
+}
+
+// New returns a Scheduler checking every interval, DefaultInterval when interval isn't positive
+func New(posts Publisher, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{Posts: posts, Interval: interval}
+}
+
+// Run publishes due posts straight away and then every Interval until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Tick(time.Now())
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick publishes every post due at now and purges what expired, it answers how many posts were published.
+// Failures are logged and retried on the next tick
+func (s *Scheduler) Tick(now time.Time) int64 {
+	s.purge(now)
+	published, err := s.Posts.PublishDuePosts(now)
+	if err != nil {
+		s.Logger.Error("Scheduler Error", logger.Fields{"error": err})
+		return 0
+	}
+	if published > 0 {
+		metrics.PostsPublished.Add(float64(published))
+		s.Logger.Info("Scheduler published posts", logger.Fields{"published": published})
+	}
+	return published
+}
+
+// purge deletes the revoked jtis expired at now
+func (s *Scheduler) purge(now time.Time) {
+	if s.Tokens == nil {
+		return
+	}
+	purged, err := s.Tokens.PurgeRevokedTokens(now)
+	if err != nil {
+		s.Logger.Error("Scheduler Error", logger.Fields{"error": err})
+		return
+	}
+	if purged > 0 {
+		s.Logger.Debug("Scheduler purged revoked tokens", logger.Fields{"purged": purged})
+	}
+}
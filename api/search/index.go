@@ -0,0 +1,135 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// titleWeight makes a word in the title count as much as this many in the content
+const titleWeight = 3
+
+// Document is what an Index keeps of a post: its id and the plain text of its title and content
+type Document struct {
+	ID      uint
+	Title   string
+	Content string
+}
+
+// Match is a document a query found and how well it matched, higher is better
+type Match struct {
+	ID    uint
+	Score float64
+}
+
+// Index finds documents by the words in them. It stands in for the database when the database has no
+// full-text search; InvertedIndex keeps one in process
+type Index interface {
+	// Add indexes the document, replacing an earlier version with the same ID
+	Add(doc Document)
+	// Remove drops the document with the ID
+	Remove(id uint)
+	// Search returns every document containing all the query's terms, best first
+	Search(query string) []Match
+}
+
+// posting counts a term's occurrences in one document
+type posting struct {
+	title   int
+	content int
+}
+
+// InvertedIndex maps each term to the documents containing it. It is safe for concurrent use
+type InvertedIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[uint]posting
+	terms    map[uint][]string
+}
+
+// NewInvertedIndex returns an empty in-process index
+func NewInvertedIndex() *InvertedIndex {
+	return &InvertedIndex{postings: map[string]map[uint]posting{}, terms: map[uint][]string{}}
+}
+
+// Add indexes the document, replacing an earlier version with the same ID
+func (ix *InvertedIndex) Add(doc Document) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(doc.ID)
+	counts := map[string]posting{}
+	for _, t := range Tokens(doc.Title) {
+		p := counts[t]
+		p.title++
+		counts[t] = p
+	}
+	for _, t := range Tokens(doc.Content) {
+		p := counts[t]
+		p.content++
+		counts[t] = p
+	}
+	terms := make([]string, 0, len(counts))
+	for t, p := range counts {
+		if ix.postings[t] == nil {
+			ix.postings[t] = map[uint]posting{}
+		}
+		ix.postings[t][doc.ID] = p
+		terms = append(terms, t)
+	}
+	ix.terms[doc.ID] = terms
+}
+
+// Remove drops the document with the ID
+func (ix *InvertedIndex) Remove(id uint) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+func (ix *InvertedIndex) remove(id uint) {
+	for _, t := range ix.terms[id] {
+		delete(ix.postings[t], id)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	delete(ix.terms, id)
+}
+
+// Search returns every document containing all the query's terms, scored by tf-idf with title words
+// weighted up, best first and newest first among equals
+func (ix *InvertedIndex) Search(query string) []Match {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := Terms(query)
+	if len(terms) == 0 {
+		return []Match{}
+	}
+	scores := map[uint]float64{}
+	for i, t := range terms {
+		docs := ix.postings[t]
+		idf := math.Log(1 + float64(len(ix.terms))/float64(len(docs)+1))
+		next := map[uint]float64{}
+		for id, p := range docs {
+			score, ok := scores[id]
+			if i > 0 && !ok {
+				continue
+			}
+			next[id] = score + float64(titleWeight*p.title+p.content)*idf
+		}
+		scores = next
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for id, score := range scores {
+		matches = append(matches, Match{ID: id, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID > matches[j].ID
+	})
+	return matches
+}
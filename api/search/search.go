@@ -0,0 +1,142 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SnippetWords is the number of words Snippet keeps around the first match
+const SnippetWords = 30
+
+// stopWords are too common to narrow a search, Postgres' english configuration drops them as well
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true,
+	"for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// isWord reports whether the rune belongs to a word rather than separating words
+func isWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// fold lower cases the word and strips its accents so café and Cafe are the same word
+func fold(word string) string {
+	// Chained transformers keep state, so each call gets its own
+	marks := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)))
+	folded, _, err := transform.String(marks, strings.ToLower(word))
+	if err != nil {
+		return strings.ToLower(word)
+	}
+	return folded
+}
+
+// stem strips the common English inflections, light enough that it rarely joins unrelated words
+func stem(word string) string {
+	switch n := len(word); {
+	case n > 4 && strings.HasSuffix(word, "ies"):
+		return word[:n-3] + "y"
+	case n > 5 && strings.HasSuffix(word, "ing"):
+		return word[:n-3]
+	case n > 4 && strings.HasSuffix(word, "ed"):
+		return word[:n-2]
+	case n > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:n-1]
+	}
+	return word
+}
+
+// term is the form a word is indexed and looked up by, empty for stop words
+func term(word string) string {
+	folded := fold(word)
+	if stopWords[folded] {
+		return ""
+	}
+	return stem(folded)
+}
+
+// Tokens returns the terms of text in order, repeats included
+func Tokens(text string) []string {
+	tokens := []string{}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isWord(r) }) {
+		if t := term(word); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Terms returns the distinct terms of a query
+func Terms(query string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	for _, t := range Tokens(query) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// Highlight escapes plain text for HTML and wraps the words matching terms in <mark>
+func Highlight(text string, terms []string) string {
+	wanted := map[string]bool{}
+	for _, t := range terms {
+		wanted[t] = true
+	}
+	var b strings.Builder
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		j := i
+		for j < len(rs) && isWord(rs[j]) == isWord(rs[i]) {
+			j++
+		}
+		chunk := string(rs[i:j])
+		if isWord(rs[i]) && wanted[term(chunk)] {
+			b.WriteString("<mark>" + html.EscapeString(chunk) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(chunk))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// Snippet returns SnippetWords words of plain text around the first word matching terms, highlighted
+func Snippet(text string, terms []string) string {
+	wanted := map[string]bool{}
+	for _, t := range terms {
+		wanted[t] = true
+	}
+	words := strings.Fields(text)
+	start := 0
+	for i, w := range words {
+		if wanted[term(strings.TrimFunc(w, func(r rune) bool { return !isWord(r) }))] {
+			// A little context before the match reads better than starting on it
+			start = i - SnippetWords/3
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + SnippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	snippet := strings.Join(words[start:end], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(words) {
+		snippet += "…"
+	}
+	return Highlight(snippet, terms)
+}
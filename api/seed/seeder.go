@@ -1,9 +1,11 @@
 package seed
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
 	"github.com/aaronprice00/goblog-mvc/api/model"
 	"gorm.io/gorm"
 )
@@ -13,6 +15,7 @@ var users = []model.User{
 		Username: "aaronprice00",
 		Email:    "aaronprice00@gmail.com",
 		Password: "pass123",
+		Role:     "admin",
 	},
 	{
 		Username: "phlesh",
@@ -25,37 +28,73 @@ var posts = []model.Post{
 	{
 		Title:   "Title 1",
 		Content: "Content 3",
+		Status:  model.PostPublished,
 	},
 	{
 		Title:   "Title 2",
 		Content: "Content 4",
+		Status:  model.PostPublished,
 	},
 }
 
-// Load Drops, Migrates, Creates
-func Load(db *gorm.DB) {
+// ErrNotEmpty is returned by Load when the database already has users and force was not given
+var ErrNotEmpty = errors.New("Database Not Empty")
 
-	var err error
-	err = db.Migrator().DropTable(&model.Post{}, &model.User{})
-	if err != nil {
-		log.Fatalf("Could not drop table: %v", err)
-	} else {
-		fmt.Println("Dropped Tables")
+// Load inserts the sample users and posts. It refuses to touch a database that already has users
+// unless force is set, in which case every existing row is deleted first. The schema must be current
+func Load(db *gorm.DB, force bool) error {
+	if err := migrate.Current(db); err != nil {
+		return err
 	}
 
-	err = db.AutoMigrate(&model.Post{}, &model.User{})
-	if err != nil {
-		log.Fatalf("Could not migrate table: %v", err)
-	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&model.User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			if !force {
+				return ErrNotEmpty
+			}
+			if err := truncate(tx); err != nil {
+				return err
+			}
+			fmt.Println("Deleted existing rows")
+		}
+
+		// The sample accounts can sign in even when verified emails are required
+		now := time.Now()
+		for i := range users {
+			user := users[i]
+			user.EmailVerifiedAt = &now
+			if err := tx.Create(&user).Error; err != nil {
+				return fmt.Errorf("Could not seed User table: %w", err)
+			}
+			post := posts[i]
+			post.AuthorID = user.ID
 
-	for i := range users {
-		if err := db.Create(&users[i]).Error; err != nil {
-			log.Fatalf("Could not seed User table: %v", err)
+			if _, err := post.CreatePost(tx); err != nil {
+				return fmt.Errorf("Could not seed Post table: %w", err)
+			}
 		}
-		posts[i].AuthorID = users[i].ID
+		fmt.Printf("Seeded %d users and %d posts\n", len(users), len(posts))
+		return nil
+	})
+}
 
-		if err := db.Create(&posts[i]).Error; err != nil {
-			log.Fatalf("could not seed Post table: %v", err)
+// truncate deletes every row, soft deleted or not, children before parents
+func truncate(tx *gorm.DB) error {
+	// The join tables have no model of their own
+	for _, join := range []string{"post_tags", "post_categories"} {
+		if err := tx.Exec("DELETE FROM " + join).Error; err != nil {
+			return err
+		}
+	}
+	tables := []interface{}{&model.RevokedToken{}, &model.RefreshToken{}, &model.UserToken{}, &model.RecoveryCode{}, &model.LoginAttempt{}, &model.Lockout{}, &model.Tag{}, &model.Category{}, &model.Comment{}, &model.PostSlug{}, &model.Post{}, &model.User{}}
+	for _, table := range tables {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(table).Error; err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -1,25 +1,78 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/config"
 	"github.com/aaronprice00/goblog-mvc/api/controller"
-	"github.com/aaronprice00/goblog-mvc/api/seed"
-	"github.com/joho/godotenv"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/scheduler"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
 )
 
 var server = controller.Server{}
 
-// Run the REST server
+const usage = `usage:
+  main [flags]                      start the REST server
+  main [flags] migrate up           apply pending migrations
+  main [flags] migrate down [n]     revert the last n migrations (default 1)
+  main [flags] migrate status       list migrations and when they were applied
+  main [flags] seed [-force]        insert sample data, -force deletes existing rows first
+
+Settings are read from a YAML file named by -config or CONFIG_FILE, then .env or -env-file, then the
+environment, then flags such as -db-host for DB_HOST, later ones winning. Run main -h for every flag`
+
+// Run the REST server, or the migrate or seed command named by the arguments
 func Run() {
-	err := godotenv.Load()
+	cfg, args, err := config.Load(config.Options{Args: os.Args[1:]})
+	if err != nil {
+		log.Fatalf("Could not load config %v\n%s", err, usage)
+	}
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	switch args[0] {
+	case "serve":
+		if err = cfg.Validate(); err != nil {
+			log.Fatalf("Invalid config %v", err)
+		}
+		var flushSpans func(context.Context) error
+		if flushSpans, err = tracing.Setup(cfg.Tracing); err != nil {
+			log.Fatalf("Could not set up tracing %v", err)
+		}
+		server.Initialize(cfg)
+		// Publishes scheduled posts and purges expired revoked tokens for as long as the server runs
+		server.Scheduler = scheduler.New(server.Posts, cfg.SchedulerInterval)
+		server.Scheduler.Tokens = server.Tokens
+		server.Scheduler.Logger = server.Logger
+		err = server.Run(fmt.Sprintf(":%s", cfg.HTTPPort))
+		flushTraces(flushSpans, cfg.HTTP.ShutdownTimeout)
+	case "migrate":
+		err = runMigrate(cfg.DB, args[1:])
+	case "seed":
+		err = runSeed(cfg.DB, args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
 	if err != nil {
-		log.Fatalf("Could not load .env file %v", err)
+		log.Fatalln(err)
 	}
+}
 
-	server.Initialize(os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_PORT"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME"))
-	seed.Load(server.DB)
-	server.Run(fmt.Sprintf(":%s", os.Getenv("HTTP_PORT")))
+// flushTraces sends the spans still buffered before exiting, waiting up to timeout for the exporter
+func flushTraces(flush func(context.Context) error, timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := flush(ctx); err != nil {
+		server.Logger.Warn("Could not flush spans", logger.Fields{"error": err})
+	}
 }
@@ -0,0 +1,108 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters spans can be sent to
+const (
+	Off    = "off"
+	OTLP   = "otlp"
+	Stdout = "stdout"
+	File   = "file"
+)
+
+// instrumentation names the tracer the API's spans are started with
+const instrumentation = "github.com/aaronprice00/goblog-mvc/api"
+
+// Config picks where spans are exported, only the fields of the Exporter are used. Endpoint is the host:port
+// of the OTLP/HTTP collector, when empty the exporter reads OTEL_EXPORTER_OTLP_ENDPOINT or uses
+// localhost:4318. SampleRatio is the share of new traces recorded, a sampled caller's traceparent is
+// always followed
+type Config struct {
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	Path        string
+	ServiceName string
+	SampleRatio float64
+}
+
+// Setup makes W3C traceparent the propagation format and installs the tracer provider exporting to the
+// configured exporter. The returned func flushes the spans still buffered and stops exporting. With the
+// exporter off no spans are recorded, but the traceparent of callers is still passed on
+func Setup(c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch c.Exporter {
+	case OTLP:
+		opts := []otlptracehttp.Option{}
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case Stdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case File:
+		if c.Path == "" {
+			return nil, fmt.Errorf("Required: trace file path")
+		}
+		file, err = os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case Off, "":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("Unknown Trace Exporter: %s", c.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, err
+	}
+
+	name := c.ServiceName
+	if name == "" {
+		name = "goblog"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Tracer starts the API's spans with the installed tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
@@ -2,25 +2,59 @@ package formaterror
 
 import (
 	"errors"
+	"regexp"
 	"strings"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"gorm.io/gorm"
 )
 
-// FormatError changes error response for testing
-func FormatError(err string) error {
-	if strings.Contains(err, "username") {
-		return errors.New("Username Already Taken")
-	}
+var (
+	// Postgres: duplicate key value violates unique constraint "users_email_key", or "idx_posts_slug"
+	// for a unique index
+	postgresUnique = regexp.MustCompile(`unique constraint "([a-z_]+)"`)
+	// SQLite: UNIQUE constraint failed: users.email
+	sqliteUnique = regexp.MustCompile(`UNIQUE constraint failed: [a-z_]+\.([a-z_]+)`)
+)
 
-	if strings.Contains(err, "email") {
-		return errors.New("Email Already Used")
-	}
+// uniqueMessages maps the column of a unique violation to the message shown to the client
+var uniqueMessages = map[string]string{
+	"username": "Username Already Taken",
+	"email":    "Email Already Used",
+	"title":    "Title Already Used",
+	"slug":     "Slug Already Used",
+}
 
-	if strings.Contains(err, "title") {
-		return errors.New("Title Already Used")
+// uniqueColumn returns the column of a Postgres or SQLite unique constraint violation that has a message
+func uniqueColumn(err string) (string, bool) {
+	if m := sqliteUnique.FindStringSubmatch(err); m != nil {
+		return m[1], true
 	}
+	if m := postgresUnique.FindStringSubmatch(err); m != nil {
+		// Postgres only names the constraint, which ends in the column
+		name := strings.TrimSuffix(m[1], "_key")
+		for column := range uniqueMessages {
+			if strings.HasSuffix(name, "_"+column) {
+				return column, true
+			}
+		}
+	}
+	return "", false
+}
 
-	if strings.Contains(err, "hashedPassword") {
-		return errors.New("Incorrect Password")
+// FormatError turns a unique constraint violation the client can fix into an apierror.Conflict naming the
+// column, and a missing row into an apierror.NotFound. Other errors are returned as they are
+func FormatError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if column, ok := uniqueColumn(err.Error()); ok {
+		if message, ok := uniqueMessages[column]; ok {
+			return &apierror.Conflict{Field: column, Message: message}
+		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &apierror.NotFound{}
 	}
-	return errors.New("Incorrect Details")
+	return err
 }
@@ -0,0 +1,61 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// ExcerptLength is the number of characters Excerpt keeps
+const ExcerptLength = 200
+
+var renderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// policy allows the markup user generated content needs and nothing that runs script or styles the page
+var policy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Fenced code blocks name their language for highlighters
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9+#-]+$`)).OnElements("code")
+	return p
+}()
+
+// text keeps no markup at all, only the text between tags
+var text = bluemonday.StrictPolicy()
+
+// Render converts Markdown source to HTML sanitized against the allowlist. Raw HTML in the source is dropped
+// by the renderer, the sanitizer is the second line of defence for what Markdown itself produces
+func Render(src string) string {
+	var buf bytes.Buffer
+	if err := renderer.Convert([]byte(src), &buf); err != nil {
+		// goldmark only fails writing to the buffer, fall back to the escaped source
+		return text.Sanitize(src)
+	}
+	return policy.Sanitize(buf.String())
+}
+
+// Text returns the rendered text without markup or entities and with whitespace collapsed
+func Text(src string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(text.Sanitize(Render(src)))), " ")
+}
+
+// Excerpt returns the first ExcerptLength characters of the rendered text, cut at a word and followed by
+// an ellipsis when shortened
+func Excerpt(src string) string {
+	// The excerpt is plain text, JSON encoding escapes it for HTML
+	plain := Text(src)
+	if utf8.RuneCountInString(plain) <= ExcerptLength {
+		return plain
+	}
+	runes := []rune(plain)[:ExcerptLength]
+	cut := string(runes)
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
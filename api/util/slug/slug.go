@@ -0,0 +1,101 @@
+package slug
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength is the longest slug Make returns, suffixes included
+const MaxLength = 100
+
+// letters spells out lower case letters that don't decompose to ASCII, accented Latin letters are
+// handled by stripping their combining marks
+var letters = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i", '&': "and",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Make lower cases and transliterates s to ASCII letters and digits separated by single hyphens.
+// Characters it can't transliterate are dropped, so the result may be empty
+func Make(s string) string {
+	// Each rune is looked up before decomposing so й is spelled y rather than losing its breve to become и
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		for _, c := range spell(r) {
+			if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+				b.WriteRune(c)
+				hyphen = false
+			} else if b.Len() > 0 && !hyphen {
+				b.WriteByte('-')
+				hyphen = true
+			}
+		}
+	}
+	return truncate(strings.TrimSuffix(b.String(), "-"), MaxLength)
+}
+
+// WithSuffix returns the n-th candidate for base: base itself, then base-2, base-3... shortened so the
+// suffix still fits in MaxLength
+func WithSuffix(base string, n int) string {
+	if n <= 1 {
+		return base
+	}
+	suffix := "-" + strconv.Itoa(n)
+	return truncate(base, MaxLength-len(suffix)) + suffix
+}
+
+// spell returns r as it should appear in a slug, before filtering to ASCII
+func spell(r rune) string {
+	if spelled, ok := letters[r]; ok {
+		return spelled
+	}
+	// ά has no entry of its own, once its accent is gone α does
+	var b strings.Builder
+	for _, c := range stripMarks(string(r)) {
+		if spelled, ok := letters[c]; ok {
+			b.WriteString(spelled)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// stripMarks splits é into e and a combining accent with NFKD and drops the accent
+func stripMarks(s string) string {
+	// Chained transformers keep state, so each call gets its own
+	marks := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)))
+	stripped, _, err := transform.String(marks, s)
+	if err != nil {
+		return ""
+	}
+	return stripped
+}
+
+// truncate cuts s to at most max bytes, at a hyphen when there is one so words stay whole
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	if i := strings.LastIndexByte(s, '-'); i > 0 {
+		s = s[:i]
+	}
+	return strings.TrimSuffix(s, "-")
+}
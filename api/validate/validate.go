@@ -0,0 +1,184 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/badoux/checkmail"
+)
+
+// Codes of the rules here beyond the ones apierror defines
+const (
+	CodeTooShort = "too_short"
+	CodeWeak     = "weak"
+)
+
+// Rule says what is wrong with the value of the field label names in messages, nil when nothing is
+type Rule func(label, value string) *apierror.FieldError
+
+// Field is one value of a request and the rules it must pass. Name is the field in the JSON body and Label
+// names it in messages, its rules run in order up to the first one that fails
+type Field struct {
+	Name  string
+	Label string
+	Value string
+	Rules []Rule
+}
+
+// Errors collects what is wrong with each field so every mistake is answered at once
+type Errors []apierror.FieldError
+
+// Add records what is wrong with the field
+func (e *Errors) Add(field, code, message string) {
+	*e = append(*e, apierror.FieldError{Field: field, Code: code, Message: message})
+}
+
+// Include records the fields of an apierror.Validation
+func (e *Errors) Include(err error) {
+	*e = append(*e, apierror.Fields(err)...)
+}
+
+// Check runs the field's rules and records the first that fails
+func (e *Errors) Check(f Field) {
+	for _, rule := range f.Rules {
+		if fe := rule(f.Label, f.Value); fe != nil {
+			fe.Field = f.Name
+			*e = append(*e, *fe)
+			return
+		}
+	}
+}
+
+// Err is an apierror.Validation of the fields recorded, nil when there are none
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return &apierror.Validation{Fields: e}
+}
+
+// Check runs the rules of every field and returns what is wrong with all of them together
+func Check(fields ...Field) error {
+	var errs Errors
+	for _, f := range fields {
+		errs.Check(f)
+	}
+	return errs.Err()
+}
+
+func fail(code, message string) *apierror.FieldError {
+	return &apierror.FieldError{Code: code, Message: message}
+}
+
+// Required refuses an empty value
+func Required(label, value string) *apierror.FieldError {
+	if value == "" {
+		return fail(apierror.CodeRequired, "Required: "+label)
+	}
+	return nil
+}
+
+// MaxLength refuses values over max characters
+func MaxLength(max int) Rule {
+	return func(label, value string) *apierror.FieldError {
+		if utf8.RuneCountInString(value) > max {
+			return fail(apierror.CodeTooLong, fmt.Sprintf("%s Too Long: max %d characters", label, max))
+		}
+		return nil
+	}
+}
+
+// MinLength refuses values under min characters
+func MinLength(min int) Rule {
+	return func(label, value string) *apierror.FieldError {
+		if utf8.RuneCountInString(value) < min {
+			return fail(CodeTooShort, fmt.Sprintf("%s Too Short: min %d characters", label, min))
+		}
+		return nil
+	}
+}
+
+// Matches refuses values the pattern doesn't match, allowed says what may be used instead
+func Matches(pattern *regexp.Regexp, allowed string) Rule {
+	return func(label, value string) *apierror.FieldError {
+		if !pattern.MatchString(value) {
+			return fail(apierror.CodeInvalid, fmt.Sprintf("Invalid %s: %s", label, allowed))
+		}
+		return nil
+	}
+}
+
+// Email refuses values that aren't shaped like an email address
+func Email(label, value string) *apierror.FieldError {
+	if err := checkmail.ValidateFormat(value); err != nil {
+		return fail(apierror.CodeInvalid, "Invalid "+label)
+	}
+	return nil
+}
+
+// LookupMX finds the mail servers of a domain, replaced in tests
+var LookupMX = net.LookupMX
+
+// MailServer refuses an email whose domain has no mail server. A lookup that fails for any other reason
+// than the domain not existing lets the email through, so an unreachable resolver doesn't stop sign ups
+func MailServer(label, value string) *apierror.FieldError {
+	domain := value[strings.LastIndex(value, "@")+1:]
+	mx, err := LookupMX(domain)
+	if dnsErr, ok := err.(*net.DNSError); ok && !dnsErr.IsNotFound {
+		return nil
+	}
+	if err != nil || len(mx) == 0 {
+		return fail(apierror.CodeInvalid, fmt.Sprintf("Invalid %s: no mail server for %s", label, domain))
+	}
+	return nil
+}
+
+// MaxPasswordBytes is as much of a password as bcrypt uses
+const MaxPasswordBytes = 72
+
+// PasswordPolicy is what a new password has to meet: MinLength characters and MinClasses of lower case
+// letters, upper case letters, digits and symbols
+type PasswordPolicy struct {
+	MinLength  int
+	MinClasses int
+}
+
+// DefaultPasswordPolicy is the policy unless the config sets another
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 6, MinClasses: 1}
+
+// Rule refuses passwords that don't meet the policy, or are longer than bcrypt can hash
+func (p PasswordPolicy) Rule(label, value string) *apierror.FieldError {
+	if utf8.RuneCountInString(value) < p.MinLength {
+		return fail(CodeTooShort, fmt.Sprintf("%s Too Short: min %d characters", label, p.MinLength))
+	}
+	if len(value) > MaxPasswordBytes {
+		return fail(apierror.CodeTooLong, fmt.Sprintf("%s Too Long: max %d bytes", label, MaxPasswordBytes))
+	}
+	if classes(value) < p.MinClasses {
+		return fail(CodeWeak, fmt.Sprintf("%s Too Weak: use %d of lower case, upper case, digits and symbols", label, p.MinClasses))
+	}
+	return nil
+}
+
+// classes counts which of lower case letters, upper case letters, digits and symbols the value has
+func classes(value string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range value {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
@@ -3,13 +3,22 @@ module github.com/aaronprice00/goblog-mvc
 go 1.15
 
 require (
-	github.com/aaronprice00/goblog v0.0.0-20210129234810-bc90d4ceda14
 	github.com/badoux/checkmail v1.2.1
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.3.0
+	github.com/microcosm-cc/bluemonday v1.0.4
 	github.com/stretchr/testify v1.7.0
+	github.com/yuin/goldmark v1.3.2
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/text v0.3.3
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	gorm.io/driver/postgres v1.0.7
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.12
 )
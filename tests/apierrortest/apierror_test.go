@@ -0,0 +1,110 @@
+package apierrortest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	samples := []struct {
+		testID int
+		err    error
+		status int
+		code   string
+		fields []apierror.FieldError
+	}{
+		{testID: 1, err: &apierror.NotFound{Resource: "Post"}, status: http.StatusNotFound, code: "not_found"},
+		{testID: 2, err: &apierror.Conflict{Field: "email", Message: "Email Already Used"}, status: http.StatusConflict, code: "conflict",
+			fields: []apierror.FieldError{{Field: "email", Code: apierror.CodeTaken, Message: "Email Already Used"}}},
+		{testID: 3, err: apierror.Invalid("title", apierror.CodeRequired, "Required: Title"), status: http.StatusUnprocessableEntity, code: "validation_failed",
+			fields: []apierror.FieldError{{Field: "title", Code: apierror.CodeRequired, Message: "Required: Title"}}},
+		{testID: 4, err: &apierror.Unauthorized{Message: "Unauthorized"}, status: http.StatusUnauthorized, code: "unauthorized"},
+		{testID: 5, err: &apierror.Unauthorized{Code: "invalid_credentials", Message: "Invalid Credentials"}, status: http.StatusUnauthorized, code: "invalid_credentials"},
+		{testID: 6, err: &apierror.Forbidden{Message: "Forbidden"}, status: http.StatusForbidden, code: "forbidden"},
+		{testID: 7, err: &apierror.BadRequest{Code: "invalid_cursor", Message: "Invalid Cursor"}, status: http.StatusBadRequest, code: "invalid_cursor"},
+		{testID: 8, err: &apierror.TooManyRequests{Message: "Too Many Requests"}, status: http.StatusTooManyRequests, code: "too_many_requests"},
+		{testID: 9, err: &apierror.TooLarge{}, status: http.StatusRequestEntityTooLarge, code: "body_too_large"},
+		// Wrapping keeps the kind
+		{testID: 10, err: fmt.Errorf("reading post: %w", &apierror.NotFound{Resource: "Post"}), status: http.StatusNotFound, code: "not_found"},
+		{testID: 11, err: errors.New("connection refused"), status: http.StatusInternalServerError, code: apierror.CodeInternal},
+	}
+	for _, v := range samples {
+		assert.Equal(t, v.status, apierror.Status(v.err))
+		assert.Equal(t, v.code, apierror.Code(v.err))
+		assert.Equal(t, v.fields, apierror.Fields(v.err))
+		assert.Equal(t, v.status == http.StatusInternalServerError, apierror.Internal(v.err))
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, apierror.Code(v.err))
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &apierror.Validation{Fields: []apierror.FieldError{
+		{Field: "title", Code: apierror.CodeRequired, Message: "Required: Title"},
+		{Field: "tags", Code: apierror.CodeTooMany, Message: "Too Many Tags: max 10"},
+	}}
+	assert.EqualError(t, err, "Required: Title; Too Many Tags: max 10")
+	assert.EqualError(t, &apierror.NotFound{}, "Not Found")
+}
+
+func TestERROR(t *testing.T) {
+	samples := []struct {
+		testID    int
+		err       error
+		requestID string
+		status    int
+		title     string
+		detail    string
+		code      string
+		fields    int
+	}{
+		{testID: 1, err: &apierror.NotFound{Resource: "Post"}, requestID: "abc-123", status: 404, title: "Not Found", detail: "Post Not Found", code: "not_found"},
+		{testID: 2, err: apierror.Invalid("email", apierror.CodeInvalid, "Invalid Email"), status: 422, title: "Unprocessable Entity", detail: "Invalid Email", code: "validation_failed", fields: 1},
+		// What went wrong on the server isn't told to the client
+		{testID: 3, err: errors.New("pq: password authentication failed"), requestID: "abc-123", status: 500, title: "Internal Server Error", code: "internal"},
+	}
+	for _, v := range samples {
+		rr := httptest.NewRecorder()
+		if v.requestID != "" {
+			rr.Header().Set(response.RequestIDHeader, v.requestID)
+		}
+		response.ERROR(rr, v.err)
+
+		assert.Equal(t, v.status, rr.Code)
+		assert.Equal(t, response.ProblemContentType, rr.Header().Get("Content-Type"))
+		problem := response.Problem{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, v.title, problem.Title)
+		assert.Equal(t, v.status, problem.Status)
+		assert.Equal(t, v.code, problem.Code)
+		assert.Equal(t, v.requestID, problem.RequestID)
+		assert.Len(t, problem.Errors, v.fields)
+		if v.detail != "" {
+			assert.Equal(t, v.detail, problem.Detail)
+		} else {
+			assert.NotContains(t, problem.Detail, "pq")
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestNoBody(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusAccepted} {
+		rr := httptest.NewRecorder()
+		// As every route behind SetMiddlewareJSON starts out
+		rr.Header().Set("Content-Type", "application/json")
+		response.NoBody(rr, status)
+		assert.Equal(t, status, rr.Code)
+		assert.Empty(t, rr.Header().Get("Content-Type"))
+		assert.Empty(t, rr.Body.String())
+		fmt.Printf("%v Finished w/ code: %v\n", status, rr.Code)
+	}
+}
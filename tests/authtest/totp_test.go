@@ -0,0 +1,98 @@
+package authtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the RFC 6238 test key "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238's SHA1 vectors, cut to six digits
+	samples := []struct {
+		testID int
+		unix   int64
+		code   string
+	}{
+		{testID: 1, unix: 59, code: "287082"},
+		{testID: 2, unix: 1111111109, code: "081804"},
+		{testID: 3, unix: 1111111111, code: "050471"},
+		{testID: 4, unix: 1234567890, code: "005924"},
+		{testID: 5, unix: 2000000000, code: "279037"},
+	}
+
+	for _, v := range samples {
+		code, err := auth.TOTPCode(rfcSecret, auth.TOTPStep(time.Unix(v.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, v.code, code)
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := auth.TOTPStep(now)
+	code := func(s int64) string {
+		c, _ := auth.TOTPCode(rfcSecret, s)
+		return c
+	}
+
+	samples := []struct {
+		testID int
+		code   string
+		after  int64
+		step   int64
+		err    error
+	}{
+		{testID: 1, code: code(step), step: step},
+		{testID: 2, code: " " + code(step) + " ", step: step},
+		// A step of drift either way is allowed, two isn't
+		{testID: 3, code: code(step - 1), step: step - 1},
+		{testID: 4, code: code(step + 1), step: step + 1},
+		{testID: 5, code: code(step + 2), err: auth.ErrInvalidCode},
+		// Steps already used are refused
+		{testID: 6, code: code(step), after: step, err: auth.ErrInvalidCode},
+		{testID: 7, code: code(step + 1), after: step, step: step + 1},
+		{testID: 8, code: "12345", err: auth.ErrInvalidCode},
+	}
+
+	for _, v := range samples {
+		got, err := auth.ValidateTOTP(rfcSecret, v.code, now, v.after)
+		assert.Equal(t, v.err, err)
+		assert.Equal(t, v.step, got)
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+}
+
+func TestTOTPSecretAndURI(t *testing.T) {
+	secret, err := auth.NewTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+	other, _ := auth.NewTOTPSecret()
+	assert.NotEqual(t, secret, other)
+
+	uri := auth.TOTPURI("GoBlog", "pet@gmail.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/GoBlog:pet@gmail.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.NewRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, auth.RecoveryCodeCount)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, code)
+		assert.Equal(t, code, auth.NormalizeRecoveryCode(strings.ToUpper(strings.Replace(code, "-", " ", 1))))
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+}
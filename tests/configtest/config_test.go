@@ -0,0 +1,131 @@
+package configtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/config"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile puts the content in a file under dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Could not write %s, Error: %v \n", name, err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each source sets one more value than the next, so each value shows which source won
+	yaml := writeFile(t, dir, "config.yml", "db_driver: sqlite\ndb_name: file.db\nhttp_port: 1111\napi_secret: file\n"+
+		"rate_limit_auth: 3/1m\nscheduler_interval: 30s\npublic_url: https://blog.example.com\n")
+	env := writeFile(t, dir, "test.env", "CT_CONFIG_FILE="+yaml+"\nCT_HTTP_PORT=2222\nCT_API_SECRET=dotenv\nCT_DB_NAME=dotenv.db\n")
+	os.Setenv("CT_HTTP_PORT", "3333")
+	os.Setenv("CT_API_SECRET", "environment")
+	defer os.Unsetenv("CT_HTTP_PORT")
+	defer os.Unsetenv("CT_API_SECRET")
+
+	cfg, args, err := config.Load(config.Options{
+		Args:    []string{"-env-file", env, "-api-secret", "flag", "migrate", "up"},
+		Prefix:  "CT_",
+		EnvFile: filepath.Join(dir, "missing.env"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+	assert.Equal(t, "sqlite", cfg.DB.Driver)
+	assert.Equal(t, "dotenv.db", cfg.DB.Name)
+	assert.Equal(t, "3333", cfg.HTTPPort)
+	assert.Equal(t, "flag", cfg.APISecret)
+	assert.Equal(t, 30*time.Second, cfg.SchedulerInterval)
+	assert.Equal(t, ratelimit.Limit{Burst: 3, Per: time.Minute}, cfg.RateLimits[ratelimit.Auth])
+	// Settings nobody gave keep their defaults
+	assert.Equal(t, ratelimit.DefaultLimits[ratelimit.Signup], cfg.RateLimits[ratelimit.Signup])
+	assert.Equal(t, config.Defaults().PostMaxContentLength, cfg.PostMaxContentLength)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nested := writeFile(t, dir, "nested.yml", "db:\n  host: localhost\n")
+
+	samples := []struct {
+		testID int
+		args   []string
+	}{
+		// A missing default .env is fine, a named one is not
+		{testID: 1, args: []string{"-env-file", filepath.Join(dir, "missing.env")}},
+		{testID: 2, args: []string{"-config", filepath.Join(dir, "missing.yml")}},
+		{testID: 3, args: []string{"-config", nested}},
+		{testID: 4, args: []string{"-scheduler-interval", "soon"}},
+		{testID: 5, args: []string{"-rate-limit", "10"}},
+		{testID: 6, args: []string{"-no-such-flag"}},
+		{testID: 7, args: []string{"-log-level", "loud"}},
+		{testID: 8, args: []string{"-trace-sample-ratio", "half"}},
+	}
+	for _, v := range samples {
+		_, _, err := config.Load(config.Options{Args: v.args, Prefix: "CT_", EnvFile: filepath.Join(dir, "missing.env")})
+		assert.Error(t, err)
+		fmt.Printf("%v Finished w/ error: %v\n", v.testID, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := config.Defaults()
+	valid.DB = config.Database{Driver: "sqlite", Name: ":memory:"}
+	valid.APISecret = "secret"
+	valid.PublicURL = "https://blog.example.com"
+	assert.NoError(t, valid.Validate())
+
+	samples := []struct {
+		testID       int
+		change       func(c *config.Config)
+		errorMessage string
+	}{
+		{testID: 1, change: func(c *config.Config) { c.APISecret = "" }, errorMessage: "Required: API_SECRET"},
+		{testID: 2, change: func(c *config.Config) { c.DB.Driver = "mysql" }, errorMessage: "Invalid DB_DRIVER: mysql"},
+		{testID: 3, change: func(c *config.Config) { c.DB.Name = "" }, errorMessage: "Required: DB_NAME as the SQLite file path or :memory:"},
+		{testID: 4, change: func(c *config.Config) { c.DB = config.Database{Driver: "postgres", Host: "db"} }, errorMessage: "Required: DB_HOST and DB_NAME"},
+		{testID: 5, change: func(c *config.Config) { c.HTTPPort = "http" }, errorMessage: "Invalid HTTP_PORT: http"},
+		{testID: 6, change: func(c *config.Config) { c.PublicURL = "blog.example.com" }, errorMessage: "Invalid PUBLIC_URL: blog.example.com"},
+		{testID: 7, change: func(c *config.Config) { c.Mail.Driver = "smtp" }, errorMessage: "Required: SMTP_HOST and MAIL_FROM for the smtp mail driver"},
+		{testID: 8, change: func(c *config.Config) { c.PostMaxContentLength = 0 }, errorMessage: "Invalid POST_MAX_CONTENT_LENGTH: 0"},
+		{testID: 9, change: func(c *config.Config) { c.HTTP.TLSCertFile = "cert.pem" }, errorMessage: "Required: TLS_CERT_FILE and TLS_KEY_FILE together"},
+		{testID: 10, change: func(c *config.Config) { c.HTTP.WriteTimeout = -time.Second }, errorMessage: "Invalid HTTP_WRITE_TIMEOUT: -1s"},
+		{testID: 11, change: func(c *config.Config) { c.DB.MaxIdleConns = -1 }, errorMessage: "Invalid DB pool settings: DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME can't be negative"},
+		// Every problem is reported at once
+		{testID: 12, change: func(c *config.Config) { c.APISecret = ""; c.HTTPPort = "0" }, errorMessage: "Required: API_SECRET; Invalid HTTP_PORT: 0"},
+		{testID: 13, change: func(c *config.Config) { c.Tracing.Exporter = "jaeger" }, errorMessage: "Invalid TRACE_EXPORTER: jaeger"},
+		{testID: 14, change: func(c *config.Config) { c.Tracing.Exporter = "file" }, errorMessage: "Required: TRACE_FILE for the file trace exporter"},
+		{testID: 15, change: func(c *config.Config) { c.Tracing.SampleRatio = 1.5 }, errorMessage: "Invalid TRACE_SAMPLE_RATIO: 1.5"},
+		{testID: 16, change: func(c *config.Config) { c.PasswordPolicy.MinLength = 0 }, errorMessage: "Invalid PASSWORD_MIN_LENGTH: 0"},
+		{testID: 17, change: func(c *config.Config) { c.PasswordPolicy.MinClasses = 5 }, errorMessage: "Invalid PASSWORD_MIN_CLASSES: 5"},
+		// Mail links never come from the request's Host, whichever driver sends them
+		{testID: 18, change: func(c *config.Config) { c.PublicURL = "" }, errorMessage: "Required: PUBLIC_URL for the links in mail"},
+	}
+	for _, v := range samples {
+		c := valid
+		v.change(&c)
+		err := c.Validate()
+		if assert.Error(t, err) {
+			assert.Equal(t, v.errorMessage, err.Error())
+		}
+		fmt.Printf("%v Finished w/ error: %v\n", v.testID, err)
+	}
+}
@@ -0,0 +1,256 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenLine finds the token in a mailed body, it sits on a line of its own
+var tokenLine = regexp.MustCompile(`(?m)^([A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)$`)
+
+// mailedToken returns the token in the newest mail to the address, empty when nothing was mailed
+func mailedToken(to string) string {
+	msg, ok := mail.Last(to)
+	if !ok {
+		return ""
+	}
+	if m := tokenLine.FindStringSubmatch(msg.Body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// accountRequest posts the JSON body to the handler and decodes the response when there is one
+func accountRequest(handler http.HandlerFunc, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, _ := http.NewRequest("POST", "/", bytes.NewBufferString(body))
+	req.Host = "blog.test"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+func TestVerifyEmail(t *testing.T) {
+	if err := refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	rr, responseMap := accountRequest(server.CreateUser, `{"username": "Pet", "email": "pet@gmail.com", "password": "password"}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Nil(t, responseMap["email_verified_at"])
+
+	msg, ok := mail.Last("pet@gmail.com")
+	assert.True(t, ok)
+	assert.Equal(t, "Verify your email", msg.Subject)
+	assert.Contains(t, msg.Body, "POST http://blog.test/users/verify")
+	token := mailedToken("pet@gmail.com")
+	assert.NotEmpty(t, token)
+
+	// Resending replaces the first token, an unknown address gets the same answer and no mail
+	rr, _ = accountRequest(server.ResendVerification, `{"email": "pet@gmail.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	resent := mailedToken("pet@gmail.com")
+	assert.NotEqual(t, token, resent)
+	rr, _ = accountRequest(server.ResendVerification, `{"email": "nobody@gmail.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Len(t, mail.Sent(), 2)
+
+	// A verification token is no use for resetting the password
+	rr, responseMap = accountRequest(server.ResetPassword, fmt.Sprintf(`{"token": %q, "password": "newpass"}`, resent))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, "Invalid Token", responseMap["detail"])
+
+	samples := []struct {
+		testID       int
+		token        string
+		statusCode   int
+		errorMessage string
+	}{
+		{testID: 1, token: "", statusCode: 422, errorMessage: "Required: Token"},
+		{testID: 2, token: "forged.signature", statusCode: 422, errorMessage: "Invalid Token"},
+		{testID: 3, token: token, statusCode: 422, errorMessage: "Invalid Token"},
+		{testID: 4, token: resent, statusCode: 200},
+		// Single use
+		{testID: 5, token: resent, statusCode: 422, errorMessage: "Invalid Token"},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := accountRequest(server.VerifyEmail, fmt.Sprintf(`{"token": %q}`, v.token))
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			assert.Equal(t, "pet@gmail.com", responseMap["email"])
+			assert.NotNil(t, responseMap["email_verified_at"])
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// A verified account isn't mailed again
+	rr, _ = accountRequest(server.ResendVerification, `{"email": "pet@gmail.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Len(t, mail.Sent(), 2)
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	server.RequireVerifiedEmail = true
+	defer func() { server.RequireVerifiedEmail = false }()
+
+	loginJSON := fmt.Sprintf(`{"email": %q, "password": "pass123"}`, user.Email)
+	rr, responseMap := accountRequest(server.Login, loginJSON)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, model.ErrEmailNotVerified.Error(), responseMap["detail"])
+
+	if _, err = server.Users.VerifyEmail(user.ID); err != nil {
+		log.Fatalf("Could not verify user, Error: %v \n", err)
+	}
+	rr, responseMap = accountRequest(server.Login, loginJSON)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, responseMap["access_token"])
+}
+
+func TestMailNeedsPublicURL(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	// A reset link can't be pointed at another host by the request
+	req, _ := http.NewRequest("POST", "/password/forgot", bytes.NewBufferString(fmt.Sprintf(`{"email": %q}`, user.Email)))
+	req.Host = "evil.example"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.ForgotPassword).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	msg, ok := mail.Last(user.Email)
+	if assert.True(t, ok) {
+		assert.Contains(t, msg.Body, "POST http://blog.test/password/reset")
+		assert.NotContains(t, msg.Body, "evil.example")
+	}
+
+	// Without PublicURL nothing is mailed, and the answer is still the one an unknown email gets
+	publicURL := server.PublicURL
+	server.PublicURL = ""
+	defer func() { server.PublicURL = publicURL }()
+	sent := len(mail.Sent())
+	rr, _ = accountRequest(server.ForgotPassword, fmt.Sprintf(`{"email": %q}`, user.Email))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	assert.Len(t, mail.Sent(), sent)
+}
+
+func TestChangeEmailUnverifies(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	if _, err = server.Users.VerifyEmail(user.ID); err != nil {
+		log.Fatalf("Could not verify user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not sign in, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID   int
+		email    string
+		verified bool
+		mailed   bool
+	}{
+		// Keeping the email keeps it verified
+		{testID: 1, email: user.Email, verified: true},
+		// A new one has to be verified again, a token is mailed to it
+		{testID: 2, email: "wonka@chocolate.com", verified: false, mailed: true},
+	}
+	for _, v := range samples {
+		body := fmt.Sprintf(`{"username": %q, "email": %q, "password": "pass123"}`, user.Username, v.email)
+		req, _ := http.NewRequest("PUT", "/users", bytes.NewBufferString(body))
+		req = mux.SetURLVars(req, map[string]string{"id": fmt.Sprint(user.ID)})
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.UpdateUser).ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		updated, err := server.Users.ReadUserByID(user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, v.verified, updated.Verified())
+		assert.Equal(t, v.mailed, mailedToken(v.email) != "")
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	session, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login the user, Error: %v \n", err)
+	}
+
+	rr, responseMap := accountRequest(server.ForgotPassword, `{"email": "not an email"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, "Invalid Email", responseMap["detail"])
+	rr, _ = accountRequest(server.ForgotPassword, `{"email": "nobody@gmail.com"}`)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Len(t, mail.Sent(), 0)
+
+	rr, _ = accountRequest(server.ForgotPassword, fmt.Sprintf(`{"email": %q}`, user.Email))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	msg, _ := mail.Last(user.Email)
+	assert.Equal(t, "Reset your password", msg.Subject)
+	token := mailedToken(user.Email)
+
+	samples := []struct {
+		testID       int
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{testID: 1, inputJSON: `{"password": "newpass"}`, statusCode: 422, errorMessage: "Required: Token"},
+		// A missing password doesn't spend the token
+		{testID: 2, inputJSON: fmt.Sprintf(`{"token": %q}`, token), statusCode: 422, errorMessage: "Required: Password"},
+		{testID: 3, inputJSON: fmt.Sprintf(`{"token": %q, "password": "newpass"}`, token+"x"), statusCode: 422, errorMessage: "Invalid Token"},
+		{testID: 4, inputJSON: fmt.Sprintf(`{"token": %q, "password": "newpass"}`, token), statusCode: 204},
+		{testID: 5, inputJSON: fmt.Sprintf(`{"token": %q, "password": "again1"}`, token), statusCode: 422, errorMessage: "Invalid Token"},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := accountRequest(server.ResetPassword, v.inputJSON)
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.errorMessage != "" {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// The new password works, the old one and the old session don't
+	_, err = server.SignIn(user.Email, "pass123")
+	assert.Error(t, err)
+	_, err = server.SignIn(user.Email, "newpass")
+	assert.NoError(t, err)
+	stored, err := server.Tokens.ReadRefreshTokenByHash(auth.HashToken(session.RefreshToken))
+	assert.NoError(t, err)
+	assert.NotNil(t, stored.RevokedAt)
+
+	// Reading the mail proved the address
+	verified, err := server.Users.ReadUserByEmail(user.Email)
+	assert.NoError(t, err)
+	assert.True(t, verified.Verified())
+}
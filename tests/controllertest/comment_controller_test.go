@@ -0,0 +1,282 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// commentRequest calls a comment handler on the post as the user with the token, empty for nobody
+func commentRequest(handler http.HandlerFunc, method, token string, pid, cid uint, query, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest(method, "/posts/comments?"+query, bytes.NewBufferString(body))
+	if err != nil {
+		log.Fatalf("Error: %v \n", err)
+	}
+	vars := map[string]string{"id": strconv.Itoa(int(pid))}
+	if cid != 0 {
+		vars["cid"] = strconv.Itoa(int(cid))
+	}
+	req = mux.SetURLVars(req, vars)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+func TestCreateComment(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	// users[0] wrote posts[0], users[1] comments on it
+	author, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+	reader, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		token        string
+		post         uint
+		inputJSON    string
+		statusCode   int
+		status       string
+		errorMessage string
+	}{
+		{testID: 1, token: reader.AccessToken, post: posts[0].ID, inputJSON: `{"content": "Glub <b>glub</b>", "status": "approved"}`, statusCode: 201, status: model.CommentPending},
+		{testID: 2, token: author.AccessToken, post: posts[0].ID, inputJSON: `{"content": "Thanks!", "parent_id": 1}`, statusCode: 201, status: model.CommentApproved},
+		{testID: 3, token: reader.AccessToken, post: posts[0].ID, inputJSON: `{"content": ""}`, statusCode: 422, errorMessage: "Required: Content"},
+		{testID: 4, token: reader.AccessToken, post: posts[1].ID, inputJSON: `{"content": "Wrong thread", "parent_id": 1}`, statusCode: 422, errorMessage: model.ErrInvalidParent.Error()},
+		{testID: 5, token: reader.AccessToken, post: 99, inputJSON: `{"content": "Nowhere"}`, statusCode: 404, errorMessage: "Post Not Found"},
+		{testID: 6, token: "", post: posts[0].ID, inputJSON: `{"content": "Anonymous"}`, statusCode: 401, errorMessage: "Unauthorized"},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := commentRequest(server.CreateComment, "POST", v.token, v.post, 0, "", v.inputJSON)
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 201 {
+			assert.Equal(t, v.status, responseMap["status"])
+			assert.Equal(t, float64(v.post), responseMap["post_id"])
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestGetComments(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	author, _ := server.SignIn(users[0].Email, "pass123")
+	reader, _ := server.SignIn(users[1].Email, "pass123")
+
+	// 1 approved root, 2 its approved reply, 3 a reply to 2, 4 a pending root by the reader
+	pid := posts[0].ID
+	seeds := []model.Comment{
+		{Content: "First", Status: model.CommentApproved, AuthorID: users[1].ID},
+		{Content: "Reply", Status: model.CommentApproved, AuthorID: users[0].ID, ParentID: uintPtr(1)},
+		{Content: "Reply to reply", Status: model.CommentApproved, AuthorID: users[1].ID, ParentID: uintPtr(2)},
+		{Content: "Held", Status: model.CommentPending, AuthorID: users[1].ID},
+	}
+	for i := range seeds {
+		seeds[i].PostID = pid
+		if _, err = server.Comments.CreateComment(&seeds[i]); err != nil {
+			log.Fatalf("Could not seed comments, Error: %v \n", err)
+		}
+	}
+
+	samples := []struct {
+		testID     int
+		token      string
+		query      string
+		statusCode int
+		ids        []float64
+	}{
+		{testID: 1, token: "", query: "", statusCode: 200, ids: []float64{1, 2, 3}},
+		{testID: 2, token: reader.AccessToken, query: "", statusCode: 200, ids: []float64{1, 2, 3, 4}},
+		{testID: 3, token: author.AccessToken, query: "status=pending", statusCode: 200, ids: []float64{4}},
+		{testID: 4, token: "", query: "view=tree", statusCode: 200, ids: []float64{1}},
+		{testID: 5, token: "", query: "limit=2", statusCode: 200, ids: []float64{1, 2}},
+		{testID: 6, token: "", query: "view=nested", statusCode: 400},
+		{testID: 7, token: "", query: "status=hidden", statusCode: 400},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/posts/comments?"+v.query, nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(pid))})
+		if v.token != "" {
+			req.Header.Set("Authorization", "Bearer "+v.token)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetComments).ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			var page struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err = json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+				t.Errorf("Cannot convert to json: %v\n", err)
+			}
+			ids := []float64{}
+			for _, c := range page.Data {
+				ids = append(ids, c["id"].(float64))
+			}
+			assert.Equal(t, v.ids, ids)
+			if v.query == "view=tree" {
+				// 3 nests under 2 which nests under 1
+				reply := page.Data[0]["replies"].([]interface{})[0].(map[string]interface{})
+				assert.Equal(t, 2.0, reply["id"])
+				assert.Equal(t, 3.0, reply["replies"].([]interface{})[0].(map[string]interface{})["id"])
+			}
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestModerateComment(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	author, _ := server.SignIn(users[0].Email, "pass123")
+	reader, _ := server.SignIn(users[1].Email, "pass123")
+
+	comment := model.Comment{Content: "Buy now", Status: model.CommentPending, PostID: posts[0].ID, AuthorID: users[1].ID}
+	if _, err = server.Comments.CreateComment(&comment); err != nil {
+		log.Fatalf("Could not seed comment, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		token        string
+		post         uint
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{testID: 1, token: reader.AccessToken, post: posts[0].ID, inputJSON: `{"status": "approved"}`, statusCode: 403, errorMessage: "Forbidden"},
+		{testID: 2, token: author.AccessToken, post: posts[0].ID, inputJSON: `{"status": "deleted"}`, statusCode: 422, errorMessage: model.ErrInvalidCommentStatus.Error()},
+		{testID: 3, token: author.AccessToken, post: posts[1].ID, inputJSON: `{"status": "spam"}`, statusCode: 404, errorMessage: "Comment Not Found"},
+		{testID: 4, token: author.AccessToken, post: posts[0].ID, inputJSON: `{"status": "spam"}`, statusCode: 200},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := commentRequest(server.UpdateCommentStatus, "PUT", v.token, v.post, comment.ID, "", v.inputJSON)
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			assert.Equal(t, model.CommentSpam, responseMap["status"])
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// An admin moderates comments on anyone's post
+	if _, err = server.Users.UpdateRole(users[1].ID, "admin"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	admin, _ := server.SignIn(users[1].Email, "pass123")
+	rr, responseMap := commentRequest(server.UpdateCommentStatus, "PUT", admin.AccessToken, posts[0].ID, comment.ID, "", `{"status": "approved"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, model.CommentApproved, responseMap["status"])
+}
+
+func TestUpdateAndDeleteComment(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	author, _ := server.SignIn(users[0].Email, "pass123")
+	reader, _ := server.SignIn(users[1].Email, "pass123")
+
+	comments := []model.Comment{
+		{Content: "Typo teh", Status: model.CommentApproved, PostID: posts[0].ID, AuthorID: users[1].ID},
+		{Content: "Rude", Status: model.CommentApproved, PostID: posts[0].ID, AuthorID: users[1].ID},
+		{Content: "Thanks for reading", Status: model.CommentApproved, PostID: posts[0].ID, AuthorID: users[0].ID},
+	}
+	for i := range comments {
+		if _, err = server.Comments.CreateComment(&comments[i]); err != nil {
+			log.Fatalf("Could not seed comments, Error: %v \n", err)
+		}
+	}
+
+	// The post's author can't reword someone else's comment
+	rr, responseMap := commentRequest(server.UpdateComment, "PUT", author.AccessToken, posts[0].ID, comments[0].ID, "", `{"content": "Edited"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr, responseMap = commentRequest(server.UpdateComment, "PUT", reader.AccessToken, posts[0].ID, comments[0].ID, "", `{"content": "Typo the"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Typo the", responseMap["content"])
+	// Changed content is moderated again, except when the post's author edits
+	assert.Equal(t, model.CommentPending, responseMap["status"])
+	rr, responseMap = commentRequest(server.UpdateComment, "PUT", author.AccessToken, posts[0].ID, comments[2].ID, "", `{"content": "Thanks for reading!"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, model.CommentApproved, responseMap["status"])
+
+	defer func(window time.Duration) { server.CommentEditWindow = window }(server.CommentEditWindow)
+	server.CommentEditWindow = 0
+	rr, responseMap = commentRequest(server.UpdateComment, "PUT", reader.AccessToken, posts[0].ID, comments[0].ID, "", `{"content": "Too late"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, model.ErrEditWindowClosed.Error(), responseMap["detail"])
+
+	// The commenter and the post's author may delete, nobody else
+	other := model.User{Username: "bystander", Email: "by@stander.com", Password: "pass123"}
+	if _, err = server.Users.CreateUser(&other); err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	bystander, _ := server.SignIn(other.Email, "pass123")
+	rr, _ = commentRequest(server.DeleteComment, "DELETE", bystander.AccessToken, posts[0].ID, comments[0].ID, "", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = commentRequest(server.DeleteComment, "DELETE", reader.AccessToken, posts[0].ID, comments[0].ID, "", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, rr.Body.String())
+	rr, _ = commentRequest(server.DeleteComment, "DELETE", author.AccessToken, posts[0].ID, comments[1].ID, "", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr, _ = commentRequest(server.DeleteComment, "DELETE", author.AccessToken, posts[0].ID, comments[1].ID, "", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func uintPtr(n uint) *uint {
+	return &n
+}
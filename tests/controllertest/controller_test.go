@@ -1,51 +1,54 @@
 package controllertest
 
 import (
-	"fmt"
 	"log"
 	"os"
 	"testing"
 
+	"github.com/aaronprice00/goblog-mvc/api/config"
 	"github.com/aaronprice00/goblog-mvc/api/controller"
+	"github.com/aaronprice00/goblog-mvc/api/mailer"
 	"github.com/aaronprice00/goblog-mvc/api/model"
-	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
 )
 
 var server = controller.Server{}
 var userInstance = model.User{}
 var postInstance = model.Post{}
 
+// mail keeps what the server mails so tests can read the tokens
+var mail *mailer.Recorder
+
+// TestMain runs the controllers against the in-memory repositories, no database needed
 func TestMain(m *testing.M) {
-	if err := godotenv.Load(os.ExpandEnv("../../.env")); err != nil {
-		log.Fatalf("Could not get .env data %v \n", err)
+	cfg, _, err := config.Load(config.Options{EnvFile: "../../.env"})
+	if err != nil {
+		log.Printf("Bad config, using defaults %v \n", err)
+		cfg = config.Defaults()
+	}
+	if cfg.APISecret == "" {
+		cfg.APISecret = "controllertest"
+	}
+	// Mail is kept in memory by Database, its links under PublicURL
+	cfg.Mail = mailer.Config{Driver: mailer.Log}
+	cfg.PublicURL = "http://blog.test"
+	if err = server.Configure(cfg); err != nil {
+		log.Fatalf("Could not configure server %v \n", err)
 	}
 	Database()
 
 	os.Exit(m.Run())
 }
 
+// Database starts every test on empty repositories and an empty mailbox
 func Database() {
-	var err error
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("T_DB_HOST"), os.Getenv("T_DB_PORT"), os.Getenv("T_DB_USER"), os.Getenv("T_DB_NAME"), os.Getenv("T_DB_PASSWORD"))
-	server.DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		fmt.Println("Could not connect to database")
-		log.Fatalf("Error: %v \n", err)
-	} else {
-		fmt.Println("Db connected :)")
-	}
+	server.UseRepositories(repository.NewMemoryRepositories())
+	mail = &mailer.Recorder{}
+	server.Mailer = mail
 }
 
 func refreshUserTable() error {
-	var err error
-	if err = server.DB.Migrator().DropTable(&model.User{}); err != nil {
-		return err
-	}
-	if err = server.DB.AutoMigrate(&model.User{}); err != nil {
-		return err
-	}
+	Database()
 	log.Printf("Refreshed User table successfully")
 	return nil
 }
@@ -62,7 +65,7 @@ func seedOneUser() (model.User, error) {
 		Password: "pass123",
 	}
 
-	if err = server.DB.Model(&model.User{}).Create(&user).Error; err != nil {
+	if _, err = server.Users.CreateUser(&user); err != nil {
 		return model.User{}, err
 	}
 	return user, nil
@@ -81,21 +84,16 @@ func seedUsers() ([]model.User, error) {
 			Password: "pass123",
 		},
 	}
-	var err error
-	if err = server.DB.Create(&users).Error; err != nil {
-		return []model.User{}, err
+	for i := range users {
+		if _, err := server.Users.CreateUser(&users[i]); err != nil {
+			return []model.User{}, err
+		}
 	}
 	return users, nil
 }
 
 func refreshUserAndPostTable() error {
-	var err error
-	if err = server.DB.Migrator().DropTable(&model.User{}, &model.Post{}); err != nil {
-		return err
-	}
-	if err = server.DB.AutoMigrate(&model.User{}, &model.Post{}); err != nil {
-		return err
-	}
+	Database()
 	log.Printf("Refreshed tables successfully")
 	return nil
 }
@@ -110,15 +108,16 @@ func seedOneUserAndOnePost() (model.Post, error) {
 		Email:    "jaques@cousteau.com",
 		Password: "pass123",
 	}
-	if err = server.DB.Create(&user).Error; err != nil {
+	if _, err = server.Users.CreateUser(&user); err != nil {
 		return model.Post{}, err
 	}
 	post := model.Post{
 		Title:    "Under the sea",
 		Content:  "Life is better, down where it's wetter",
+		Status:   model.PostPublished,
 		AuthorID: user.ID,
 	}
-	if err = server.DB.Create(&post).Error; err != nil {
+	if _, err = server.Posts.CreatePost(&post); err != nil {
 		return model.Post{}, err
 	}
 	return post, nil
@@ -142,21 +141,23 @@ func seedUsersAndPosts() ([]model.User, []model.Post, error) {
 		{
 			Title:   "We got no troubles",
 			Content: "Life is the bubbles",
+			Status:  model.PostPublished,
 		},
 		{
 			Title:   "Compartment Schompartment",
 			Content: "why not 16?",
+			Status:  model.PostPublished,
 		},
 	}
 
 	for i := range users {
 		var err error
-		if err = server.DB.Create(&users[i]).Error; err != nil {
+		if _, err = server.Users.CreateUser(&users[i]); err != nil {
 			log.Fatalf("Could not seed users table: %v \n", err)
 		}
 		posts[i].AuthorID = users[i].ID
 
-		if err = server.DB.Create(&posts[i]).Error; err != nil {
+		if _, err = server.Posts.CreatePost(&posts[i]); err != nil {
 			log.Fatalf("Could not seed posts table: %v \n", err)
 		}
 	}
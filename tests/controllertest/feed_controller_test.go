@@ -0,0 +1,193 @@
+package controllertest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// feedRequest serves a feed route with the URL variables filled in, token may be empty
+func feedRequest(handler http.HandlerFunc, path string, vars map[string]string, token string, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Host = "blog.test"
+	req = mux.SetURLVars(req, vars)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetFeed(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	posts := []model.Post{
+		{Title: "Fish & Chips", Content: "Crispy *fish*", Status: model.PostPublished, AuthorID: users[0].ID, Tags: []model.Tag{{Name: "food"}}},
+		{Title: "Unfinished", Content: "Draft", AuthorID: users[0].ID},
+		{Title: "Birds", Content: "Nothing to see", Status: model.PostPublished, AuthorID: users[1].ID},
+	}
+	// Created last but published before the others, feeds go by when posts went out
+	published := time.Now().Add(-time.Hour)
+	posts = append(posts, model.Post{Title: "Old news", Content: "Imported", Status: model.PostPublished, PublishedAt: &published, AuthorID: users[1].ID})
+	for i := range posts {
+		posts[i].Prepare()
+		if _, err = server.Posts.CreatePost(&posts[i]); err != nil {
+			log.Fatalf("Could not seed posts, Error: %v \n", err)
+		}
+	}
+	author, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+	uid := strconv.Itoa(int(users[0].ID))
+
+	samples := []struct {
+		testID      int
+		handler     http.HandlerFunc
+		vars        map[string]string
+		token       string
+		statusCode  int
+		contentType string
+		titles      []string
+	}{
+		// The draft stays out of the feed even for its author
+		{testID: 1, handler: server.GetFeed, vars: map[string]string{"format": "rss"}, token: author.AccessToken, statusCode: 200, contentType: "application/rss+xml; charset=utf-8", titles: []string{"Birds", "Fish & Chips", "Old news"}},
+		{testID: 2, handler: server.GetFeed, vars: map[string]string{"format": "atom"}, statusCode: 200, contentType: "application/atom+xml; charset=utf-8", titles: []string{"Birds", "Fish & Chips", "Old news"}},
+		{testID: 3, handler: server.GetFeed, vars: map[string]string{"format": "json"}, statusCode: 200, contentType: "application/feed+json; charset=utf-8", titles: []string{"Birds", "Fish & Chips", "Old news"}},
+		{testID: 4, handler: server.GetUserFeed, vars: map[string]string{"id": uid, "format": "json"}, statusCode: 200, contentType: "application/feed+json; charset=utf-8", titles: []string{"Fish & Chips"}},
+		{testID: 5, handler: server.GetTagFeed, vars: map[string]string{"slug": "food", "format": "rss"}, statusCode: 200, contentType: "application/rss+xml; charset=utf-8", titles: []string{"Fish & Chips"}},
+		{testID: 6, handler: server.GetUserFeed, vars: map[string]string{"id": "99", "format": "rss"}, statusCode: 404, contentType: response.ProblemContentType},
+		{testID: 7, handler: server.GetUserFeed, vars: map[string]string{"id": "unknown", "format": "rss"}, statusCode: 400, contentType: response.ProblemContentType},
+		{testID: 8, handler: server.GetTagFeed, vars: map[string]string{"slug": "snorkeling", "format": "atom"}, statusCode: 404, contentType: response.ProblemContentType},
+		{testID: 9, handler: server.GetFeed, vars: map[string]string{"format": "html"}, statusCode: 404, contentType: response.ProblemContentType},
+	}
+
+	for _, v := range samples {
+		rr := feedRequest(v.handler, "/feed."+v.vars["format"], v.vars, v.token, nil)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		assert.Equal(t, v.contentType, rr.Header().Get("Content-Type"))
+		if v.statusCode == 200 {
+			titles := []string{}
+			switch v.vars["format"] {
+			case "rss":
+				var doc struct {
+					Items []struct {
+						Title string `xml:"title"`
+						Link  string `xml:"link"`
+					} `xml:"channel>item"`
+				}
+				if err = xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+					t.Errorf("Cannot convert from xml: %v\n", err)
+				}
+				for _, item := range doc.Items {
+					titles = append(titles, item.Title)
+				}
+				if v.testID == 1 {
+					assert.Equal(t, "http://blog.test/posts/by-slug/birds", doc.Items[0].Link)
+				}
+			case "atom":
+				var doc struct {
+					Entries []struct {
+						Title string `xml:"title"`
+					} `xml:"entry"`
+				}
+				if err = xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+					t.Errorf("Cannot convert from xml: %v\n", err)
+				}
+				for _, entry := range doc.Entries {
+					titles = append(titles, entry.Title)
+				}
+			case "json":
+				var doc struct {
+					Items []struct {
+						Title       string `json:"title"`
+						ContentHTML string `json:"content_html"`
+					} `json:"items"`
+				}
+				if err = json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+					t.Errorf("Cannot convert to json: %v\n", err)
+				}
+				for _, item := range doc.Items {
+					titles = append(titles, item.Title)
+				}
+				if v.testID == 4 {
+					assert.Equal(t, "<p>Crispy <em>fish</em></p>\n", doc.Items[0].ContentHTML)
+				}
+			}
+			assert.Equal(t, v.titles, titles)
+			assert.NotEmpty(t, rr.Header().Get("ETag"))
+			assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestGetFeedConditional(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	post, err := seedOneUserAndOnePost()
+	if err != nil {
+		log.Fatalf("Could not seed user and post, Error: %v \n", err)
+	}
+	vars := map[string]string{"format": "atom"}
+	first := feedRequest(server.GetFeed, "/feed.atom", vars, "", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	modified := first.Header().Get("Last-Modified")
+
+	samples := []struct {
+		testID     int
+		headers    map[string]string
+		statusCode int
+	}{
+		{testID: 1, headers: map[string]string{"If-None-Match": etag}, statusCode: 304},
+		{testID: 2, headers: map[string]string{"If-None-Match": `"stale", ` + etag}, statusCode: 304},
+		{testID: 3, headers: map[string]string{"If-None-Match": `"stale"`}, statusCode: 200},
+		{testID: 4, headers: map[string]string{"If-Modified-Since": modified}, statusCode: 304},
+		{testID: 5, headers: map[string]string{"If-Modified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, statusCode: 200},
+		// If-None-Match decides when both are sent
+		{testID: 6, headers: map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": modified}, statusCode: 200},
+	}
+
+	for _, v := range samples {
+		rr := feedRequest(server.GetFeed, "/feed.atom", vars, "", v.headers)
+		assert.Equal(t, v.statusCode, rr.Code)
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		if v.statusCode == 304 {
+			assert.Empty(t, rr.Body.String())
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// Deleting the post changes the feed, the old ETag no longer matches
+	if _, err = server.Posts.DeletePost(post.ID); err != nil {
+		log.Fatalf("Could not delete post, Error: %v \n", err)
+	}
+	rr := feedRequest(server.GetFeed, "/feed.atom", vars, "", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+}
@@ -0,0 +1,98 @@
+package controllertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/controller"
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+// healthRequest calls a health handler and decodes its body
+func healthRequest(handler http.HandlerFunc) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+func TestHealthz(t *testing.T) {
+	rr, responseMap := healthRequest(server.Healthz)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", responseMap["status"])
+
+	// The in-memory repositories need no database to be ready
+	rr, _ = healthRequest(server.Readyz)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	db, err := database.Open(database.SQLite, "", "", "", "", ":memory:")
+	if err != nil {
+		log.Fatalf("Could not open database, Error: %v \n", err)
+	}
+	s := controller.Server{DB: db}
+
+	samples := []struct {
+		testID     int
+		prepare    func()
+		statusCode int
+		failed     string
+	}{
+		{testID: 1, prepare: func() {}, statusCode: 503, failed: "migrations"},
+		{testID: 2, prepare: func() {
+			if _, err := migrate.Up(db); err != nil {
+				log.Fatalf("Could not migrate, Error: %v \n", err)
+			}
+		}, statusCode: 200},
+		{testID: 3, prepare: func() { database.Close(db) }, statusCode: 503, failed: "database"},
+	}
+	for _, v := range samples {
+		v.prepare()
+		rr, responseMap := healthRequest(s.Readyz)
+		assert.Equal(t, v.statusCode, rr.Code)
+		checks, _ := responseMap["checks"].(map[string]interface{})
+		if v.failed != "" {
+			assert.Equal(t, "unavailable", responseMap["status"])
+			assert.NotEqual(t, "ok", checks[v.failed])
+		} else {
+			assert.Equal(t, "ok", checks["database"])
+			assert.Equal(t, "ok", checks["migrations"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestLoginMetrics(t *testing.T) {
+	if err := refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	failed := metrics.LoginsFailed.Value(metrics.InvalidCredentials)
+	succeeded := metrics.LoginsSucceeded.Value()
+
+	server.SignIn(users[0].Email, "wrong")
+	server.SignIn("nobody@example.com", "pass123")
+	if _, err = server.SignIn(users[0].Email, "pass123"); err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+	assert.Equal(t, failed+2, metrics.LoginsFailed.Value(metrics.InvalidCredentials))
+	assert.Equal(t, succeeded+1, metrics.LoginsSucceeded.Value())
+
+	rr, _ := healthRequest(server.GetMetrics)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, strings.Contains(rr.Body.String(), `goblog_logins_failed_total{reason="invalid_credentials"}`))
+}
@@ -0,0 +1,136 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// loginFrom posts the credentials to Login from the client address
+func loginFrom(ip, email, password string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	body := fmt.Sprintf(`{"email": %q, "password": %q}`, email, password)
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	if err != nil {
+		log.Fatalf("Error: %v \n", err)
+	}
+	req.RemoteAddr = ip + ":41000"
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.Login).ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+func TestLoginBackoff(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		email        string
+		password     string
+		statusCode   int
+		errorMessage string
+	}{
+		// Unknown emails and wrong passwords look the same and both count
+		{testID: 1, email: user.Email, password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		{testID: 2, email: user.Email, password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		{testID: 3, email: user.Email, password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		// Past the free attempts even the right password has to wait
+		{testID: 4, email: user.Email, password: "pass123", statusCode: 429, errorMessage: "Too Many Login Attempts"},
+		{testID: 5, email: "nobody@gmail.com", password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		{testID: 6, email: "nobody@gmail.com", password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		{testID: 7, email: "nobody@gmail.com", password: "wrong", statusCode: 401, errorMessage: "Invalid Credentials"},
+		{testID: 8, email: "nobody@gmail.com", password: "wrong", statusCode: 429, errorMessage: "Too Many Login Attempts"},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := loginFrom("192.0.2.1", v.email, v.password)
+		assert.Equal(t, v.statusCode, rr.Code)
+		assert.Equal(t, v.errorMessage, responseMap["detail"])
+		if v.statusCode == 429 {
+			assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// Both emails were guessed from the same address, it is still under its limit
+	attempt, err := server.Attempts.ReadLoginAttempt("ip:192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, attempt.Failures)
+}
+
+func TestLoginLockout(t *testing.T) {
+	if err := refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	if _, err = server.Users.UpdateRole(users[1].ID, "admin"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	admin, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+
+	// No backoff, so the lockout is reached right away
+	defer func(throttle auth.Throttle) { auth.EmailThrottle = throttle }(auth.EmailThrottle)
+	auth.EmailThrottle = auth.Throttle{Free: 3, Lockout: 5, LockFor: 15 * time.Minute, Window: time.Hour}
+	email := users[0].Email
+
+	// Signing in forgets the failures so far
+	for i := 0; i < 4; i++ {
+		rr, _ := loginFrom("192.0.2.7", email, "wrong")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	rr, _ := loginFrom("192.0.2.7", email, "pass123")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	_, err = server.Attempts.ReadLoginAttempt("email:" + email)
+	assert.Error(t, err)
+
+	for i := 0; i < 5; i++ {
+		rr, _ = loginFrom("192.0.2.7", email, "wrong")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	rr, responseMap := loginFrom("192.0.2.7", email, "pass123")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "Too Many Login Attempts", responseMap["detail"])
+	retry, _ := strconv.Atoi(rr.Header().Get("Retry-After"))
+	assert.InDelta(t, 15*60, retry, 2)
+
+	// Admins can review the lockout
+	req, _ := http.NewRequest("GET", "/lockouts?email="+email, nil)
+	req.Header.Set("Authorization", "Bearer "+admin.AccessToken)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.GetLockouts).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page := struct {
+		Data []model.Lockout `json:"data"`
+	}{}
+	if err = json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Errorf("Could not convert to JSON, Error: %v \n", err)
+	}
+	if assert.Len(t, page.Data, 1) {
+		assert.Equal(t, "email:"+email, page.Data[0].Subject)
+		assert.Equal(t, "192.0.2.7", page.Data[0].IP)
+		assert.Equal(t, 5, page.Data[0].Failures)
+		assert.True(t, page.Data[0].LockedUntil.After(time.Now().Add(14*time.Minute)))
+	}
+	assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+}
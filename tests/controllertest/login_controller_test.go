@@ -3,7 +3,6 @@ package controllertest
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -39,22 +38,23 @@ func TestSignIn(t *testing.T) {
 			testID:       2,
 			email:        user.Email,
 			password:     "wrong password",
-			errorMessage: "crypto/bcrypt: hashedPassword is not the hash of the given password",
+			errorMessage: "Invalid Credentials",
 		},
 		{
 			testID:       3,
 			email:        "Wrong email",
 			password:     "password",
-			errorMessage: "record not found",
+			errorMessage: "Invalid Credentials",
 		},
 	}
 
 	for _, v := range samples {
 		token, err := server.SignIn(v.email, v.password)
 		if err != nil {
-			assert.Equal(t, errors.New(v.errorMessage), err)
+			assert.EqualError(t, err, v.errorMessage)
 		} else {
-			assert.NotEqual(t, "", token)
+			assert.NotEqual(t, "", token.AccessToken)
+			assert.NotEqual(t, "", token.RefreshToken)
 		}
 		fmt.Printf("%v Finished \n", v.testID)
 	}
@@ -85,15 +85,15 @@ func TestLogin(t *testing.T) {
 		},
 		{
 			testID:       2,
-			inputJSON:    `{"email": "jcousteau@gmail.com", "password": "wrong password"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Details",
+			inputJSON:    `{"email": "willy@wonkamail.com", "password": "wrong password"}`,
+			statusCode:   401,
+			errorMessage: "Invalid Credentials",
 		},
 		{
 			testID:       3,
 			inputJSON:    `{"email": "banana@gmail.com", "password": "pass123"}`,
-			statusCode:   422,
-			errorMessage: "Incorrect Details",
+			statusCode:   401,
+			errorMessage: "Invalid Credentials",
 		},
 		{
 			testID:       4,
@@ -131,12 +131,12 @@ func TestLogin(t *testing.T) {
 		}
 
 		// Login not successful, process response
-		if v.statusCode == 422 && v.errorMessage != "" {
+		if v.statusCode != 200 && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
 				t.Errorf("Could not convert to JSON, Error: %v \n", err)
 			}
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished \n", v.testID)
 	}
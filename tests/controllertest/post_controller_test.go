@@ -8,8 +8,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aaronprice00/goblog-mvc/api/dto"
 	"github.com/aaronprice00/goblog-mvc/api/model"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -28,13 +31,14 @@ func TestCreatePost(t *testing.T) {
 	if err != nil {
 		log.Fatalf("Could not login, Error: %v \n", err)
 	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
 
 	samples := []struct {
 		testID       int
 		inputJSON    string
 		statusCode   int
 		title        string
+		slug         string
 		content      string
 		authorID     uint
 		tokenGiven   string
@@ -43,45 +47,71 @@ func TestCreatePost(t *testing.T) {
 		{
 			// sucessful
 			testID:       1,
-			inputJSON:    `{"title": "Title 1", "content": "Content 1", "authorID": 1}`,
+			inputJSON:    `{"title": "Title 1", "content": "Content 1", "author_id": 1}`,
 			statusCode:   201,
 			title:        "Title 1",
+			slug:         "title-1",
 			content:      "Content 1",
 			authorID:     user.ID,
 			tokenGiven:   tokenString,
 			errorMessage: "",
 		},
 		{
+			// duplicate title, the slug gets a suffix
 			testID:       2,
-			inputJSON:    `{"title": "Title 1", "content": "Content 2", "authorID": 1}`,
-			statusCode:   500,
+			inputJSON:    `{"title": "Title 1", "content": "Content 2", "author_id": 1}`,
+			statusCode:   201,
+			title:        "Title 1",
+			slug:         "title-1-2",
+			content:      "Content 2",
+			authorID:     user.ID,
 			tokenGiven:   tokenString,
-			errorMessage: "Title Already Used",
+			errorMessage: "",
+		},
+		{
+			// requested slug is normalised
+			testID:       9,
+			inputJSON:    `{"title": "Title 3", "slug": "Über Title!", "content": "Content 3", "author_id": 1}`,
+			statusCode:   201,
+			title:        "Title 3",
+			slug:         "uber-title",
+			content:      "Content 3",
+			authorID:     user.ID,
+			tokenGiven:   tokenString,
+			errorMessage: "",
+		},
+		{
+			// requested slug belongs to another post
+			testID:       10,
+			inputJSON:    `{"title": "Title 4", "slug": "title-1", "content": "Content 4", "author_id": 1}`,
+			statusCode:   409,
+			tokenGiven:   tokenString,
+			errorMessage: "Slug Already Used",
 		},
 		{
 			testID:       3,
-			inputJSON:    `{"title": "Title 2", "content": "Content 2", "authorID": 1}`,
+			inputJSON:    `{"title": "Title 2", "content": "Content 2", "author_id": 1}`,
 			statusCode:   401,
 			tokenGiven:   "", // Blank Token
 			errorMessage: "Unauthorized",
 		},
 		{
 			testID:       4,
-			inputJSON:    `{"title": "Title 2", "content": "Content 2", "authorID": 1}`,
+			inputJSON:    `{"title": "Title 2", "content": "Content 2", "author_id": 1}`,
 			statusCode:   401,
 			tokenGiven:   "badtoken", // Bad Token
 			errorMessage: "Unauthorized",
 		},
 		{
 			testID:       5,
-			inputJSON:    `{"title": "", "content": "Content 2", "authorID": 1}`,
+			inputJSON:    `{"title": "", "content": "Content 2", "author_id": 1}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required: Title",
 		},
 		{
 			testID:       6,
-			inputJSON:    `{"title": "Title 2", "content": "", "authorID": 1}`,
+			inputJSON:    `{"title": "Title 2", "content": "", "author_id": 1}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required: Content",
@@ -96,10 +126,18 @@ func TestCreatePost(t *testing.T) {
 		{
 			// When user 2 uses user 1 token
 			testID:       8,
-			inputJSON:    `{"title": "Title 2", "content": "Content 2", "authorID": 2}`,
-			statusCode:   401,
+			inputJSON:    `{"title": "Title 2", "content": "Content 2", "author_id": 2}`,
+			statusCode:   403,
 			tokenGiven:   tokenString,
-			errorMessage: "Unauthorized",
+			errorMessage: "Forbidden",
+		},
+		{
+			// Every mistake is answered at once
+			testID:       9,
+			inputJSON:    `{"title": "` + strings.Repeat("T", 101) + `", "content": "", "status": "gone"}`,
+			statusCode:   422,
+			tokenGiven:   tokenString,
+			errorMessage: "Title Too Long: max 100 characters; Required: Content; Required: Author; Invalid Status",
 		},
 	}
 
@@ -121,11 +159,12 @@ func TestCreatePost(t *testing.T) {
 		assert.Equal(t, v.statusCode, rr.Code)
 		if v.statusCode == 201 {
 			assert.Equal(t, v.title, responseMap["title"])
+			assert.Equal(t, v.slug, responseMap["slug"])
 			assert.Equal(t, v.content, responseMap["content"])
-			assert.Equal(t, float64(v.authorID), responseMap["authorID"])
+			assert.Equal(t, float64(v.authorID), responseMap["author_id"])
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 409 {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -149,13 +188,22 @@ func TestGetPosts(t *testing.T) {
 	handler := http.HandlerFunc(server.GetPosts)
 	handler.ServeHTTP(rr, req)
 
-	var postsReceived []model.Post
+	var postsReceived struct {
+		Data []dto.Post `json:"data"`
+		Next string     `json:"next"`
+	}
 	if err = json.Unmarshal([]byte(rr.Body.String()), &postsReceived); err != nil {
 		t.Errorf("Could not convert to json, Error: %v \n", err)
 	}
 
 	assert.Equal(t, http.StatusOK, rr.Code)
-	assert.Equal(t, len(posts), len(postsReceived))
+	assert.Equal(t, len(posts), len(postsReceived.Data))
+	assert.Equal(t, strconv.Itoa(len(posts)), rr.Header().Get("X-Total-Count"))
+	assert.Equal(t, "", postsReceived.Next)
+	// Authors are shown in the public view, without their email or password
+	assert.NotContains(t, rr.Body.String(), "password")
+	assert.NotContains(t, rr.Body.String(), "@")
+	assert.NotEmpty(t, postsReceived.Data[0].Author.Username)
 }
 
 func TestGetPostByID(t *testing.T) {
@@ -175,6 +223,7 @@ func TestGetPostByID(t *testing.T) {
 		title      string
 		content    string
 		authorID   uint
+		code       string
 	}{
 		{
 			testID:     1,
@@ -188,6 +237,13 @@ func TestGetPostByID(t *testing.T) {
 			testID:     2,
 			id:         "unknown",
 			statusCode: 400,
+			code:       "invalid_id",
+		},
+		{
+			testID:     3,
+			id:         "999",
+			statusCode: 404,
+			code:       "not_found",
 		},
 	}
 
@@ -210,7 +266,67 @@ func TestGetPostByID(t *testing.T) {
 		if v.statusCode == 200 {
 			assert.Equal(t, v.title, responseMap["title"])
 			assert.Equal(t, v.content, responseMap["content"])
-			assert.Equal(t, float64(v.authorID), responseMap["authorID"])
+			assert.Equal(t, float64(v.authorID), responseMap["author_id"])
+		} else {
+			assert.Equal(t, v.code, responseMap["code"])
+			assert.Equal(t, float64(v.statusCode), responseMap["status"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestGetPostBySlug(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	post, err := seedOneUserAndOnePost()
+	if err != nil {
+		log.Fatalf("Could not seed user and post, Error: %v \n", err)
+	}
+	oldSlug := post.Slug
+
+	// Renaming the post replaces its slug, the old one becomes a redirect
+	post.Title = "Over the sea"
+	post.Slug = ""
+	renamed, err := server.Posts.UpdatePost(&post)
+	if err != nil {
+		log.Fatalf("Could not rename post, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID     int
+		slug       string
+		statusCode int
+		location   string
+	}{
+		{testID: 1, slug: renamed.Slug, statusCode: 200},
+		{testID: 2, slug: oldSlug, statusCode: 301, location: "/posts/by-slug/" + renamed.Slug},
+		{testID: 3, slug: "unknown", statusCode: 404},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/posts/by-slug", nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"slug": v.slug})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.GetPostBySlug)
+		handler.ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, v.statusCode, rr.Code)
+		assert.Equal(t, v.location, rr.Header().Get("Location"))
+		if v.statusCode == 200 || v.statusCode == 301 {
+			assert.Equal(t, renamed.Slug, responseMap["slug"])
+			assert.Equal(t, renamed.Title, responseMap["title"])
+		}
+		if v.statusCode == 404 {
+			assert.Equal(t, "Post Not Found", responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -242,7 +358,7 @@ func TestUpdatePost(t *testing.T) {
 	if err != nil {
 		t.Errorf("Could not login the user, Error: %v \n", err)
 	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
 
 	// grab first only the first post, wouldn't something like posts[1].Email work?
 	for _, p := range posts {
@@ -268,7 +384,7 @@ func TestUpdatePost(t *testing.T) {
 			// successful update
 			testID:       1,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Title 1", "content": "Content 1", "authorID": 1}`,
+			updateJSON:   `{"title": "Title 1", "content": "Content 1", "author_id": 1}`,
 			statusCode:   200,
 			title:        "Title 1",
 			content:      "Content 1",
@@ -280,7 +396,7 @@ func TestUpdatePost(t *testing.T) {
 			// blank token
 			testID:       2,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Title 2", "content": "Content 2", "authorID": 1}`,
+			updateJSON:   `{"title": "Title 2", "content": "Content 2", "author_id": 1}`,
 			statusCode:   401,
 			tokenGiven:   "",
 			errorMessage: "Unauthorized",
@@ -289,7 +405,7 @@ func TestUpdatePost(t *testing.T) {
 			// bad token
 			testID:       3,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Title 2", "content": "Content 2", "authorID": 1}`,
+			updateJSON:   `{"title": "Title 2", "content": "Content 2", "author_id": 1}`,
 			statusCode:   401,
 			tokenGiven:   "badtoken",
 			errorMessage: "Unauthorized",
@@ -298,25 +414,37 @@ func TestUpdatePost(t *testing.T) {
 			// no title
 			testID:       4,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "", "content": "Content 2", "authorID": 1}`,
+			updateJSON:   `{"title": "", "content": "Content 2", "author_id": 1}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required: Title",
 		},
 		{
-			// duplicate title, must be unique
+			// duplicate title is allowed, the slug gets a suffix
 			testID:       5,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Compartment Schompartment", "content": "Content 2", "authorID": 1}`,
-			statusCode:   500,
+			updateJSON:   `{"title": "Compartment Schompartment", "content": "Content 2", "author_id": 1}`,
+			statusCode:   200,
+			title:        "Compartment Schompartment",
+			content:      "Content 2",
+			authorID:     authPostAuthorID,
 			tokenGiven:   tokenString,
-			errorMessage: "Title Already Used",
+			errorMessage: "",
+		},
+		{
+			// requested slug belongs to another post
+			testID:       10,
+			id:           strconv.Itoa(int(authPostID)),
+			updateJSON:   `{"title": "Title 2", "slug": "compartment-schompartment", "content": "Content 2", "author_id": 1}`,
+			statusCode:   409,
+			tokenGiven:   tokenString,
+			errorMessage: "Slug Already Used",
 		},
 		{
 			// no content
 			testID:       6,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Title 2", "content": "", "authorID": 1}`,
+			updateJSON:   `{"title": "Title 2", "content": "", "author_id": 1}`,
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required: Content",
@@ -326,9 +454,9 @@ func TestUpdatePost(t *testing.T) {
 			testID:       7,
 			id:           strconv.Itoa(int(authPostID)),
 			updateJSON:   `{"title": "Title 2", "content": "Content 2"}`,
-			statusCode:   401,
+			statusCode:   403,
 			tokenGiven:   tokenString,
-			errorMessage: "Unauthorized",
+			errorMessage: "Forbidden",
 		},
 		{
 			// Bad request
@@ -340,10 +468,10 @@ func TestUpdatePost(t *testing.T) {
 			// user's tokenID mismatch authorID
 			testID:       9,
 			id:           strconv.Itoa(int(authPostID)),
-			updateJSON:   `{"title": "Title 2", "content": "Content 2", "authorID": 2}`,
-			statusCode:   401,
+			updateJSON:   `{"title": "Title 2", "content": "Content 2", "author_id": 2}`,
+			statusCode:   403,
 			tokenGiven:   tokenString,
-			errorMessage: "Unauthorized",
+			errorMessage: "Forbidden",
 		},
 	}
 
@@ -368,12 +496,12 @@ func TestUpdatePost(t *testing.T) {
 		if rr.Code == 200 {
 			assert.Equal(t, v.title, responseMap["title"])
 			assert.Equal(t, v.content, responseMap["content"])
-			assert.Equal(t, float64(v.authorID), responseMap["authorID"])
+			assert.Equal(t, float64(v.authorID), responseMap["author_id"])
 		}
 
 		// What about the 400 error? Do we need to check that one?
-		if rr.Code == 401 || rr.Code == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+		if rr.Code == 401 || rr.Code == 403 || rr.Code == 422 || v.statusCode == 409 {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -415,7 +543,7 @@ func TestDeletePost(t *testing.T) {
 	if err != nil {
 		t.Errorf("Could not login the user, Error: %v \n", err)
 	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
 
 	sample := []struct {
 		testID       int
@@ -485,13 +613,275 @@ func TestDeletePost(t *testing.T) {
 		handler.ServeHTTP(rr, req)
 
 		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 204 {
+			assert.Empty(t, rr.Body.String())
+		}
 
 		if v.statusCode == 401 && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
 				t.Errorf("Could not convert to Json %v \n", err)
 			}
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestUpdatePostAsEditor(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+
+	// users[1] moderates posts[0], which belongs to users[0]
+	if _, err = server.Users.UpdateRole(users[1].ID, "editor"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+
+	updateJSON := fmt.Sprintf(`{"title": "Moderated", "content": "Edited by an editor", "author_id": %d}`, posts[0].AuthorID)
+	req, err := http.NewRequest("PUT", "/posts", bytes.NewBufferString(updateJSON))
+	if err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(posts[0].ID))})
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token.AccessToken))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.UpdatePost).ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+		t.Errorf("Could not convert to JSON, Error: %v \n", err)
+	}
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Moderated", responseMap["title"])
+}
+
+func TestPostVisibility(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, _, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+
+	// Each user also has a draft
+	drafts := []model.Post{}
+	for _, u := range users {
+		draft := model.Post{Title: "Draft by " + u.Username, Content: "Not yet", AuthorID: u.ID}
+		if _, err = server.Posts.CreatePost(&draft); err != nil {
+			log.Fatalf("Could not seed draft, Error: %v \n", err)
+		}
+		drafts = append(drafts, draft)
+	}
+	if _, err = server.Users.UpdateRole(users[1].ID, "editor"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	author, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+	editor, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+
+	listSamples := []struct {
+		testID     int
+		query      string
+		tokenGiven string
+		statusCode int
+		total      string
+	}{
+		{testID: 1, query: "", tokenGiven: "", statusCode: 200, total: "2"},
+		{testID: 2, query: "", tokenGiven: author.AccessToken, statusCode: 200, total: "3"},
+		{testID: 3, query: "", tokenGiven: editor.AccessToken, statusCode: 200, total: "4"},
+		{testID: 4, query: "?status=draft", tokenGiven: author.AccessToken, statusCode: 200, total: "1"},
+		{testID: 5, query: "?status=draft", tokenGiven: "", statusCode: 200, total: "0"},
+		{testID: 6, query: "?status=unknown", tokenGiven: "", statusCode: 400},
+	}
+	for _, v := range listSamples {
+		req, err := http.NewRequest("GET", "/posts"+v.query, nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+v.tokenGiven)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetPosts).ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			assert.Equal(t, v.total, rr.Header().Get("X-Total-Count"))
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	readSamples := []struct {
+		testID     int
+		id         uint
+		tokenGiven string
+		statusCode int
+	}{
+		{testID: 7, id: drafts[0].ID, tokenGiven: "", statusCode: 404},
+		{testID: 8, id: drafts[0].ID, tokenGiven: author.AccessToken, statusCode: 200},
+		{testID: 9, id: drafts[1].ID, tokenGiven: author.AccessToken, statusCode: 404},
+		{testID: 10, id: drafts[0].ID, tokenGiven: editor.AccessToken, statusCode: 200},
+	}
+	for _, v := range readSamples {
+		req, err := http.NewRequest("GET", "/posts", nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(v.id))})
+		req.Header.Set("Authorization", "Bearer "+v.tokenGiven)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetPost).ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestPublishPost(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	samples := []struct {
+		testID       int
+		inputJSON    string
+		statusCode   int
+		status       string
+		published    bool
+		errorMessage string
+	}{
+		{testID: 1, inputJSON: `{"title": "A", "content": "C", "author_id": %d}`, statusCode: 201, status: "draft"},
+		{testID: 2, inputJSON: `{"title": "B", "content": "C", "status": "Published", "author_id": %d}`, statusCode: 201, status: "published", published: true},
+		{testID: 3, inputJSON: `{"title": "C", "content": "C", "status": "scheduled", "published_at": "` + future + `", "author_id": %d}`, statusCode: 201, status: "scheduled", published: true},
+		{testID: 4, inputJSON: `{"title": "D", "content": "C", "status": "scheduled", "published_at": "` + past + `", "author_id": %d}`, statusCode: 422, errorMessage: "Required: Future Published At"},
+		{testID: 5, inputJSON: `{"title": "E", "content": "C", "status": "scheduled", "author_id": %d}`, statusCode: 422, errorMessage: "Required: Future Published At"},
+		{testID: 6, inputJSON: `{"title": "F", "content": "C", "status": "live", "author_id": %d}`, statusCode: 422, errorMessage: "Invalid Status"},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("POST", "/posts", bytes.NewBufferString(fmt.Sprintf(v.inputJSON, user.ID)))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreatePost).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 201 {
+			assert.Equal(t, v.status, responseMap["status"])
+			assert.Equal(t, v.published, responseMap["published_at"] != nil)
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// The scheduler publishes the scheduled post once it is due
+	published, err := server.Posts.PublishDuePosts(time.Now().Add(2 * time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), published)
+}
+
+func TestCreatePostMarkdown(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+	defer func(max int) { server.MaxContentLength = max }(server.MaxContentLength)
+	server.MaxContentLength = 300
+
+	samples := []struct {
+		testID       int
+		content      string
+		statusCode   int
+		contentHTML  string
+		excerpt      string
+		errorMessage string
+	}{
+		{
+			testID:      1,
+			content:     "## Dive log\n\nDepth & *time* <script>alert(1)</script>",
+			statusCode:  201,
+			contentHTML: "<h2>Dive log</h2>\n<p>Depth &amp; <em>time</em> alert(1)</p>\n",
+			excerpt:     "Dive log Depth & time alert(1)",
+		},
+		{
+			// longer than the old 255 character column, within the configured maximum
+			testID:     2,
+			content:    strings.Repeat("a", 300),
+			statusCode: 201,
+		},
+		{
+			testID:       3,
+			content:      strings.Repeat("a", 301),
+			statusCode:   422,
+			errorMessage: "Content Too Long: max 300 characters",
+		},
+	}
+
+	for _, v := range samples {
+		body, _ := json.Marshal(map[string]interface{}{"title": "Log", "content": v.content, "author_id": user.ID})
+		req, err := http.NewRequest("POST", "/posts", bytes.NewBuffer(body))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreatePost).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 201 {
+			// Content is kept as the author wrote it
+			assert.Equal(t, v.content, responseMap["content"])
+			if v.contentHTML != "" {
+				assert.Equal(t, v.contentHTML, responseMap["content_html"])
+				assert.Equal(t, v.excerpt, responseMap["excerpt"])
+			}
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -0,0 +1,104 @@
+package controllertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPosts(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	posts := []model.Post{
+		{Title: "Diving with gophers", Content: "Gophers can't swim, we tried.", Status: model.PostPublished, AuthorID: users[0].ID},
+		{Title: "Tunnels", Content: "Every gopher digs **tunnels** <script>x</script>", Status: model.PostPublished, AuthorID: users[0].ID},
+		{Title: "Unfinished gophers", Content: "Draft", AuthorID: users[0].ID},
+		{Title: "Birds", Content: "Nothing to see", Status: model.PostPublished, AuthorID: users[1].ID},
+	}
+	for i := range posts {
+		posts[i].Prepare()
+		if _, err = server.Posts.CreatePost(&posts[i]); err != nil {
+			log.Fatalf("Could not seed posts, Error: %v \n", err)
+		}
+	}
+	author, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID     int
+		query      string
+		token      string
+		statusCode int
+		ids        []float64
+		total      string
+	}{
+		// The title match ranks first, the draft stays hidden
+		{testID: 1, query: "q=gopher", statusCode: 200, ids: []float64{1, 2}, total: "2"},
+		{testID: 2, query: "q=gopher", token: author.AccessToken, statusCode: 200, ids: []float64{1, 3, 2}, total: "3"},
+		{testID: 3, query: "q=gopher+tunnels", statusCode: 200, ids: []float64{2}, total: "1"},
+		{testID: 4, query: "q=gopher&limit=1", statusCode: 200, ids: []float64{1}, total: "2"},
+		{testID: 5, query: "q=penguins", statusCode: 200, ids: []float64{}, total: "0"},
+		{testID: 6, query: "q=the", statusCode: 400},
+		{testID: 7, query: "", statusCode: 400},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/search?"+v.query, nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		if v.token != "" {
+			req.Header.Set("Authorization", "Bearer "+v.token)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.SearchPosts).ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			var page struct {
+				Data []model.SearchResult `json:"data"`
+				Next string               `json:"next"`
+			}
+			if err = json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+				t.Errorf("Cannot convert to json: %v\n", err)
+			}
+			ids := []float64{}
+			for _, result := range page.Data {
+				ids = append(ids, float64(result.Post.ID))
+			}
+			assert.Equal(t, v.ids, ids)
+			assert.Equal(t, v.total, rr.Header().Get("X-Total-Count"))
+			if v.testID == 3 {
+				assert.Equal(t, "<mark>Tunnels</mark>", page.Data[0].TitleHighlight)
+				assert.Equal(t, "Every <mark>gopher</mark> digs <mark>tunnels</mark> x", page.Data[0].ContentHighlight)
+			}
+			if v.testID == 4 {
+				// The cursor picks up where the first page stopped
+				req, _ = http.NewRequest("GET", "/search?q=gopher&limit=1&after="+url.QueryEscape(page.Next), nil)
+				rr = httptest.NewRecorder()
+				http.HandlerFunc(server.SearchPosts).ServeHTTP(rr, req)
+				if err = json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+					t.Errorf("Cannot convert to json: %v\n", err)
+				}
+				assert.Equal(t, uint(2), page.Data[0].Post.ID)
+				assert.Equal(t, "", page.Next)
+			}
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package controllertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/config"
+	"github.com/aaronprice00/goblog-mvc/api/controller"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/aaronprice00/goblog-mvc/api/scheduler"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowServer serves a route that takes delay to answer, it signals started when a request comes in
+func slowServer(delay, shutdownTimeout time.Duration, started chan struct{}) (*controller.Server, *http.Server, string) {
+	s := &controller.Server{Router: mux.NewRouter(), HTTP: config.Defaults().HTTP}
+	s.HTTP.ShutdownTimeout = shutdownTimeout
+	s.Router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusOK)
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Could not listen, Error: %v \n", err)
+	}
+	srv := s.HTTPServer(ln.Addr().String())
+	go srv.Serve(ln)
+	return s, srv, "http://" + ln.Addr().String()
+}
+
+func TestHTTPServer(t *testing.T) {
+	s := controller.Server{Router: mux.NewRouter(), HTTP: config.Defaults().HTTP}
+	s.HTTP.MaxBodyBytes = 10
+	s.Router.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.Write(buf.Bytes())
+	})
+	srv := s.HTTPServer(":8080")
+	assert.Equal(t, s.HTTP.ReadTimeout, srv.ReadTimeout)
+	assert.Equal(t, s.HTTP.ReadHeaderTimeout, srv.ReadHeaderTimeout)
+	assert.Equal(t, s.HTTP.WriteTimeout, srv.WriteTimeout)
+	assert.Equal(t, s.HTTP.IdleTimeout, srv.IdleTimeout)
+	assert.Equal(t, s.HTTP.MaxHeaderBytes, srv.MaxHeaderBytes)
+	assert.Nil(t, srv.TLSConfig)
+
+	samples := []struct {
+		testID     int
+		body       string
+		chunked    bool
+		statusCode int
+	}{
+		{testID: 1, body: "0123456789", statusCode: 200},
+		{testID: 2, body: "0123456789x", statusCode: 413},
+		// Without a length up front the handler is stopped once it reads past the limit
+		{testID: 3, body: "0123456789x", chunked: true, statusCode: 422},
+	}
+	for _, v := range samples {
+		req, _ := http.NewRequest("POST", "/echo", strings.NewReader(v.body))
+		if v.chunked {
+			req.ContentLength = -1
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		assert.Equal(t, v.statusCode, rr.Code)
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	s.HTTP.TLSCertFile, s.HTTP.TLSKeyFile = "cert.pem", "key.pem"
+	assert.NotNil(t, s.HTTPServer(":8443").TLSConfig)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	started := make(chan struct{}, 1)
+	s, srv, url := slowServer(200*time.Millisecond, 5*time.Second, started)
+
+	code := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			code <- 0
+			return
+		}
+		res.Body.Close()
+		code <- res.StatusCode
+	}()
+	<-started
+
+	// The request in flight finishes, new ones are refused
+	assert.NoError(t, s.Shutdown(srv))
+	assert.Equal(t, http.StatusOK, <-code)
+	_, err := http.Get(url + "/slow")
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{}, 1)
+	s, srv, url := slowServer(2*time.Second, 50*time.Millisecond, started)
+
+	go http.Get(url + "/slow")
+	<-started
+
+	begin := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(srv))
+	assert.True(t, time.Since(begin) < time.Second)
+}
+
+// slowPublisher takes delay to publish, it counts the ticks that finished
+type slowPublisher struct {
+	delay    time.Duration
+	finished int32
+}
+
+func (p *slowPublisher) PublishDuePosts(now time.Time) (int64, error) {
+	time.Sleep(p.delay)
+	atomic.AddInt32(&p.finished, 1)
+	return 0, nil
+}
+
+func TestShutdownStopsScheduler(t *testing.T) {
+	posts := &slowPublisher{delay: 100 * time.Millisecond}
+	s := &controller.Server{Router: mux.NewRouter(), HTTP: config.Defaults().HTTP}
+	s.Scheduler = scheduler.New(posts, time.Hour)
+
+	// Run fails to listen and returns only once the tick in progress has finished
+	assert.Error(t, s.Run("127.0.0.1:-1"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&posts.finished))
+}
+
+func TestInitializeInMemory(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.DB = config.Database{Driver: "sqlite", Name: ":memory:"}
+	cfg.APISecret = "secret"
+	cfg.PublicURL = "http://blog.test"
+
+	// Nothing but this process can migrate an in-memory database, so starting up does
+	s := &controller.Server{}
+	s.Initialize(cfg)
+	defer s.Shutdown(s.HTTPServer(":0"))
+	assert.NoError(t, migrate.Current(s.DB))
+	assert.NotNil(t, s.Router)
+}
@@ -0,0 +1,231 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatePostWithTags(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	// users[0] writes, users[1] edits and may add categories
+	if _, err = server.Users.UpdateRole(users[1].ID, "editor"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		user         int
+		inputJSON    string
+		statusCode   int
+		tags         []string
+		categories   []string
+		errorMessage string
+	}{
+		{
+			// repeats of the same slug collapse onto the first spelling
+			testID:     1,
+			user:       0,
+			inputJSON:  `{"title": "Gophers", "content": "dig", "status": "published", "tags": ["Go", " go ", "Web  Dev"]}`,
+			statusCode: 201,
+			tags:       []string{"go", "web-dev"},
+			categories: []string{},
+		},
+		{
+			testID:       2,
+			user:         0,
+			inputJSON:    `{"title": "Breaking", "content": "news", "status": "published", "categories": ["News"]}`,
+			statusCode:   422,
+			errorMessage: "Unknown Category: News",
+		},
+		{
+			testID:     3,
+			user:       1,
+			inputJSON:  `{"title": "Breaking", "content": "news", "status": "published", "tags": ["go"], "categories": ["News"]}`,
+			statusCode: 201,
+			tags:       []string{"go"},
+			categories: []string{"news"},
+		},
+		{
+			// once an editor added it, authors may file under it
+			testID:     4,
+			user:       0,
+			inputJSON:  `{"title": "Unfinished", "content": "draft", "tags": ["go"], "categories": ["news"]}`,
+			statusCode: 201,
+			tags:       []string{"go"},
+			categories: []string{"news"},
+		},
+		{
+			testID:       5,
+			user:         0,
+			inputJSON:    `{"title": "Symbols", "content": "only", "tags": ["!!!"]}`,
+			statusCode:   422,
+			errorMessage: "Invalid Tag: !!!",
+		},
+	}
+
+	for _, v := range samples {
+		token, err := server.SignIn(users[v.user].Email, "pass123")
+		if err != nil {
+			t.Errorf("Could not login the user, Error: %v \n", err)
+		}
+		body := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(v.inputJSON), &body); err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		body["author_id"] = users[v.user].ID
+		b, _ := json.Marshal(body)
+		req, err := http.NewRequest("POST", "/posts", bytes.NewBuffer(b))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.CreatePost).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 201 {
+			assert.Equal(t, v.tags, termSlugs(responseMap["tags"]))
+			assert.Equal(t, v.categories, termSlugs(responseMap["categories"]))
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// The draft isn't counted, the first spelling names the tag
+	tags := getTerms(t, server.GetTags, "/tags")
+	assert.Equal(t, []interface{}{"Go", "Web Dev"}, []interface{}{tags[0]["name"], tags[1]["name"]})
+	assert.Equal(t, []interface{}{2.0, 1.0}, []interface{}{tags[0]["post_count"], tags[1]["post_count"]})
+
+	categories := getTerms(t, server.GetCategories, "/categories")
+	assert.Equal(t, 1, len(categories))
+	assert.Equal(t, 1.0, categories[0]["post_count"])
+}
+
+func TestGetTermPosts(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh user and post tables, Error: %v \n", err)
+	}
+	users, posts, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts, Error: %v \n", err)
+	}
+	if _, err = server.Users.UpdateRole(users[0].ID, "editor"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		t.Errorf("Could not login the user, Error: %v \n", err)
+	}
+
+	// Tags the first post, then updates it again without tags, which leaves them alone
+	updates := []string{
+		`{"title": "%s", "content": "%s", "author_id": %d, "tags": ["diving"], "categories": ["Ocean"]}`,
+		`{"title": "%s", "content": "%s", "author_id": %d}`,
+	}
+	for _, update := range updates {
+		updateJSON := fmt.Sprintf(update, posts[0].Title, posts[0].Content, posts[0].AuthorID)
+		req, err := http.NewRequest("PUT", "/posts", bytes.NewBufferString(updateJSON))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(posts[0].ID))})
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.UpdatePost).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, []string{"diving"}, termSlugs(responseMap["tags"]))
+		assert.Equal(t, []string{"ocean"}, termSlugs(responseMap["categories"]))
+	}
+
+	samples := []struct {
+		testID     int
+		handler    http.HandlerFunc
+		slug       string
+		statusCode int
+		length     int
+	}{
+		{testID: 1, handler: server.GetTagPosts, slug: "diving", statusCode: 200, length: 1},
+		{testID: 2, handler: server.GetTagPosts, slug: "snorkeling", statusCode: 404},
+		{testID: 3, handler: server.GetCategoryPosts, slug: "ocean", statusCode: 200, length: 1},
+		{testID: 4, handler: server.GetCategoryPosts, slug: "diving", statusCode: 404},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/posts", nil)
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"slug": v.slug})
+		rr := httptest.NewRecorder()
+		v.handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			var page struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err = json.Unmarshal([]byte(rr.Body.String()), &page); err != nil {
+				t.Errorf("Cannot convert to json: %v\n", err)
+			}
+			assert.Equal(t, v.length, len(page.Data))
+			assert.Equal(t, float64(posts[0].ID), page.Data[0]["id"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+// termSlugs picks the slugs out of the tags or categories of a decoded post
+func termSlugs(terms interface{}) []string {
+	slugs := []string{}
+	list, _ := terms.([]interface{})
+	for _, term := range list {
+		slugs = append(slugs, term.(map[string]interface{})["slug"].(string))
+	}
+	return slugs
+}
+
+// getTerms calls a tag or category listing and returns its data
+func getTerms(t *testing.T, handler http.HandlerFunc, path string) []map[string]interface{} {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var page struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal([]byte(rr.Body.String()), &page); err != nil {
+		t.Errorf("Cannot convert to json: %v\n", err)
+	}
+	return page.Data
+}
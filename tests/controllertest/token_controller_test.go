@@ -0,0 +1,165 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshToken(t *testing.T) {
+	var err error
+	if err = refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			// successful rotation
+			testID:     1,
+			inputJSON:  fmt.Sprintf(`{"refresh_token": "%s"}`, token.RefreshToken),
+			statusCode: 200,
+		},
+		{
+			// replaying the spent token
+			testID:       2,
+			inputJSON:    fmt.Sprintf(`{"refresh_token": "%s"}`, token.RefreshToken),
+			statusCode:   401,
+			errorMessage: "Unauthorized",
+		},
+		{
+			testID:       3,
+			inputJSON:    `{"refresh_token": "badtoken"}`,
+			statusCode:   401,
+			errorMessage: "Unauthorized",
+		},
+		{
+			testID:       4,
+			inputJSON:    `{"refresh_token": ""}`,
+			statusCode:   422,
+			errorMessage: "Required: Refresh Token",
+		},
+	}
+
+	var rotated auth.TokenPair
+	for _, v := range samples {
+		req, err := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(v.inputJSON))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.RefreshToken)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			if err = json.Unmarshal([]byte(rr.Body.String()), &rotated); err != nil {
+				t.Errorf("Could not convert to JSON, Error: %v \n", err)
+			}
+			assert.NotEqual(t, "", rotated.AccessToken)
+			assert.NotEqual(t, token.RefreshToken, rotated.RefreshToken)
+		} else {
+			responseMap := make(map[string]interface{})
+			if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+				t.Errorf("Could not convert to JSON, Error: %v \n", err)
+			}
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// The replay in sample 2 ended every session, so the rotated access token is dead too
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", rotated.AccessToken))
+	assert.Equal(t, auth.ErrTokenRevoked, server.Auth.TokenValid(req))
+}
+
+func TestLogout(t *testing.T) {
+	var err error
+	if err = refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user, Error: %v \n", err)
+	}
+	token, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
+
+	samples := []struct {
+		testID     int
+		inputJSON  string
+		tokenGiven string
+		statusCode int
+	}{
+		{
+			// successful logout
+			testID:     1,
+			inputJSON:  fmt.Sprintf(`{"refresh_token": "%s"}`, token.RefreshToken),
+			tokenGiven: tokenString,
+			statusCode: 204,
+		},
+		{
+			// the same access token is now revoked
+			testID:     2,
+			tokenGiven: tokenString,
+			statusCode: 401,
+		},
+		{
+			testID:     3,
+			tokenGiven: "badtoken",
+			statusCode: 401,
+		},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("POST", "/logout", bytes.NewBufferString(v.inputJSON))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.Logout)
+		req.Header.Set("Authorization", v.tokenGiven)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 204 {
+			assert.Empty(t, rr.Body.String())
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// The refresh token handed back at logout can no longer be exchanged
+	req, err := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(fmt.Sprintf(`{"refresh_token": "%s"}`, token.RefreshToken)))
+	if err != nil {
+		t.Errorf("Error: %v \n", err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.RefreshToken).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
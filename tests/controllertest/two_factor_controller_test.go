@@ -0,0 +1,185 @@
+package controllertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// twoFactorRequest posts the body to a two-factor handler for the user as the caller with the token
+func twoFactorRequest(handler http.HandlerFunc, method, token string, uid uint, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	req, err := http.NewRequest(method, "/users/2fa", bytes.NewBufferString(body))
+	if err != nil {
+		log.Fatalf("Error: %v \n", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(uid))})
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+// totpCode is the code for the secret steps away from now
+func totpCode(secret string, steps int64) string {
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now())+steps)
+	if err != nil {
+		log.Fatalf("Could not make code, Error: %v \n", err)
+	}
+	return code
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	if err := refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	user := users[0]
+	session, err := server.SignIn(user.Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+	other, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+
+	// Only the user enrolls themself
+	rr, _ := twoFactorRequest(server.EnrollTwoFactor, "POST", other.AccessToken, user.ID, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, responseMap := twoFactorRequest(server.ConfirmTwoFactor, "POST", session.AccessToken, user.ID, `{"code": "123456"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Equal(t, "Two-Factor Not Enrolled", responseMap["detail"])
+
+	rr, responseMap = twoFactorRequest(server.EnrollTwoFactor, "POST", session.AccessToken, user.ID, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	secret, _ := responseMap["secret"].(string)
+	assert.NotEmpty(t, secret)
+	assert.True(t, strings.HasPrefix(responseMap["otpauth_uri"].(string), "otpauth://totp/GoBlog:"))
+
+	// Two-factor stays off until confirmed
+	_, err = server.SignIn(user.Email, "pass123")
+	assert.NoError(t, err)
+
+	rr, responseMap = twoFactorRequest(server.ConfirmTwoFactor, "POST", session.AccessToken, user.ID, `{"code": "000000x"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, "Invalid Code", responseMap["detail"])
+	rr, responseMap = twoFactorRequest(server.ConfirmTwoFactor, "POST", session.AccessToken, user.ID, fmt.Sprintf(`{"code": %q}`, totpCode(secret, 0)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	codes, _ := responseMap["recovery_codes"].([]interface{})
+	assert.Len(t, codes, auth.RecoveryCodeCount)
+	rr, _ = twoFactorRequest(server.EnrollTwoFactor, "POST", session.AccessToken, user.ID, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// The password alone only earns a challenge
+	_, err = server.SignIn(user.Email, "pass123")
+	assert.Error(t, err)
+	rr, responseMap = accountRequest(server.Login, fmt.Sprintf(`{"email": %q, "password": "pass123"}`, user.Email))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, true, responseMap["two_factor_required"])
+	assert.Nil(t, responseMap["access_token"])
+	challenge, _ := responseMap["challenge_token"].(string)
+	assert.NotEmpty(t, challenge)
+
+	// The code confirming enrollment was spent, the next one works once
+	code := totpCode(secret, 1)
+	recovery := strings.ToUpper(strings.Replace(codes[0].(string), "-", "", 1))
+	samples := []struct {
+		testID       int
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{testID: 1, inputJSON: fmt.Sprintf(`{"code": %q}`, code), statusCode: 422, errorMessage: "Required: Challenge Token"},
+		{testID: 2, inputJSON: fmt.Sprintf(`{"challenge_token": %q}`, challenge), statusCode: 422, errorMessage: "Required: Code"},
+		{testID: 3, inputJSON: fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge+"x", code), statusCode: 401, errorMessage: "Invalid Token"},
+		{testID: 4, inputJSON: fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, totpCode(secret, 0)), statusCode: 401, errorMessage: "Invalid Code"},
+		// A mistyped code doesn't spend the challenge
+		{testID: 5, inputJSON: fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code), statusCode: 200},
+		{testID: 6, inputJSON: fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code), statusCode: 401, errorMessage: "Invalid Token"},
+	}
+	for _, v := range samples {
+		rr, responseMap := accountRequest(server.LoginTwoFactor, v.inputJSON)
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			assert.NotEmpty(t, responseMap["access_token"])
+			assert.NotEmpty(t, responseMap["refresh_token"])
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// A replayed code fails on a fresh challenge, a recovery code works once however it is typed
+	_, responseMap = accountRequest(server.Login, fmt.Sprintf(`{"email": %q, "password": "pass123"}`, user.Email))
+	challenge = responseMap["challenge_token"].(string)
+	rr, responseMap = accountRequest(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": %q, "code": %q}`, challenge, code))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "Invalid Code", responseMap["detail"])
+	rr, responseMap = accountRequest(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": %q, "recovery_code": %q}`, challenge, recovery))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, responseMap["access_token"])
+	_, responseMap = accountRequest(server.Login, fmt.Sprintf(`{"email": %q, "password": "pass123"}`, user.Email))
+	challenge = responseMap["challenge_token"].(string)
+	rr, _ = accountRequest(server.LoginTwoFactor, fmt.Sprintf(`{"challenge_token": %q, "recovery_code": %q}`, challenge, recovery))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// Turning it off takes a second factor, then the password is enough again
+	rr, responseMap = twoFactorRequest(server.DisableTwoFactor, "DELETE", session.AccessToken, user.ID, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, "Required: Code", responseMap["detail"])
+	rr, _ = twoFactorRequest(server.DisableTwoFactor, "DELETE", session.AccessToken, user.ID, fmt.Sprintf(`{"recovery_code": %q}`, codes[1]))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	_, err = server.SignIn(user.Email, "pass123")
+	assert.NoError(t, err)
+}
+
+func TestAdminDisablesTwoFactor(t *testing.T) {
+	if err := refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh user table, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed users, Error: %v \n", err)
+	}
+	if _, err = server.Users.UpdateRole(users[1].ID, "admin"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	admin, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+	secret, _ := auth.NewTOTPSecret()
+	if err = server.Users.SetTOTPSecret(users[0].ID, secret); err != nil {
+		log.Fatalf("Could not enroll user, Error: %v \n", err)
+	}
+	if err = server.Users.EnableTOTP(users[0].ID, auth.TOTPStep(time.Now()), nil); err != nil {
+		log.Fatalf("Could not enable two-factor, Error: %v \n", err)
+	}
+
+	// Admins can't enroll someone else, but can turn off two-factor for a user who lost their device
+	rr, _ := twoFactorRequest(server.EnrollTwoFactor, "POST", admin.AccessToken, users[0].ID, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr, _ = twoFactorRequest(server.DisableTwoFactor, "DELETE", admin.AccessToken, users[0].ID, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	_, err = server.SignIn(users[0].Email, "pass123")
+	assert.NoError(t, err)
+}
@@ -10,7 +10,6 @@ import (
 	"strconv"
 	"testing"
 
-	"github.com/aaronprice00/goblog-mvc/api/model"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
@@ -27,6 +26,7 @@ func TestCreateUser(t *testing.T) {
 		username     string
 		email        string
 		errorMessage string
+		fields       []string
 	}{
 		{
 			// sucessful
@@ -40,38 +40,44 @@ func TestCreateUser(t *testing.T) {
 		{
 			testID:       2,
 			inputJSON:    `{"username": "abuhlmann", "email": "jcousteau@gmail.com", "password": "pass123"}`,
-			statusCode:   500,
+			statusCode:   409,
 			errorMessage: "Email Already Used",
+			fields:       []string{"email"},
 		},
 		{
 			testID:       3,
 			inputJSON:    `{"username": "jcousteau", "email": "abuhlmann@gmail.com", "password": "pass123"}`,
-			statusCode:   500,
+			statusCode:   409,
 			errorMessage: "Username Already Taken",
+			fields:       []string{"username"},
 		},
 		{
 			testID:       4,
 			inputJSON:    `{"username": "abuhlman", "email": "abuhlmanngmail.com", "password": "pass123"}`,
 			statusCode:   422,
 			errorMessage: "Invalid Email",
+			fields:       []string{"email"},
 		},
 		{
 			testID:       5,
 			inputJSON:    `{"username": "", "email": "abuhlmann@gmail.com", "password": "pass123"}`,
 			statusCode:   422,
 			errorMessage: "Required: Username",
+			fields:       []string{"username"},
 		},
 		{
 			testID:       6,
 			inputJSON:    `{"username": "abuhlmann", "email": "", "password": "pass123"}`,
 			statusCode:   422,
 			errorMessage: "Required: Email",
+			fields:       []string{"email"},
 		},
 		{
 			testID:       7,
 			inputJSON:    `{"username": "abuhlmann", "email": "abuhlmann@gmail.com", "password": ""}`,
 			statusCode:   422,
 			errorMessage: "Required: Password",
+			fields:       []string{"password"},
 		},
 	}
 
@@ -97,8 +103,15 @@ func TestCreateUser(t *testing.T) {
 			assert.Equal(t, v.email, responseMap["email"])
 		}
 
-		if v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+		if v.statusCode == 422 || v.statusCode == 409 {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
+			// Each field in the way is named, with what is wrong with it
+			fields, _ := responseMap["errors"].([]interface{})
+			if assert.Len(t, fields, len(v.fields)) {
+				for i, field := range v.fields {
+					assert.Equal(t, field, fields[i].(map[string]interface{})["field"])
+				}
+			}
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -121,12 +134,21 @@ func TestGetUsers(t *testing.T) {
 	handler := http.HandlerFunc(server.GetUsers)
 	handler.ServeHTTP(rr, req)
 
-	var usersReceived []model.User
+	var usersReceived struct {
+		Data []map[string]interface{} `json:"data"`
+		Next string                   `json:"next"`
+	}
 	if err = json.Unmarshal([]byte(rr.Body.String()), &usersReceived); err != nil {
 		log.Fatalf("Could not convert to JSON, Error: %v \n", err)
 	}
 	assert.Equal(t, http.StatusOK, rr.Code)
-	assert.Equal(t, len(users), len(usersReceived))
+	assert.Equal(t, len(users), len(usersReceived.Data))
+	assert.Equal(t, strconv.Itoa(len(users)), rr.Header().Get("X-Total-Count"))
+	// Strangers get the public view
+	for _, u := range usersReceived.Data {
+		assert.NotContains(t, u, "password")
+		assert.NotContains(t, u, "email")
+	}
 }
 
 func TestGetUserByID(t *testing.T) {
@@ -134,26 +156,31 @@ func TestGetUserByID(t *testing.T) {
 	if err = refreshUserTable(); err != nil {
 		log.Fatalf("Could not refresh User table, Error: %v \n", err)
 	}
-	user, err := seedOneUser()
+	users, err := seedUsers()
 	if err != nil {
-		log.Fatalf("Could not seed User, Error: %v \n", err)
+		log.Fatalf("Could not seed Users, Error: %v \n", err)
+	}
+	if _, err = server.Users.UpdateRole(users[1].ID, "admin"); err != nil {
+		log.Fatalf("Could not promote User, Error: %v \n", err)
 	}
+	self, _ := server.SignIn(users[0].Email, "pass123")
+	admin, _ := server.SignIn(users[1].Email, "pass123")
+	user := users[0]
 
+	// Only the user and admins see the email, nobody the password
 	userSample := []struct {
+		testID     int
 		id         string
+		token      string
 		statusCode int
-		username   string
-		email      string
+		email      bool
+		updatedAt  bool
 	}{
-		{
-			id:         strconv.Itoa(int(user.ID)),
-			statusCode: 200,
-			username:   user.Username,
-			email:      user.Email,
-		}, {
-			id:         "unknown",
-			statusCode: 400,
-		},
+		{testID: 1, id: strconv.Itoa(int(user.ID)), statusCode: 200},
+		{testID: 2, id: strconv.Itoa(int(user.ID)), token: self.AccessToken, statusCode: 200, email: true},
+		{testID: 3, id: strconv.Itoa(int(users[1].ID)), token: self.AccessToken, statusCode: 200},
+		{testID: 4, id: strconv.Itoa(int(user.ID)), token: admin.AccessToken, statusCode: 200, email: true, updatedAt: true},
+		{testID: 5, id: "unknown", statusCode: 400},
 	}
 
 	for _, v := range userSample {
@@ -162,6 +189,9 @@ func TestGetUserByID(t *testing.T) {
 			t.Errorf("Error: %v \n", err)
 		}
 		req = mux.SetURLVars(req, map[string]string{"id": v.id})
+		if v.token != "" {
+			req.Header.Set("Authorization", "Bearer "+v.token)
+		}
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(server.GetUser)
 		handler.ServeHTTP(rr, req)
@@ -171,12 +201,19 @@ func TestGetUserByID(t *testing.T) {
 			log.Fatalf("Could not convert to JSON, Error: %v \n", err)
 		}
 
-		assert.Equal(t, rr.Code, v.statusCode)
-
+		assert.Equal(t, v.statusCode, rr.Code)
 		if v.statusCode == 200 {
-			assert.Equal(t, user.Username, responseMap["username"])
-			assert.Equal(t, user.Email, responseMap["email"])
+			assert.NotEmpty(t, responseMap["username"])
+			assert.NotContains(t, responseMap, "password")
+			_, hasEmail := responseMap["email"]
+			assert.Equal(t, v.email, hasEmail)
+			_, hasUpdatedAt := responseMap["updated_at"]
+			assert.Equal(t, v.updatedAt, hasUpdatedAt)
+			if v.email {
+				assert.Equal(t, user.Email, responseMap["email"])
+			}
 		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
 }
 
@@ -209,7 +246,7 @@ func TestUpdateUser(t *testing.T) {
 	if err != nil {
 		log.Fatalf("Could not login user, Error: %v \n", err)
 	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
 
 	samples := []struct {
 		testID         int
@@ -260,7 +297,7 @@ func TestUpdateUser(t *testing.T) {
 			testID:       5,
 			id:           strconv.Itoa(int(authID)),
 			updateJSON:   `{"username": "ebaker", "email": "albert@buhlmann.com", "password": "pass123"}`,
-			statusCode:   500,
+			statusCode:   409,
 			tokenGiven:   tokenString,
 			errorMessage: "Email Already Used",
 		},
@@ -269,7 +306,7 @@ func TestUpdateUser(t *testing.T) {
 			testID:       6,
 			id:           strconv.Itoa(int(authID)),
 			updateJSON:   `{"username": "abuhlmann", "email": "erik@baker.com", "password": "pass123"}`,
-			statusCode:   500,
+			statusCode:   409,
 			tokenGiven:   tokenString,
 			errorMessage: "Username Already Taken",
 		},
@@ -309,8 +346,8 @@ func TestUpdateUser(t *testing.T) {
 			id:           strconv.Itoa(int(2)),
 			updateJSON:   `{"username": "ebaker", "email": "erik@baker.com", "password": "pass123"}`,
 			tokenGiven:   tokenString,
-			statusCode:   401,
-			errorMessage: "Unauthorized",
+			statusCode:   403,
+			errorMessage: "Forbidden",
 		},
 	}
 
@@ -335,8 +372,8 @@ func TestUpdateUser(t *testing.T) {
 			assert.Equal(t, v.updateUsername, responseMap["username"])
 			assert.Equal(t, v.updateEmail, responseMap["email"])
 		}
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
-			assert.Equal(t, v.errorMessage, responseMap["error"])
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 409 {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
@@ -370,7 +407,7 @@ func TestDeleteUser(t *testing.T) {
 	if err != nil {
 		log.Fatalf("Could not login, Error: %v \n", err)
 	}
-	tokenString := fmt.Sprintf("Bearer %v", token)
+	tokenString := fmt.Sprintf("Bearer %v", token.AccessToken)
 
 	userSample := []struct {
 		testID       int
@@ -379,6 +416,14 @@ func TestDeleteUser(t *testing.T) {
 		statusCode   int
 		errorMessage string
 	}{
+		{
+			// User 2 trying to use User 1 token, runs first since deleting user 1 ends its session
+			testID:       5,
+			id:           strconv.Itoa(int(2)),
+			tokenGiven:   tokenString,
+			statusCode:   403,
+			errorMessage: "Forbidden",
+		},
 		{
 			testID:       1,
 			id:           strconv.Itoa(int(authID)),
@@ -406,14 +451,6 @@ func TestDeleteUser(t *testing.T) {
 			tokenGiven: tokenString,
 			statusCode: 400, // Bad Request
 		},
-		{
-			// User 2 trying to use User 1 token
-			testID:       5,
-			id:           strconv.Itoa(int(2)),
-			tokenGiven:   tokenString,
-			statusCode:   401,
-			errorMessage: "Unauthorized",
-		},
 	}
 	for _, v := range userSample {
 		var err error
@@ -429,13 +466,106 @@ func TestDeleteUser(t *testing.T) {
 		handler.ServeHTTP(rr, req)
 
 		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 204 {
+			assert.Empty(t, rr.Body.String())
+		}
 
 		if v.statusCode == 401 && v.errorMessage == "" {
 			responseMap := make(map[string]interface{})
 			if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
 				t.Errorf("Could not convert to JSON, Error: %v \n", err)
 			}
-			assert.Equal(t, responseMap["error"], v.errorMessage)
+			assert.Equal(t, responseMap["detail"], v.errorMessage)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestUpdateUserRole(t *testing.T) {
+	var err error
+	if err = refreshUserTable(); err != nil {
+		log.Fatalf("Could not refresh User Table, Error: %v \n", err)
+	}
+	users, err := seedUsers()
+	if err != nil {
+		log.Fatalf("Could not seed Users, Error: %v \n", err)
+	}
+	if _, err = server.Users.UpdateRole(users[0].ID, "admin"); err != nil {
+		log.Fatalf("Could not promote user, Error: %v \n", err)
+	}
+	adminToken, err := server.SignIn(users[0].Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+	authorToken, err := server.SignIn(users[1].Email, "pass123")
+	if err != nil {
+		log.Fatalf("Could not login, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID       int
+		id           string
+		inputJSON    string
+		tokenGiven   string
+		statusCode   int
+		role         string
+		errorMessage string
+	}{
+		{
+			// authors can't promote themselves, runs first since a role change ends the session
+			testID:       1,
+			id:           strconv.Itoa(int(users[1].ID)),
+			inputJSON:    `{"role": "admin"}`,
+			tokenGiven:   fmt.Sprintf("Bearer %v", authorToken.AccessToken),
+			statusCode:   403,
+			errorMessage: "Forbidden",
+		},
+		{
+			testID:     2,
+			id:         strconv.Itoa(int(users[1].ID)),
+			inputJSON:  `{"role": "editor"}`,
+			tokenGiven: fmt.Sprintf("Bearer %v", adminToken.AccessToken),
+			statusCode: 200,
+			role:       "editor",
+		},
+		{
+			testID:       3,
+			id:           strconv.Itoa(int(users[1].ID)),
+			inputJSON:    `{"role": "overlord"}`,
+			tokenGiven:   fmt.Sprintf("Bearer %v", adminToken.AccessToken),
+			statusCode:   422,
+			errorMessage: "Invalid Role",
+		},
+		{
+			testID:       4,
+			id:           strconv.Itoa(int(users[1].ID)),
+			inputJSON:    `{"role": "admin"}`,
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "Unauthorized",
+		},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("PUT", "/users/role", bytes.NewBufferString(v.inputJSON))
+		if err != nil {
+			t.Errorf("Error: %v \n", err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": v.id})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.UpdateUserRole)
+		req.Header.Set("Authorization", v.tokenGiven)
+		handler.ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(rr.Body.String()), &responseMap); err != nil {
+			t.Errorf("Could not convert to JSON, Error: %v \n", err)
+		}
+		assert.Equal(t, v.statusCode, rr.Code)
+		if v.statusCode == 200 {
+			assert.Equal(t, v.role, responseMap["role"])
+		} else {
+			assert.Equal(t, v.errorMessage, responseMap["detail"])
 		}
 		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
 	}
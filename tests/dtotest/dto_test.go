@@ -0,0 +1,92 @@
+package dtotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/dto"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserViews(t *testing.T) {
+	verified := time.Now()
+	user := model.User{Username: "jcousteau", Email: "jaques@cousteau.com", Password: "$2a$10$hash", Role: "author",
+		EmailVerifiedAt: &verified, TOTPSecret: "SECRET"}
+	user.ID = 1
+
+	samples := []struct {
+		testID  int
+		view    dto.View
+		present []string
+		absent  []string
+	}{
+		{testID: 1, view: dto.Public, present: []string{"id", "username", "role", "created_at"},
+			absent: []string{"email", "email_verified_at", "updated_at"}},
+		{testID: 2, view: dto.Self, present: []string{"email", "email_verified_at", "totp_enabled_at"}, absent: []string{"updated_at"}},
+		{testID: 3, view: dto.Admin, present: []string{"email", "updated_at"}},
+	}
+	for _, v := range samples {
+		b, err := json.Marshal(dto.NewUserView(&user, v.view))
+		assert.NoError(t, err)
+		fields := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(b, &fields))
+		// No view has the password or the two-factor secret
+		assert.NotContains(t, string(b), "password")
+		assert.NotContains(t, string(b), user.Password)
+		assert.NotContains(t, string(b), user.TOTPSecret)
+		for _, key := range v.present {
+			assert.Contains(t, fields, key)
+		}
+		for _, key := range v.absent {
+			assert.NotContains(t, fields, key)
+		}
+		fmt.Printf("%v Finished w/ fields: %v\n", v.testID, len(fields))
+	}
+}
+
+func TestPostRequest(t *testing.T) {
+	samples := []struct {
+		testID     int
+		inputJSON  string
+		tags       []model.Tag
+		categories []model.Category
+	}{
+		// Names bare or as the objects posts are answered with
+		{testID: 1, inputJSON: `{"tags": ["go", {"id": 3, "name": "Testing", "slug": "testing"}], "categories": ["News"]}`,
+			tags: []model.Tag{{Name: "go"}, {Name: "Testing"}}, categories: []model.Category{{Name: "News"}}},
+		// Left out keeps an updated post's terms, empty clears them
+		{testID: 2, inputJSON: `{"title": "T"}`},
+		{testID: 3, inputJSON: `{"tags": [], "categories": null}`, tags: []model.Tag{}},
+	}
+	for _, v := range samples {
+		req := dto.PostRequest{}
+		assert.NoError(t, json.Unmarshal([]byte(v.inputJSON), &req))
+		post := req.Model()
+		assert.Equal(t, v.tags, post.Tags)
+		assert.Equal(t, v.categories, post.Categories)
+		fmt.Printf("%v Finished w/ tags: %v\n", v.testID, len(post.Tags))
+	}
+
+	req := dto.PostRequest{}
+	assert.Error(t, json.Unmarshal([]byte(`{"tags": [1]}`), &req))
+}
+
+func TestNewPost(t *testing.T) {
+	post := model.Post{Title: "Title", Content: "Content", AuthorID: 1,
+		Author: model.User{Username: "jcousteau", Email: "jaques@cousteau.com", Password: "$2a$10$hash"},
+		Tags:   []model.Tag{{ID: 1, Name: "Go", Slug: "go"}}}
+	b, err := json.Marshal(dto.NewPost(&post))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "jaques@cousteau.com")
+	assert.NotContains(t, string(b), "$2a$10$hash")
+
+	answered := dto.Post{}
+	assert.NoError(t, json.Unmarshal(b, &answered))
+	assert.Equal(t, "jcousteau", answered.Author.Username)
+	assert.Equal(t, []dto.Term{{ID: 1, Name: "Go", Slug: "go"}}, answered.Tags)
+	// A post without categories answers an empty list rather than null
+	assert.Equal(t, []dto.Term{}, answered.Categories)
+}
@@ -0,0 +1,128 @@
+package feedtest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/feed"
+	"github.com/stretchr/testify/assert"
+)
+
+var updated = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+var sample = feed.Feed{
+	Title:       "GoBlog",
+	Description: "The newest posts",
+	Link:        "http://blog.test/posts",
+	FeedURL:     "http://blog.test/feed.rss",
+	Updated:     updated,
+	Items: []feed.Item{{
+		ID:          "http://blog.test/posts/1",
+		Link:        "http://blog.test/posts/by-slug/fish-&-chips",
+		Title:       "Fish & Chips",
+		Summary:     "Crispy",
+		ContentHTML: "<p>Crispy <em>fish</em></p>",
+		Author:      "gopher",
+		Tags:        []string{"food"},
+		Published:   updated.Add(-time.Hour),
+		Updated:     updated,
+	}},
+}
+
+func TestEncodeRSS(t *testing.T) {
+	b, err := feed.Encode(sample, feed.RSS)
+	assert.NoError(t, err)
+
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Title         string `xml:"title"`
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				Title       string   `xml:"title"`
+				Link        string   `xml:"link"`
+				GUID        string   `xml:"guid"`
+				PubDate     string   `xml:"pubDate"`
+				Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+				Categories  []string `xml:"category"`
+				Description string   `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	assert.NoError(t, xml.Unmarshal(b, &doc))
+	assert.Equal(t, "2.0", doc.Version)
+	assert.Equal(t, "GoBlog", doc.Channel.Title)
+	assert.Equal(t, "Thu, 04 Mar 2021 05:06:07 +0000", doc.Channel.LastBuildDate)
+	assert.Len(t, doc.Channel.Items, 1)
+	item := doc.Channel.Items[0]
+	assert.Equal(t, "Fish & Chips", item.Title)
+	assert.Equal(t, "http://blog.test/posts/by-slug/fish-&-chips", item.Link)
+	assert.Equal(t, "http://blog.test/posts/1", item.GUID)
+	assert.Equal(t, "Thu, 04 Mar 2021 04:06:07 +0000", item.PubDate)
+	assert.Equal(t, "gopher", item.Creator)
+	assert.Equal(t, []string{"food"}, item.Categories)
+	assert.Equal(t, "<p>Crispy <em>fish</em></p>", item.Description)
+}
+
+func TestEncodeAtom(t *testing.T) {
+	b, err := feed.Encode(sample, feed.Atom)
+	assert.NoError(t, err)
+
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string   `xml:"id"`
+		Updated string   `xml:"updated"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Updated   string `xml:"updated"`
+			Published string `xml:"published"`
+			Author    string `xml:"author>name"`
+			Link      struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Content struct {
+				Type  string `xml:"type,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"content"`
+		} `xml:"entry"`
+	}
+	assert.NoError(t, xml.Unmarshal(b, &doc))
+	assert.Equal(t, "http://blog.test/feed.rss", doc.ID)
+	assert.Equal(t, "2021-03-04T05:06:07Z", doc.Updated)
+	assert.Len(t, doc.Entries, 1)
+	entry := doc.Entries[0]
+	assert.Equal(t, "http://blog.test/posts/1", entry.ID)
+	assert.Equal(t, "2021-03-04T05:06:07Z", entry.Updated)
+	assert.Equal(t, "2021-03-04T04:06:07Z", entry.Published)
+	assert.Equal(t, "gopher", entry.Author)
+	assert.Equal(t, "http://blog.test/posts/by-slug/fish-&-chips", entry.Link.Href)
+	assert.Equal(t, "html", entry.Content.Type)
+	assert.Equal(t, "<p>Crispy <em>fish</em></p>", entry.Content.Value)
+}
+
+func TestEncodeJSON(t *testing.T) {
+	b, err := feed.Encode(sample, feed.JSON)
+	assert.NoError(t, err)
+
+	doc := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &doc))
+	assert.Equal(t, "https://jsonfeed.org/version/1.1", doc["version"])
+	assert.Equal(t, "http://blog.test/feed.rss", doc["feed_url"])
+	items := doc["items"].([]interface{})
+	assert.Len(t, items, 1)
+	item := items[0].(map[string]interface{})
+	assert.Equal(t, "http://blog.test/posts/1", item["id"])
+	assert.Equal(t, "<p>Crispy <em>fish</em></p>", item["content_html"])
+	assert.Equal(t, "2021-03-04T05:06:07Z", item["date_modified"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "gopher"}}, item["authors"])
+
+	// An empty feed still lists its items as an array
+	b, err = feed.Encode(feed.Feed{Title: "GoBlog"}, feed.JSON)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"items": []`)
+
+	_, err = feed.Encode(sample, "html")
+	assert.Equal(t, feed.ErrUnknownFormat, err)
+}
@@ -0,0 +1,46 @@
+package formaterrortest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestFormatError(t *testing.T) {
+	samples := []struct {
+		testID  int
+		err     error
+		message string
+		status  int
+		field   string
+	}{
+		{testID: 1, err: errors.New(`ERROR: duplicate key value violates unique constraint "users_email_key" (SQLSTATE 23505)`), message: "Email Already Used", status: http.StatusConflict, field: "email"},
+		{testID: 2, err: errors.New(`ERROR: duplicate key value violates unique constraint "users_username_key" (SQLSTATE 23505)`), message: "Username Already Taken", status: http.StatusConflict, field: "username"},
+		{testID: 3, err: errors.New(`ERROR: duplicate key value violates unique constraint "posts_title_key" (SQLSTATE 23505)`), message: "Title Already Used", status: http.StatusConflict, field: "title"},
+		{testID: 4, err: errors.New("UNIQUE constraint failed: users.email"), message: "Email Already Used", status: http.StatusConflict, field: "email"},
+		{testID: 5, err: errors.New("UNIQUE constraint failed: users.username"), message: "Username Already Taken", status: http.StatusConflict, field: "username"},
+		{testID: 6, err: errors.New("UNIQUE constraint failed: posts.title"), message: "Title Already Used", status: http.StatusConflict, field: "title"},
+		{testID: 7, err: gorm.ErrRecordNotFound, message: "Not Found", status: http.StatusNotFound},
+		// Anything else is the server's failure, not guessed at from its text
+		{testID: 8, err: errors.New("crypto/bcrypt: hashedPassword is not the hash of the given password"), message: "crypto/bcrypt: hashedPassword is not the hash of the given password", status: http.StatusInternalServerError},
+		{testID: 9, err: errors.New("connection refused while checking the email"), message: "connection refused while checking the email", status: http.StatusInternalServerError},
+	}
+
+	for _, v := range samples {
+		err := formaterror.FormatError(v.err)
+		assert.EqualError(t, err, v.message)
+		assert.Equal(t, v.status, apierror.Status(err))
+		if v.field != "" {
+			assert.Equal(t, []apierror.FieldError{{Field: v.field, Code: apierror.CodeTaken, Message: v.message}}, apierror.Fields(err))
+		} else {
+			assert.Empty(t, apierror.Fields(err))
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, err)
+	}
+}
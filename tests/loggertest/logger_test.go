@@ -0,0 +1,156 @@
+package loggertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/logger"
+	"github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// entries decodes the JSON lines written to buf
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Could not decode %q, Error: %v \n", line, err)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func TestLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := logger.New(buf, logger.Info)
+	log.Now = func() time.Time { return time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC) }
+
+	log.Debug("dropped")
+	log.With(logger.Fields{"component": "test"}).Warn("careful", logger.Fields{"error": errors.New("boom"), "wait": time.Second})
+	log.Info("plain")
+	assert.Equal(t, `{"time":"2021-02-03T04:05:06Z","level":"warn","msg":"careful","component":"test","error":"boom","wait":"1s"}
+{"time":"2021-02-03T04:05:06Z","level":"info","msg":"plain"}
+`, buf.String())
+
+	// A nil logger drops everything
+	var none *logger.Logger
+	none.With(logger.Fields{"a": 1}).Error("nothing")
+	assert.False(t, none.Enabled(logger.Error))
+
+	samples := []struct {
+		testID int
+		input  string
+		level  logger.Level
+		err    bool
+	}{
+		{testID: 1, input: "debug", level: logger.Debug},
+		{testID: 2, input: " WARN ", level: logger.Warn},
+		{testID: 3, input: "error", level: logger.Error},
+		{testID: 4, input: "loud", err: true},
+	}
+	for _, v := range samples {
+		level, err := logger.ParseLevel(v.input)
+		if v.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, v.level, level)
+		}
+		fmt.Printf("%v Finished w/ level: %v\n", v.testID, level)
+	}
+}
+
+func TestSetMiddlewareRequestLog(t *testing.T) {
+	issuer := auth.NewIssuer("loggertest")
+	token, _, err := issuer.CreateToken(7, auth.RoleAuthor)
+	if err != nil {
+		t.Fatalf("Could not create token, Error: %v \n", err)
+	}
+	buf := &bytes.Buffer{}
+	log := logger.New(buf, logger.Debug)
+
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.SetMiddlewareRequestLog(log, next.ServeHTTP)
+	})
+	router.HandleFunc("/posts/{id}", middleware.SetMiddlewareAuthentication(issuer, func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Debug("handler")
+		response.ERROR(w, &apierror.NotFound{Resource: "Post"})
+	}))
+
+	samples := []struct {
+		testID    int
+		requestID string
+		token     string
+		keepID    bool
+		userID    interface{}
+	}{
+		{testID: 1, requestID: "abc-123", token: token, keepID: true, userID: float64(7)},
+		// Ids that could forge log lines are replaced
+		{testID: 2, requestID: "abc\n{\"level\":\"error\"}"},
+		{testID: 3, requestID: strings.Repeat("a", 200)},
+		{testID: 4},
+	}
+	for _, v := range samples {
+		buf.Reset()
+		req, _ := http.NewRequest("GET", "/posts/42", nil)
+		if v.requestID != "" {
+			req.Header.Set("X-Request-ID", v.requestID)
+		}
+		if v.token != "" {
+			req.Header.Set("Authorization", "Bearer "+v.token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		id := rr.Header().Get("X-Request-ID")
+		if v.keepID {
+			assert.Equal(t, v.requestID, id)
+		} else {
+			assert.Len(t, id, 32)
+		}
+		body := map[string]interface{}{}
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		if v.token != "" {
+			assert.Equal(t, http.StatusNotFound, rr.Code)
+			assert.Equal(t, "Post Not Found", body["detail"])
+			assert.Equal(t, "not_found", body["code"])
+		} else {
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		}
+		assert.Equal(t, id, body["request_id"])
+
+		logged := entries(t, buf)
+		last := logged[len(logged)-1]
+		assert.Equal(t, "request", last["msg"])
+		assert.Equal(t, id, last["request_id"])
+		assert.Equal(t, "GET", last["method"])
+		assert.Equal(t, "/posts/{id}", last["route"])
+		assert.Equal(t, float64(rr.Code), last["status"])
+		assert.Equal(t, float64(rr.Body.Len()), last["bytes"])
+		assert.Equal(t, v.userID, last["user_id"])
+		assert.NotNil(t, last["latency_ms"])
+		if v.token != "" {
+			// Handlers log under the same request id
+			assert.Equal(t, "handler", logged[0]["msg"])
+			assert.Equal(t, id, logged[0]["request_id"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
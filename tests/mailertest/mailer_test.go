@@ -0,0 +1,76 @@
+package mailertest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	samples := []struct {
+		testID       int
+		config       mailer.Config
+		errorMessage string
+	}{
+		{testID: 1, config: mailer.Config{}},
+		{testID: 2, config: mailer.Config{Driver: mailer.Log}},
+		{testID: 3, config: mailer.Config{Driver: mailer.SMTP, Host: "smtp.test", From: "blog@test"}},
+		{testID: 4, config: mailer.Config{Driver: mailer.SMTP, From: "blog@test"}, errorMessage: "Required: SMTP host and from address"},
+		{testID: 5, config: mailer.Config{Driver: mailer.File}, errorMessage: "Required: mail file path"},
+		{testID: 6, config: mailer.Config{Driver: "pigeon"}, errorMessage: "Unknown Mail Driver: pigeon"},
+	}
+
+	for _, v := range samples {
+		m, err := mailer.New(v.config)
+		if v.errorMessage != "" {
+			assert.EqualError(t, err, v.errorMessage)
+		} else {
+			assert.NoError(t, err)
+			assert.NotNil(t, m)
+		}
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m, err := mailer.New(mailer.Config{Driver: mailer.File, Path: path, From: "blog@test"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Send(mailer.Message{To: "pet@test", Subject: "Hello", Body: "line one\nline two"}))
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "From: blog@test\r\nTo: pet@test\r\nSubject: Hello\r\n")
+	assert.Contains(t, string(b), "\r\n\r\nline one\r\nline two\n")
+}
+
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := mailer.NewLogMailer(&out, "blog@test")
+
+	// Line breaks can't smuggle in more headers
+	assert.NoError(t, m.Send(mailer.Message{To: "pet@test", Subject: "Hi\r\nBcc: eve@test", Body: "first"}))
+	assert.NoError(t, m.Send(mailer.Message{To: "sam@test", Subject: "Hi", Body: "second"}))
+	assert.NoError(t, m.Send(mailer.Message{To: "pet@test", Subject: "Hi", Body: "third"}))
+	assert.Contains(t, out.String(), "Subject: HiBcc: eve@test\r\n")
+	assert.NotContains(t, out.String(), "\r\nBcc:")
+}
+
+func TestRecorder(t *testing.T) {
+	m := &mailer.Recorder{}
+	assert.NoError(t, m.Send(mailer.Message{To: "pet@test", Subject: "Hi", Body: "first"}))
+	assert.NoError(t, m.Send(mailer.Message{To: "sam@test", Subject: "Hi", Body: "second"}))
+	assert.NoError(t, m.Send(mailer.Message{To: "pet@test", Subject: "Hi", Body: "third"}))
+
+	assert.Len(t, m.Sent(), 3)
+	last, ok := m.Last("pet@test")
+	assert.True(t, ok)
+	assert.Equal(t, "third", last.Body)
+	_, ok = m.Last("eve@test")
+	assert.False(t, ok)
+}
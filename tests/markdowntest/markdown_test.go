@@ -0,0 +1,49 @@
+package markdowntest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/aaronprice00/goblog-mvc/api/util/markdown"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	samples := []struct {
+		testID int
+		src    string
+		html   string
+	}{
+		{testID: 1, src: "# Title", html: "<h1>Title</h1>\n"},
+		{testID: 2, src: "Some *em* and **strong**", html: "<p>Some <em>em</em> and <strong>strong</strong></p>\n"},
+		{testID: 3, src: "Fish & chips", html: "<p>Fish &amp; chips</p>\n"},
+		{testID: 4, src: "<script>alert(1)</script>", html: "\n"},
+		{testID: 5, src: "[x](javascript:alert(1))", html: "<p>x</p>\n"},
+		{testID: 6, src: "[x](https://example.com)", html: "<p><a href=\"https://example.com\" rel=\"nofollow\">x</a></p>\n"},
+		{testID: 7, src: "```go\nx := 1\n```", html: "<pre><code class=\"language-go\">x := 1\n</code></pre>\n"},
+		{testID: 8, src: "<img src=x onerror=alert(1)>", html: "\n"},
+		{testID: 9, src: "Hi <b onclick=alert(1)>there</b>", html: "<p>Hi there</p>\n"},
+	}
+
+	for _, v := range samples {
+		rendered := markdown.Render(v.src)
+		assert.Equal(t, v.html, rendered)
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rendered == v.html)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	assert.Equal(t, "Title Fish & chips", markdown.Excerpt("# Title\n\nFish & *chips*"))
+
+	long := strings.Repeat("word ", 100)
+	excerpt := markdown.Excerpt(long)
+	assert.True(t, strings.HasSuffix(excerpt, "word…"))
+	assert.True(t, utf8.RuneCountInString(excerpt) <= markdown.ExcerptLength+1)
+}
+
+func TestText(t *testing.T) {
+	assert.Equal(t, "Dive log Depth & time", markdown.Text("## Dive log\n\nDepth &amp; *time*"))
+	assert.Equal(t, "", markdown.Text("<script>alert(1)</script>"))
+}
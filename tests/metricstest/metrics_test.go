@@ -0,0 +1,96 @@
+package metricstest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/metrics"
+	"github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.Counter("app_requests_total", "Requests served", "route", "code")
+	latency := r.Histogram("app_latency_seconds", "Time taken", []float64{0.1, 1}, "route")
+	r.Counter("app_logins_total", "Logins\nseen")
+	r.Func("app_open_connections", "Connections open", metrics.GaugeKind, func() float64 { return 3 })
+
+	requests.Inc("/posts/{id}", "200")
+	requests.Add(2, "/posts/{id}", "200")
+	requests.Inc(`/say "hi"`, "404")
+	latency.Observe(0.05, "/posts")
+	latency.Observe(0.5, "/posts")
+	latency.Observe(5, "/posts")
+
+	buf := &bytes.Buffer{}
+	_, err := r.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP app_latency_seconds Time taken
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{route="/posts",le="0.1"} 1
+app_latency_seconds_bucket{route="/posts",le="1"} 2
+app_latency_seconds_bucket{route="/posts",le="+Inf"} 3
+app_latency_seconds_sum{route="/posts"} 5.55
+app_latency_seconds_count{route="/posts"} 3
+# HELP app_logins_total Logins\nseen
+# TYPE app_logins_total counter
+app_logins_total 0
+# HELP app_open_connections Connections open
+# TYPE app_open_connections gauge
+app_open_connections 3
+# HELP app_requests_total Requests served
+# TYPE app_requests_total counter
+app_requests_total{route="/posts/{id}",code="200"} 3
+app_requests_total{route="/say \"hi\"",code="404"} 1
+`, buf.String())
+	assert.Equal(t, float64(3), requests.Value("/posts/{id}", "200"))
+	assert.Equal(t, float64(0), requests.Value("/posts/{id}", "500"))
+}
+
+func TestSetMiddlewareMetrics(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.SetMiddlewareMetrics(next.ServeHTTP)
+	})
+	router.HandleFunc("/metricstest/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	before := metrics.Requests.Value("GET", "/metricstest/{id}", "200")
+
+	samples := []struct {
+		testID     int
+		path       string
+		statusCode int
+	}{
+		{testID: 1, path: "/metricstest/1", statusCode: 200},
+		{testID: 2, path: "/metricstest/2", statusCode: 200},
+		{testID: 3, path: "/metricstest/0", statusCode: 404},
+	}
+	for _, v := range samples {
+		req, _ := http.NewRequest("GET", v.path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, v.statusCode, rr.Code)
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+
+	// Requests are split by route template, not by path
+	assert.Equal(t, before+2, metrics.Requests.Value("GET", "/metricstest/{id}", "200"))
+	assert.Equal(t, float64(1), metrics.Requests.Value("GET", "/metricstest/{id}", "404"))
+
+	rr := httptest.NewRecorder()
+	metrics.Default.Handler(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, metrics.ContentType, rr.Header().Get("Content-Type"))
+	assert.True(t, strings.Contains(rr.Body.String(),
+		`goblog_http_request_duration_seconds_count{method="GET",route="/metricstest/{id}"} 3`))
+}
@@ -0,0 +1,166 @@
+package migratetest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/seed"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// Database opens a new empty SQLite database, every :memory: connection is its own database
+func Database() *gorm.DB {
+	db, err := database.Open(database.SQLite, "", "", "", "", ":memory:")
+	if err != nil {
+		log.Fatalf("Could not open sqlite Error: %v \n", err)
+	}
+	return db
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db := Database()
+
+	err := migrate.Current(db)
+	assert.True(t, errors.Is(err, migrate.ErrSchemaOutdated))
+
+	applied, err := migrate.Up(db)
+	if err != nil {
+		t.Fatalf("Could not migrate up Error: %v \n", err)
+	}
+	assert.NotEmpty(t, applied)
+	assert.NoError(t, migrate.Current(db))
+	assert.True(t, db.Migrator().HasTable(&model.User{}))
+	assert.True(t, db.Migrator().HasTable(&model.Post{}))
+	assert.True(t, db.Migrator().HasTable(&model.Tag{}))
+	assert.True(t, db.Migrator().HasTable("post_categories"))
+	assert.True(t, db.Migrator().HasTable(&model.Comment{}))
+	assert.True(t, db.Migrator().HasTable(&model.UserToken{}))
+	assert.True(t, db.Migrator().HasColumn(&model.User{}, "EmailVerifiedAt"))
+	assert.True(t, db.Migrator().HasTable(&model.RecoveryCode{}))
+	assert.True(t, db.Migrator().HasColumn(&model.User{}, "TOTPSecret"))
+	assert.True(t, db.Migrator().HasTable(&model.LoginAttempt{}))
+	assert.True(t, db.Migrator().HasTable(&model.Lockout{}))
+
+	// Nothing is pending the second time
+	applied, err = migrate.Up(db)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+
+	list, err := migrate.List(db)
+	assert.NoError(t, err)
+	for _, s := range list {
+		assert.NotNil(t, s.AppliedAt)
+	}
+
+	reverted, err := migrate.Down(db, len(list))
+	if err != nil {
+		t.Fatalf("Could not migrate down Error: %v \n", err)
+	}
+	assert.Equal(t, len(list), len(reverted))
+	assert.False(t, db.Migrator().HasTable(&model.User{}))
+	assert.False(t, db.Migrator().HasTable("post_tags"))
+	assert.True(t, errors.Is(migrate.Current(db), migrate.ErrSchemaOutdated))
+}
+
+// legacyUser and legacyPost are the tables AutoMigrate built before migrations existed
+type legacyUser struct {
+	gorm.Model
+	Username string `gorm:"size:100;not null;unique;"`
+	Email    string `gorm:"size:100;not null;unique;"`
+	Password string `gorm:"size:100;not null;"`
+}
+
+func (legacyUser) TableName() string { return "users" }
+
+type legacyPost struct {
+	gorm.Model
+	Title    string `gorm:"size:100;not null;unique;"`
+	Content  string `gorm:"size:255;not null;"`
+	AuthorID uint
+	Author   legacyUser
+}
+
+func (legacyPost) TableName() string { return "posts" }
+
+func TestMigrateAdoptsAutoMigratedDatabase(t *testing.T) {
+	db := Database()
+
+	if err := db.AutoMigrate(&legacyUser{}, &legacyPost{}); err != nil {
+		t.Fatalf("Could not auto migrate Error: %v \n", err)
+	}
+	user := legacyUser{Username: "willywonka", Email: "willy@wonkamail.com", Password: "pass123"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("Could not create user Error: %v \n", err)
+	}
+	for _, title := range []string{"Hello, World!", "Hello World", "Ünïcode"} {
+		if err := db.Create(&legacyPost{Title: title, Content: "content", AuthorID: user.ID}).Error; err != nil {
+			t.Fatalf("Could not create post Error: %v \n", err)
+		}
+	}
+
+	_, err := migrate.Up(db)
+	assert.NoError(t, err)
+	assert.NoError(t, migrate.Current(db))
+	// Rebuilding users while posts reference them needs foreign keys off, they are back on afterwards
+	var enforced int
+	db.Raw("PRAGMA foreign_keys").Scan(&enforced)
+	assert.Equal(t, 1, enforced)
+
+	var count int64
+	db.Model(&model.User{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// Existing posts get slugs from their titles, and titles are free to repeat
+	var posts []model.Post
+	db.Order("id").Find(&posts)
+	slugs := []string{}
+	for _, p := range posts {
+		slugs = append(slugs, p.Slug)
+		// They were public before posts had a status
+		assert.Equal(t, model.PostPublished, p.Status)
+		assert.NotNil(t, p.PublishedAt)
+	}
+	assert.Equal(t, []string{"hello-world", "hello-world-2", "unicode"}, slugs)
+	assert.NoError(t, db.Create(&model.Post{Title: "Hello World", Slug: "hello-world-3", Content: "content", AuthorID: user.ID}).Error)
+	assert.Error(t, db.Create(&model.Post{Title: "Hello World", Slug: "hello-world", Content: "content", AuthorID: user.ID}).Error)
+}
+
+func TestSeed(t *testing.T) {
+	db := Database()
+
+	samples := []struct {
+		testID  int
+		migrate bool
+		force   bool
+		err     error
+	}{
+		{testID: 1, migrate: false, force: false, err: migrate.ErrSchemaOutdated},
+		{testID: 2, migrate: true, force: false, err: nil},
+		{testID: 3, migrate: false, force: false, err: seed.ErrNotEmpty},
+		{testID: 4, migrate: false, force: true, err: nil},
+	}
+
+	for _, v := range samples {
+		if v.migrate {
+			if _, err := migrate.Up(db); err != nil {
+				t.Fatalf("Could not migrate up Error: %v \n", err)
+			}
+		}
+		err := seed.Load(db, v.force)
+		if v.err == nil {
+			assert.NoError(t, err)
+			var count int64
+			db.Unscoped().Model(&model.User{}).Count(&count)
+			assert.Equal(t, int64(2), count)
+		} else {
+			assert.True(t, errors.Is(err, v.err), "got %v", err)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, err)
+	}
+}
@@ -0,0 +1,59 @@
+package modeltest
+
+import (
+	"log"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommentThreads(t *testing.T) {
+	post, err := seedOneUserAndOnePost()
+	if err != nil {
+		log.Fatalf("Could not seed user and post Error: %v \n", err)
+	}
+
+	root := model.Comment{Content: "Root", Status: model.CommentApproved, PostID: post.ID, AuthorID: post.AuthorID}
+	if _, err = root.CreateComment(server.DB); err != nil {
+		log.Fatalf("Could not seed comment Error: %v \n", err)
+	}
+	assert.Equal(t, post.AuthorID, root.Author.ID)
+
+	replies := []model.Comment{
+		{Content: "Approved reply", Status: model.CommentApproved, ParentID: &root.ID},
+		{Content: "Pending reply", Status: model.CommentPending, ParentID: &root.ID},
+	}
+	for i := range replies {
+		replies[i].PostID, replies[i].AuthorID = post.ID, post.AuthorID
+		if _, err = replies[i].CreateComment(server.DB); err != nil {
+			log.Fatalf("Could not seed comment Error: %v \n", err)
+		}
+	}
+
+	// A parent on another post is refused
+	stray := model.Comment{Content: "Stray", PostID: post.ID + 1, AuthorID: post.AuthorID, ParentID: &root.ID}
+	_, err = stray.CreateComment(server.DB)
+	assert.Equal(t, model.ErrInvalidParent, err)
+
+	q := model.CommentQuery{PostID: post.ID, TopLevel: true}
+	roots, info, err := (&model.Comment{}).ReadAllComments(server.DB, q)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), info.Total)
+
+	found, err := (&model.Comment{}).ReadCommentReplies(server.DB, q)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*found))
+
+	threads := model.Thread(*roots, *found)
+	assert.Equal(t, 1, len(threads[0].Replies))
+	assert.Equal(t, "Approved reply", threads[0].Replies[0].Content)
+
+	moderated, err := (&model.Comment{}).UpdateCommentStatus(server.DB, replies[1].ID, model.CommentSpam)
+	assert.Nil(t, err)
+	assert.Equal(t, model.CommentSpam, moderated.Status)
+
+	deleted, err := (&model.Comment{}).DeleteComment(server.DB, root.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
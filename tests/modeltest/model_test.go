@@ -6,10 +6,10 @@ import (
 	"os"
 	"testing"
 
+	"github.com/aaronprice00/goblog-mvc/api/config"
 	"github.com/aaronprice00/goblog-mvc/api/controller"
+	"github.com/aaronprice00/goblog-mvc/api/database"
 	"github.com/aaronprice00/goblog-mvc/api/model"
-	"github.com/joho/godotenv"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -17,12 +17,18 @@ var server = controller.Server{}
 var userInstance = model.User{}
 var postInstance = model.Post{}
 
+// db is where the tests run, read from the T_DB_* settings
+var db config.Database
+
 func TestMain(m *testing.M) {
-	var err error
-	err = godotenv.Load(os.ExpandEnv("../../.env"))
+	cfg, _, err := config.Load(config.Options{EnvFile: "../../.env", Prefix: "T_"})
+	if err == nil {
+		err = cfg.DB.Validate()
+	}
 	if err != nil {
 		log.Fatalf("Error getting env %v \n", err)
 	}
+	db = cfg.DB
 	Database()
 
 	os.Exit(m.Run())
@@ -31,8 +37,7 @@ func TestMain(m *testing.M) {
 func Database() {
 	var err error
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("T_DB_HOST"), os.Getenv("T_DB_PORT"), os.Getenv("T_DB_USER"), os.Getenv("T_DB_NAME"), os.Getenv("T_DB_PASSWORD"))
-	server.DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	server.DB, err = database.Open(db.Driver, db.User, db.Password, db.Port, db.Host, db.Name)
 	if err != nil {
 		fmt.Println("Could not connect to database")
 		log.Fatalf("Failed with error %v \n", err)
@@ -91,10 +96,11 @@ func seedUsers() ([]model.User, error) {
 
 func refreshUserAndPostTable() error {
 	var err error
-	if err = server.DB.Migrator().DropTable(&model.User{}, &model.Post{}); err != nil {
+	if err = server.DB.Migrator().DropTable(&model.User{}, &model.Post{}, &model.PostSlug{}, &model.Tag{}, &model.Category{}, "post_tags", "post_categories",
+		&model.Comment{}); err != nil {
 		return err
 	}
-	if err = server.DB.AutoMigrate(&model.User{}, &model.Post{}); err != nil {
+	if err = server.DB.AutoMigrate(&model.User{}, &model.Post{}, &model.PostSlug{}, &model.Tag{}, &model.Category{}, &model.Comment{}); err != nil {
 		return err
 	}
 	fmt.Println("Tables refreshed sucessfully")
@@ -118,9 +124,10 @@ func seedOneUserAndOnePost() (model.Post, error) {
 	post := model.Post{
 		Title:    "Under the sea",
 		Content:  "Life is better, down where it's wetter",
+		Status:   model.PostPublished,
 		AuthorID: user.ID,
 	}
-	if err = server.DB.Create(&post).Error; err != nil {
+	if _, err = post.CreatePost(server.DB); err != nil {
 		return model.Post{}, err
 	}
 	return post, nil
@@ -144,11 +151,13 @@ func seedUsersAndPosts() ([]model.User, []model.Post, error) {
 		{
 			Title:   "We got no troubles",
 			Content: "Life is the bubbles",
+			Status:  model.PostPublished,
 		},
 		{
 			Model:   gorm.Model{},
 			Title:   "ZHL16A-C",
 			Content: "Who is this Erik Baker fella anyhow?",
+			Status:  model.PostPublished,
 		},
 	}
 
@@ -158,7 +167,7 @@ func seedUsersAndPosts() ([]model.User, []model.Post, error) {
 		}
 		posts[i].AuthorID = users[i].ID
 
-		if err = server.DB.Create(&posts[i]).Error; err != nil {
+		if _, err = posts[i].CreatePost(server.DB); err != nil {
 			log.Fatalf("Could not seed posts table: %v \n", err)
 		}
 	}
@@ -3,8 +3,11 @@ package modeltest
 import (
 	"log"
 	"testing"
+	"time"
 
 	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
+	"github.com/aaronprice00/goblog-mvc/api/search"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,12 +19,61 @@ func TestFindAllPosts(t *testing.T) {
 	if _, _, err = seedUsersAndPosts(); err != nil {
 		log.Fatalf("Could not seed users and posts Error: %v \n", err)
 	}
-	posts, err := postInstance.ReadAllPosts(server.DB)
+	posts, info, err := postInstance.ReadAllPosts(server.DB, model.PostQuery{})
 	if err != nil {
 		t.Errorf("Could not find posts Error: %v \n", err)
 		return
 	}
 	assert.Equal(t, len(*posts), 2)
+	assert.Equal(t, info.Total, int64(2))
+	assert.Equal(t, info.Next, "")
+	for _, post := range *posts {
+		assert.Equal(t, post.AuthorID, post.Author.ID)
+	}
+}
+
+func TestFindAllPostsPaged(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh User and Post tables Error: %v \n", err)
+	}
+	users, _, err := seedUsersAndPosts()
+	if err != nil {
+		log.Fatalf("Could not seed users and posts Error: %v \n", err)
+	}
+
+	// First page holds one post and hands back a cursor to the second
+	q := model.PostQuery{Page: model.Page{Limit: 1, Sort: "title"}}
+	first, info, err := postInstance.ReadAllPosts(server.DB, q)
+	if err != nil {
+		t.Errorf("Could not find posts Error: %v \n", err)
+		return
+	}
+	assert.Equal(t, len(*first), 1)
+	assert.Equal(t, info.Total, int64(2))
+	assert.NotEqual(t, info.Next, "")
+
+	q.After = info.Next
+	second, info, err := postInstance.ReadAllPosts(server.DB, q)
+	if err != nil {
+		t.Errorf("Could not find posts Error: %v \n", err)
+		return
+	}
+	assert.Equal(t, len(*second), 1)
+	assert.Equal(t, info.Next, "")
+	assert.True(t, (*first)[0].Title < (*second)[0].Title)
+
+	// Filtering by author leaves one post
+	byAuthor, info, err := postInstance.ReadAllPosts(server.DB, model.PostQuery{AuthorID: users[1].ID})
+	if err != nil {
+		t.Errorf("Could not find posts Error: %v \n", err)
+		return
+	}
+	assert.Equal(t, len(*byAuthor), 1)
+	assert.Equal(t, info.Total, int64(1))
+
+	_, _, err = postInstance.ReadAllPosts(server.DB, model.PostQuery{Page: model.Page{Sort: "password"}})
+	assert.Equal(t, err, model.ErrInvalidSort)
 }
 
 func TestCreatePost(t *testing.T) {
@@ -119,3 +171,128 @@ func TestDeletePost(t *testing.T) {
 
 	assert.Equal(t, isDeleted, int64(1))
 }
+
+func TestPublishDuePosts(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh User and Post table Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	at := time.Now().Add(time.Hour)
+	post := model.Post{Title: "Later", Content: "Scheduled", Status: model.PostScheduled, PublishedAt: &at, AuthorID: user.ID}
+	if _, err = post.CreatePost(server.DB); err != nil {
+		log.Fatalf("Could not seed post Error: %v \n", err)
+	}
+
+	published, err := model.PublishDuePosts(server.DB, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), published)
+
+	published, err = model.PublishDuePosts(server.DB, at.Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), published)
+
+	found, err := (&model.Post{}).ReadPostByID(server.DB, post.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, model.PostPublished, found.Status)
+}
+
+func TestPostTags(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh User and Post table Error: %v \n", err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	posts := []model.Post{
+		{Title: "Gophers", Content: "dig", Status: model.PostPublished, Tags: []model.Tag{{Name: "Go"}, {Name: "Web Dev"}},
+			Categories: []model.Category{{Name: "Programming"}}},
+		{Title: "Channels", Content: "flow", Status: model.PostPublished, Tags: []model.Tag{{Name: "go"}}},
+		{Title: "Someday", Content: "maybe", Tags: []model.Tag{{Name: "GO"}}},
+	}
+	for i := range posts {
+		posts[i].AuthorID = user.ID
+		posts[i].Prepare()
+		if _, err = posts[i].CreatePost(server.DB); err != nil {
+			log.Fatalf("Could not seed post Error: %v \n", err)
+		}
+	}
+
+	// Drafts aren't counted
+	tags, err := model.ReadAllTags(server.DB)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*tags))
+	assert.Equal(t, "Go", (*tags)[0].Name)
+	assert.Equal(t, int64(2), *(*tags)[0].PostCount)
+	assert.Equal(t, "web-dev", (*tags)[1].Slug)
+	assert.Equal(t, int64(1), *(*tags)[1].PostCount)
+
+	categories, err := model.ReadAllCategories(server.DB)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*categories))
+	assert.Equal(t, int64(1), *(*categories)[0].PostCount)
+
+	found, info, err := (&model.Post{}).ReadAllPosts(server.DB, model.PostQuery{Tag: "go"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), info.Total)
+	assert.Equal(t, 1, len((*found)[0].Tags))
+
+	found, _, err = (&model.Post{}).ReadAllPosts(server.DB, model.PostQuery{Category: "programming"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*found))
+	assert.Equal(t, 2, len((*found)[0].Tags))
+
+	// Nil tags leave the post's alone, an empty list clears them
+	update := model.Post{Title: posts[0].Title, Content: "dig deeper", AuthorID: user.ID}
+	update.ID = posts[0].ID
+	update.Prepare()
+	updated, err := update.UpdatePost(server.DB)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(updated.Tags))
+
+	update.Tags = []model.Tag{}
+	updated, err = update.UpdatePost(server.DB)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(updated.Tags))
+	assert.Equal(t, 1, len(updated.Categories))
+}
+
+func TestSearchPostsWithIndex(t *testing.T) {
+	var err error
+	if err = refreshUserAndPostTable(); err != nil {
+		log.Fatalf("Could not refresh User and Post table Error: %v \n", err)
+	}
+	if _, _, err = seedUsersAndPosts(); err != nil {
+		log.Fatalf("Could not seed users and posts Error: %v \n", err)
+	}
+	// The fallback for databases without full-text search, filled from the table on the first search
+	posts := &repository.GormPostRepository{DB: server.DB, Index: search.NewInvertedIndex()}
+	q := model.SearchQuery{Text: "bubbles"}
+
+	results, info, err := posts.SearchPosts(q)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), info.Total)
+	assert.Equal(t, "Life is the <mark>bubbles</mark>", (*results)[0].ContentHighlight)
+	assert.NotEmpty(t, (*results)[0].Post.Author.Username)
+
+	post := model.Post{Title: "More bubbles", Content: "Fizz", Status: model.PostPublished, AuthorID: (*results)[0].Post.AuthorID}
+	if _, err = posts.CreatePost(&post); err != nil {
+		log.Fatalf("Could not seed post Error: %v \n", err)
+	}
+	results, _, err = posts.SearchPosts(q)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(*results))
+	assert.Equal(t, post.ID, (*results)[0].Post.ID)
+
+	if _, err = posts.DeletePost(post.ID); err != nil {
+		log.Fatalf("Could not delete post Error: %v \n", err)
+	}
+	results, _, err = posts.SearchPosts(q)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*results))
+}
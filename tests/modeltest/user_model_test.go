@@ -1,8 +1,10 @@
 package modeltest
 
 import (
+	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"github.com/aaronprice00/goblog-mvc/api/model"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +20,7 @@ func TestFindAllUsers(t *testing.T) {
 		log.Fatalf("Could not seed Users, Error: %v \n", err)
 	}
 
-	usersReceived, err := userInstance.ReadAllUsers(server.DB)
+	usersReceived, _, err := userInstance.ReadAllUsers(server.DB, model.UserQuery{})
 	if err != nil {
 		t.Errorf("Could not get users Error: %v \n", err)
 		return
@@ -117,3 +119,234 @@ func TestDeleteUser(t *testing.T) {
 
 	assert.Equal(t, isDeleted, int64(1))
 }
+
+func TestVerifyEmail(t *testing.T) {
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	assert.False(t, user.Verified())
+
+	verified, err := userInstance.VerifyEmail(server.DB, user.ID)
+	if err != nil {
+		t.Errorf("Could not verify user Error: %v \n", err)
+	}
+	assert.True(t, verified.Verified())
+
+	// Verifying again keeps the first time
+	first := *verified.EmailVerifiedAt
+	again, err := userInstance.VerifyEmail(server.DB, user.ID)
+	assert.NoError(t, err)
+	assert.True(t, first.Equal(*again.EmailVerifiedAt))
+
+	// Saving the same email keeps it verified, a new one isn't
+	same := model.User{Username: user.Username, Email: user.Email, Password: "pass123"}
+	updated, err := same.UpdateUser(server.DB, user.ID)
+	assert.NoError(t, err)
+	assert.True(t, updated.Verified())
+	changed := model.User{Username: user.Username, Email: "wonka@chocolate.com", Password: "pass123"}
+	updated, err = changed.UpdateUser(server.DB, user.ID)
+	assert.NoError(t, err)
+	assert.False(t, updated.Verified())
+}
+
+func TestUserTokens(t *testing.T) {
+	var err error
+	if err = server.DB.Migrator().DropTable(&model.UserToken{}); err != nil {
+		log.Fatal(err)
+	}
+	if err = server.DB.AutoMigrate(&model.UserToken{}); err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	first := model.UserToken{UserID: user.ID, Purpose: model.ResetPasswordPurpose, TokenHash: "first", ExpiresAt: expires}
+	verify := model.UserToken{UserID: user.ID, Purpose: model.VerifyEmailPurpose, TokenHash: "verify", ExpiresAt: expires}
+	second := model.UserToken{UserID: user.ID, Purpose: model.ResetPasswordPurpose, TokenHash: "second", ExpiresAt: expires}
+	for _, ut := range []*model.UserToken{&first, &verify, &second} {
+		if _, err = ut.CreateUserToken(server.DB); err != nil {
+			t.Errorf("Could not create user token Error: %v \n", err)
+		}
+	}
+
+	samples := []struct {
+		testID int
+		hash   string
+		active bool
+	}{
+		// A newer token of the same purpose spends the older one, other purposes are left alone
+		{testID: 1, hash: "first", active: false},
+		{testID: 2, hash: "verify", active: true},
+		{testID: 3, hash: "second", active: true},
+	}
+
+	for _, v := range samples {
+		ut := model.UserToken{}
+		stored, err := ut.ReadUserTokenByHash(server.DB, v.hash)
+		if err != nil {
+			t.Errorf("Could not read user token Error: %v \n", err)
+		}
+		assert.Equal(t, v.active, stored.Active())
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+
+	// Only the first use counts
+	stored, _ := (&model.UserToken{}).ReadUserTokenByHash(server.DB, "second")
+	assert.NoError(t, stored.UseUserToken(server.DB))
+	assert.False(t, stored.Active())
+	stale, _ := (&model.UserToken{}).ReadUserTokenByHash(server.DB, "second")
+	stale.UsedAt = nil
+	assert.Equal(t, model.ErrTokenUsed, stale.UseUserToken(server.DB))
+}
+
+func TestRefreshTokens(t *testing.T) {
+	var err error
+	if err = server.DB.Migrator().DropTable(&model.RefreshToken{}, &model.RevokedToken{}); err != nil {
+		log.Fatal(err)
+	}
+	if err = server.DB.AutoMigrate(&model.RefreshToken{}, &model.RevokedToken{}); err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	rt := model.RefreshToken{UserID: user.ID, TokenHash: "refresh", AccessJTI: "access", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err = rt.CreateRefreshToken(server.DB); err != nil {
+		t.Errorf("Could not create refresh token Error: %v \n", err)
+	}
+
+	// Of two requests that read the token before either revoked it, only the first gets through
+	first, _ := (&model.RefreshToken{}).ReadRefreshTokenByHash(server.DB, "refresh")
+	second, _ := (&model.RefreshToken{}).ReadRefreshTokenByHash(server.DB, "refresh")
+	assert.NoError(t, first.RevokeRefreshToken(server.DB))
+	assert.Equal(t, model.ErrTokenUsed, second.RevokeRefreshToken(server.DB))
+	assert.NoError(t, model.RevokeUserTokens(server.DB, user.ID))
+
+	revoked, _ := model.IsTokenRevoked(server.DB, "access")
+	assert.True(t, revoked)
+	// The jti stays listed until its access token expired
+	purged, err := model.PurgeRevokedTokens(server.DB, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = model.PurgeRevokedTokens(server.DB, time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	revoked, _ = model.IsTokenRevoked(server.DB, "access")
+	assert.False(t, revoked)
+}
+
+func TestTwoFactor(t *testing.T) {
+	var err error
+	if err = server.DB.Migrator().DropTable(&model.RecoveryCode{}); err != nil {
+		log.Fatal(err)
+	}
+	if err = server.DB.AutoMigrate(&model.RecoveryCode{}); err != nil {
+		log.Fatal(err)
+	}
+	user, err := seedOneUser()
+	if err != nil {
+		log.Fatalf("Could not seed user Error: %v \n", err)
+	}
+
+	if err = model.SetTOTPSecret(server.DB, user.ID, "SECRET"); err != nil {
+		t.Errorf("Could not set secret Error: %v \n", err)
+	}
+	if err = model.EnableTOTP(server.DB, user.ID, 100, []string{"one", "two"}); err != nil {
+		t.Errorf("Could not enable two-factor Error: %v \n", err)
+	}
+	enabled, err := userInstance.ReadUserByID(server.DB, user.ID)
+	assert.NoError(t, err)
+	assert.True(t, enabled.TwoFactor())
+	assert.Equal(t, "SECRET", enabled.TOTPSecret)
+
+	samples := []struct {
+		testID int
+		step   int64
+		hash   string
+		err    error
+	}{
+		// Steps only move forward
+		{testID: 1, step: 100, err: model.ErrTokenUsed},
+		{testID: 2, step: 101},
+		{testID: 3, step: 99, err: model.ErrTokenUsed},
+		// Recovery codes work once
+		{testID: 4, hash: "one"},
+		{testID: 5, hash: "one", err: model.ErrTokenUsed},
+		{testID: 6, hash: "three", err: model.ErrTokenUsed},
+	}
+
+	for _, v := range samples {
+		if v.hash == "" {
+			err = model.UseTOTPStep(server.DB, user.ID, v.step)
+		} else {
+			err = model.UseRecoveryCode(server.DB, user.ID, v.hash)
+		}
+		assert.Equal(t, v.err, err)
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+
+	if err = model.DisableTOTP(server.DB, user.ID); err != nil {
+		t.Errorf("Could not disable two-factor Error: %v \n", err)
+	}
+	disabled, err := userInstance.ReadUserByID(server.DB, user.ID)
+	assert.NoError(t, err)
+	assert.False(t, disabled.TwoFactor())
+	assert.Empty(t, disabled.TOTPSecret)
+	assert.Equal(t, model.ErrTokenUsed, model.UseRecoveryCode(server.DB, user.ID, "two"))
+}
+
+func TestLoginAttempts(t *testing.T) {
+	var err error
+	if err = server.DB.Migrator().DropTable(&model.LoginAttempt{}, &model.Lockout{}); err != nil {
+		log.Fatal(err)
+	}
+	if err = server.DB.AutoMigrate(&model.LoginAttempt{}, &model.Lockout{}); err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now().Truncate(time.Second)
+	samples := []struct {
+		testID   int
+		subject  string
+		at       time.Time
+		failures int
+	}{
+		{testID: 1, subject: "email:a@b.com", at: start, failures: 1},
+		{testID: 2, subject: "email:a@b.com", at: start.Add(time.Minute), failures: 2},
+		{testID: 3, subject: "ip:192.0.2.1", at: start.Add(time.Minute), failures: 1},
+		// Failures older than the window are forgotten
+		{testID: 4, subject: "email:a@b.com", at: start.Add(3 * time.Hour), failures: 1},
+	}
+
+	for _, v := range samples {
+		la, err := model.RecordLoginFailure(server.DB, v.subject, v.at, time.Hour)
+		if err != nil {
+			t.Errorf("Could not record failure Error: %v \n", err)
+		}
+		assert.Equal(t, v.failures, la.Failures)
+		assert.True(t, v.at.Equal(la.LastFailedAt))
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+
+	assert.NoError(t, model.ClearLoginAttempts(server.DB, "email:a@b.com"))
+	_, err = model.ReadLoginAttempt(server.DB, "email:a@b.com")
+	assert.Error(t, err)
+	_, err = model.ReadLoginAttempt(server.DB, "ip:192.0.2.1")
+	assert.NoError(t, err)
+
+	for _, subject := range []string{"email:a@b.com", "ip:192.0.2.1", "email:a@b.com"} {
+		l := model.Lockout{Subject: subject, Failures: 10, LockedUntil: start.Add(time.Hour)}
+		if _, err = l.CreateLockout(server.DB); err != nil {
+			t.Errorf("Could not create lockout Error: %v \n", err)
+		}
+	}
+	lockouts, info, err := (&model.Lockout{}).ReadAllLockouts(server.DB, model.LockoutQuery{Subject: "email:a@b.com"})
+	assert.NoError(t, err)
+	assert.Len(t, *lockouts, 2)
+	assert.Equal(t, int64(2), info.Total)
+}
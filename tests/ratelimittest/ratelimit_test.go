@@ -0,0 +1,146 @@
+package ratelimittest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/auth"
+	"github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/aaronprice00/goblog-mvc/api/ratelimit"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	samples := []struct {
+		testID int
+		input  string
+		limit  ratelimit.Limit
+		err    bool
+	}{
+		{testID: 1, input: "10/1m", limit: ratelimit.Limit{Burst: 10, Per: time.Minute}},
+		{testID: 2, input: " 5/1h ", limit: ratelimit.Limit{Burst: 5, Per: time.Hour}},
+		{testID: 3, input: "off", limit: ratelimit.Limit{}},
+		{testID: 4, input: "0", limit: ratelimit.Limit{}},
+		{testID: 5, input: "10", err: true},
+		{testID: 6, input: "-1/1m", err: true},
+		{testID: 7, input: "10/soon", err: true},
+	}
+
+	for _, v := range samples {
+		limit, err := ratelimit.ParseLimit(v.input)
+		if v.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, v.limit, limit)
+		}
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Burst: 3, Per: 3 * time.Second}
+	start := time.Now()
+
+	samples := []struct {
+		testID     int
+		key        string
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{testID: 1, key: "a", allowed: true, remaining: 2},
+		{testID: 2, key: "a", allowed: true, remaining: 1},
+		{testID: 3, key: "a", allowed: true, remaining: 0},
+		{testID: 4, key: "a", allowed: false, remaining: 0, retryAfter: time.Second},
+		// Keys have their own buckets
+		{testID: 5, key: "b", allowed: true, remaining: 2},
+		// One token a second comes back
+		{testID: 6, key: "a", after: time.Second, allowed: true, remaining: 0},
+		{testID: 7, key: "a", after: 1500 * time.Millisecond, allowed: false, retryAfter: 500 * time.Millisecond},
+		// Never more than the burst
+		{testID: 8, key: "a", after: time.Hour, allowed: true, remaining: 2},
+	}
+
+	for _, v := range samples {
+		res, err := store.Take(v.key, limit, start.Add(v.after))
+		assert.NoError(t, err)
+		assert.Equal(t, v.allowed, res.Allowed)
+		assert.Equal(t, v.remaining, res.Remaining)
+		assert.InDelta(t, v.retryAfter.Seconds(), res.RetryAfter.Seconds(), 0.001)
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+
+	// Buckets that refilled are forgotten, b was by the time an hour had passed
+	assert.Equal(t, 1, store.Len())
+	_, err := store.Take("c", limit, start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestSetMiddlewareRateLimit(t *testing.T) {
+	issuer := auth.NewIssuer("ratelimittest")
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		ratelimit.Auth:    {Burst: 2, Per: time.Minute},
+		ratelimit.Default: {},
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	limited := middleware.SetMiddlewareRateLimit(limiter, issuer, ratelimit.Auth, ok)
+	unlimited := middleware.SetMiddlewareRateLimit(limiter, issuer, ratelimit.Default, ok)
+	token, _, err := issuer.CreateToken(7, auth.RoleAuthor)
+	if err != nil {
+		t.Fatalf("Could not create token, Error: %v \n", err)
+	}
+
+	samples := []struct {
+		testID     int
+		handler    http.HandlerFunc
+		ip         string
+		token      string
+		statusCode int
+		remaining  string
+	}{
+		{testID: 1, handler: limited, ip: "192.0.2.1", statusCode: 200, remaining: "1"},
+		{testID: 2, handler: limited, ip: "192.0.2.1", statusCode: 200, remaining: "0"},
+		{testID: 3, handler: limited, ip: "192.0.2.1", statusCode: 429, remaining: "0"},
+		{testID: 4, handler: limited, ip: "192.0.2.2", statusCode: 200, remaining: "1"},
+		// A signed in user has a budget of their own wherever they come from
+		{testID: 5, handler: limited, ip: "192.0.2.1", token: token, statusCode: 200, remaining: "1"},
+		{testID: 6, handler: limited, ip: "192.0.2.2", token: token, statusCode: 200, remaining: "0"},
+		{testID: 7, handler: limited, ip: "192.0.2.3", token: token, statusCode: 429, remaining: "0"},
+		{testID: 8, handler: unlimited, ip: "192.0.2.1", statusCode: 200},
+	}
+
+	for _, v := range samples {
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = v.ip + ":52000"
+		if v.token != "" {
+			req.Header.Set("Authorization", "Bearer "+v.token)
+		}
+		rr := httptest.NewRecorder()
+		v.handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, v.statusCode, rr.Code)
+		assert.Equal(t, v.remaining, rr.Header().Get("RateLimit-Remaining"))
+		if v.remaining != "" {
+			assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+			assert.NotEmpty(t, rr.Header().Get("RateLimit-Reset"))
+		}
+		if v.statusCode == 429 {
+			assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+			assert.Equal(t, response.ProblemContentType, rr.Header().Get("Content-Type"))
+			responseMap := make(map[string]interface{})
+			json.Unmarshal(rr.Body.Bytes(), &responseMap)
+			assert.Equal(t, "Too Many Requests", responseMap["detail"])
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package repositorytest
+
+import (
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
+	"github.com/aaronprice00/goblog-mvc/api/util/formaterror"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func seedMemory(t *testing.T) (repository.Repositories, model.User, model.Post) {
+	repos := repository.NewMemoryRepositories()
+	user := model.User{
+		Username: "jcousteau",
+		Email:    "jaques@cousteau.com",
+		Password: "pass123",
+	}
+	if _, err := repos.Users.CreateUser(&user); err != nil {
+		t.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	post := model.Post{
+		Title:    "Under the sea",
+		Content:  "Life is better, down where it's wetter",
+		Status:   model.PostPublished,
+		AuthorID: user.ID,
+	}
+	if _, err := repos.Posts.CreatePost(&post); err != nil {
+		t.Fatalf("Could not seed post Error: %v \n", err)
+	}
+	return repos, user, post
+}
+
+func TestMemoryUniqueColumns(t *testing.T) {
+	repos, user, post := seedMemory(t)
+
+	_, err := repos.Users.CreateUser(&model.User{Username: "abuhlmann", Email: user.Email, Password: "pass123"})
+	assert.Equal(t, "Email Already Used", formaterror.FormatError(err).Error())
+
+	_, err = repos.Users.CreateUser(&model.User{Username: user.Username, Email: "albert@buhlmann.com", Password: "pass123"})
+	assert.Equal(t, "Username Already Taken", formaterror.FormatError(err).Error())
+
+	// Titles may repeat, slugs may not
+	again, err := repos.Posts.CreatePost(&model.Post{Title: post.Title, Content: "again", AuthorID: user.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, post.Slug+"-2", again.Slug)
+
+	_, err = repos.Posts.CreatePost(&model.Post{Title: "Another", Slug: post.Slug, Content: "again", AuthorID: user.ID})
+	assert.Equal(t, model.ErrSlugTaken, err)
+}
+
+func TestMemorySoftDelete(t *testing.T) {
+	repos, user, post := seedMemory(t)
+
+	deleted, err := repos.Posts.DeletePost(post.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = repos.Posts.ReadPostByID(post.ID)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	// Deleting again affects nothing, and the slug stays taken like the unique index
+	deleted, err = repos.Posts.DeletePost(post.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), deleted)
+	again, err := repos.Posts.CreatePost(&model.Post{Title: post.Title, Content: "again", AuthorID: user.ID})
+	assert.Nil(t, err)
+	assert.NotEqual(t, post.Slug, again.Slug)
+}
+
+func TestMemoryAuthorAssembly(t *testing.T) {
+	repos, user, post := seedMemory(t)
+
+	found, err := repos.Posts.ReadPostByID(post.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, found.Author.ID)
+	assert.Equal(t, user.Username, found.Author.Username)
+
+	posts, info, err := repos.Posts.ReadAllPosts(model.PostQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), info.Total)
+	assert.Equal(t, user.Username, (*posts)[0].Author.Username)
+
+	// Passwords are hashed on the way in
+	assert.Nil(t, model.VerifyPassword(found.Author.Password, "pass123"))
+}
@@ -0,0 +1,76 @@
+package schedulertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
+	"github.com/aaronprice00/goblog-mvc/api/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerPublishesDuePosts(t *testing.T) {
+	repos := repository.NewMemoryRepositories()
+	user := model.User{Username: "jcousteau", Email: "jaques@cousteau.com", Password: "pass123"}
+	if _, err := repos.Users.CreateUser(&user); err != nil {
+		t.Fatalf("Could not seed user Error: %v \n", err)
+	}
+	at := time.Now().Add(time.Hour)
+	post := model.Post{Title: "Under the sea", Content: "Soon", Status: model.PostScheduled, PublishedAt: &at, AuthorID: user.ID}
+	if _, err := repos.Posts.CreatePost(&post); err != nil {
+		t.Fatalf("Could not seed post Error: %v \n", err)
+	}
+
+	s := scheduler.New(repos.Posts, 0)
+	assert.Equal(t, scheduler.DefaultInterval, s.Interval)
+
+	// Not due yet
+	assert.Equal(t, int64(0), s.Tick(time.Now()))
+	found, _ := repos.Posts.ReadPostByID(post.ID)
+	assert.Equal(t, model.PostScheduled, found.Status)
+
+	assert.Equal(t, int64(1), s.Tick(at.Add(time.Second)))
+	found, _ = repos.Posts.ReadPostByID(post.ID)
+	assert.Equal(t, model.PostPublished, found.Status)
+	assert.Equal(t, at.Unix(), found.PublishedAt.Unix())
+
+	// Already published, nothing left to do
+	assert.Equal(t, int64(0), s.Tick(at.Add(time.Minute)))
+}
+
+func TestSchedulerPurgesRevokedTokens(t *testing.T) {
+	repos := repository.NewMemoryRepositories()
+	now := time.Now()
+	for jti, expiresAt := range map[string]time.Time{"expired": now.Add(-time.Minute), "live": now.Add(time.Hour)} {
+		if err := repos.Tokens.RevokeToken(jti, expiresAt); err != nil {
+			t.Fatalf("Could not seed revoked token Error: %v \n", err)
+		}
+	}
+
+	s := scheduler.New(repos.Posts, 0)
+	s.Tokens = repos.Tokens
+	s.Tick(now)
+
+	revoked, _ := repos.Tokens.IsRevoked("expired")
+	assert.False(t, revoked)
+	revoked, _ = repos.Tokens.IsRevoked("live")
+	assert.True(t, revoked)
+}
+
+func TestSchedulerStops(t *testing.T) {
+	s := scheduler.New(repository.NewMemoryRepositories().Posts, time.Millisecond)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.Run(stop)
+		close(done)
+	}()
+	close(stop)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Scheduler did not stop")
+	}
+}
@@ -0,0 +1,71 @@
+package searchtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerms(t *testing.T) {
+	samples := []struct {
+		testID int
+		query  string
+		terms  []string
+	}{
+		{testID: 1, query: "Gophers", terms: []string{"gopher"}},
+		{testID: 2, query: "the diving and the DIVING", terms: []string{"div"}},
+		{testID: 3, query: "Café stories", terms: []string{"cafe", "story"}},
+		{testID: 4, query: "pressed glass", terms: []string{"press", "glass"}},
+		{testID: 5, query: "the a of", terms: []string{}},
+		{testID: 6, query: "go-1.15 <b>", terms: []string{"go", "1", "15", "b"}},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, v.terms, search.Terms(v.query))
+		fmt.Printf("%v Finished\n", v.testID)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	terms := search.Terms("gopher")
+	assert.Equal(t, "Happy <mark>Gophers</mark> &amp; <mark>gopher</mark>&lt;3", search.Highlight("Happy Gophers & gopher<3", terms))
+	assert.Equal(t, "&lt;script&gt;", search.Highlight("<script>", []string{"alert"}))
+
+	long := strings.Repeat("filler ", 40) + "the gopher dug " + strings.Repeat("filler ", 40)
+	snippet := search.Snippet(long, terms)
+	assert.True(t, strings.HasPrefix(snippet, "…filler"))
+	assert.True(t, strings.HasSuffix(snippet, "filler…"))
+	assert.Contains(t, snippet, "<mark>gopher</mark> dug")
+	assert.Equal(t, search.SnippetWords, len(strings.Fields(snippet)))
+
+	assert.Equal(t, "Short and sweet", search.Snippet("Short and sweet", terms))
+}
+
+func TestInvertedIndex(t *testing.T) {
+	ix := search.NewInvertedIndex()
+	ix.Add(search.Document{ID: 1, Title: "Gophers", Content: "Gophers dig tunnels"})
+	ix.Add(search.Document{ID: 2, Title: "Tunnels", Content: "A gopher lives here"})
+	ix.Add(search.Document{ID: 3, Title: "Birds", Content: "Birds fly"})
+
+	// Title words weigh more than content words
+	matches := ix.Search("gopher")
+	assert.Equal(t, 2, len(matches))
+	assert.Equal(t, uint(1), matches[0].ID)
+	assert.True(t, matches[0].Score > matches[1].Score)
+
+	// Every term has to match
+	matches = ix.Search("gopher fly")
+	assert.Equal(t, 0, len(matches))
+	matches = ix.Search("tunnels gophers")
+	assert.Equal(t, 2, len(matches))
+
+	// Adding again replaces, removing forgets
+	ix.Add(search.Document{ID: 2, Title: "Tunnels", Content: "Moles live here"})
+	assert.Equal(t, 1, len(ix.Search("gopher")))
+	ix.Remove(1)
+	assert.Equal(t, 0, len(ix.Search("gopher")))
+	assert.Equal(t, 0, len(ix.Search("the")))
+}
@@ -0,0 +1,163 @@
+package tracingtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/database"
+	"github.com/aaronprice00/goblog-mvc/api/middleware"
+	"github.com/aaronprice00/goblog-mvc/api/migrate"
+	"github.com/aaronprice00/goblog-mvc/api/repository"
+	"github.com/aaronprice00/goblog-mvc/api/response"
+	"github.com/aaronprice00/goblog-mvc/api/tracing"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// attr is the value of the span's attribute, empty when it has none
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestSetMiddlewareTracing(t *testing.T) {
+	if _, err := tracing.Setup(tracing.Config{Exporter: tracing.Off}); err != nil {
+		log.Fatalf("Could not set up tracing, Error: %v \n", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, err := database.Open(database.SQLite, "", "", "", "", ":memory:")
+	if err != nil {
+		log.Fatalf("Could not open database, Error: %v \n", err)
+	}
+	// Queries reach the logs only as spans, never through GORM's logger and the values it prints
+	assert.Equal(t, gormlogger.Discard, db.Logger)
+	if _, err = migrate.Up(db); err != nil {
+		log.Fatalf("Could not migrate, Error: %v \n", err)
+	}
+	if err = tracing.RegisterCallbacks(db); err != nil {
+		log.Fatalf("Could not register callbacks, Error: %v \n", err)
+	}
+	repos := repository.NewGormRepositories(db)
+
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.SetMiddlewareTracing(next.ServeHTTP)
+	})
+	router.HandleFunc("/posts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := repos.WithContext(r.Context()).Posts.ReadPostByID(42); err != nil {
+			response.ERROR(w, &apierror.NotFound{Resource: "Post"})
+			return
+		}
+		response.JSON(w, http.StatusOK, nil)
+	})
+	router.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		response.ERROR(w, errors.New("boom"))
+	})
+
+	samples := []struct {
+		testID      int
+		path        string
+		traceparent string
+		name        string
+		status      codes.Code
+		queries     int
+	}{
+		{testID: 1, path: "/posts/42", name: "GET /posts/{id}", queries: 1},
+		// The caller's trace is carried on
+		{testID: 2, path: "/posts/42", traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", name: "GET /posts/{id}", queries: 1},
+		{testID: 3, path: "/fail", name: "GET /fail", status: codes.Error},
+	}
+	for _, v := range samples {
+		before := len(recorder.Ended())
+		req, _ := http.NewRequest("GET", v.path, nil)
+		if v.traceparent != "" {
+			req.Header.Set("traceparent", v.traceparent)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		ended := recorder.Ended()[before:]
+		server := ended[len(ended)-1]
+		assert.Equal(t, v.name, server.Name())
+		assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+		assert.Equal(t, v.status, server.Status().Code)
+		assert.Equal(t, fmt.Sprint(rr.Code), attr(server, "http.status_code"))
+		if v.status == codes.Error {
+			// The error the client was only told went wrong is kept on the span
+			if assert.Len(t, server.Events(), 1) {
+				assert.Equal(t, "exception", server.Events()[0].Name)
+			}
+		}
+		if v.traceparent != "" {
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+			assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+		}
+
+		// Queries run with the request context are children of the route's span
+		queries := ended[:len(ended)-1]
+		assert.Len(t, queries, v.queries)
+		for _, q := range queries {
+			assert.Equal(t, "gorm.query", q.Name())
+			assert.Equal(t, server.SpanContext().SpanID(), q.Parent().SpanID())
+			assert.Equal(t, "sqlite", attr(q, "db.system"))
+			assert.True(t, strings.Contains(attr(q, "db.statement"), "FROM `posts`"))
+			// A missing row isn't a failed query
+			assert.Equal(t, codes.Unset, q.Status().Code)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, rr.Code)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	samples := []struct {
+		testID int
+		config tracing.Config
+		err    bool
+	}{
+		{testID: 1, config: tracing.Config{Exporter: tracing.File, Path: path, SampleRatio: 1}},
+		{testID: 2, config: tracing.Config{Exporter: tracing.File}, err: true},
+		{testID: 3, config: tracing.Config{Exporter: "jaeger"}, err: true},
+		{testID: 4, config: tracing.Config{Exporter: tracing.Off}},
+	}
+	for _, v := range samples {
+		flush, err := tracing.Setup(v.config)
+		if v.err {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			_, span := tracing.Tracer().Start(context.Background(), fmt.Sprintf("sample %d", v.testID))
+			span.End()
+			assert.NoError(t, flush(context.Background()))
+		}
+		fmt.Printf("%v Finished w/ error: %v\n", v.testID, err)
+	}
+
+	// Only the span started while the file exporter was set up was written, once flushed
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(b), `"Name":"sample 1"`))
+	assert.False(t, strings.Contains(string(b), "sample 4"))
+}
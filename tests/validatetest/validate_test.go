@@ -0,0 +1,187 @@
+package validatetest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aaronprice00/goblog-mvc/api/apierror"
+	"github.com/aaronprice00/goblog-mvc/api/model"
+	"github.com/aaronprice00/goblog-mvc/api/validate"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules(t *testing.T) {
+	samples := []struct {
+		testID  int
+		rule    validate.Rule
+		value   string
+		code    string
+		message string
+	}{
+		{testID: 1, rule: validate.Required, value: "", code: apierror.CodeRequired, message: "Required: Name"},
+		{testID: 2, rule: validate.Required, value: "x"},
+		{testID: 3, rule: validate.MaxLength(3), value: "abcd", code: apierror.CodeTooLong, message: "Name Too Long: max 3 characters"},
+		// Lengths count characters, not bytes
+		{testID: 4, rule: validate.MaxLength(3), value: "äöü"},
+		{testID: 5, rule: validate.MinLength(3), value: "ab", code: validate.CodeTooShort, message: "Name Too Short: min 3 characters"},
+		{testID: 6, rule: validate.Matches(regexp.MustCompile(`^[a-z]+$`), "use a to z"), value: "a1", code: apierror.CodeInvalid, message: "Invalid Name: use a to z"},
+		{testID: 7, rule: validate.Email, value: "jcousteau.gmail.com", code: apierror.CodeInvalid, message: "Invalid Name"},
+		{testID: 8, rule: validate.Email, value: "jcousteau@gmail.com"},
+	}
+	for _, v := range samples {
+		fe := v.rule("Name", v.value)
+		if v.code == "" {
+			assert.Nil(t, fe)
+		} else if assert.NotNil(t, fe) {
+			assert.Equal(t, v.code, fe.Code)
+			assert.Equal(t, v.message, fe.Message)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, v.code)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	samples := []struct {
+		testID   int
+		policy   validate.PasswordPolicy
+		password string
+		code     string
+	}{
+		{testID: 1, policy: validate.DefaultPasswordPolicy, password: "pass123"},
+		{testID: 2, policy: validate.DefaultPasswordPolicy, password: "pass", code: validate.CodeTooShort},
+		{testID: 3, policy: validate.PasswordPolicy{MinLength: 8, MinClasses: 3}, password: "password1", code: validate.CodeWeak},
+		{testID: 4, policy: validate.PasswordPolicy{MinLength: 8, MinClasses: 3}, password: "Password1"},
+		{testID: 5, policy: validate.PasswordPolicy{MinLength: 8, MinClasses: 4}, password: "Password1!"},
+		// bcrypt ignores what comes after 72 bytes
+		{testID: 6, policy: validate.DefaultPasswordPolicy, password: strings.Repeat("a", 73), code: apierror.CodeTooLong},
+	}
+	for _, v := range samples {
+		fe := v.policy.Rule("Password", v.password)
+		if v.code == "" {
+			assert.Nil(t, fe)
+		} else if assert.NotNil(t, fe) {
+			assert.Equal(t, v.code, fe.Code)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, v.code)
+	}
+}
+
+func TestMailServer(t *testing.T) {
+	defer func(lookup func(string) ([]*net.MX, error)) { validate.LookupMX = lookup }(validate.LookupMX)
+	validate.LookupMX = func(domain string) ([]*net.MX, error) {
+		switch domain {
+		case "gmail.com":
+			return []*net.MX{{Host: "mx.gmail.com.", Pref: 10}}, nil
+		case "nomail.example.com":
+			return nil, nil
+		case "unreachable.example.com":
+			return nil, &net.DNSError{Err: "i/o timeout", Name: domain, IsTimeout: true}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+
+	samples := []struct {
+		testID  int
+		email   string
+		message string
+	}{
+		{testID: 1, email: "jcousteau@gmail.com"},
+		{testID: 2, email: "jcousteau@nomail.example.com", message: "Invalid Email: no mail server for nomail.example.com"},
+		{testID: 3, email: "jcousteau@missing.example.com", message: "Invalid Email: no mail server for missing.example.com"},
+		// A resolver that can't answer lets the email through
+		{testID: 4, email: "jcousteau@unreachable.example.com"},
+	}
+	for _, v := range samples {
+		fe := validate.MailServer("Email", v.email)
+		if v.message == "" {
+			assert.Nil(t, fe)
+		} else if assert.NotNil(t, fe) {
+			assert.Equal(t, v.message, fe.Message)
+		}
+		fmt.Printf("%v Finished w/ code: %v\n", v.testID, v.message)
+	}
+
+	// Only checked when CheckEmailMX is set
+	user := model.User{Username: "jcousteau", Email: "jcousteau@missing.example.com", Password: "pass123"}
+	assert.NoError(t, user.Validate(model.UserCreate, model.DefaultUserPolicy))
+	policy := model.DefaultUserPolicy
+	policy.CheckEmailMX = true
+	assert.EqualError(t, user.Validate(model.UserCreate, policy), "Invalid Email: no mail server for missing.example.com")
+}
+
+func TestCheck(t *testing.T) {
+	err := validate.Check(
+		validate.Field{Name: "title", Label: "Title", Value: "", Rules: []validate.Rule{validate.Required, validate.MaxLength(3)}},
+		validate.Field{Name: "slug", Label: "Slug", Value: "fine", Rules: []validate.Rule{validate.Required}},
+		validate.Field{Name: "summary", Label: "Summary", Value: "abcd", Rules: []validate.Rule{validate.Required, validate.MaxLength(3)}},
+	)
+	// Every field is named once, with the first rule it fails
+	assert.Equal(t, []apierror.FieldError{
+		{Field: "title", Code: apierror.CodeRequired, Message: "Required: Title"},
+		{Field: "summary", Code: apierror.CodeTooLong, Message: "Summary Too Long: max 3 characters"},
+	}, apierror.Fields(err))
+
+	assert.NoError(t, validate.Check(validate.Field{Name: "slug", Label: "Slug", Value: "fine", Rules: []validate.Rule{validate.Required}}))
+	var errs validate.Errors
+	assert.NoError(t, errs.Err())
+	errs.Include(errors.New("not a validation"))
+	assert.NoError(t, errs.Err())
+}
+
+func TestUserValidate(t *testing.T) {
+	samples := []struct {
+		testID int
+		user   model.User
+		op     model.UserOperation
+		fields []string
+	}{
+		{testID: 1, user: model.User{Username: "jcousteau", Email: "jcousteau@gmail.com", Password: "pass123"}, op: model.UserCreate},
+		{testID: 2, user: model.User{}, op: model.UserCreate, fields: []string{"username", "email", "password"}},
+		{testID: 3, user: model.User{Username: "j cousteau", Email: "jcousteau", Password: "pass"}, op: model.UserCreate, fields: []string{"username", "email", "password"}},
+		// Logging in checks neither the username nor the policy, older passwords still sign in
+		{testID: 4, user: model.User{Email: "jcousteau@gmail.com", Password: "pass"}, op: model.UserLogin},
+		{testID: 5, user: model.User{Username: "jcousteau"}, op: model.UserLogin, fields: []string{"email", "password"}},
+		// Updates check the policy only when the password changes, through ValidatePassword
+		{testID: 6, user: model.User{Username: "jcousteau", Email: "jcousteau@gmail.com", Password: "pass"}, op: model.UserUpdate},
+		{testID: 7, user: model.User{Username: strings.Repeat("j", 101), Email: "jcousteau@gmail.com", Password: "pass"}, op: model.UserUpdate, fields: []string{"username"}},
+	}
+	for _, v := range samples {
+		err := v.user.Validate(v.op, model.DefaultUserPolicy)
+		assert.Equal(t, v.fields, fieldNames(err))
+		assert.Equal(t, v.fields == nil, err == nil)
+		fmt.Printf("%v Finished w/ error: %v\n", v.testID, err)
+	}
+
+	assert.Error(t, model.DefaultUserPolicy.ValidatePassword("pass"))
+	assert.NoError(t, model.DefaultUserPolicy.ValidatePassword("pass123"))
+	strict := model.UserPolicy{Password: validate.PasswordPolicy{MinLength: 8, MinClasses: 2}}
+	assert.Error(t, strict.ValidatePassword("password"))
+	assert.NoError(t, strict.ValidatePassword("pass1234"))
+}
+
+func TestPostValidate(t *testing.T) {
+	post := model.Post{Title: strings.Repeat("T", 101), Status: model.PostScheduled}
+	assert.Equal(t, []apierror.FieldError{
+		{Field: "title", Code: apierror.CodeTooLong, Message: "Title Too Long: max 100 characters"},
+		{Field: "content", Code: apierror.CodeRequired, Message: "Required: Content"},
+		{Field: "author_id", Code: apierror.CodeRequired, Message: "Required: Author"},
+		{Field: "published_at", Code: apierror.CodeRequired, Message: "Required: Future Published At"},
+	}, apierror.Fields(post.Validate(100000)))
+	post = model.Post{Title: "T", Content: "Too long", AuthorID: 1, Status: model.PostDraft}
+	assert.Equal(t, []string{"content"}, fieldNames(post.Validate(3)))
+
+	comment := model.Comment{Status: "hidden"}
+	assert.Equal(t, []string{"content", "post_id", "author_id", "status"}, fieldNames(comment.Validate()))
+}
+
+func fieldNames(err error) []string {
+	var names []string
+	for _, fe := range apierror.Fields(err) {
+		names = append(names, fe.Field)
+	}
+	return names
+}